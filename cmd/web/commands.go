@@ -0,0 +1,883 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/cancellation"
+	"github.com/flaviusp23/bookings/internal/currency"
+	"github.com/flaviusp23/bookings/internal/driver"
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/property"
+	"github.com/flaviusp23/bookings/internal/quote"
+	"github.com/flaviusp23/bookings/internal/repository"
+	"github.com/flaviusp23/bookings/internal/repository/dbrepo"
+	"github.com/flaviusp23/bookings/internal/waitlist"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cmdFlags are the flags shared by the operational subcommands
+type cmdFlags struct {
+	*flag.FlagSet
+	db       *dbConfig
+	asJSON   *bool
+	property *string
+}
+
+// newCmdFlags creates the flag set for a subcommand such as "user create"
+func newCmdFlags(name, usage string) *cmdFlags {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	c := &cmdFlags{
+		FlagSet: fs,
+		db:      addDBFlags(fs),
+		asJSON:  fs.Bool("json", false, "Print the result as JSON"),
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: bookings %s [flags]\n\n%s\n\nflags:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return c
+}
+
+// parse parses the arguments and exits with the usage if the database flags are missing
+func (c *cmdFlags) parse(args []string) {
+	c.Parse(args)
+	if !c.db.valid() {
+		c.Usage()
+		os.Exit(1)
+	}
+}
+
+// repo connects to the database and returns the repository with a function to close it
+func (c *cmdFlags) repo() (repository.DatabaseRepo, func(), error) {
+	db, err := driver.ConnectSQL(c.db.dsn())
+	if err != nil {
+		return nil, nil, err
+	}
+	return dbrepo.NewPostgresRepo(db.SQL, &app), func() { db.SQL.Close() }, nil
+}
+
+// addPropertyFlag adds the -property flag, for subcommands working on the data of one property
+func (c *cmdFlags) addPropertyFlag() {
+	c.property = c.String("property", "", "Slug of the property, the first one when empty")
+}
+
+// propertyRepo connects to the database and returns the repository scoped to the property given with -property,
+// with the property, all the properties and a function to close it
+func (c *cmdFlags) propertyRepo() (repository.DatabaseRepo, models.Property, []models.Property, func(), error) {
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return nil, models.Property{}, nil, nil, err
+	}
+
+	properties, err := repo.AllProperties()
+	if err != nil {
+		closeDB()
+		return nil, models.Property{}, nil, nil, err
+	}
+	p, err := findProperty(properties, *c.property)
+	if err != nil {
+		closeDB()
+		return nil, models.Property{}, nil, nil, err
+	}
+
+	return repo.ForProperty(p.ID), p, properties, closeDB, nil
+}
+
+// findProperty returns the property with slug, or the first one when slug is empty
+func findProperty(properties []models.Property, slug string) (models.Property, error) {
+	for _, p := range properties {
+		if slug == "" || p.Slug == slug {
+			return p, nil
+		}
+	}
+	if slug == "" {
+		return models.Property{}, errors.New("there are no properties")
+	}
+	return models.Property{}, fmt.Errorf("no property %q", slug)
+}
+
+// print writes v as JSON when -json was given, otherwise it prints the text
+func (c *cmdFlags) print(v interface{}, text string) error {
+	if !*c.asJSON {
+		fmt.Println(text)
+		return nil
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// dispatch runs the action named by the first argument
+func dispatch(group string, actions map[string]func(args []string) error, args []string) error {
+	if len(args) > 0 {
+		if action, ok := actions[args[0]]; ok {
+			return action(args[1:])
+		}
+	}
+
+	var names []string
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: bookings %s <action> [flags]\n\nactions:\n", group)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	os.Exit(1)
+	return nil
+}
+
+type userOutput struct {
+	ID          int    `json:"id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Email       string `json:"email"`
+	AccessLevel int    `json:"access_level"`
+	Password    string `json:"password,omitempty"`
+}
+
+type reservationOutput struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	Processed int    `json:"processed"`
+	Adults    int    `json:"adults"`
+	Children  int    `json:"children"`
+	Payment   string `json:"payment_status"`
+	Refund    int    `json:"refund_amount"`
+}
+
+type propertyOutput struct {
+	ID      int    `json:"id"`
+	Slug    string `json:"slug"`
+	Name    string `json:"name"`
+	Host    string `json:"host"`
+	Address string `json:"address"`
+	Phone   string `json:"phone"`
+	Email   string `json:"email"`
+}
+
+func newPropertyOutput(p models.Property) propertyOutput {
+	return propertyOutput{ID: p.ID, Slug: p.Slug, Name: p.Name, Host: p.Host, Address: p.Address, Phone: p.Phone,
+		Email: p.Email}
+}
+
+type roomOutput struct {
+	ID                 int    `json:"id"`
+	RoomName           string `json:"room_name"`
+	MaxOccupancy       int    `json:"max_occupancy"`
+	CancellationPolicy string `json:"cancellation_policy"`
+}
+
+type blockOutput struct {
+	RoomID int      `json:"room_id"`
+	Dates  []string `json:"dates"`
+}
+
+type rateOutput struct {
+	Currency  string `json:"currency"`
+	Rate      string `json:"rate"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+type mailOutput struct {
+	To   string `json:"to"`
+	Sent bool   `json:"sent"`
+}
+
+func newReservationOutput(r models.Reservation) reservationOutput {
+	return reservationOutput{
+		ID:        r.ID,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Phone:     r.Phone,
+		StartDate: r.StartDate.Format("2006-01-02"),
+		EndDate:   r.EndDate.Format("2006-01-02"),
+		RoomID:    r.RoomID,
+		RoomName:  r.Room.RoomName,
+		Processed: r.Processed,
+		Adults:    r.Adults,
+		Children:  r.Children,
+		Payment:   r.PaymentStatus,
+		Refund:    r.RefundAmount,
+	}
+}
+
+// userCommand runs the user subcommand
+func userCommand(args []string) error {
+	return dispatch("user", map[string]func(args []string) error{
+		"create":         userCreate,
+		"reset-password": userResetPassword,
+	}, args)
+}
+
+func userCreate(args []string) error {
+	c := newCmdFlags("user create", "Creates a staff user with access to a property. A random password is generated if\n"+
+		"none is given.")
+	firstName := c.String("first", "", "First name")
+	lastName := c.String("last", "", "Last name")
+	email := c.String("email", "", "Email address (required)")
+	password := c.String("password", "", "Password")
+	accessLevel := c.Int("access", 3, "Access level")
+	c.addPropertyFlag()
+	c.parse(args)
+
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	generated := ""
+	if *password == "" {
+		generated = randomPassword()
+		*password = generated
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), 12)
+	if err != nil {
+		return err
+	}
+
+	repo, p, _, closeDB, err := c.propertyRepo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	id, err := repo.InsertUser(models.User{
+		FirstName:   *firstName,
+		LastName:    *lastName,
+		Email:       *email,
+		Password:    string(hash),
+		AccessLevel: *accessLevel,
+	})
+	if err != nil {
+		return err
+	}
+	err = repo.GrantPropertyAccess(p.ID, id)
+	if err != nil {
+		return fmt.Errorf("user %d created, but can't give them access to %s: %w", id, p.Name, err)
+	}
+
+	text := fmt.Sprintf("created user %d <%s> with access to %s", id, *email, p.Name)
+	if generated != "" {
+		text += fmt.Sprintf(" with password %s", generated)
+	}
+	return c.print(userOutput{
+		ID:          id,
+		FirstName:   *firstName,
+		LastName:    *lastName,
+		Email:       *email,
+		AccessLevel: *accessLevel,
+		Password:    generated,
+	}, text)
+}
+
+func userResetPassword(args []string) error {
+	c := newCmdFlags("user reset-password", "Sets a new password for a user. A random password is generated if none is given.")
+	email := c.String("email", "", "Email address (required)")
+	password := c.String("password", "", "New password")
+	c.parse(args)
+
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	generated := ""
+	if *password == "" {
+		generated = randomPassword()
+		*password = generated
+	}
+
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	u, err := repo.GetUserByEmail(*email)
+	if err != nil {
+		return fmt.Errorf("can't find user %s: %w", *email, err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), 12)
+	if err != nil {
+		return err
+	}
+
+	err = repo.UpdateUserPassword(u.ID, string(hash))
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("password reset for user %d <%s>", u.ID, u.Email)
+	if generated != "" {
+		text += fmt.Sprintf(", new password is %s", generated)
+	}
+	return c.print(userOutput{
+		ID:          u.ID,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Email:       u.Email,
+		AccessLevel: u.AccessLevel,
+		Password:    generated,
+	}, text)
+}
+
+// reservationCommand runs the reservation subcommand
+func reservationCommand(args []string) error {
+	return dispatch("reservation", map[string]func(args []string) error{
+		"list":   reservationList,
+		"cancel": reservationCancel,
+	}, args)
+}
+
+func reservationList(args []string) error {
+	c := newCmdFlags("reservation list", "Lists reservations ordered by arrival date.")
+	onlyNew := c.Bool("new", false, "Only list reservations that are not processed yet")
+	c.addPropertyFlag()
+	c.parse(args)
+
+	repo, _, _, closeDB, err := c.propertyRepo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	var reservations []models.Reservation
+	if *onlyNew {
+		reservations, err = repo.AllNewReservations()
+	} else {
+		reservations, err = repo.AllReservations()
+	}
+	if err != nil {
+		return err
+	}
+
+	out := []reservationOutput{}
+	text := ""
+	for _, r := range reservations {
+		out = append(out, newReservationOutput(r))
+		text += fmt.Sprintf("%d\t%s\t%s\t%s %s <%s>\t%s\n", r.ID, r.StartDate.Format("2006-01-02"), r.EndDate.Format("2006-01-02"),
+			r.FirstName, r.LastName, r.Email, r.Room.RoomName)
+	}
+	if text == "" {
+		text = "no reservations"
+	}
+
+	return c.print(out, text)
+}
+
+func reservationCancel(args []string) error {
+	c := newCmdFlags("reservation cancel", "Cancels a reservation and frees its rooms, recording the refund its\n"+
+		"cancellation policy gives; the refund itself is issued with the payment provider. With -bookingkey, guests on\n"+
+		"the waitlist for the freed dates are emailed a claim link.")
+	id := c.Int("id", 0, "Reservation id (required)")
+	bookingKey := c.String("bookingkey", "", "Secret the server signs booking links with, needed to notify the waitlist")
+	baseURL := c.String("baseurl", "http://localhost"+portNumber, "Public address of the site, used for links in emails")
+	c.addPropertyFlag()
+	c.parse(args)
+
+	if *id == 0 {
+		return errors.New("-id is required")
+	}
+
+	repo, p, properties, closeDB, err := c.propertyRepo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	res, err := repo.GetReservationByID(*id)
+	if err != nil {
+		return fmt.Errorf("can't find reservation %d: %w", *id, err)
+	}
+
+	refund := cancellation.Refund(res.CancellationPolicy, res.Paid(), res.StartDate, time.Now())
+	err = repo.CancelReservation(res.ID, refund)
+	if err != nil {
+		return err
+	}
+	res.RefundAmount = refund
+
+	text := fmt.Sprintf("cancelled reservation %d for %s %s (%s to %s)\nrefund due under the %s policy: %d.%02d",
+		res.ID, res.FirstName, res.LastName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+		res.CancellationPolicy, refund/100, refund%100)
+	if *bookingKey == "" {
+		text += "\nwaitlist not notified: -bookingkey not given"
+	} else {
+		sites := property.NewDirectory(strings.TrimSuffix(*baseURL, "/"))
+		sites.Set(properties)
+		n := &waitlist.Notifier{
+			DB:      repo,
+			Quotes:  quote.NewSigner([]byte(*bookingKey)),
+			BaseURL: sites.URL(p),
+			From:    p.Email,
+			Send: func(msg models.MailData) {
+				if err := sendMsg(msg); err != nil {
+					fmt.Fprintf(os.Stderr, "can't email %s: %v\n", msg.To, err)
+				}
+			},
+		}
+		notified := 0
+		for _, stay := range res.Stays {
+			count, err := n.RoomFreed(stay.RoomID, stay.StartDate, stay.EndDate)
+			if err != nil {
+				return fmt.Errorf("reservation cancelled, but can't notify the waitlist: %w", err)
+			}
+			notified += count
+		}
+		text += fmt.Sprintf("\nnotified %d guest(s) on the waitlist", notified)
+	}
+
+	return c.print(newReservationOutput(res), text)
+}
+
+// roomCommand runs the room subcommand
+func roomCommand(args []string) error {
+	return dispatch("room", map[string]func(args []string) error{
+		"list":   roomList,
+		"policy": roomPolicy,
+	}, args)
+}
+
+func roomList(args []string) error {
+	c := newCmdFlags("room list", "Lists the rooms of a property.")
+	c.addPropertyFlag()
+	c.parse(args)
+
+	repo, _, _, closeDB, err := c.propertyRepo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	rooms, err := repo.AllRooms()
+	if err != nil {
+		return err
+	}
+
+	out := []roomOutput{}
+	text := ""
+	for _, r := range rooms {
+		out = append(out, roomOutput{ID: r.ID, RoomName: r.RoomName, MaxOccupancy: r.MaxOccupancy,
+			CancellationPolicy: r.CancellationPolicy})
+		text += fmt.Sprintf("%d\t%s\tsleeps %d\t%s\n", r.ID, r.RoomName, r.MaxOccupancy, r.CancellationPolicy)
+	}
+
+	return c.print(out, text)
+}
+
+func roomPolicy(args []string) error {
+	c := newCmdFlags("room policy", "Sets the cancellation policy of a room ("+strings.Join(cancellation.Policies, ", ")+
+		"). It applies to reservations made from now on.")
+	roomID := c.Int("id", 0, "Room id (required)")
+	policy := c.String("policy", "", "Cancellation policy (required)")
+	c.addPropertyFlag()
+	c.parse(args)
+
+	if *roomID == 0 || !cancellation.Valid(*policy) {
+		return fmt.Errorf("-id and -policy (%s) are required", strings.Join(cancellation.Policies, ", "))
+	}
+
+	repo, _, _, closeDB, err := c.propertyRepo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	err = repo.UpdateRoomCancellationPolicy(*roomID, *policy)
+	if err != nil {
+		return fmt.Errorf("can't update room %d: %w", *roomID, err)
+	}
+
+	room, err := repo.GetRoomByID(*roomID)
+	if err != nil {
+		return err
+	}
+
+	return c.print(roomOutput{ID: room.ID, RoomName: room.RoomName, MaxOccupancy: room.MaxOccupancy,
+		CancellationPolicy: room.CancellationPolicy},
+		fmt.Sprintf("%s now has the %s cancellation policy", room.RoomName, room.CancellationPolicy))
+}
+
+// blockCommand runs the block subcommand
+func blockCommand(args []string) error {
+	return dispatch("block", map[string]func(args []string) error{
+		"add": blockAdd,
+	}, args)
+}
+
+func blockAdd(args []string) error {
+	c := newCmdFlags("block add", "Adds an owner block to a room, one per night.")
+	roomID := c.Int("room", 0, "Room id (required)")
+	date := c.String("date", "", "First blocked night, yyyy-mm-dd (required)")
+	nights := c.Int("nights", 1, "Number of nights to block")
+	c.addPropertyFlag()
+	c.parse(args)
+
+	if *roomID == 0 || *date == "" {
+		return errors.New("-room and -date are required")
+	}
+	if *nights < 1 {
+		return errors.New("-nights must be at least 1")
+	}
+
+	start, err := time.Parse("2006-01-02", *date)
+	if err != nil {
+		return fmt.Errorf("invalid date %q, use yyyy-mm-dd", *date)
+	}
+
+	repo, _, _, closeDB, err := c.propertyRepo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	room, err := repo.GetRoomByID(*roomID)
+	if err != nil {
+		return fmt.Errorf("can't find room %d: %w", *roomID, err)
+	}
+
+	out := blockOutput{RoomID: room.ID, Dates: []string{}}
+	for i := 0; i < *nights; i++ {
+		d := start.AddDate(0, 0, i)
+		err := repo.InsertBlockForRoom(room.ID, d)
+		if err != nil {
+			return err
+		}
+		out.Dates = append(out.Dates, d.Format("2006-01-02"))
+	}
+
+	return c.print(out, fmt.Sprintf("blocked %s for %d night(s) from %s", room.RoomName, *nights, *date))
+}
+
+// propertyCommand runs the property subcommand
+func propertyCommand(args []string) error {
+	return dispatch("property", map[string]func(args []string) error{
+		"list":   propertyList,
+		"add":    propertyAdd,
+		"update": propertyUpdate,
+		"grant":  propertyGrant,
+		"revoke": propertyRevoke,
+	}, args)
+}
+
+func propertyList(args []string) error {
+	c := newCmdFlags("property list", "Lists the properties, the default one first.")
+	c.parse(args)
+
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	properties, err := repo.AllProperties()
+	if err != nil {
+		return err
+	}
+
+	out := []propertyOutput{}
+	text := ""
+	for _, p := range properties {
+		out = append(out, newPropertyOutput(p))
+		host := p.Host
+		if host == "" {
+			host = "-"
+		}
+		text += fmt.Sprintf("%d\t%s\t%s\t%s\t%s\n", p.ID, p.Slug, host, p.Name, p.Email)
+	}
+
+	return c.print(out, text)
+}
+
+// addPropertyDetailFlags adds the flags setting the details of a property, returning a function that copies the
+// ones given to p
+func addPropertyDetailFlags(c *cmdFlags) func(p *models.Property) {
+	name := c.String("name", "", "Name")
+	host := c.String("host", "", "Host name of its public site, e.g. lakeside.example.com")
+	address := c.String("address", "", `Address, with \n between the lines`)
+	phone := c.String("phone", "", "Phone number")
+	email := c.String("email", "", "Email address guests write to and emails are sent from")
+
+	return func(p *models.Property) {
+		c.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				p.Name = *name
+			case "host":
+				p.Host = property.NormalizeHost(*host)
+			case "address":
+				p.Address = strings.ReplaceAll(*address, `\n`, "\n")
+			case "phone":
+				p.Phone = *phone
+			case "email":
+				p.Email = *email
+			}
+		})
+	}
+}
+
+func propertyAdd(args []string) error {
+	c := newCmdFlags("property add", "Adds a property. Its public site is at its host name, or else at /<slug> on\n"+
+		"any host; the site picks it up within five minutes. Give staff access to it with property grant.")
+	slug := c.String("slug", "", "Path prefix of its public site, lowercase letters, digits and hyphens (required)")
+	apply := addPropertyDetailFlags(c)
+	c.parse(args)
+
+	p := models.Property{Slug: *slug}
+	apply(&p)
+	if !property.ValidSlug(p.Slug) || p.Name == "" || p.Email == "" {
+		return errors.New("-slug, -name and -email are required, and the slug can't be a page of the site")
+	}
+
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	p.ID, err = repo.InsertProperty(p)
+	if err != nil {
+		return err
+	}
+
+	return c.print(newPropertyOutput(p), fmt.Sprintf("added property %d %s at /%s", p.ID, p.Name, p.Slug))
+}
+
+func propertyUpdate(args []string) error {
+	c := newCmdFlags("property update", "Changes the details of a property, only the ones given.")
+	slug := c.String("slug", "", "Slug of the property (required)")
+	apply := addPropertyDetailFlags(c)
+	c.parse(args)
+
+	if *slug == "" {
+		return errors.New("-slug is required")
+	}
+
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	properties, err := repo.AllProperties()
+	if err != nil {
+		return err
+	}
+	p, err := findProperty(properties, *slug)
+	if err != nil {
+		return err
+	}
+
+	apply(&p)
+	if p.Name == "" || p.Email == "" {
+		return errors.New("the name and email can't be empty")
+	}
+
+	err = repo.UpdateProperty(p)
+	if err != nil {
+		return err
+	}
+
+	return c.print(newPropertyOutput(p), fmt.Sprintf("updated property %d %s", p.ID, p.Name))
+}
+
+func propertyGrant(args []string) error {
+	return propertyAccess("grant", "Gives a staff user access to a property.", args,
+		func(repo repository.DatabaseRepo, p models.Property, u models.User) (string, error) {
+			return fmt.Sprintf("%s now has access to %s", u.Email, p.Name), repo.GrantPropertyAccess(p.ID, u.ID)
+		})
+}
+
+func propertyRevoke(args []string) error {
+	return propertyAccess("revoke", "Takes a property away from a staff user.", args,
+		func(repo repository.DatabaseRepo, p models.Property, u models.User) (string, error) {
+			return fmt.Sprintf("%s no longer has access to %s", u.Email, p.Name), repo.RevokePropertyAccess(p.ID, u.ID)
+		})
+}
+
+// accessChange changes the access of u to p, returning what it did
+type accessChange func(repo repository.DatabaseRepo, p models.Property, u models.User) (string, error)
+
+// propertyAccess runs property grant or revoke, which change the access of the user given with -email to the
+// property given with -slug
+func propertyAccess(action, usage string, args []string, change accessChange) error {
+	c := newCmdFlags("property "+action, usage)
+	slug := c.String("slug", "", "Slug of the property (required)")
+	email := c.String("email", "", "Email address of the user (required)")
+	c.parse(args)
+
+	if *slug == "" || *email == "" {
+		return errors.New("-slug and -email are required")
+	}
+
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	properties, err := repo.AllProperties()
+	if err != nil {
+		return err
+	}
+	p, err := findProperty(properties, *slug)
+	if err != nil {
+		return err
+	}
+	u, err := repo.GetUserByEmail(*email)
+	if err != nil {
+		return fmt.Errorf("can't find user %s: %w", *email, err)
+	}
+
+	text, err := change(repo, p, u)
+	if err != nil {
+		return err
+	}
+
+	return c.print(userOutput{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email,
+		AccessLevel: u.AccessLevel}, text)
+}
+
+// mailCommand runs the mail subcommand
+func mailCommand(args []string) error {
+	return dispatch("mail", map[string]func(args []string) error{
+		"test": mailTest,
+	}, args)
+}
+
+func mailTest(args []string) error {
+	fs := flag.NewFlagSet("mail test", flag.ExitOnError)
+	to := fs.String("to", "", "Recipient (required)")
+	from := fs.String("from", "me@here.com", "Sender")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	fs.Parse(args)
+
+	if *to == "" {
+		return errors.New("-to is required")
+	}
+
+	err := sendMsg(models.MailData{
+		To:       *to,
+		From:     *from,
+		Subject:  "Test email",
+		Content:  "This is a test email from the bookings application.",
+		Template: "basic.html",
+	})
+	if err != nil {
+		return err
+	}
+
+	c := &cmdFlags{FlagSet: fs, asJSON: asJSON}
+	return c.print(mailOutput{To: *to, Sent: true}, fmt.Sprintf("test email sent to %s", *to))
+}
+
+// ratesCommand runs the rates subcommand
+func ratesCommand(args []string) error {
+	return dispatch("rates", map[string]func(args []string) error{
+		"list":   ratesList,
+		"import": ratesImport,
+	}, args)
+}
+
+func ratesList(args []string) error {
+	c := newCmdFlags("rates list", "Lists the exchange rates from the base currency.")
+	c.parse(args)
+
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	rates, err := repo.AllExchangeRates()
+	if err != nil {
+		return err
+	}
+
+	out := []rateOutput{}
+	text := ""
+	for _, e := range rates {
+		rate := currency.Rate(e.Rate).String()
+		out = append(out, rateOutput{Currency: e.Currency, Rate: rate, UpdatedAt: e.UpdatedAt.Format(time.RFC3339)})
+		text += fmt.Sprintf("%s\t%s\tupdated %s\n", e.Currency, rate, e.UpdatedAt.Format("2006-01-02 15:04"))
+	}
+
+	return c.print(out, text)
+}
+
+func ratesImport(args []string) error {
+	c := newCmdFlags("rates import", "Imports exchange rates from a file with a currency code and the rate from the base "+
+		"currency on each line, e.g. EUR,0.2011. Currencies in the file are added or updated, the others are kept. "+
+		"The site picks the rates up within ten minutes.")
+	file := c.String("file", "", "Rates file (required)")
+	c.parse(args)
+
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	parsed, err := currency.ParseRates(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+	if len(parsed) == 0 {
+		return fmt.Errorf("%s has no rates", *file)
+	}
+
+	var rates []models.ExchangeRate
+	out := []rateOutput{}
+	for code, rate := range parsed {
+		rates = append(rates, models.ExchangeRate{Currency: code, Rate: int64(rate)})
+		out = append(out, rateOutput{Currency: code, Rate: rate.String()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Currency < out[j].Currency })
+
+	repo, closeDB, err := c.repo()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	err = repo.UpsertExchangeRates(rates)
+	if err != nil {
+		return err
+	}
+
+	return c.print(out, fmt.Sprintf("imported %d exchange rate(s) from %s", len(rates), *file))
+}
+
+// randomPassword returns a random password for generated credentials
+func randomPassword() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
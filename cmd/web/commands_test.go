@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+func TestDBConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	dbc := addDBFlags(fs)
+
+	_ = fs.Parse([]string{})
+	if dbc.valid() {
+		t.Error("db flags are valid without dbname and dbuser")
+	}
+
+	_ = fs.Parse([]string{"-dbname=bookings", "-dbuser=someuser"})
+	if !dbc.valid() {
+		t.Error("db flags are not valid with dbname and dbuser")
+	}
+
+	expected := "host=localhost port=5432 dbname=bookings user=someuser password= sslmode=disable"
+	if dbc.dsn() != expected {
+		t.Errorf("expected dsn %q but got %q", expected, dbc.dsn())
+	}
+}
+
+func TestNewReservationOutput(t *testing.T) {
+	res := models.Reservation{
+		ID:        7,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	out := newReservationOutput(res)
+	if out.StartDate != "2050-01-01" || out.EndDate != "2050-01-03" {
+		t.Errorf("dates not formatted as yyyy-mm-dd: got %s and %s", out.StartDate, out.EndDate)
+	}
+	if out.RoomName != "General's Quarters" {
+		t.Errorf("expected room name but got %q", out.RoomName)
+	}
+}
+
+func TestRandomPassword(t *testing.T) {
+	a := randomPassword()
+	b := randomPassword()
+	if len(a) < 12 {
+		t.Errorf("generated password %q is too short", a)
+	}
+	if a == b {
+		t.Error("generated the same password twice")
+	}
+}
+
+func TestFindProperty(t *testing.T) {
+	properties := []models.Property{{ID: 1, Slug: "fort-smythe"}, {ID: 2, Slug: "lakeside"}}
+
+	p, err := findProperty(properties, "")
+	if err != nil || p.ID != 1 {
+		t.Errorf("expected the first property without a slug, got %d (%v)", p.ID, err)
+	}
+	p, err = findProperty(properties, "lakeside")
+	if err != nil || p.ID != 2 {
+		t.Errorf("expected property 2 for lakeside, got %d (%v)", p.ID, err)
+	}
+	_, err = findProperty(properties, "hilltop")
+	if err == nil {
+		t.Error("found a property that doesn't exist")
+	}
+	_, err = findProperty(nil, "")
+	if err == nil {
+		t.Error("found a property when there are none")
+	}
+}
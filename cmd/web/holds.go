@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/repository"
+)
+
+// holdSweepInterval is how often expired room holds are released
+const holdSweepInterval = time.Minute
+
+// sweepHolds releases expired room holds in the background, so abandoned bookings free their rooms
+func sweepHolds(repo repository.DatabaseRepo, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			n, err := repo.DeleteExpiredHolds()
+			if err != nil {
+				app.Logger.Error("can't release expired holds", "error", err)
+				continue
+			}
+			if n > 0 {
+				app.Logger.Info("Released expired holds", "count", n)
+			}
+		}
+	}()
+}
@@ -1,53 +1,130 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/currency"
 	"github.com/flaviusp23/bookings/internal/driver"
 	"github.com/flaviusp23/bookings/internal/handlers"
 	"github.com/flaviusp23/bookings/internal/helpers"
+	"github.com/flaviusp23/bookings/internal/metrics"
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/payments"
+	"github.com/flaviusp23/bookings/internal/property"
+	"github.com/flaviusp23/bookings/internal/quote"
 	"github.com/flaviusp23/bookings/internal/render"
+	"github.com/flaviusp23/bookings/internal/sessionstore"
 
 	"github.com/alexedwards/scs/v2"
 )
 
 const portNumber = ":8080"
 
+// shutdownTimeout bounds how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// sessionCleanupInterval is how often expired sessions are deleted from persistent stores
+const sessionCleanupInterval = 5 * time.Minute
+
+// shutdownDelay is how long readiness fails before the server stops accepting connections
+var shutdownDelay time.Duration
+
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog *log.Logger
-var errorLog *log.Logger
+
+// commands are the subcommands of the bookings binary; without one the web server is started
+var commands = map[string]func(args []string) error{
+	"migrate":     migrateCommand,
+	"user":        userCommand,
+	"reservation": reservationCommand,
+	"room":        roomCommand,
+	"block":       blockCommand,
+	"mail":        mailCommand,
+	"rates":       ratesCommand,
+	"property":    propertyCommand,
+}
 
 func main() {
+	app.Logger = newLogger(false, slog.LevelInfo)
+
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil {
+				app.Logger.Error(err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	db, err := run()
 
 	if err != nil {
-		log.Fatal(err)
+		app.Logger.Error(err.Error())
+		os.Exit(1)
 	}
 	defer db.SQL.Close()
 	defer close(app.MailChan)
-	fmt.Printf("Starting mail listener...\n")
+	app.Logger.Info("Starting mail listener...")
 	listenForMail()
 
-	fmt.Printf("Starting application on port %s", portNumber)
+	if app.MetricsAddr != "" {
+		app.Logger.Info("Starting metrics listener", "addr", app.MetricsAddr)
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler(app.MetricsToken))
+			err := http.ListenAndServe(app.MetricsAddr, mux)
+			if err != nil {
+				app.Logger.Error("metrics listener stopped", "error", err)
+			}
+		}()
+	}
+
+	app.Logger.Info("Starting application", "port", portNumber)
 
 	srv := &http.Server{
 		Addr:    portNumber,
 		Handler: routes(),
 	}
 
-	err = srv.ListenAndServe()
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.Logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	// fail readiness first and give load balancers time to stop sending traffic
+	app.ShuttingDown.Store(true)
+	app.Logger.Info("Shutting down, draining traffic", "delay", shutdownDelay)
+	time.Sleep(shutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(ctx)
 	if err != nil {
-		log.Fatal(err)
+		app.Logger.Error("graceful shutdown failed", "error", err)
 	}
+	app.Logger.Info("Stopped")
 }
 
 func run() (*driver.DB, error) {
@@ -61,32 +138,68 @@ func run() (*driver.DB, error) {
 	// read flags
 	inProduction := flag.Bool("production", true, "Application is in production")
 	useCache := flag.Bool("cache", true, "Use template cache")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require)")
+	autoMigrate := flag.Bool("automigrate", false, "Apply pending database migrations on startup")
+	metricsToken := flag.String("metricstoken", "", "Bearer token required to read /metrics")
+	metricsAddr := flag.String("metricsaddr", "", "Serve /metrics on this separate admin address (e.g. 127.0.0.1:9090) instead of the main port")
+	flag.DurationVar(&shutdownDelay, "shutdowndelay", 5*time.Second, "How long /readyz fails before the server stops on SIGTERM")
+	sessionStore := flag.String("sessionstore", "memory", "Session store (memory, postgres, file)")
+	sessionDir := flag.String("sessiondir", "./sessions", "Directory for the file session store")
+	logLevel := flag.String("loglevel", "info", "Log level (debug, info, warn, error)")
+	bookingKey := flag.String("bookingkey", "", "Secret used to sign booking links; a random one is used when empty")
+	baseURL := flag.String("baseurl", "http://localhost"+portNumber, "Public address of the site, used for links in emails")
+	paymentProvider := flag.String("payments", "fake", "Payment provider (fake, which takes no money, only with -production=false)")
+	webhookSecret := flag.String("webhooksecret", "", "Secret the payment provider signs webhooks with; a random one is used when empty")
+	depositPercent := flag.Int("depositpercent", 30, "Share of the price taken as a deposit when booking, in percent")
+	baseCurrency := flag.String("currency", "RON", "Currency prices are set and stored in, an ISO 4217 code of a currency with cents")
+	retentionYears := flag.Int("retentionyears", 0, "Anonymise guests' personal data on reservations that ended more than this many years ago; 0 keeps it")
+	dbc := addDBFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	if *dbName == "" || *dbUser == "" {
+	if !dbc.valid() {
 		fmt.Println("Missing required flags")
 		os.Exit(1)
 	}
 
-	mailChan := make(chan models.MailData)
+	mailChan := make(chan models.MailData, 100)
 	app.MailChan = mailChan
+	metrics.RegisterMailQueue(func() int { return len(app.MailChan) })
 
 	// change this to true when in production
 	app.InProduction = *inProduction
 	app.UseCache = *useCache
+	app.MetricsToken = *metricsToken
+	app.MetricsAddr = *metricsAddr
+	app.BaseURL = strings.TrimSuffix(*baseURL, "/")
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
+	base, ok := currency.Parse(*baseCurrency)
+	if !ok || currency.Exponent(base) != 2 {
+		return nil, fmt.Errorf("invalid currency %q, prices need a currency with cents", *baseCurrency)
+	}
+	app.Rates = currency.NewRates(base)
+	app.Properties = property.NewDirectory(app.BaseURL)
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(*logLevel))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", *logLevel)
+	}
+	app.Logger = newLogger(app.InProduction, level)
+	slog.SetDefault(app.Logger)
 
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	// connect to database
+	app.Logger.Info("Connecting to database...")
+	db, err := driver.ConnectSQL(dbc.dsn())
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+	app.Logger.Info("Connected to database!")
+	metrics.RegisterDB(db.SQL)
+
+	err = checkSchema(db, *autoMigrate)
+	if err != nil {
+		return nil, err
+	}
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -94,29 +207,128 @@ func run() (*driver.DB, error) {
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProduction
 
+	switch *sessionStore {
+	case "memory":
+		// the default scs store
+	case "postgres":
+		session.Store = sessionstore.NewPostgresStore(db.SQL, sessionCleanupInterval)
+	case "file":
+		session.Store, err = sessionstore.NewFileStore(*sessionDir, sessionCleanupInterval)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create session directory: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown session store %q", *sessionStore)
+	}
+	app.Logger.Info("Using session store", "store", *sessionStore)
+
 	app.Session = session
 
-	// connect to database
-	log.Println("Connecting to database...")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
-	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
+	key := []byte(*bookingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate booking key: %w", err)
+		}
+		app.Logger.Warn("No -bookingkey given, booking links will not survive a restart")
+	}
+	app.Quotes = quote.NewSigner(key)
+
+	if *depositPercent < 0 || *depositPercent > 100 {
+		return nil, fmt.Errorf("invalid deposit percent %d", *depositPercent)
+	}
+	app.DepositPercent = *depositPercent
+
+	if *retentionYears < 0 {
+		return nil, fmt.Errorf("invalid retention period of %d years", *retentionYears)
+	}
+
+	secret := []byte(*webhookSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate webhook secret: %w", err)
+		}
+		app.Logger.Warn("No -webhooksecret given, the payment provider can't be configured to sign webhooks")
+	}
+	switch *paymentProvider {
+	case "fake":
+		if app.InProduction {
+			return nil, errors.New("the fake payment provider takes no money and can't be used in production, run with -production=false to try it")
+		}
+		app.Payments = payments.NewFakeProvider(app.BaseURL, secret)
+		app.Logger.Warn("Using the fake payment provider, no money is taken")
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", *paymentProvider)
 	}
-	log.Println("Connected to database!")
 
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("cannot create template cache")
-		return nil, err
+		return nil, fmt.Errorf("cannot create template cache: %w", err)
 	}
 
 	app.TemplateCache = tc
 
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
+	err = repo.LoadProperties()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load properties: %w", err)
+	}
+	refreshProperties(repo, propertiesRefreshInterval)
+	sweepHolds(repo.DB, holdSweepInterval)
+	expireWaitlistOffers(repo.DB, waitlistOfferInterval)
+
+	refreshRates(repo, ratesRefreshInterval)
+	if *retentionYears > 0 {
+		enforceRetention(repo.DB, *retentionYears, retentionInterval)
+		app.Logger.Info("Applying the retention policy", "years", *retentionYears)
+	}
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
 	return db, nil
 }
+
+// newLogger returns the application logger, which writes JSON in production and text otherwise
+func newLogger(inProduction bool, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if inProduction {
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, opts))
+}
+
+// dbConfig holds the database connection flags
+type dbConfig struct {
+	host *string
+	name *string
+	user *string
+	pass *string
+	port *string
+	ssl  *string
+}
+
+// addDBFlags registers the database connection flags on fs
+func addDBFlags(fs *flag.FlagSet) *dbConfig {
+	return &dbConfig{
+		host: fs.String("dbhost", "localhost", "Database host"),
+		name: fs.String("dbname", "", "Database name"),
+		user: fs.String("dbuser", "", "Database user"),
+		pass: fs.String("dbpass", "", "Database password"),
+		port: fs.String("dbport", "5432", "Database port"),
+		ssl:  fs.String("dbssl", "disable", "Database ssl settings (disable, prefer, require)"),
+	}
+}
+
+// valid reports whether the required database flags were given
+func (c *dbConfig) valid() bool {
+	return *c.name != "" && *c.user != ""
+}
+
+// dsn returns the connection string for the database
+func (c *dbConfig) dsn() string {
+	return fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *c.host, *c.port, *c.name, *c.user, *c.pass, *c.ssl)
+}
@@ -2,8 +2,18 @@ package main
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/flaviusp23/bookings/internal/currency"
 	"github.com/flaviusp23/bookings/internal/helpers"
+	"github.com/flaviusp23/bookings/internal/i18n"
+	"github.com/flaviusp23/bookings/internal/metrics"
+	"github.com/flaviusp23/bookings/internal/property"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 )
 
@@ -16,6 +26,8 @@ func NoSurf(next http.Handler) http.Handler {
 		Secure:   app.InProduction,
 		SameSite: http.SameSiteLaxMode,
 	})
+	// the payment provider authenticates its webhooks with a signature instead
+	csrfHandler.ExemptPath("/payments/webhook")
 	return csrfHandler
 }
 
@@ -23,6 +35,176 @@ func SessionLoad(next http.Handler) http.Handler {
 	return app.Session.LoadAndSave(next)
 }
 
+// RequestLogger gives every request a logger tagged with its request id, and the user or guest id
+// when logged in, and logs the request once it is served. It must run after RequestID and SessionLoad.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := middleware.GetReqID(r.Context())
+		w.Header().Set(middleware.RequestIDHeader, requestID)
+
+		logger := app.Logger.With("request_id", requestID)
+		if userID := app.Session.GetInt(r.Context(), "user_id"); userID > 0 {
+			logger = logger.With("user_id", userID)
+		}
+		if guestID := helpers.GuestID(r); guestID > 0 {
+			logger = logger.With("guest_id", guestID)
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(helpers.WithLogger(r.Context(), logger)))
+
+		logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// Metrics records the count and latency of requests by chi route pattern
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// the pattern is only known once chi has routed the request
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(ww.Status())).Inc()
+		metrics.HTTPDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// preferenceCookieAge is how long the locale and currency chosen with URL parameters are remembered
+const preferenceCookieAge = 365 * 24 * time.Hour
+
+// Locale puts the locale of the guest in the request context: the one chosen with the lang URL parameter, which is
+// remembered in a cookie, or else the one in that cookie, or else the best match of the Accept-Language header.
+func Locale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale, ok := i18n.Parse(r.URL.Query().Get(i18n.Param))
+		if ok {
+			http.SetCookie(w, &http.Cookie{
+				Name:     i18n.CookieName,
+				Value:    string(locale),
+				Path:     "/",
+				MaxAge:   int(preferenceCookieAge.Seconds()),
+				HttpOnly: true,
+				Secure:   app.InProduction,
+				SameSite: http.SameSiteLaxMode,
+			})
+		} else if c, err := r.Cookie(i18n.CookieName); err == nil {
+			locale, ok = i18n.Parse(c.Value)
+		}
+		if !ok {
+			locale = i18n.Negotiate(r.Header.Get("Accept-Language"))
+		}
+
+		w.Header().Set("Content-Language", string(locale))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(i18n.WithLocale(r.Context(), locale)))
+	})
+}
+
+// Currency puts the currency the guest has prices shown in in the request context: the one chosen with the currency
+// URL parameter, which is remembered in a cookie, or else the one in that cookie. Currencies without an exchange
+// rate fall back to the base currency.
+func Currency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, fromURL := currency.Parse(r.URL.Query().Get(currency.Param))
+		if !fromURL {
+			if c, err := r.Cookie(currency.CookieName); err == nil {
+				code, _ = currency.Parse(c.Value)
+			}
+		}
+		if _, ok := app.Rates.Get(code); !ok {
+			code, fromURL = app.Rates.Base(), false
+		}
+
+		if fromURL {
+			http.SetCookie(w, &http.Cookie{
+				Name:     currency.CookieName,
+				Value:    code,
+				Path:     "/",
+				MaxAge:   int(preferenceCookieAge.Seconds()),
+				HttpOnly: true,
+				Secure:   app.InProduction,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		next.ServeHTTP(w, r.WithContext(currency.WithCurrency(r.Context(), code)))
+	})
+}
+
+// Property puts the property a request is for in its context, found by the host name or else the path prefix. A
+// path prefix is taken off the path before routing and put back on the redirects, so the pages and handlers are the
+// same for every property.
+func Property(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, prefix, ok := app.Properties.Resolve(r.Host, r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if prefix != "" {
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = stripPrefix(r.URL.Path, prefix)
+			if r.URL.RawPath != "" {
+				r2.URL.RawPath = stripPrefix(r.URL.RawPath, prefix)
+			}
+			r = r2
+			w = &prefixWriter{ResponseWriter: w, prefix: prefix}
+		}
+		next.ServeHTTP(w, r.WithContext(property.WithProperty(r.Context(), p, prefix)))
+	})
+}
+
+// stripPrefix returns path without prefix, or the root for the prefix itself
+func stripPrefix(path, prefix string) string {
+	path = strings.TrimPrefix(path, prefix)
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// prefixWriter puts the path prefix of the property back on the redirects to paths of the site
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix string
+}
+
+func (pw *prefixWriter) WriteHeader(code int) {
+	h := pw.ResponseWriter.Header()
+	if loc := h.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		h.Set("Location", pw.prefix+loc)
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (pw *prefixWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// DefaultLocale puts the default locale in the request context, for the admin area which is only in English
+func DefaultLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(i18n.WithLocale(r.Context(), i18n.Default)))
+	})
+}
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !helpers.IsAuthenticated(r) {
@@ -33,3 +215,15 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// GuestAuth lets only guests logged in to their account through, staff logins don't count
+func GuestAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if helpers.GuestID(r) == 0 {
+			app.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Log in to see your bookings"))
+			http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,7 +2,13 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/flaviusp23/bookings/internal/currency"
+	"github.com/flaviusp23/bookings/internal/i18n"
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/property"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -28,3 +34,183 @@ func TestSessionLoad(t *testing.T) {
 		t.Errorf("type is not http.Handler but is %T", v)
 	}
 }
+
+func TestRequestLogger(t *testing.T) {
+	var myH myHandler
+	h := RequestLogger(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Errorf("type is not http.Handler but is %T", v)
+	}
+}
+
+func TestGuestAuth(t *testing.T) {
+	var myH myHandler
+	h := GuestAuth(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Errorf("type is not http.Handler but is %T", v)
+	}
+}
+
+func TestLocale(t *testing.T) {
+	var tests = []struct {
+		name           string
+		url            string
+		cookie         string
+		acceptLanguage string
+		want           i18n.Locale
+		setsCookie     bool
+	}{
+		{"default", "/", "", "", i18n.English, false},
+		{"accept language", "/", "", "ro-RO,ro;q=0.9,en;q=0.8", i18n.Romanian, false},
+		{"cookie beats the header", "/", "en", "ro-RO,ro;q=0.9", i18n.English, false},
+		{"url beats the cookie", "/?lang=ro", "en", "", i18n.Romanian, true},
+		{"unsupported url locale", "/?lang=xx", "ro", "", i18n.Romanian, false},
+		{"unsupported cookie", "/", "xx", "ro", i18n.Romanian, false},
+	}
+
+	for _, e := range tests {
+		var got i18n.Locale
+		h := Locale(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = i18n.FromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", e.url, nil)
+		if e.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: i18n.CookieName, Value: e.cookie})
+		}
+		if e.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", e.acceptLanguage)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if got != e.want {
+			t.Errorf("%s: expected locale %q but got %q", e.name, e.want, got)
+		}
+		if rr.Header().Get("Content-Language") != string(e.want) {
+			t.Errorf("%s: expected Content-Language %q but got %q", e.name, e.want, rr.Header().Get("Content-Language"))
+		}
+		cookies := rr.Result().Cookies()
+		if e.setsCookie != (len(cookies) == 1 && cookies[0].Value == string(e.want)) {
+			t.Errorf("%s: expected the locale cookie to be set %t, got %v", e.name, e.setsCookie, cookies)
+		}
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	app.Rates = currency.NewRates("RON")
+	app.Rates.Set(map[string]currency.Rate{"EUR": 201100, "USD": 220000})
+	defer func() { app.Rates = nil }()
+
+	var tests = []struct {
+		name       string
+		url        string
+		cookie     string
+		want       string
+		setsCookie bool
+	}{
+		{"default", "/", "", "RON", false},
+		{"cookie", "/", "EUR", "EUR", false},
+		{"url beats the cookie", "/?currency=usd", "EUR", "USD", true},
+		{"base currency from the url", "/?currency=RON", "EUR", "RON", true},
+		{"url currency without a rate", "/?currency=GBP", "EUR", "RON", false},
+		{"unknown url currency", "/?currency=xx", "EUR", "EUR", false},
+		{"cookie currency without a rate", "/", "JPY", "RON", false},
+	}
+
+	for _, e := range tests {
+		var got string
+		h := Currency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = currency.FromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", e.url, nil)
+		if e.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: currency.CookieName, Value: e.cookie})
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if got != e.want {
+			t.Errorf("%s: expected currency %q but got %q", e.name, e.want, got)
+		}
+		cookies := rr.Result().Cookies()
+		if e.setsCookie != (len(cookies) == 1 && cookies[0].Value == e.want) {
+			t.Errorf("%s: expected the currency cookie to be set %t, got %v", e.name, e.setsCookie, cookies)
+		}
+	}
+}
+
+func TestProperty(t *testing.T) {
+	app.Properties = property.NewDirectory("http://localhost:8080")
+	app.Properties.Set([]models.Property{
+		{ID: 1, Slug: "fort-smythe"},
+		{ID: 2, Slug: "lakeside", Host: "lakeside.example.com"},
+		{ID: 3, Slug: "hilltop"},
+	})
+	defer func() { app.Properties = nil }()
+
+	var tests = []struct {
+		name         string
+		host         string
+		url          string
+		redirect     string
+		wantID       int
+		wantPath     string
+		wantLocation string
+	}{
+		{"default", "localhost:8080", "/about", "/", 1, "/about", "/"},
+		{"host name", "lakeside.example.com", "/about", "/", 2, "/about", "/"},
+		{"path prefix", "localhost:8080", "/hilltop/about", "/make-reservation", 3, "/about", "/hilltop/make-reservation"},
+		{"prefix only", "localhost:8080", "/hilltop", "/", 3, "/", "/hilltop/"},
+		{"host beats prefix", "lakeside.example.com", "/hilltop/about", "/", 2, "/hilltop/about", "/"},
+		{"other site redirect", "localhost:8080", "/hilltop/about", "//example.com/", 3, "/about", "//example.com/"},
+		{"absolute redirect", "localhost:8080", "/hilltop/about", "https://example.com/", 3, "/about", "https://example.com/"},
+	}
+
+	for _, e := range tests {
+		var gotID int
+		var gotPath string
+		h := Property(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotID = property.FromContext(r.Context()).ID
+			gotPath = r.URL.Path
+			http.Redirect(w, r, e.redirect, http.StatusSeeOther)
+		}))
+
+		req := httptest.NewRequest("GET", e.url, nil)
+		req.Host = e.host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if gotID != e.wantID {
+			t.Errorf("%s: expected property %d but got %d", e.name, e.wantID, gotID)
+		}
+		if gotPath != e.wantPath {
+			t.Errorf("%s: expected path %q but got %q", e.name, e.wantPath, gotPath)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.wantLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.wantLocation, loc)
+		}
+	}
+}
+
+func TestPropertyNone(t *testing.T) {
+	app.Properties = property.NewDirectory("http://localhost:8080")
+	defer func() { app.Properties = nil }()
+
+	var myH myHandler
+	rr := httptest.NewRecorder()
+	Property(&myH).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected code %d without properties but got %d", http.StatusNotFound, rr.Code)
+	}
+}
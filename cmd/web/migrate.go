@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/flaviusp23/bookings/internal/driver"
+	"github.com/flaviusp23/bookings/internal/migrate"
+	"github.com/flaviusp23/bookings/migrations"
+)
+
+const migrateUsage = `usage: bookings migrate [flags] <command>
+
+commands:
+  up        apply all pending migrations
+  down N    roll back the last N migrations (default 1)
+  status    list migrations and whether they are applied
+
+flags:
+`
+
+// migrateCommand runs the migrate subcommand
+func migrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbc := addDBFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !dbc.valid() || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	db, err := driver.ConnectSQL(dbc.dsn())
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	m, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := m.Up()
+		for _, mg := range applied {
+			fmt.Printf("applied %d_%s\n", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+
+	case "down":
+		n := 1
+		if fs.NArg() > 1 {
+			n, err = strconv.Atoi(fs.Arg(1))
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", fs.Arg(1))
+			}
+		}
+		rolledBack, err := m.Down(n)
+		for _, mg := range rolledBack {
+			fmt.Printf("rolled back %d_%s\n", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-19s %d_%s\n", applied, s.Version, s.Name)
+		}
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return nil
+}
+
+// checkSchema makes sure the database has every embedded migration applied before the server starts.
+// With autoMigrate the pending migrations are applied, otherwise the server refuses to start.
+func checkSchema(db *driver.DB, autoMigrate bool) error {
+	m, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	if autoMigrate {
+		applied, err := m.Up()
+		for _, mg := range applied {
+			app.Logger.Info("Applied migration", "version", mg.Version, "name", mg.Name)
+		}
+		return err
+	}
+
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind by %d migration(s); run \"bookings migrate up\" or start with -automigrate", len(pending))
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/handlers"
+)
+
+// propertiesRefreshInterval is how often the properties are read again, to pick up the ones added or changed from the
+// command line
+const propertiesRefreshInterval = 5 * time.Minute
+
+// refreshProperties reads the properties again every interval in the background. They are first loaded on start, as
+// no request can be served without them.
+func refreshProperties(repo *handlers.Repository, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := repo.LoadProperties()
+			if err != nil {
+				app.Logger.Error("can't load properties", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/handlers"
+)
+
+// ratesRefreshInterval is how often the exchange rates are read again, to pick up the ones imported from the command
+// line
+const ratesRefreshInterval = 10 * time.Minute
+
+// refreshRates loads the exchange rates on start and then every interval in the background. Until they are loaded,
+// prices are only shown in the base currency.
+func refreshRates(repo *handlers.Repository, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			err := repo.LoadExchangeRates()
+			if err != nil {
+				app.Logger.Error("can't load exchange rates", "error", err)
+			}
+
+			<-ticker.C
+		}
+	}()
+}
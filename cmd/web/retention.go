@@ -0,0 +1,37 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/repository"
+)
+
+// retentionInterval is how often the retention policy is applied
+const retentionInterval = 24 * time.Hour
+
+// enforceRetention anonymises the reservations of every property that ended more than years ago in the background, on
+// start and then every interval, so personal data isn't kept longer than the accounts need it
+func enforceRetention(repo repository.DatabaseRepo, years int, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			cutoff := time.Now().AddDate(-years, 0, 0).Truncate(24 * time.Hour)
+			for _, p := range app.Properties.All() {
+				n, err := repo.ForProperty(p.ID).AnonymiseReservationsBefore(cutoff, models.PrivacyLogEntry{
+					Action:  models.PrivacyRetention,
+					Subject: "before " + cutoff.Format("2006-01-02"),
+				})
+				if err != nil {
+					app.Logger.Error("can't apply the retention policy", "property", p.Slug, "error", err)
+				} else if n > 0 {
+					app.Logger.Info("Anonymised personal data past the retention period", "property", p.Slug, "count", n, "cutoff", cutoff)
+				}
+			}
+
+			<-ticker.C
+		}
+	}()
+}
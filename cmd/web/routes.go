@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/flaviusp23/bookings/internal/handlers"
+	"github.com/flaviusp23/bookings/internal/metrics"
+	"github.com/flaviusp23/bookings/internal/payments"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -12,9 +14,23 @@ import (
 func routes() http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(middleware.RequestID)
+	mux.Use(Metrics)
 	mux.Use(middleware.Recoverer)
+	mux.Use(Property)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(RequestLogger)
+	mux.Use(Locale)
+	mux.Use(Currency)
+
+	mux.Get("/healthz", handlers.Repo.Healthz)
+	mux.Get("/readyz", handlers.Repo.Readyz)
+
+	// without a separate listener, metrics are only served on the main port when protected by a token
+	if app.MetricsAddr == "" && app.MetricsToken != "" {
+		mux.Handle("/metrics", metrics.Handler(app.MetricsToken))
+	}
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
@@ -25,36 +41,103 @@ func routes() http.Handler {
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	mux.Post("/search-availability-flexible", handlers.Repo.PostFlexibleAvailability)
+	mux.Get("/search-availability-flexible-json", handlers.Repo.FlexibleAvailabilityJSON)
+	mux.Get("/rooms/{id}/availability", handlers.Repo.RoomAvailabilityCalendar)
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Get("/choose-rooms", handlers.Repo.ChooseRooms)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
+	mux.Post("/waitlist", handlers.Repo.JoinWaitlist)
+	mux.Get("/waitlist/claim", handlers.Repo.ClaimWaitlist)
+	mux.Post("/waitlist/claim", handlers.Repo.PostClaimWaitlist)
+	mux.Post("/waitlist/decline", handlers.Repo.PostDeclineWaitlist)
 
 	mux.Get("/contact", handlers.Repo.Contact)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/reservations/cancel", handlers.Repo.CancelReservation)
+	mux.Post("/reservations/cancel", handlers.Repo.PostCancelReservation)
+
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+	mux.Get("/payments/done", handlers.Repo.PaymentDone)
+
+	// the fake provider's checkout page is served by the site itself
+	if _, ok := app.Payments.(*payments.FakeProvider); ok {
+		mux.Get("/payments/fake/{id}", handlers.Repo.FakeCheckout)
+		mux.Post("/payments/fake/{id}", handlers.Repo.PostFakeCheckout)
+	}
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
+	mux.Get("/account/login", handlers.Repo.GuestLogin)
+	mux.Post("/account/login", handlers.Repo.PostGuestLogin)
+	mux.Get("/account/login/verify", handlers.Repo.GuestLoginLink)
+	mux.Post("/account/login/verify", handlers.Repo.PostGuestLoginLink)
+	mux.Get("/account/logout", handlers.Repo.GuestLogout)
+
+	mux.Route("/account", func(mux chi.Router) {
+		mux.Use(GuestAuth)
+
+		mux.Get("/", handlers.Repo.GuestAccount)
+		mux.Post("/", handlers.Repo.PostGuestAccount)
+		mux.Get("/reservations/{id}/invoice", handlers.Repo.GuestReservationInvoice)
+	})
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(Auth)
+		mux.Use(handlers.Repo.AdminProperty)
+		mux.Use(DefaultLocale)
 
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Post("/property", handlers.Repo.AdminSwitchProperty)
 
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Post("/reservations/{src}/{id}/balance-link", handlers.Repo.AdminSendBalanceLink)
+		mux.Get("/reservations/{src}/{id}/cancel", handlers.Repo.AdminCancelReservation)
+		mux.Get("/reservations/{src}/{id}/invoice", handlers.Repo.AdminReservationInvoice)
+		mux.Get("/reservations/{src}/{id}/confirmation", handlers.Repo.AdminReservationConfirmation)
+		mux.Post("/reservations/{src}/{id}/cancel", handlers.Repo.AdminPostCancelReservation)
+		mux.Post("/reservations/{src}/{id}/delete", handlers.Repo.AdminDeleteReservation)
+
+		mux.Get("/stay-rules", handlers.Repo.AdminStayRules)
+		mux.Get("/stay-rules/{id}", handlers.Repo.AdminShowStayRule)
+		mux.Post("/stay-rules/{id}", handlers.Repo.AdminPostStayRule)
+		mux.Post("/stay-rules/{id}/delete", handlers.Repo.AdminDeleteStayRule)
+		mux.Get("/charges", handlers.Repo.AdminCharges)
+		mux.Get("/charges/{id}", handlers.Repo.AdminShowCharge)
+		mux.Post("/charges/{id}", handlers.Repo.AdminPostCharge)
+		mux.Post("/charges/{id}/delete", handlers.Repo.AdminDeleteCharge)
+		mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		mux.Get("/promo-codes/{id}", handlers.Repo.AdminShowPromoCode)
+		mux.Post("/promo-codes/{id}", handlers.Repo.AdminPostPromoCode)
+		mux.Post("/promo-codes/{id}/delete", handlers.Repo.AdminDeletePromoCode)
+		mux.Get("/guests", handlers.Repo.AdminGuests)
+		mux.Get("/guests/{id}", handlers.Repo.AdminShowGuest)
+		mux.Post("/guests/{id}", handlers.Repo.AdminPostGuest)
+		mux.Post("/guests/{id}/merge", handlers.Repo.AdminMergeGuest)
+		mux.Get("/exchange-rates", handlers.Repo.AdminExchangeRates)
+		mux.Post("/exchange-rates", handlers.Repo.AdminPostExchangeRate)
+		mux.Post("/exchange-rates/{currency}/delete", handlers.Repo.AdminDeleteExchangeRate)
+		mux.Get("/privacy", handlers.Repo.AdminPrivacy)
+		mux.Post("/privacy/export", handlers.Repo.AdminPrivacyExport)
+		mux.Post("/privacy/erase", handlers.Repo.AdminPrivacyErase)
+
+		mux.Get("/sessions", handlers.Repo.AdminSessions)
+		mux.Post("/sessions/revoke", handlers.Repo.AdminRevokeSessions)
 	})
 
 	return mux
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/flaviusp23/bookings/internal/payments"
+	"github.com/flaviusp23/bookings/internal/property"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -17,3 +21,47 @@ func TestRoutes(t *testing.T) {
 		t.Errorf("type is not *chi.Mux, but is %T", v)
 	}
 }
+
+// TestRoutesFakePayments checks that the fake provider's checkout page is only served when it is the provider
+func TestRoutesFakePayments(t *testing.T) {
+	defer func(p payments.PaymentProvider) { app.Payments = p }(app.Payments)
+
+	for _, e := range []struct {
+		name     string
+		provider payments.PaymentProvider
+		expected bool
+	}{
+		{"no provider", nil, false},
+		{"fake provider", payments.NewFakeProvider("", []byte("secret")), true},
+	} {
+		app.Payments = e.provider
+
+		served := false
+		err := chi.Walk(routes().(*chi.Mux), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			if strings.HasPrefix(route, "/payments/fake/") {
+				served = true
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if served != e.expected {
+			t.Errorf("%s: expected the fake checkout page served %t, but got %t", e.name, e.expected, served)
+		}
+	}
+}
+
+// TestRoutesReserved checks that no property slug can hide a page, as a property's path prefix is matched first
+func TestRoutesReserved(t *testing.T) {
+	err := chi.Walk(routes().(*chi.Mux), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+		if segment != "" && property.ValidSlug(segment) {
+			t.Errorf("route %s %s can be hidden by a property with the slug %q", method, route, segment)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -3,24 +3,30 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
 	"strings"
 	"time"
 
+	"github.com/flaviusp23/bookings/internal/metrics"
 	"github.com/flaviusp23/bookings/internal/models"
 	mail "github.com/xhit/go-simple-mail/v2"
 )
 
 func listenForMail() {
+	app.MailRunning.Store(true)
 	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
+		defer app.MailRunning.Store(false)
+		for msg := range app.MailChan {
+			err := sendMsg(msg)
+			if err != nil {
+				metrics.MailFailures.Inc()
+				app.Logger.Error("can't send email", "to", msg.To, "subject", msg.Subject, "error", err)
+			}
 		}
 	}() // a function that will listen in background all the time
 }
 
-func sendMsg(m models.MailData) {
+// sendMsg sends one email through the mail server
+func sendMsg(m models.MailData) error {
 	server := mail.NewSMTPClient()
 	server.Host = "localhost"
 	server.Port = 1025
@@ -30,7 +36,7 @@ func sendMsg(m models.MailData) {
 
 	client, err := server.Connect()
 	if err != nil {
-		errorLog.Println(err)
+		return err
 	}
 
 	email := mail.NewMSG()
@@ -40,17 +46,22 @@ func sendMsg(m models.MailData) {
 	} else {
 		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
 		if err != nil {
-			errorLog.Println(err)
+			return err
 		}
 		mailTemplate := string(data)
 		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
 		email.SetBody(mail.TextHTML, msgToSend)
 
 	}
+	for _, a := range m.Attachments {
+		email.Attach(&mail.File{Name: a.Name, MimeType: a.ContentType, Data: a.Data})
+	}
 
 	err = email.Send(client)
 	if err != nil {
-		errorLog.Println(err)
+		return err
 	}
-	log.Println("Email sent!")
+	metrics.MailSent.Inc()
+	app.Logger.Info("Email sent", "to", m.To, "subject", m.Subject)
+	return nil
 }
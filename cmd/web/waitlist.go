@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/repository"
+	"github.com/flaviusp23/bookings/internal/waitlist"
+)
+
+// waitlistOfferInterval is how often expired waitlist offers are passed on
+const waitlistOfferInterval = 5 * time.Minute
+
+// expireWaitlistOffers passes the rooms offered to waiting guests who let their claim link expire on to the next
+// guests waiting, for every property, in the background
+func expireWaitlistOffers(repo repository.DatabaseRepo, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, p := range app.Properties.All() {
+				n := &waitlist.Notifier{
+					DB:      repo.ForProperty(p.ID),
+					Quotes:  app.Quotes,
+					BaseURL: app.Properties.URL(p),
+					From:    p.Email,
+					Send:    func(msg models.MailData) { app.MailChan <- msg },
+				}
+				notified, err := n.ExpireOffers()
+				if err != nil {
+					app.Logger.Error("can't pass on expired waitlist offers", "property", p.Slug, "error", err)
+				} else if notified > 0 {
+					app.Logger.Info("Passed expired waitlist offers on", "property", p.Slug, "count", notified)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,118 @@
+// Package cancellation computes what guests get back when they cancel, under the cancellation policy of their rooms.
+package cancellation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/i18n"
+)
+
+// Cancellation policies, from the most to the least generous
+const (
+	Flexible = "flexible"
+	Moderate = "moderate"
+	Strict   = "strict"
+)
+
+// Default is the policy of rooms that were not given one
+const Default = Moderate
+
+// Policies lists the policies, from the most to the least generous
+var Policies = []string{Flexible, Moderate, Strict}
+
+// tier refunds percent of what was paid to guests cancelling at least notice before arrival
+type tier struct {
+	notice  time.Duration
+	percent int
+}
+
+// tiers are the refund tiers of each policy, longest notice first
+var tiers = map[string][]tier{
+	Flexible: {{24 * time.Hour, 100}},
+	Moderate: {{5 * 24 * time.Hour, 100}, {24 * time.Hour, 50}},
+	Strict:   {{7 * 24 * time.Hour, 50}},
+}
+
+// Valid reports whether policy is a known cancellation policy
+func Valid(policy string) bool {
+	_, ok := tiers[policy]
+	return ok
+}
+
+// Refund returns how much of paid is refunded under policy to a guest cancelling at now for an arrival at arrival.
+// Unknown policies are treated as the default.
+func Refund(policy string, paid int, arrival, now time.Time) int {
+	if !Valid(policy) {
+		policy = Default
+	}
+	notice := arrival.Sub(now)
+	for _, t := range tiers[policy] {
+		if notice >= t.notice {
+			return paid * t.percent / 100
+		}
+	}
+	return 0
+}
+
+// Strictest returns the least generous of policies, so a reservation of several rooms follows the strictest room
+func Strictest(policies ...string) string {
+	strictest := -1
+	for _, p := range policies {
+		for i, known := range Policies {
+			if p == known && i > strictest {
+				strictest = i
+			}
+		}
+	}
+	if strictest < 0 {
+		return Default
+	}
+	return Policies[strictest]
+}
+
+// Describe explains policy to guests in English, e.g. in the confirmation email
+func Describe(policy string) string {
+	return DescribeIn(i18n.Default, policy)
+}
+
+// DescribeIn explains policy to guests in locale l
+func DescribeIn(l i18n.Locale, policy string) string {
+	if !Valid(policy) {
+		policy = Default
+	}
+	var parts []string
+	for _, t := range tiers[policy] {
+		parts = append(parts, l.T("%d%% of what you paid is refunded if you cancel at least %s before arrival",
+			t.percent, notice(l, t.notice)))
+	}
+	return l.T("%s: %s, nothing after that.", l.T(strings.ToUpper(policy[:1])+policy[1:]), strings.Join(parts, ", "))
+}
+
+// notice formats a notice period in days or hours
+func notice(l i18n.Locale, d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		days := int(d / (24 * time.Hour))
+		if days == 1 {
+			return l.T("1 day")
+		}
+		return l.T("%d days", days)
+	}
+	return l.T("%d hours", int(d.Hours()))
+}
+
+// HashKey returns the hash of a cancellation key, which is all the database keeps of it
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKey returns a random key for the cancellation link emailed to the guest
+func NewKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,65 @@
+package cancellation
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefund(t *testing.T) {
+	arrival := time.Date(2040, 6, 10, 0, 0, 0, 0, time.UTC)
+	daysBefore := func(days float64) time.Time {
+		return arrival.Add(-time.Duration(days * 24 * float64(time.Hour)))
+	}
+
+	tests := []struct {
+		policy string
+		now    time.Time
+		refund int
+	}{
+		{Flexible, daysBefore(30), 3000},
+		{Flexible, daysBefore(1), 3000},
+		{Flexible, daysBefore(0.5), 0},
+		{Moderate, daysBefore(5), 3000},
+		{Moderate, daysBefore(4), 1500},
+		{Moderate, daysBefore(0.5), 0},
+		{Strict, daysBefore(30), 1500},
+		{Strict, daysBefore(6), 0},
+		{Strict, daysBefore(-1), 0},
+		{"unknown", daysBefore(4), 1500},
+	}
+	for _, e := range tests {
+		if got := Refund(e.policy, 3000, arrival, e.now); got != e.refund {
+			t.Errorf("Refund(%s) %s before arrival = %d, want %d", e.policy, arrival.Sub(e.now), got, e.refund)
+		}
+	}
+}
+
+func TestStrictest(t *testing.T) {
+	tests := []struct {
+		policies []string
+		want     string
+	}{
+		{[]string{Flexible, Strict, Moderate}, Strict},
+		{[]string{Flexible, Flexible}, Flexible},
+		{[]string{Flexible, ""}, Flexible},
+		{nil, Default},
+	}
+	for _, e := range tests {
+		if got := Strictest(e.policies...); got != e.want {
+			t.Errorf("Strictest(%v) = %s, want %s", e.policies, got, e.want)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	got := Describe(Moderate)
+	want := "Moderate: 100% of what you paid is refunded if you cancel at least 5 days before arrival, 50% of what you " +
+		"paid is refunded if you cancel at least 1 day before arrival, nothing after that."
+	if got != want {
+		t.Errorf("Describe(moderate) = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(Describe("unknown"), "Moderate") {
+		t.Error("expected unknown policies to be described as the default")
+	}
+}
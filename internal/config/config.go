@@ -2,18 +2,32 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
+	"sync/atomic"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/flaviusp23/bookings/internal/currency"
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/payments"
+	"github.com/flaviusp23/bookings/internal/property"
+	"github.com/flaviusp23/bookings/internal/quote"
 )
 
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache       bool
+	TemplateCache  map[string]*template.Template
+	Logger         *slog.Logger
+	InProduction   bool
+	Session        *scs.SessionManager
+	MailChan       chan models.MailData
+	MetricsToken   string
+	MetricsAddr    string
+	MailRunning    atomic.Bool
+	ShuttingDown   atomic.Bool
+	Quotes         *quote.Signer
+	BaseURL        string
+	Payments       payments.PaymentProvider
+	DepositPercent int
+	Properties     *property.Directory
+	Rates          *currency.Rates
 }
@@ -0,0 +1,241 @@
+// Package currency converts and formats amounts of money. Amounts are integers in the minor unit of their currency,
+// e.g. cents, and exchange rates are integers too, so an amount never goes through a float.
+package currency
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	cldr "golang.org/x/text/currency"
+)
+
+// Param is the URL parameter that switches the display currency, e.g. ?currency=EUR, and CookieName the cookie that
+// remembers it
+const (
+	Param      = "currency"
+	CookieName = "currency"
+)
+
+// Rate is how much of a currency one unit of the base currency buys, in millionths, e.g. 201100 for 1 RON = 0.2011 EUR
+type Rate int64
+
+// RateScale is the Rate of one unit, the rate of the base currency to itself
+const RateScale Rate = 1_000_000
+
+// rateDecimals is the number of decimals a Rate keeps
+const rateDecimals = 6
+
+// ErrInvalidRate is returned for rates that are not positive numbers with at most six decimals
+var ErrInvalidRate = errors.New("a rate must be a positive number with at most 6 decimals, e.g. 0.2011")
+
+// Parse returns the ISO 4217 code of a currency, e.g. EUR for eur
+func Parse(s string) (string, bool) {
+	u, err := cldr.ParseISO(strings.TrimSpace(s))
+	if err != nil {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// Exponent returns the number of decimals of the minor unit of a currency, e.g. 2 for EUR and 0 for JPY. Unknown
+// currencies have 2.
+func Exponent(code string) int {
+	u, err := cldr.ParseISO(code)
+	if err != nil {
+		return 2
+	}
+	scale, _ := cldr.Standard.Rounding(u)
+	return scale
+}
+
+// ParseRate reads a rate written as a decimal number, e.g. 0.2011
+func ParseRate(s string) (Rate, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if whole == "" && frac == "" || len(whole) > 9 || len(frac) > rateDecimals || !digits(whole) || !digits(frac) {
+		return 0, ErrInvalidRate
+	}
+
+	n, err := strconv.ParseInt("0"+whole+frac+strings.Repeat("0", rateDecimals-len(frac)), 10, 64)
+	if err != nil || n == 0 {
+		return 0, ErrInvalidRate
+	}
+	return Rate(n), nil
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String writes r as a decimal number without trailing zeros, e.g. 0.2011
+func (r Rate) String() string {
+	s := fmt.Sprintf("%d.%06d", r/RateScale, r%RateScale)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// Convert converts an amount in the minor unit of from to the minor unit of to at rate, rounding half to even so
+// conversions are not biased up or down
+func Convert(amount int, from, to string, rate Rate) int {
+	num := big.NewInt(int64(amount))
+	num.Mul(num, big.NewInt(int64(rate)))
+	num.Mul(num, pow10(Exponent(to)))
+	den := new(big.Int).Mul(big.NewInt(int64(RateScale)), pow10(Exponent(from)))
+
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	switch new(big.Int).Abs(m.Mul(m, big.NewInt(2))).Cmp(den) {
+	case 1:
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(int64(num.Sign())))
+		}
+	}
+	return int(q.Int64())
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Format writes an amount in the minor unit of a currency with the currency code, e.g. 12550 EUR as 125.50 EUR
+func Format(amount int, code string) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	exp := Exponent(code)
+	if exp == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, code)
+	}
+	unit := int(pow10(exp).Int64())
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, exp, amount%unit, code)
+}
+
+// Display writes an amount in the base currency followed by its approximate value in code at rate, e.g.
+// 125.50 RON (≈ 25.24 EUR). Without a rate, or when code is the base currency, only the base amount is written.
+func Display(amount int, base, code string, rate Rate) string {
+	if code == "" || code == base || rate <= 0 {
+		return Format(amount, base)
+	}
+	return fmt.Sprintf("%s (≈ %s)", Format(amount, base), Format(Convert(amount, base, code, rate), code))
+}
+
+// Rates are the exchange rates from the base currency to the currencies prices can be displayed in. They are safe
+// for concurrent use.
+type Rates struct {
+	base  string
+	mu    sync.RWMutex
+	rates map[string]Rate
+}
+
+// NewRates returns the rates of base, which has none but itself until they are Set
+func NewRates(base string) *Rates {
+	return &Rates{base: base, rates: map[string]Rate{}}
+}
+
+// Base returns the currency prices are set and stored in
+func (r *Rates) Base() string {
+	return r.base
+}
+
+// Set replaces the rates
+func (r *Rates) Set(rates map[string]Rate) {
+	m := make(map[string]Rate, len(rates))
+	for code, rate := range rates {
+		if code != r.base && rate > 0 {
+			m[code] = rate
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rates = m
+}
+
+// Get returns the rate of a currency. The base currency always has one.
+func (r *Rates) Get(code string) (Rate, bool) {
+	if code == r.base {
+		return RateScale, true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rate, ok := r.rates[code]
+	return rate, ok
+}
+
+// Codes returns the currencies with a rate, the base first and then alphabetically
+func (r *Rates) Codes() []string {
+	r.mu.RLock()
+	codes := make([]string, 0, len(r.rates))
+	for code := range r.rates {
+		codes = append(codes, code)
+	}
+	r.mu.RUnlock()
+
+	sort.Strings(codes)
+	return append([]string{r.base}, codes...)
+}
+
+// ParseRates reads a rates file: a currency code and its rate on each line, separated by a comma, e.g. EUR,0.2011.
+// Blank lines, lines starting with # and a currency,rate header are skipped.
+func ParseRates(in io.Reader) (map[string]Rate, error) {
+	r := csv.NewReader(in)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	rates := map[string]Rate{}
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "currency") {
+			continue
+		}
+		code, ok := Parse(record[0])
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown currency %q", line, record[0])
+		}
+		if _, ok := rates[code]; ok {
+			return nil, fmt.Errorf("line %d: %s is listed twice", line, code)
+		}
+		rate, err := ParseRate(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rates[code] = rate
+	}
+}
+
+type contextKey struct{}
+
+// WithCurrency returns a copy of ctx that carries the display currency code
+func WithCurrency(ctx context.Context, code string) context.Context {
+	return context.WithValue(ctx, contextKey{}, code)
+}
+
+// FromContext returns the display currency of ctx, or an empty string when it has none
+func FromContext(ctx context.Context) string {
+	code, _ := ctx.Value(contextKey{}).(string)
+	return code
+}
@@ -0,0 +1,199 @@
+package currency
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"EUR", "EUR", true},
+		{" eur ", "EUR", true},
+		{"JPY", "JPY", true},
+		{"XYZ", "", false},
+		{"EURO", "", false},
+		{"", "", false},
+	}
+
+	for _, e := range tests {
+		got, ok := Parse(e.in)
+		if got != e.want || ok != e.ok {
+			t.Errorf("Parse(%q) = %q, %t, want %q, %t", e.in, got, ok, e.want, e.ok)
+		}
+	}
+}
+
+func TestExponent(t *testing.T) {
+	for code, want := range map[string]int{"RON": 2, "EUR": 2, "JPY": 0, "BHD": 3, "XYZ": 2} {
+		if got := Exponent(code); got != want {
+			t.Errorf("Exponent(%s) = %d, want %d", code, got, want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want Rate
+		ok   bool
+	}{
+		{"0.2011", 201100, true},
+		{"4.976543", 4976543, true},
+		{"31", 31000000, true},
+		{".5", 500000, true},
+		{"1.", 1000000, true},
+		{"0", 0, false},
+		{"0.0000001", 0, false},
+		{"-1", 0, false},
+		{"1,5", 0, false},
+		{"1e3", 0, false},
+		{"1234567890", 0, false},
+		{".", 0, false},
+		{"", 0, false},
+	}
+
+	for _, e := range tests {
+		got, err := ParseRate(e.in)
+		if got != e.want || (err == nil) != e.ok {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d, ok %t", e.in, got, err, e.want, e.ok)
+		}
+	}
+
+	for in, want := range map[Rate]string{201100: "0.2011", 31000000: "31", 1: "0.000001"} {
+		if got := in.String(); got != want {
+			t.Errorf("Rate(%d).String() = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	var tests = []struct {
+		amount int
+		from   string
+		to     string
+		rate   Rate
+		want   int
+	}{
+		{12550, "RON", "EUR", 201100, 2524},   // 25.238 rounds up
+		{10000, "RON", "EUR", 200000, 2000},   // exact
+		{25, "RON", "EUR", 500000, 12},        // 12.5 rounds to even
+		{35, "RON", "EUR", 500000, 18},        // 17.5 rounds to even
+		{-25, "RON", "EUR", 500000, -12},      // negative amounts round the same way
+		{-12550, "RON", "EUR", 201100, -2524}, // and away from zero past the half
+		{12550, "RON", "JPY", 32500000, 4079}, // 4078.75 yen
+		{12550, "RON", "BHD", 82000, 10291},   // 10.291 dinars
+		{99999999, "RON", "EUR", RateScale, 99999999},
+	}
+
+	for _, e := range tests {
+		if got := Convert(e.amount, e.from, e.to, e.rate); got != e.want {
+			t.Errorf("Convert(%d, %s, %s, %s) = %d, want %d", e.amount, e.from, e.to, e.rate, got, e.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		amount int
+		code   string
+		want   string
+	}{
+		{12550, "RON", "125.50 RON"},
+		{5, "EUR", "0.05 EUR"},
+		{-2050, "EUR", "-20.50 EUR"},
+		{4079, "JPY", "4079 JPY"},
+		{10291, "BHD", "10.291 BHD"},
+	}
+
+	for _, e := range tests {
+		if got := Format(e.amount, e.code); got != e.want {
+			t.Errorf("Format(%d, %s) = %q, want %q", e.amount, e.code, got, e.want)
+		}
+	}
+
+	if got := Display(12550, "RON", "EUR", 201100); got != "125.50 RON (≈ 25.24 EUR)" {
+		t.Errorf("expected both currencies, got %q", got)
+	}
+	for _, code := range []string{"", "RON"} {
+		if got := Display(12550, "RON", code, 201100); got != "125.50 RON" {
+			t.Errorf("expected only the base currency for %q, got %q", code, got)
+		}
+	}
+	if got := Display(12550, "RON", "EUR", 0); got != "125.50 RON" {
+		t.Errorf("expected only the base currency without a rate, got %q", got)
+	}
+}
+
+func TestRates(t *testing.T) {
+	rates := NewRates("RON")
+	if got := rates.Codes(); !slices.Equal(got, []string{"RON"}) {
+		t.Errorf("expected only the base currency before rates are set, got %v", got)
+	}
+
+	rates.Set(map[string]Rate{"USD": 220000, "EUR": 201100, "RON": 5, "GBP": 0})
+	if got := rates.Codes(); !slices.Equal(got, []string{"RON", "EUR", "USD"}) {
+		t.Errorf("Codes() = %v, want the base then the others in order", got)
+	}
+	if rate, ok := rates.Get("RON"); !ok || rate != RateScale {
+		t.Errorf("expected the base to have a rate of 1, got %s, %t", rate, ok)
+	}
+	if rate, ok := rates.Get("EUR"); !ok || rate != 201100 {
+		t.Errorf("expected the EUR rate, got %s, %t", rate, ok)
+	}
+	if _, ok := rates.Get("GBP"); ok {
+		t.Error("expected a zero rate to be dropped")
+	}
+
+	rates.Set(nil)
+	if _, ok := rates.Get("EUR"); ok {
+		t.Error("expected Set to replace the rates")
+	}
+}
+
+func TestParseRates(t *testing.T) {
+	in := `currency,rate
+# rates of 18 October 2026
+EUR,0.2011
+
+usd, 0.22
+JPY,32.5
+`
+	rates, err := ParseRates(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 3 || rates["EUR"] != 201100 || rates["USD"] != 220000 || rates["JPY"] != 32500000 {
+		t.Errorf("unexpected rates %v", rates)
+	}
+
+	var tests = []struct {
+		in  string
+		err string
+	}{
+		{"EUR,0.2011\nXYZ,1", "line 2: unknown currency"},
+		{"EUR,0.2011\nEUR,0.2", "line 2: EUR is listed twice"},
+		{"EUR,abc", "line 1: a rate must be"},
+		{"EUR,0.2011,extra", "wrong number of fields"},
+	}
+	for _, e := range tests {
+		_, err := ParseRates(strings.NewReader(e.in))
+		if err == nil || !strings.Contains(err.Error(), e.err) {
+			t.Errorf("ParseRates(%q) = %v, want an error with %q", e.in, err, e.err)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != "" {
+		t.Errorf("expected no currency, got %q", got)
+	}
+	if got := FromContext(WithCurrency(context.Background(), "EUR")); got != "EUR" {
+		t.Errorf("expected EUR, got %q", got)
+	}
+}
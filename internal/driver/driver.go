@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -36,6 +37,11 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return dbConn, nil
 }
 
+// Ping checks that the database is reachable
+func (d *DB) Ping(ctx context.Context) error {
+	return d.SQL.PingContext(ctx)
+}
+
 func testDB(d *sql.DB) error {
 	err := d.Ping()
 	if err != nil {
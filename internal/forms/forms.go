@@ -1,22 +1,25 @@
 package forms
 
 import (
-	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
+	"github.com/flaviusp23/bookings/internal/i18n"
 )
 
 type Form struct {
 	url.Values
 	Errors errors
+	// Locale is the language of the error messages, English when not set
+	Locale i18n.Locale
 }
 
 func New(data url.Values) *Form {
 	return &Form{
-		data,
-		errors(map[string][]string{}),
+		Values: data,
+		Errors: errors(map[string][]string{}),
 	}
 }
 
@@ -24,7 +27,7 @@ func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
 		value := f.Get(field)
 		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
+			f.Errors.Add(field, f.Locale.T("This field cannot be blank"))
 		}
 	}
 }
@@ -42,7 +45,7 @@ func (f *Form) Valid() bool {
 func (f *Form) MinLength(field string, length int) bool {
 	x := f.Get(field)
 	if len(x) < length {
-		f.Errors.Add(field, fmt.Sprintf("This field must be at least %d characters long", length))
+		f.Errors.Add(field, f.Locale.T("This field must be at least %d characters long", length))
 		return false
 	}
 	return true
@@ -50,6 +53,60 @@ func (f *Form) MinLength(field string, length int) bool {
 
 func (f *Form) IsEmail(field string) {
 	if !govalidator.IsEmail(f.Get(field)) {
-		f.Errors.Add(field, "Invalid email address")
+		f.Errors.Add(field, f.Locale.T("Invalid email address"))
 	}
 }
+
+// IsInt checks that the field is a whole number
+func (f *Form) IsInt(field string) bool {
+	_, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil {
+		f.Errors.Add(field, f.Locale.T("This field must be a whole number"))
+		return false
+	}
+	return true
+}
+
+// IntRange checks that the field is a whole number between min and max, inclusive
+func (f *Form) IntRange(field string, min, max int) bool {
+	x, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil {
+		f.Errors.Add(field, f.Locale.T("This field must be a whole number"))
+		return false
+	}
+	if x < min || x > max {
+		f.Errors.Add(field, f.Locale.T("This field must be between %d and %d", min, max))
+		return false
+	}
+	return true
+}
+
+// Hundredths checks that the field is an amount with at most two decimals, like 12.50, and returns it in
+// hundredths, e.g. 1250
+func (f *Form) Hundredths(field string) (int, bool) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(f.Get(field)), ".")
+	if !digits(whole) || len(frac) > 2 || (frac != "" && !digits(frac)) {
+		f.Errors.Add(field, f.Locale.T("This field must be an amount like 12.50"))
+		return 0, false
+	}
+	w, err := strconv.Atoi(whole)
+	if err != nil {
+		f.Errors.Add(field, f.Locale.T("This field must be an amount like 12.50"))
+		return 0, false
+	}
+	cents, _ := strconv.Atoi(frac + strings.Repeat("0", 2-len(frac)))
+	return w*100 + cents, true
+}
+
+// digits reports whether s is a non-empty run of decimal digits
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
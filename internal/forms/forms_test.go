@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+
+	"github.com/flaviusp23/bookings/internal/i18n"
 )
 
 func TestForm_Valid(t *testing.T) {
@@ -126,3 +128,107 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("got valid for invalid email address")
 	}
 }
+
+func TestForm_IsInt(t *testing.T) {
+	postedValues := url.Values{}
+	form := New(postedValues)
+
+	form.IsInt("x")
+	if form.Valid() {
+		t.Error("form shows valid number for non-existent field")
+	}
+
+	postedValues = url.Values{}
+	postedValues.Add("adults", "2")
+	form = New(postedValues)
+
+	form.IsInt("adults")
+	if !form.Valid() {
+		t.Error("got an invalid number when we should not have")
+	}
+
+	postedValues = url.Values{}
+	postedValues.Add("adults", "two")
+	form = New(postedValues)
+
+	form.IsInt("adults")
+	if form.Valid() {
+		t.Error("got valid for a number that is not whole")
+	}
+}
+
+func TestForm_IntRange(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("children", "3")
+	form := New(postedValues)
+
+	form.IntRange("children", 0, 10)
+	if !form.Valid() {
+		t.Error("got out of range when the number is in range")
+	}
+
+	form.IntRange("children", 4, 10)
+	if form.Valid() {
+		t.Error("got in range when the number is below the minimum")
+	}
+
+	form = New(postedValues)
+	form.IntRange("children", 0, 2)
+	if form.Errors.Get("children") == "" {
+		t.Error("should have error for a number above the maximum but did not get one")
+	}
+
+	form = New(url.Values{})
+	form.IntRange("children", 0, 10)
+	if form.Valid() {
+		t.Error("form shows in range for non-existent field")
+	}
+}
+
+func TestForm_Hundredths(t *testing.T) {
+	var tests = []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"12.50", 1250, true},
+		{"12.5", 1250, true},
+		{"12", 1200, true},
+		{"0.07", 7, true},
+		{"12.505", 0, false},
+		{"-1", 0, false},
+		{"1.-5", 0, false},
+		{"abc", 0, false},
+		{"", 0, false},
+	}
+
+	for _, e := range tests {
+		postedValues := url.Values{}
+		postedValues.Add("amount", e.value)
+		form := New(postedValues)
+
+		got, ok := form.Hundredths("amount")
+		if ok != e.ok || got != e.want {
+			t.Errorf("%q: expected %d, %t but got %d, %t", e.value, e.want, e.ok, got, ok)
+		}
+		if form.Valid() != e.ok {
+			t.Errorf("%q: expected the form to be valid %t", e.value, e.ok)
+		}
+	}
+}
+
+func TestForm_Locale(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("email", "not an email")
+	form := New(postedValues)
+	form.Locale = i18n.Romanian
+
+	form.Required("name")
+	form.IsEmail("email")
+	if got := form.Errors.Get("name"); got != "Acest câmp nu poate fi gol" {
+		t.Errorf("expected the required error in Romanian, got %q", got)
+	}
+	if got := form.Errors.Get("email"); got != "Adresă de email invalidă" {
+		t.Errorf("expected the email error in Romanian, got %q", got)
+	}
+}
@@ -0,0 +1,53 @@
+// Package guestlogin logs guests in to their account with one-time links emailed to them, so they need no password.
+package guestlogin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+// TTL is how long a login link can be used
+const TTL = 30 * time.Minute
+
+// SessionKey is the session key holding the id of the logged in guest, apart from the user_id of staff
+const SessionKey = "guest_id"
+
+// HashKey returns the hash of a login key, which is all the database keeps of it
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKey returns a random key for a login link
+func NewKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Mail is the email from the property at baseURL, whose email is from, with the login link of key to guest g, valid
+// until expires
+func Mail(g models.Guest, baseURL, from, key string, expires time.Time) models.MailData {
+	link := fmt.Sprintf("%s/account/login/verify?%s", baseURL, url.Values{"k": {key}}.Encode())
+
+	content := fmt.Sprintf(`
+		<strong>Log in to your bookings</strong><br>
+		Dear %s: <br>
+		<a href="%s">Log in</a> to see your bookings and update your details. The link works once, until %s. If
+		you didn't ask for it, you can ignore this email.
+`, g.FirstName, link, expires.Format("2006-01-02 15:04"))
+
+	return models.MailData{
+		To:       g.Email,
+		From:     from,
+		Subject:  "Your login link",
+		Content:  content,
+		Template: "basic.html",
+	}
+}
@@ -0,0 +1,37 @@
+package guestlogin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+func TestNewKey(t *testing.T) {
+	a, b := NewKey(), NewKey()
+	if len(a) != 32 || a == b {
+		t.Errorf("expected two different keys of 32 characters but got %q and %q", a, b)
+	}
+	if HashKey(a) == a || HashKey(a) != HashKey(a) || HashKey(a) == HashKey(b) {
+		t.Error("expected the hash of a key to differ from the key and from the hash of another key")
+	}
+}
+
+func TestMail(t *testing.T) {
+	g := models.Guest{FirstName: "John", Email: "john@smith.com"}
+	msg := Mail(g, "https://example.com", "fort@here.com", "abc", time.Date(2041, 6, 1, 10, 30, 0, 0, time.UTC))
+
+	if msg.To != "john@smith.com" {
+		t.Errorf("expected the email to go to john@smith.com but it went to %s", msg.To)
+	}
+	if msg.From != "fort@here.com" {
+		t.Errorf("expected the email to come from the property but it came from %s", msg.From)
+	}
+	if !strings.Contains(msg.Content, `href="https://example.com/account/login/verify?k=abc"`) {
+		t.Errorf("expected the login link in the email, got %s", msg.Content)
+	}
+	if !strings.Contains(msg.Content, "2041-06-01 10:30") {
+		t.Error("expected the expiry of the link in the email")
+	}
+}
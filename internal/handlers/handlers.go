@@ -1,39 +1,89 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/flaviusp23/bookings/internal/cancellation"
 	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/currency"
 	"github.com/flaviusp23/bookings/internal/driver"
 	"github.com/flaviusp23/bookings/internal/forms"
+	"github.com/flaviusp23/bookings/internal/guestlogin"
+	"github.com/flaviusp23/bookings/internal/health"
 	"github.com/flaviusp23/bookings/internal/helpers"
+	"github.com/flaviusp23/bookings/internal/i18n"
+	"github.com/flaviusp23/bookings/internal/invoice"
+	"github.com/flaviusp23/bookings/internal/metrics"
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/payments"
+	"github.com/flaviusp23/bookings/internal/privacy"
+	"github.com/flaviusp23/bookings/internal/promo"
+	"github.com/flaviusp23/bookings/internal/property"
+	"github.com/flaviusp23/bookings/internal/quote"
 	"github.com/flaviusp23/bookings/internal/render"
 	"github.com/flaviusp23/bookings/internal/repository"
 	"github.com/flaviusp23/bookings/internal/repository/dbrepo"
+	"github.com/flaviusp23/bookings/internal/stayrules"
+	"github.com/flaviusp23/bookings/internal/waitlist"
 	"github.com/go-chi/chi/v5"
 )
 
+// quoteTTL is how long a booking link stays valid after the guest picks a room
+const quoteTTL = 2 * time.Hour
+
+// maxPartySize is the most adults, and the most children, a single booking can be made for
+const maxPartySize = 10
+
+// maxFlexibleDays is the widest window, in days, a flexible date search may cover
+const maxFlexibleDays = 62
+
+// maxCalendarMonths is the most months the availability calendar of a room covers at once
+const maxCalendarMonths = 12
+
+// checkInTime and checkOutTime are the times guests may arrive from and must leave by
+const (
+	checkInTime  = "15:00"
+	checkOutTime = "11:00"
+)
+
+// summaryTTL is how long the link to the reservation summary works after booking, long enough for the guest to come
+// back from paying the deposit
+const summaryTTL = 24 * time.Hour
+
+// holdTTL is how long a chosen room is held for the guest while they fill in the reservation form
+const holdTTL = 15 * time.Minute
+
 // Repo the repository used by the handlers
 var Repo *Repository
 
 // Repository is the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App  *config.AppConfig
+	DB   repository.DatabaseRepo
+	Conn *driver.DB
 }
 
 // NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		App:  a,
+		DB:   dbrepo.NewPostgresRepo(db.SQL, a),
+		Conn: db,
 	}
 }
 
@@ -50,6 +100,72 @@ func NewHandlers(r *Repository) {
 	Repo = r
 }
 
+// db returns the repository scoped to the property of the request
+func (m *Repository) db(r *http.Request) repository.DatabaseRepo {
+	return m.DB.ForProperty(property.FromContext(r.Context()).ID)
+}
+
+// property returns the property of the request
+func (m *Repository) property(r *http.Request) models.Property {
+	return property.FromContext(r.Context())
+}
+
+// siteURL returns the address of the public site of the property of the request, for links in emails and for the
+// payment provider to send guests back to
+func (m *Repository) siteURL(r *http.Request) string {
+	return m.App.Properties.URL(m.property(r))
+}
+
+// issuer returns the property of the request as it appears on its confirmations and invoices
+func (m *Repository) issuer(r *http.Request) invoice.Issuer {
+	p := m.property(r)
+	return invoice.Issuer{Name: p.Name, Address: p.Address, Email: p.Email, Currency: m.App.Rates.Base()}
+}
+
+// Healthz reports that the process is alive
+func (m *Repository) Healthz(w http.ResponseWriter, r *http.Request) {
+	out, _ := json.MarshalIndent(health.Report{Status: "ok"}, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// Readyz reports whether the application can serve traffic: the database answers, the templates are
+// loaded and the mail worker is running. It fails while the server is shutting down so load balancers drain it.
+func (m *Repository) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := []health.Check{
+		{Name: "database", Run: func(ctx context.Context) error {
+			if m.Conn == nil || m.Conn.SQL == nil {
+				return errors.New("no database connection")
+			}
+			return m.Conn.Ping(ctx)
+		}},
+		{Name: "templates", Run: func(ctx context.Context) error {
+			if len(m.App.TemplateCache) == 0 {
+				return errors.New("template cache is empty")
+			}
+			return nil
+		}},
+		{Name: "mail_worker", Run: func(ctx context.Context) error {
+			if !m.App.MailRunning.Load() {
+				return errors.New("mail worker is not running")
+			}
+			return nil
+		}},
+	}
+
+	report := health.Run(r.Context(), 2*time.Second, checks)
+	if m.App.ShuttingDown.Load() {
+		report.Status = "shutting down"
+	}
+
+	out, _ := json.MarshalIndent(report, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(out)
+}
+
 // Home is the handler for the home page
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
@@ -62,734 +178,3707 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 
 // Reservation renders the make a reservation page and displays form
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	tokens := r.URL.Query()["t"]
+	res, ok := m.bookingFromTokens(w, r, tokens)
 	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
-	room, err := m.DB.GetRoomByID(res.RoomID)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Can't find room")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	res.Adults, res.Children, _ = partySize(r.URL.Query())
+	if res.Adults == 0 {
+		res.Adults = 1
 	}
-	res.Room = room
-	m.App.Session.Put(r.Context(), "reservation", res)
 
-	sd := res.StartDate.Format("2006-01-02")
-	ed := res.EndDate.Format("2006-01-02")
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	m.renderReservationForm(w, r, res, tokens, forms.New(nil))
+}
 
+// renderReservationForm renders the reservation form for res, booked with tokens
+func (m *Repository) renderReservationForm(w http.ResponseWriter, r *http.Request, res models.Reservation, tokens []string, form *forms.Form) {
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["booking_tokens"] = tokens
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-		Form:      forms.New(nil),
-		Data:      data,
-		StringMap: stringMap,
+		Form: form,
+		Data: data,
 	})
 }
 
-func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+// bookingFromTokens builds a reservation with one room stay per booking token. The reservation spans all the
+// stays and its room is the first one booked. A room can be booked for several stays, each with its own dates, as
+// long as they don't overlap; a token given twice books its stay once. When a token can't be used the guest is
+// redirected, and ok is false.
+func (m *Repository) bookingFromTokens(w http.ResponseWriter, r *http.Request, tokens []string) (models.Reservation, bool) {
+	var res models.Reservation
+
+	if len(tokens) == 0 {
+		// an empty token is rejected like any other invalid one
+		tokens = []string{""}
 	}
-	err := r.ParseForm()
+
+	for _, token := range tokens {
+		q, room, ok := m.verifyQuote(w, r, token)
+		if !ok {
+			return res, false
+		}
+		duplicate := false
+		for _, stay := range res.Stays {
+			if stay.RoomID != room.ID || !q.StartDate.Before(stay.EndDate) || !q.EndDate.After(stay.StartDate) {
+				continue
+			}
+			if !q.StartDate.Equal(stay.StartDate) || !q.EndDate.Equal(stay.EndDate) {
+				m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "You chose the same room twice for overlapping dates, please search again"))
+				http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+				return res, false
+			}
+			duplicate = true
+		}
+		if duplicate {
+			continue
+		}
+
+		res.Stays = append(res.Stays, models.RoomStay{
+			RoomID:    room.ID,
+			Room:      room,
+			StartDate: q.StartDate,
+			EndDate:   q.EndDate,
+			Price:     q.Price,
+			HoldID:    q.HoldID,
+		})
+		res.Price += q.Price
+		if res.StartDate.IsZero() || q.StartDate.Before(res.StartDate) {
+			res.StartDate = q.StartDate
+		}
+		if q.EndDate.After(res.EndDate) {
+			res.EndDate = q.EndDate
+		}
+	}
+
+	res.RoomID = res.Stays[0].RoomID
+	res.Room = res.Stays[0].Room
+	return res, true
+}
+
+// verifyQuote checks a booking token and loads its room. When the token can't be used the guest is
+// sent back to the search page with an error, and ok is false.
+func (m *Repository) verifyQuote(w http.ResponseWriter, r *http.Request, token string) (quote.Quote, models.Room, bool) {
+	var room models.Room
+
+	q, err := m.App.Quotes.Verify(token)
+	if err == nil && q.Nights() < 1 {
+		err = quote.ErrInvalid
+	}
+	if errors.Is(err, quote.ErrExpired) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your booking link has expired, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return q, room, false
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your booking link is not valid, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return q, room, false
+	}
+
+	room, err = m.db(r).GetRoomByID(q.RoomID)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Can't parse form!")
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't find room"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return q, room, false
 	}
-	room, err := m.DB.GetRoomByID(reservation.RoomID) //speram sa fie populat cu Room.ID si nu RoomID
+
+	return q, room, true
+}
+
+// newForm returns a form of the posted values of r whose error messages are in the locale of the guest
+func newForm(r *http.Request) *forms.Form {
+	form := forms.New(r.PostForm)
+	form.Locale = i18n.FromContext(r.Context())
+	return form
+}
+
+// PostReservation handles the posting of the reservation form
+func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't find room!")
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't parse form!"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+
+	tokens := r.Form["booking_token"]
+	reservation, ok := m.bookingFromTokens(w, r, tokens)
+	if !ok {
+		return
+	}
 	reservation.FirstName = r.Form.Get("first_name")
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
-	reservation.Room = room
+	reservation.Adults, _ = strconv.Atoi(r.Form.Get("adults"))
+	reservation.Children, _ = strconv.Atoi(r.Form.Get("children"))
+	reservation.PromoCode = promo.Normalize(r.Form.Get("promo_code"))
+	reservation.MarketingConsent = r.Form.Get("marketing_consent") != ""
 
-	form := forms.New(r.PostForm)
+	form := newForm(r)
 
-	form.Required("first_name", "last_name", "email")
+	form.Required("first_name", "last_name", "email", "adults", "children")
 	form.MinLength("first_name", 3)
 	form.MinLength("last_name", 3)
 	form.IsEmail("email")
+	if form.IntRange("adults", 1, maxPartySize) && form.IntRange("children", 0, maxPartySize) {
+		occupancy := 0
+		for _, stay := range reservation.Stays {
+			occupancy += stay.Room.MaxOccupancy
+		}
+		if reservation.Guests() > occupancy {
+			form.Errors.Add("adults", i18n.T(r.Context(), "The rooms you chose sleep at most %d guests", occupancy))
+		}
+	}
+
+	// the code is checked with the rest of the form, so the guest can correct it
+	var discounts []int
+	if reservation.PromoCode != "" {
+		code, err := m.db(r).GetPromoCodeByCode(reservation.PromoCode)
+		if errors.Is(err, sql.ErrNoRows) {
+			form.Errors.Add("promo_code", i18n.T(r.Context(), "This promo code isn't valid"))
+		} else if err != nil {
+			helpers.Log(r).Error("can't get promo code", "error", err)
+			m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't check promo code"))
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		} else if discounts, err = promo.Discounts(code, reservation.Stays, time.Now()); err != nil {
+			form.Errors.Add("promo_code", i18n.Message(r.Context(), err))
+		} else {
+			reservation.PromoCodeID = code.ID
+		}
+	}
 
-	sd := reservation.StartDate.Format("2006 January 02")
-	ed := reservation.EndDate.Format("2006 January 02")
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form:      form,
-			Data:      data,
-			StringMap: stringMap,
-		})
+		m.renderReservationForm(w, r, reservation, tokens, form)
 		return
 	}
-	var newReservationID int
-	newReservationID, err = m.DB.InsertReservation(reservation)
+	entered := reservation
+
+	// the rules may have changed since the guest searched
+	if !m.checkStayRules(w, r, reservation.Stays) {
+		return
+	}
+
+	// taxes and fees depend on the party, so they are priced once the guest has told us about it, on the room
+	// price after the discount
+	chargeRules, err := m.db(r).GetChargeRulesByDate(reservation.StartDate, reservation.EndDate)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "database-insert-fails-reservation")
+		helpers.Log(r).Error("can't get charge rules", "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't get taxes and fees for reservation"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.Room.ID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	discounted := slices.Clone(reservation.Stays)
+	for i, d := range discounts {
+		discounted[i].Price -= d
+		reservation.Discount += d
 	}
-	err = m.DB.InsertRoomRestriction(restriction)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "database-insert-fails-restriction")
+	reservation.Price -= reservation.Discount
+	reservation.Charges = quote.Charges(chargeRules, discounted, reservation.Guests())
+	reservation.Price += quote.Total(reservation.Charges)
+
+	reservation.PaymentStatus = models.PaymentUnpaid
+	reservation.DepositAmount = payments.Deposit(reservation.Price, m.App.DepositPercent)
+	reservation.BalanceAmount = reservation.Price - reservation.DepositAmount
+
+	// the rate the guest was shown is kept, so their confirmation and invoice give the amounts they were quoted
+	reservation.DisplayCurrency, reservation.DisplayRate = m.displayCurrency(r)
+
+	var policies []string
+	for _, stay := range reservation.Stays {
+		policies = append(policies, stay.Room.CancellationPolicy)
+	}
+	reservation.CancellationPolicy = cancellation.Strictest(policies...)
+	cancelKey := cancellation.NewKey()
+	reservation.CancelKeyHash = cancellation.HashKey(cancelKey)
+
+	// all the rooms are booked together, or none if one of them was taken since the guest chose it
+	reservation.ID, err = m.db(r).InsertBooking(reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Sorry, a room you chose is no longer available for your dates"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrInvalidStay) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your booking link is not valid, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrPromoCodeUsedUp) {
+		// another booking took the last use since the code was checked
+		form.Errors.Add("promo_code", i18n.Message(r.Context(), promo.ErrUsedUp))
+		m.renderReservationForm(w, r, entered, tokens, form)
+		return
+	} else if err != nil {
+		helpers.Log(r).Error("can't insert booking", "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "database-insert-fails-reservation"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var rooms []string
+	for _, stay := range reservation.Stays {
+		rooms = append(rooms, fmt.Sprintf("%s from %s to %s", stay.Room.RoomName,
+			stay.StartDate.Format("2006-01-02"), stay.EndDate.Format("2006-01-02")))
 	}
+	base := m.App.Rates.Base()
+	var charges strings.Builder
+	if reservation.Discount > 0 {
+		fmt.Fprintf(&charges, "Promo code %s: -%s<br>\n", reservation.PromoCode, currency.Format(reservation.Discount, base))
+	}
+	for _, c := range reservation.Charges {
+		fmt.Fprintf(&charges, "%s: %s<br>\n", c.Name, currency.Format(c.Amount, base))
+	}
+
 	// send notifications - first to guest
 	htmlMessage := fmt.Sprintf(`
 		<strong>Reservation Confirmation</strong><br>
 		Dear %s: <br>
-		This is confirm your reservation from %s to %s.
-`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
+		This is confirm your reservation of %s.<br>
+		<br>
+		%sTotal: %s<br>
+		<br>
+		Cancellation policy: %s<br>
+		If your plans change, you can cancel <a href="%s/reservations/cancel?k=%s">here</a>.<br>
+		You can see all your bookings and download invoices in <a href="%s/account">My Bookings</a>.
+`, reservation.FirstName, strings.Join(rooms, ", "), charges.String(),
+		m.guestAmount(reservation, reservation.Price),
+		cancellation.Describe(reservation.CancellationPolicy), m.siteURL(r), cancelKey, m.siteURL(r))
+
+	reservation.CreatedAt = time.Now()
 	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+		To:          reservation.Email,
+		From:        m.property(r).Email,
+		Subject:     "Reservation Confirmation",
+		Content:     htmlMessage,
+		Template:    "basic.html",
+		Attachments: m.bookingDocuments(r, reservation),
 	}
 
+	metrics.BookingsCreated.Inc()
+
 	m.App.MailChan <- msg
 
-	// send notification to property owner
+	// send notification to property owner, warning about guests staff flagged
+	var flags string
+	guest, err := m.db(r).GetGuestByEmail(reservation.Email)
+	if err != nil {
+		helpers.Log(r).Error("can't get guest", "reservation_id", reservation.ID, "error", err)
+	} else {
+		reservation.GuestID = guest.ID
+		if guest.DoNotRent {
+			flags += "<br><strong>Warning: this guest is marked do not rent.</strong>"
+		}
+		if guest.VIP {
+			flags += "<br>This guest is a VIP."
+		}
+	}
 	htmlMessage = fmt.Sprintf(`
 		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s.
-`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		A reservation has been made for %s.%s
+`, strings.Join(rooms, ", "), flags)
 
 	msg = models.MailData{
-		To:      "me@here.com",
-		From:    "me@here.com",
+		To:      m.property(r).Email,
+		From:    m.property(r).Email,
 		Subject: "Reservation Notification",
 		Content: htmlMessage,
 	}
 
 	m.App.MailChan <- msg
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	// the summary is found by a signed link, like the booking steps before it
+	summary := "/reservation-summary?r=" + url.QueryEscape(m.App.Quotes.SignReservation(reservation.ID,
+		time.Now().Add(summaryTTL)))
+	if reservation.DepositAmount == 0 {
+		http.Redirect(w, r, summary, http.StatusSeeOther)
+		return
+	}
+
+	// the deposit is taken at the provider's checkout, which sends the guest on to the summary
+	checkoutURL, err := m.startCheckout(r, reservation, models.PaymentKindDeposit, reservation.DepositAmount,
+		summary, summary+"&payment=cancelled")
+	if err != nil {
+		helpers.Log(r).Error("can't start checkout", "reservation_id", reservation.ID, "error", err)
+		m.App.Session.Put(r.Context(), "warning", i18n.T(r.Context(), "Your reservation is made, but we couldn't take the deposit. We'll contact you about it."))
+		http.Redirect(w, r, summary, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, checkoutURL, http.StatusSeeOther)
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// displayCurrency returns the currency the guest is shown prices in with its rate, or nothing for the base currency
+func (m *Repository) displayCurrency(r *http.Request) (string, int64) {
+	code := currency.FromContext(r.Context())
+	rate, ok := m.App.Rates.Get(code)
+	if !ok || code == m.App.Rates.Base() {
+		return "", 0
+	}
+	return code, int64(rate)
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// guestAmount writes an amount of res for its guest, in the base currency and in the one they booked in at the rate
+// they were shown
+func (m *Repository) guestAmount(res models.Reservation, amount int) string {
+	return currency.Display(amount, m.App.Rates.Base(), res.DisplayCurrency, currency.Rate(res.DisplayRate))
 }
 
-// Availability renders the search availability page
-func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+// bookingDocuments returns the confirmation and the invoice of res as email attachments. A reservation whose invoice
+// can't be issued is confirmed without it; staff can download it later.
+func (m *Repository) bookingDocuments(r *http.Request, res models.Reservation) []models.Attachment {
+	attachments := []models.Attachment{{
+		Name:        fmt.Sprintf("confirmation-%d.pdf", res.ID),
+		ContentType: "application/pdf",
+		Data:        invoice.Confirmation(m.issuer(r), res),
+	}}
+
+	inv, err := m.invoiceFor(r, res)
+	if err != nil {
+		helpers.Log(r).Error("can't issue invoice", "reservation_id", res.ID, "error", err)
+		return attachments
+	}
+
+	return append(attachments, models.Attachment{
+		Name:        invoice.Number(inv.Number) + ".pdf",
+		ContentType: "application/pdf",
+		Data:        inv.PDF,
+	})
 }
 
-func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
+// invoiceFor returns the invoice of res, issuing it with the next invoice number the first time
+func (m *Repository) invoiceFor(r *http.Request, res models.Reservation) (models.Invoice, error) {
+	inv, err := m.db(r).GetInvoiceForReservation(res.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return inv, err
+	}
+
+	return m.db(r).IssueInvoice(res.ID, res.Price, func(number int) []byte {
+		return invoice.Invoice(m.issuer(r), number, time.Now(), res)
+	})
+}
+
+// startCheckout starts a payment of amount for res with the payment provider and returns where to send the guest
+// to pay. The provider sends the guest back to successPath or cancelPath.
+func (m *Repository) startCheckout(r *http.Request, res models.Reservation, kind string, amount int, successPath, cancelPath string) (string, error) {
+	session, err := m.App.Payments.CreateCheckout(payments.Checkout{
+		ReservationID: res.ID,
+		Amount:        amount,
+		Description:   fmt.Sprintf("%s for reservation %d", kind, res.ID),
+		Email:         res.Email,
+		SuccessURL:    m.siteURL(r) + successPath,
+		CancelURL:     m.siteURL(r) + cancelPath,
+	})
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		return "", err
 	}
-	endDate, err := time.Parse(layout, end)
+
+	_, err = m.db(r).InsertPayment(models.Payment{
+		ReservationID: res.ID,
+		Provider:      m.App.Payments.Name(),
+		ProviderRef:   session.ID,
+		Kind:          kind,
+		Amount:        amount,
+		Status:        models.PaymentPending,
+	})
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		return "", err
 	}
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+
+	return session.URL, nil
+}
+
+// errPaymentMismatch is returned for webhook events that don't match the payment they are about
+var errPaymentMismatch = errors.New("payment event does not match its payment")
+
+// errUnknownPayment is returned for a provider's event about a checkout session the site has no payment for
+var errUnknownPayment = errors.New("payment event for an unknown checkout session")
+
+// maxWebhookSize is the largest webhook body read
+const maxWebhookSize = 64 << 10
+
+// PaymentWebhook receives the payment provider's signed notifications about checkouts
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	if !endDate.After(startDate) {
-		m.App.Session.Put(r.Context(), "error", "You have to book at least one night")
-		stringMap := make(map[string]string)
-		stringMap["startDate"] = startDate.Format("2006-01-02")
-		stringMap["endDate"] = endDate.Format("2006-01-02")
-		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
-			StringMap: stringMap,
-		})
+
+	e, err := m.App.Payments.ParseWebhook(payload, r.Header)
+	if err != nil {
+		helpers.Log(r).Warn("rejected payment webhook", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	if len(rooms) == 0 {
-		// no availability
-		m.App.Session.Put(r.Context(), "error", "No availability")
-		stringMap := make(map[string]string)
-		stringMap["startDate"] = startDate.Format("2006-01-02")
-		stringMap["endDate"] = endDate.Format("2006-01-02")
-		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
-			StringMap: stringMap,
-		})
+
+	err = m.applyPaymentEvent(r, e)
+	if errors.Is(err, errPaymentMismatch) {
+		helpers.Log(r).Warn("rejected payment webhook", "event_id", e.ID, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, errUnknownPayment) {
+		// acknowledged, as retrying can't make the payment appear
+		helpers.Log(r).Warn("ignored payment webhook", "event_id", e.ID, "session_id", e.SessionID, "error", err)
+	} else if err != nil {
+		// the provider retries until it gets a success
+		helpers.ServerError(w, r, err)
 		return
 	}
-	data := make(map[string]interface{})
-	data["rooms"] = rooms
 
-	//nu putem stoca time.time in session asa ca facem o fenta si populam reservation din session doar cu startdate end date
-	res := models.Reservation{
-		StartDate: startDate,
-		EndDate:   endDate,
-	}
-	m.App.Session.Put(r.Context(), "reservation", res)
-	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	out, _ := json.MarshalIndent(jsonResponse{OK: true}, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
 }
 
-type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
-}
+// applyPaymentEvent records the outcome of a payment from a provider's event. Events seen before, and events about
+// payments that already have an outcome, change nothing, so providers can deliver events more than once.
+func (m *Repository) applyPaymentEvent(r *http.Request, e payments.Event) error {
+	// the provider calls one address for every property, so the payment tells which property the event is for
+	propertyID, err := m.DB.GetPropertyIDForPayment(m.App.Payments.Name(), e.SessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", errUnknownPayment, e.SessionID)
+	} else if err != nil {
+		return err
+	}
+	prop, ok := m.App.Properties.Get(propertyID)
+	if !ok {
+		return fmt.Errorf("property %d of payment %s is not loaded", propertyID, e.SessionID)
+	}
+	r = r.WithContext(property.WithProperty(r.Context(), prop, property.Prefix(r.Context())))
 
-// AvailabilityJSON handles request for availability and sends JSON response
-func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	p, err := m.db(r).GetPaymentByProviderRef(m.App.Payments.Name(), e.SessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", errUnknownPayment, e.SessionID)
+	} else if err != nil {
+		return err
+	}
+	res, err := m.db(r).GetReservationByID(p.ReservationID)
 	if err != nil {
-		// can't parse form, so return appropriate json
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Internal server error",
-		}
-
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
+		return err
 	}
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
 
-	// Validate start date
-	startDate, err := time.Parse("2006-01-02", start)
-	if err != nil {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Invalid start date format. Please use yyyy-mm-dd.",
+	var status, resStatus string
+	switch e.Type {
+	case payments.EventSucceeded:
+		if e.Amount != p.Amount {
+			return fmt.Errorf("%w: paid %d instead of %d", errPaymentMismatch, e.Amount, p.Amount)
 		}
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
+		status = models.PaymentSucceeded
+		resStatus = models.PaymentPaid
+		if p.Kind == models.PaymentKindDeposit && res.BalanceAmount > 0 {
+			resStatus = models.PaymentDepositPaid
+		}
+	case payments.EventFailed:
+		// the reservation keeps whatever was paid before
+		status = models.PaymentFailed
+	default:
+		return nil
 	}
 
-	// Validate end date
-	endDate, err := time.Parse("2006-01-02", end)
+	applied, err := m.db(r).ApplyPaymentEvent(m.App.Payments.Name(), e.ID, p.ID, status, resStatus)
 	if err != nil {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Invalid end date format. Please use yyyy-mm-dd.",
-		}
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
+		return err
 	}
-	if !endDate.After(startDate) {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "End date must be at least one day after start date.",
+	if !applied {
+		helpers.Log(r).Info("payment event already applied", "event_id", e.ID, "payment_id", p.ID)
+		return nil
+	}
+	helpers.Log(r).Info("payment updated", "payment_id", p.ID, "reservation_id", p.ReservationID, "status", status)
+
+	if status == models.PaymentSucceeded {
+		m.App.MailChan <- models.MailData{
+			To:      res.Email,
+			From:    m.property(r).Email,
+			Subject: "Payment Received",
+			Content: fmt.Sprintf(`
+		<strong>Payment Received</strong><br>
+		Dear %s: <br>
+		We received your payment of %s for reservation %d. Thank you!
+`, res.FirstName, m.guestAmount(res, p.Amount), res.ID),
+			Template: "basic.html",
 		}
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
 	}
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
-	if err != nil {
-		// got a database error, so return appropriate json
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Error querying database",
-		}
+	return nil
+}
 
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
-	}
-	resp := jsonResponse{
-		OK:        available,
-		Message:   "",
-		StartDate: start,
-		EndDate:   end,
-		RoomID:    strconv.Itoa(roomID),
-	}
-	out, _ := json.MarshalIndent(resp, "", "     ")
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
-}
-
-// Contact renders the contact page
-func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
-}
-
-func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+// CancelReservation shows guests following the link in their confirmation email what they get back if they cancel
+func (m *Repository) CancelReservation(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("k")
+	res, err := m.db(r).GetReservationByCancelKey(cancellation.HashKey(key))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "This cancellation link is not valid, or the reservation is already cancelled"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	m.App.Session.Remove(r.Context(), "reservation")
 
 	data := make(map[string]interface{})
-	data["reservation"] = reservation
+	data["reservation"] = res
+	data["refund"] = cancellation.Refund(res.CancellationPolicy, res.Paid(), res.StartDate, time.Now())
 
-	sd := reservation.StartDate.Format("2006 January 02")
-	ed := reservation.EndDate.Format("2006 January 02")
 	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
-	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
-		Data:      data,
+	stringMap["key"] = key
+	stringMap["cancellation_policy"] = cancellation.DescribeIn(i18n.FromContext(r.Context()), res.CancellationPolicy)
+
+	render.Template(w, r, "cancel-reservation.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
+		Data:      data,
 	})
 }
 
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[2])
+// PostCancelReservation cancels the reservation of a guest's cancellation link
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't parse form!"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+
+	res, err := m.db(r).GetReservationByCancelKey(cancellation.HashKey(r.Form.Get("k")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "This cancellation link is not valid, or the reservation is already cancelled"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	res.RoomID = roomID
-	m.App.Session.Put(r.Context(), "reservation", res)
-
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 
-}
-
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	start := r.URL.Query().Get("s")
-	end := r.URL.Query().Get("e")
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
+	refund, err := m.cancelReservation(r, res)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
+		helpers.Log(r).Error("can't cancel reservation", "reservation_id", res.ID, "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your reservation could not be cancelled, please contact us"))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	endDate, err := time.Parse(layout, end)
+
+	if refund > 0 {
+		m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "Your reservation is cancelled. %s will be refunded.", m.guestAmount(res, refund)))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "Your reservation is cancelled"))
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// cancelReservation cancels res with the refund its cancellation policy gives now, frees its rooms for the waitlist,
+// returns the refund through the payment provider and emails the guest. It returns the refund.
+func (m *Repository) cancelReservation(r *http.Request, res models.Reservation) (int, error) {
+	refund := cancellation.Refund(res.CancellationPolicy, res.Paid(), res.StartDate, time.Now())
+
+	err := m.db(r).CancelReservation(res.ID, refund)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		return 0, err
 	}
-	available, _ := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
-	if !available {
-		m.App.Session.Put(r.Context(), "error", "attentione, user modified ilegally the dates after he fixed them :)))")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+	metrics.BookingsCancelled.Inc()
+	helpers.Log(r).Info("reservation cancelled", "reservation_id", res.ID, "refund", refund)
+
+	for _, stay := range res.Stays {
+		m.roomFreed(r, stay.RoomID, stay.StartDate, stay.EndDate)
 	}
-	var res models.Reservation
-	res.RoomID = roomID
-	res.StartDate = startDate
-	res.EndDate = endDate
-	room, err := m.DB.GetRoomByID(roomID)
+
+	err = m.refundPayments(r, res.ID, refund)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't find room")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		// the cancellation stands, staff issue the refund by hand
+		helpers.Log(r).Error("can't refund cancelled reservation", "reservation_id", res.ID, "refund", refund, "error", err)
 	}
-	res.Room = room
-	m.App.Session.Put(r.Context(), "reservation", res)
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
-}
 
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
-	})
-}
+	m.App.MailChan <- models.MailData{
+		To:      res.Email,
+		From:    m.property(r).Email,
+		Subject: "Reservation Cancelled",
+		Content: fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong><br>
+		Dear %s: <br>
+		Your reservation %d from %s to %s is cancelled. Under its %s cancellation policy, %s of the %s you paid is
+		refunded.
+`, res.FirstName, res.ID, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+			res.CancellationPolicy, m.guestAmount(res, refund), m.guestAmount(res, res.Paid())),
+		Template: "basic.html",
+	}
 
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.RenewToken(r.Context()) // good practice to do a login or logout
+	return refund, nil
+}
 
-	err := r.ParseForm() // this populates the r.Form and r.PostForm
+// refundPayments returns refund to the guest from the succeeded payments of a reservation, latest payment first
+func (m *Repository) refundPayments(r *http.Request, reservationID, refund int) error {
+	if refund == 0 {
+		return nil
+	}
+	paid, err := m.db(r).GetPaymentsForReservation(reservationID)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
 
-	form := forms.New(r.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
-	if !form.Valid() {
-		stringMap := make(map[string]string)
-		stringMap["email"] = email
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form:      form,
-			StringMap: stringMap,
-		})
-		return
+	for i := len(paid) - 1; i >= 0 && refund > 0; i-- {
+		p := paid[i]
+		if p.Status != models.PaymentSucceeded || p.Provider != m.App.Payments.Name() {
+			continue
+		}
+		amount := min(refund, p.Amount)
+		err = m.App.Payments.Refund(p.ProviderRef, amount)
+		if err != nil {
+			return err
+		}
+		refund -= amount
 	}
-	id, _, err := m.DB.Authenticate(email, password)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		stringMap := make(map[string]string)
-		stringMap["email"] = email
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form:      form,
-			StringMap: stringMap,
-		})
-		return
+	if refund > 0 {
+		return fmt.Errorf("%d cents not covered by payments with %s", refund, m.App.Payments.Name())
 	}
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully!")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
 }
 
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+// PaymentDone tells the guest how a payment they were sent to make by email went
+func (m *Repository) PaymentDone(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["status"] = r.URL.Query().Get("status")
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	render.Template(w, r, "payment-done.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+	})
 }
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
-}
+// FakeCheckout shows the checkout page of the fake payment provider
+func (m *Repository) FakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, ok := m.App.Payments.(*payments.FakeProvider)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 
-// AdminAllReservations shows all reservations inu admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
-	if err != nil {
-		helpers.ServerError(w, err)
+	id := chi.URLParam(r, "id")
+	c, ok := fake.Checkout(id)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "This payment is no longer open"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
+	stringMap := make(map[string]string)
+	stringMap["id"] = id
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["checkout"] = c
 
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+	render.Template(w, r, "fake-checkout.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
 	})
 }
 
-// AdminNewReservations shows all new reservations in admin tool
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+// PostFakeCheckout completes a fake payment the way the guest chose, delivering the provider's signed webhook
+// straight to the same handling as PaymentWebhook, and sends the guest back to the site
+func (m *Repository) PostFakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, ok := m.App.Payments.(*payments.FakeProvider)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't parse form!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	id := chi.URLParam(r, "id")
+	c, ok := fake.Checkout(id)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "This payment is no longer open"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	paid := r.Form.Get("outcome") == "pay"
+
+	payload, header, err := fake.Complete(id, paid)
+	if err == nil {
+		var e payments.Event
+		e, err = fake.ParseWebhook(payload, header)
+		if err == nil {
+			err = m.applyPaymentEvent(r, e)
+		}
+	}
+	if err != nil {
+		helpers.Log(r).Error("can't complete fake payment", "session_id", id, "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your payment could not be recorded"))
+	}
+
+	if paid {
+		http.Redirect(w, r, c.SuccessURL, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, c.CancelURL, http.StatusSeeOther)
 }
 
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
+// JoinWaitlist puts the guest on the waitlist for dates that are fully booked
+func (m *Repository) JoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't parse form!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	id, err := strconv.Atoi(exploded[4])
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't parse start date!"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end"))
+	if err != nil || !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't parse end date!"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	adults, children, err := partySize(r.Form)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Please enter a valid number of guests"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
-	src := exploded[3]
+	entry := models.WaitlistEntry{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+	}
+	entry.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
 
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	form := newForm(r)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.MinLength("last_name", 3)
+	form.IsEmail("email")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["startDate"] = startDate.Format(layout)
+		stringMap["endDate"] = endDate.Format(layout)
+		stringMap["adults"] = strconv.Itoa(adults)
+		stringMap["children"] = strconv.Itoa(children)
+		stringMap["room_id"] = strconv.Itoa(entry.RoomID)
+		stringMap["first_name"] = entry.FirstName
+		stringMap["last_name"] = entry.LastName
+		stringMap["email"] = entry.Email
+		m.renderWaitlistForm(w, r, stringMap, form)
+		return
+	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	_, err = m.db(r).InsertWaitlistEntry(entry)
+	if err != nil {
+		helpers.Log(r).Error("can't insert waitlist entry", "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't add you to the waitlist"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	stringMap["month"] = month
-	stringMap["year"] = year
+	m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "You're on the waitlist. We'll email you as soon as a room frees up for your dates."))
+	http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+}
 
-	// get reservation from the database
-	res, err := m.DB.GetReservationByID(id)
+// renderWaitlistForm renders the search page with the form to join the waitlist for the dates in stringMap
+func (m *Repository) renderWaitlistForm(w http.ResponseWriter, r *http.Request, stringMap map[string]string, form *forms.Form) {
+	rooms, err := m.db(r).AllRooms()
 	if err != nil {
-		helpers.ServerError(w, err)
+		// the guest can still wait for any room
+		helpers.Log(r).Error("can't get rooms", "error", err)
+	}
+
+	stringMap["waitlist"] = "1"
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// ClaimWaitlist shows a waiting guest the room offered by the link emailed to them, to book it
+func (m *Repository) ClaimWaitlist(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("k")
+	token := r.URL.Query().Get("t")
+	q, room, ok := m.waitlistOffer(w, r, key, token)
+	if !ok {
 		return
 	}
 
 	data := make(map[string]interface{})
-	data["reservation"] = res
+	data["quote"] = q
+	data["room"] = room
 
-	render.Template(w, r, "admin-reservation-show.page.tmpl", &models.TemplateData{
+	stringMap := make(map[string]string)
+	stringMap["key"] = key
+	stringMap["token"] = token
+
+	render.Template(w, r, "waitlist-claim.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
-		Form:      forms.New(nil),
 	})
 }
 
-// AdminPostShowReservation posts a reservation
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+// PostClaimWaitlist takes a waiting guest off the waitlist and to the reservation form for the room offered to them,
+// holding the room like choosing it from the search results does. A claim link can be used once.
+func (m *Repository) PostClaimWaitlist(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't parse form!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	exploded := strings.Split(r.RequestURI, "/")
+	key := r.Form.Get("k")
+	q, _, ok := m.waitlistOffer(w, r, key, r.Form.Get("t"))
+	if !ok {
+		return
+	}
 
-	id, err := strconv.Atoi(exploded[4])
-	if err != nil {
-		helpers.ServerError(w, err)
+	entry, err := m.db(r).ClaimWaitlistEntry(waitlist.HashKey(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		// the link was used in the meantime
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your waitlist link is not valid, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	src := exploded[3]
+	token, ok := m.holdRoom(w, r, q)
+	if !ok {
+		return
+	}
 
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	res, ok := m.bookingFromTokens(w, r, []string{token})
+	if !ok {
+		return
+	}
+	res.FirstName = entry.FirstName
+	res.LastName = entry.LastName
+	res.Email = entry.Email
+	res.Adults = entry.Adults
+	res.Children = entry.Children
+
+	m.renderReservationForm(w, r, res, []string{token}, forms.New(nil))
+}
 
-	res, err := m.DB.GetReservationByID(id)
+// waitlistOffer checks the claim key and booking token of a waitlist claim link, and returns the stay offered to the
+// waiting guest. When the link can't be used the guest is redirected, and ok is false.
+func (m *Repository) waitlistOffer(w http.ResponseWriter, r *http.Request, key, token string) (quote.Quote, models.Room, bool) {
+	entry, err := m.db(r).GetWaitlistEntryByClaimKey(waitlist.HashKey(key))
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your waitlist link is not valid, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return quote.Quote{}, models.Room{}, false
 	}
 
-	res.FirstName = r.Form.Get("first_name")
-	res.LastName = r.Form.Get("last_name")
-	res.Email = r.Form.Get("email")
-	res.Phone = r.Form.Get("phone")
+	q, room, ok := m.verifyQuote(w, r, token)
+	if !ok {
+		return q, room, false
+	}
+	if !q.StartDate.Equal(entry.StartDate) || !q.EndDate.Equal(entry.EndDate) || (entry.RoomID > 0 && q.RoomID != entry.RoomID) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your waitlist link is not valid, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return q, room, false
+	}
 
-	err = m.DB.UpdateReservation(res)
+	return q, room, true
+}
+
+// PostDeclineWaitlist takes a waiting guest who doesn't want the room offered to them off the waitlist, and offers
+// the room to the next guest waiting
+func (m *Repository) PostDeclineWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't parse form!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	month := r.Form.Get("month")
-	year := r.Form.Get("year")
+	entry, err := m.db(r).DeclineWaitlistEntry(waitlist.HashKey(r.Form.Get("k")))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your waitlist link is not valid, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	notified, err := m.waitlistNotifier(r).Declined(entry)
+	if err != nil {
+		helpers.Log(r).Error("can't notify waitlist", "room_id", entry.OfferedRoomID, "error", err)
+	}
+	if notified > 0 {
+		helpers.Log(r).Info("notified waitlist", "room_id", entry.OfferedRoomID, "count", notified)
+	}
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "You're off the waitlist for these dates"))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// waitlistNotifier returns the notifier of the waitlist of the request's property
+func (m *Repository) waitlistNotifier(r *http.Request) *waitlist.Notifier {
+	return &waitlist.Notifier{
+		DB:      m.db(r),
+		Quotes:  m.App.Quotes,
+		BaseURL: m.siteURL(r),
+		From:    m.property(r).Email,
+		Send:    func(msg models.MailData) { m.App.MailChan <- msg },
 	}
 }
 
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-	err := m.DB.UpdateProcessedForReservation(id, 1)
+// roomFreed offers nights of a room that became free to the guests waiting for them
+func (m *Repository) roomFreed(r *http.Request, roomID int, start, end time.Time) {
+	notified, err := m.waitlistNotifier(r).RoomFreed(roomID, start, end)
 	if err != nil {
-		log.Println(err)
+		helpers.Log(r).Error("can't notify waitlist", "room_id", roomID, "error", err)
 	}
-
+	if notified > 0 {
+		helpers.Log(r).Info("notified waitlist", "room_id", roomID, "count", notified)
+	}
+}
+
+// Generals renders the room page
+func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+}
+
+// Majors renders the room page
+func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+}
+
+// Availability renders the search availability page
+func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+}
+
+func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	start := r.Form.Get("start")
+	end := r.Form.Get("end")
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, start)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't parse start date!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse(layout, end)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't parse start date!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	adults, children, err := partySize(r.Form)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Please enter a valid number of guests"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	rooms, err := m.db(r).SearchAvailabilityForAllRooms(startDate, endDate, adults+children,
+		m.App.Session.GetString(r.Context(), "hold_key"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't get availability for rooms"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "You have to book at least one night"))
+		stringMap := make(map[string]string)
+		stringMap["startDate"] = startDate.Format("2006-01-02")
+		stringMap["endDate"] = endDate.Format("2006-01-02")
+		stringMap["adults"] = strconv.Itoa(adults)
+		stringMap["children"] = strconv.Itoa(children)
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+		})
+		return
+	}
+	rules, err := m.db(r).GetStayRulesByDate(startDate, endDate)
+	if err != nil {
+		helpers.Log(r).Error("can't get stay rules", "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't get availability for rooms"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	full := len(rooms) == 0
+	noAvailability := i18n.T(r.Context(), "No availability")
+	var allowed []models.Room
+	for _, room := range rooms {
+		if err := stayrules.Check(rules, room.ID, startDate, endDate); err != nil {
+			// when no room is left, tell the guest why the last one was turned down
+			noAvailability = i18n.Message(r.Context(), err)
+			continue
+		}
+		allowed = append(allowed, room)
+	}
+	rooms = allowed
+	if len(rooms) == 0 {
+		// no availability
+		m.App.Session.Put(r.Context(), "error", noAvailability)
+		stringMap := make(map[string]string)
+		stringMap["startDate"] = startDate.Format("2006-01-02")
+		stringMap["endDate"] = endDate.Format("2006-01-02")
+		stringMap["adults"] = strconv.Itoa(adults)
+		stringMap["children"] = strconv.Itoa(children)
+		if full {
+			// the dates are fully booked, so the guest can wait for a room to free up
+			m.renderWaitlistForm(w, r, stringMap, forms.New(nil))
+			return
+		}
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	// each room carries its own signed quote, so nothing about the search is kept in the session
+	var choices []roomChoice
+	for _, room := range rooms {
+		q := quote.New(room, startDate, endDate, quoteTTL)
+		choices = append(choices, roomChoice{
+			Room:  room,
+			Quote: q,
+			Token: m.App.Quotes.Sign(q),
+		})
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["choices"] = choices
+
+	stringMap := make(map[string]string)
+	stringMap["adults"] = strconv.Itoa(adults)
+	stringMap["children"] = strconv.Itoa(children)
+
+	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// partySize reads the adults and children of a form. Missing values default to one adult and no children.
+func partySize(v url.Values) (int, int, error) {
+	adults, children := 1, 0
+
+	var err error
+	if a := strings.TrimSpace(v.Get("adults")); a != "" {
+		adults, err = strconv.Atoi(a)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if c := strings.TrimSpace(v.Get("children")); c != "" {
+		children, err = strconv.Atoi(c)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if adults < 1 || adults > maxPartySize || children < 0 || children > maxPartySize {
+		return 0, 0, fmt.Errorf("party of %d adults and %d children is out of range", adults, children)
+	}
+
+	return adults, children, nil
+}
+
+// withParty adds the party size of the request to params, so it carries on to the reservation form
+func withParty(r *http.Request, params url.Values) url.Values {
+	for _, key := range []string{"adults", "children"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			params.Set(key, v)
+		}
+	}
+	return params
+}
+
+// roomChoice is an available room offered on the choose room page
+type roomChoice struct {
+	Room  models.Room
+	Quote quote.Quote
+	Token string
+}
+
+// flexibleSearch is a search for a stay of Nights nights anywhere between From and To, the last departure date
+type flexibleSearch struct {
+	From     time.Time
+	To       time.Time
+	Nights   int
+	Adults   int
+	Children int
+}
+
+// parseFlexibleSearch reads a flexible search from a month (yyyy-mm) or a from and to date, and the number of
+// nights and guests. Its errors are meant for the guest.
+func parseFlexibleSearch(v url.Values) (flexibleSearch, error) {
+	var s flexibleSearch
+	var err error
+
+	if month := v.Get("month"); month != "" {
+		s.From, err = time.Parse("2006-01", month)
+		if err != nil {
+			return s, errors.New("Invalid month, please use yyyy-mm")
+		}
+		s.To = s.From.AddDate(0, 1, 0)
+	} else {
+		s.From, err = time.Parse("2006-01-02", v.Get("from"))
+		if err != nil {
+			return s, errors.New("Invalid window start, please use yyyy-mm-dd")
+		}
+		s.To, err = time.Parse("2006-01-02", v.Get("to"))
+		if err != nil {
+			return s, errors.New("Invalid window end, please use yyyy-mm-dd")
+		}
+	}
+
+	s.Nights, err = strconv.Atoi(v.Get("nights"))
+	if err != nil || s.Nights < 1 {
+		return s, errors.New("Please enter how many nights you want to stay")
+	}
+
+	days := int(s.To.Sub(s.From).Hours() / 24)
+	if days < s.Nights {
+		return s, errors.New("The dates are too close together for your stay")
+	}
+	if days > maxFlexibleDays {
+		return s, i18n.Errorf("Flexible searches can cover at most %d days", maxFlexibleDays)
+	}
+
+	s.Adults, s.Children, err = partySize(v)
+	if err != nil {
+		return s, errors.New("Please enter a valid number of guests")
+	}
+
+	return s, nil
+}
+
+// flexibleChoices runs a flexible search and signs a booking token for every room and arrival date found
+func (m *Repository) flexibleChoices(r *http.Request, s flexibleSearch) ([]roomChoice, error) {
+	options, err := m.db(r).SearchFlexibleAvailability(s.From, s.To, s.Nights, s.Adults+s.Children,
+		m.App.Session.GetString(r.Context(), "hold_key"))
+	if err != nil {
+		return nil, err
+	}
+	rules, err := m.db(r).GetStayRulesByDate(s.From, s.To.AddDate(0, 0, s.Nights))
+	if err != nil {
+		return nil, err
+	}
+
+	var choices []roomChoice
+	for _, o := range options {
+		if stayrules.Check(rules, o.Room.ID, o.StartDate, o.EndDate) != nil {
+			continue
+		}
+		q := quote.New(o.Room, o.StartDate, o.EndDate, quoteTTL)
+		choices = append(choices, roomChoice{
+			Room:  o.Room,
+			Quote: q,
+			Token: m.App.Quotes.Sign(q),
+		})
+	}
+	return choices, nil
+}
+
+// PostFlexibleAvailability lists every room and arrival date for a stay length within a month or date window
+func (m *Repository) PostFlexibleAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Can't parse form!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	search, err := parseFlexibleSearch(r.Form)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.Message(r.Context(), err))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	choices, err := m.flexibleChoices(r, search)
+	if err != nil {
+		helpers.Log(r).Error("can't search flexible availability", "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't get availability for rooms"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if len(choices) == 0 {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "No availability"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["choices"] = choices
+
+	stringMap := make(map[string]string)
+	stringMap["nights"] = strconv.Itoa(search.Nights)
+	stringMap["adults"] = strconv.Itoa(search.Adults)
+	stringMap["children"] = strconv.Itoa(search.Children)
+
+	render.Template(w, r, "flexible-availability.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// flexibleJSONResponse gives prices in the minor unit of currency, and in display_currency as well when the guest
+// chose another one
+type flexibleJSONResponse struct {
+	OK              bool                 `json:"ok"`
+	Message         string               `json:"message"`
+	Currency        string               `json:"currency"`
+	DisplayCurrency string               `json:"display_currency,omitempty"`
+	Options         []flexibleJSONOption `json:"options"`
+}
+
+type flexibleJSONOption struct {
+	RoomID       int    `json:"room_id"`
+	RoomName     string `json:"room_name"`
+	StartDate    string `json:"start_date"`
+	EndDate      string `json:"end_date"`
+	Price        int    `json:"price"`
+	DisplayPrice int    `json:"display_price,omitempty"`
+	Token        string `json:"token"`
+}
+
+// FlexibleAvailabilityJSON handles flexible date searches and sends a JSON response with a booking token for each
+// room and arrival date found
+func (m *Repository) FlexibleAvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	resp := flexibleJSONResponse{Currency: m.App.Rates.Base(), Options: []flexibleJSONOption{}}
+	code, rate := m.displayCurrency(r)
+	resp.DisplayCurrency = code
+
+	search, err := parseFlexibleSearch(r.URL.Query())
+	if err != nil {
+		resp.Message = i18n.Message(r.Context(), err)
+	} else {
+		var choices []roomChoice
+		choices, err = m.flexibleChoices(r, search)
+		if err != nil {
+			helpers.Log(r).Error("can't search flexible availability", "error", err)
+			resp.Message = i18n.T(r.Context(), "Error querying database")
+		}
+		for _, c := range choices {
+			option := flexibleJSONOption{
+				RoomID:    c.Room.ID,
+				RoomName:  c.Room.RoomName,
+				StartDate: c.Quote.StartDate.Format("2006-01-02"),
+				EndDate:   c.Quote.EndDate.Format("2006-01-02"),
+				Price:     c.Quote.Price,
+				Token:     c.Token,
+			}
+			if code != "" {
+				option.DisplayPrice = currency.Convert(c.Quote.Price, resp.Currency, code, currency.Rate(rate))
+			}
+			resp.Options = append(resp.Options, option)
+		}
+		resp.OK = err == nil
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+type calendarJSONResponse struct {
+	OK           bool              `json:"ok"`
+	Message      string            `json:"message"`
+	RoomID       int               `json:"room_id"`
+	CheckInTime  string            `json:"check_in_time"`
+	CheckOutTime string            `json:"check_out_time"`
+	Days         []calendarJSONDay `json:"days"`
+}
+
+type calendarJSONDay struct {
+	Date      string `json:"date"`
+	Available bool   `json:"available"` // the night starting on Date is free
+	CheckIn   bool   `json:"check_in"`  // a stay can start on Date
+	CheckOut  bool   `json:"check_out"` // a stay can end on Date
+}
+
+// RoomAvailabilityCalendar sends the availability of a room for each day of a range of months (from and to, as
+// yyyy-mm) as JSON. Only whether each night is free is sent, nothing about the reservations or blocks behind it.
+func (m *Repository) RoomAvailabilityCalendar(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	resp := calendarJSONResponse{
+		RoomID:       roomID,
+		CheckInTime:  checkInTime,
+		CheckOutTime: checkOutTime,
+		Days:         []calendarJSONDay{},
+	}
+
+	from, to, err := parseMonthRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		resp.Message = i18n.Message(r.Context(), err)
+	} else if _, err = m.db(r).GetRoomByID(roomID); err != nil {
+		resp.Message = i18n.T(r.Context(), "Room not found")
+	} else {
+		// the night before the range decides whether its first day can be a departure
+		var restrictions []models.RoomRestriction
+		var rules []models.StayRule
+		restrictions, err = m.db(r).GetRestrictionsForRoomByDate(roomID, from.AddDate(0, 0, -1), to)
+		if err == nil {
+			rules, err = m.db(r).GetStayRulesByDate(from, to)
+		}
+		if err != nil {
+			helpers.Log(r).Error("can't get restrictions for room", "room_id", roomID, "error", err)
+			resp.Message = i18n.T(r.Context(), "Error querying database")
+		} else {
+			resp.Days = calendarDays(roomID, from, to, restrictions, rules)
+			resp.OK = true
+		}
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// parseMonthRange parses a range of months given as yyyy-mm, defaulting to the current month, and returns the
+// first day of from and the first day after to. Its errors are meant for the guest.
+func parseMonthRange(from, to string) (time.Time, time.Time, error) {
+	start := dateOnly(time.Now())
+	start = start.AddDate(0, 0, 1-start.Day())
+
+	var err error
+	if from != "" {
+		start, err = time.Parse("2006-01", from)
+		if err != nil {
+			return start, start, errors.New("Invalid month, please use yyyy-mm")
+		}
+	}
+	end := start
+	if to != "" {
+		end, err = time.Parse("2006-01", to)
+		if err != nil {
+			return start, start, errors.New("Invalid month, please use yyyy-mm")
+		}
+	}
+	end = end.AddDate(0, 1, 0)
+
+	if !end.After(start) {
+		return start, end, errors.New("The last month must not be before the first")
+	}
+	if end.After(start.AddDate(0, maxCalendarMonths, 0)) {
+		return start, end, i18n.Errorf("The calendar covers at most %d months", maxCalendarMonths)
+	}
+
+	return start, end, nil
+}
+
+// calendarDays returns the availability of each day from from up to to, given the restrictions and stay rules of
+// the room. Days in the past are never available.
+func calendarDays(roomID int, from, to time.Time, restrictions []models.RoomRestriction, rules []models.StayRule) []calendarJSONDay {
+	booked := make(map[time.Time]bool)
+	for _, rr := range restrictions {
+		start := dateOnly(rr.StartDate)
+		if start.Before(from.AddDate(0, 0, -1)) {
+			start = from.AddDate(0, 0, -1)
+		}
+		end := dateOnly(rr.EndDate)
+		if end.After(to) {
+			end = to
+		}
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			booked[d] = true
+		}
+	}
+
+	today := dateOnly(time.Now())
+	var days []calendarJSONDay
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		free := !booked[d] && !d.Before(today)
+		days = append(days, calendarJSONDay{
+			Date:      d.Format("2006-01-02"),
+			Available: free,
+			CheckIn:   free && stayrules.ArrivalAllowed(rules, roomID, d),
+			CheckOut:  d.After(today) && !booked[d.AddDate(0, 0, -1)] && stayrules.DepartureAllowed(rules, roomID, d),
+		})
+	}
+	return days
+}
+
+// dateOnly returns the date of t at midnight UTC, the way dates come back from the database
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+type jsonResponse struct {
+	OK        bool   `json:"ok"`
+	Message   string `json:"message"`
+	RoomID    string `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Token     string `json:"token,omitempty"` // the booking token of the available room
+}
+
+// AvailabilityJSON handles request for availability and sends JSON response
+func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		// can't parse form, so return appropriate json
+		resp := jsonResponse{
+			OK:      false,
+			Message: i18n.T(r.Context(), "Internal server error"),
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+	start := r.Form.Get("start")
+	end := r.Form.Get("end")
+
+	// Validate start date
+	startDate, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: i18n.T(r.Context(), "Invalid start date format. Please use yyyy-mm-dd."),
+		}
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	// Validate end date
+	endDate, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: i18n.T(r.Context(), "Invalid end date format. Please use yyyy-mm-dd."),
+		}
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+	if !endDate.After(startDate) {
+		resp := jsonResponse{
+			OK:      false,
+			Message: i18n.T(r.Context(), "End date must be at least one day after start date."),
+		}
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+
+	available, err := m.db(r).SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID,
+		m.App.Session.GetString(r.Context(), "hold_key"))
+	if err != nil {
+		// got a database error, so return appropriate json
+		resp := jsonResponse{
+			OK:      false,
+			Message: i18n.T(r.Context(), "Error querying database"),
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+	message := ""
+	if available {
+		rules, err := m.db(r).GetStayRulesByDate(startDate, endDate)
+		if err != nil {
+			resp := jsonResponse{
+				OK:      false,
+				Message: i18n.T(r.Context(), "Error querying database"),
+			}
+
+			out, _ := json.MarshalIndent(resp, "", "     ")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(out)
+			return
+		}
+		if err := stayrules.Check(rules, roomID, startDate, endDate); err != nil {
+			available = false
+			message = i18n.Message(r.Context(), err)
+		}
+	}
+	token := ""
+	if available {
+		room, err := m.db(r).GetRoomByID(roomID)
+		if err != nil {
+			resp := jsonResponse{
+				OK:      false,
+				Message: i18n.T(r.Context(), "Error querying database"),
+			}
+
+			out, _ := json.MarshalIndent(resp, "", "     ")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(out)
+			return
+		}
+		adults, children, err := partySize(r.Form)
+		if err != nil {
+			available = false
+			message = i18n.T(r.Context(), "Please enter a valid number of guests")
+		} else if adults+children > room.MaxOccupancy {
+			available = false
+			message = i18n.T(r.Context(), "The rooms you chose sleep at most %d guests", room.MaxOccupancy)
+		} else {
+			// the room is booked with a signed quote, like the rooms of the search results
+			token = m.App.Quotes.Sign(quote.New(room, startDate, endDate, quoteTTL))
+		}
+	}
+	resp := jsonResponse{
+		OK:        available,
+		Message:   message,
+		StartDate: start,
+		EndDate:   end,
+		RoomID:    strconv.Itoa(roomID),
+		Token:     token,
+	}
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// Contact renders the contact page
+func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+}
+
+// ReservationSummary shows the guest the reservation of the signed link they were sent to after booking
+func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	id, err := m.App.Quotes.VerifyReservation(r.URL.Query().Get("r"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "This reservation link is not valid or has expired"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	reservation, err := m.db(r).GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "This reservation link is not valid or has expired"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if r.URL.Query().Get("payment") == "cancelled" {
+		m.App.Session.Put(r.Context(), "warning", i18n.T(r.Context(), "Your deposit was not paid. Your reservation is made, and we'll contact you about the deposit."))
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+
+	stringMap := make(map[string]string)
+	stringMap["cancellation_policy"] = cancellation.DescribeIn(i18n.FromContext(r.Context()), reservation.CancellationPolicy)
+	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// ChooseRoom takes the room picked from the search results on to the reservation form
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	roomID, err := strconv.Atoi(exploded[len(exploded)-1])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "missing url parameter"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	token := r.URL.Query().Get("t")
+	q, _, ok := m.verifyQuote(w, r, token)
+	if !ok {
+		return
+	}
+	if q.RoomID != roomID {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Your booking link is not valid, please search again"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	token, ok = m.holdRoom(w, r, q)
+	if !ok {
+		return
+	}
+
+	params := withParty(r, url.Values{"t": {token}})
+	http.Redirect(w, r, "/make-reservation?"+params.Encode(), http.StatusSeeOther)
+}
+
+// ChooseRooms takes several rooms picked from the search results on to one reservation form
+func (m *Repository) ChooseRooms(w http.ResponseWriter, r *http.Request) {
+	tokens := r.URL.Query()["t"]
+	if len(tokens) == 0 {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Please choose at least one room"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	params := url.Values{}
+	for _, token := range tokens {
+		q, _, ok := m.verifyQuote(w, r, token)
+		if !ok {
+			return
+		}
+		held, ok := m.holdRoom(w, r, q)
+		if !ok {
+			return
+		}
+		params.Add("t", held)
+	}
+
+	http.Redirect(w, r, "/make-reservation?"+withParty(r, params).Encode(), http.StatusSeeOther)
+}
+
+// holdRoom holds the room of q while the guest fills in the reservation form and returns the booking token for
+// the held quote. When the room can't be held the guest is redirected, and ok is false.
+func (m *Repository) holdRoom(w http.ResponseWriter, r *http.Request, q quote.Quote) (string, bool) {
+	holdID, available, err := m.db(r).HoldRoom(m.holdKey(r), q.RoomID, q.StartDate, q.EndDate, time.Now().Add(holdTTL))
+	if err != nil {
+		helpers.Log(r).Error("can't hold room", "room_id", q.RoomID, "error", err)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't hold room"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return "", false
+	}
+	if !available {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Sorry, this room is no longer available for your dates"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return "", false
+	}
+
+	q.HoldID = holdID
+	return m.App.Quotes.Sign(q), true
+}
+
+// holdKey returns the key identifying the holds of the guest's session, so choosing the same room twice extends
+// the hold instead of competing with it
+func (m *Repository) holdKey(r *http.Request) string {
+	key := m.App.Session.GetString(r.Context(), "hold_key")
+	if key == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		key = hex.EncodeToString(b)
+		m.App.Session.Put(r.Context(), "hold_key", key)
+	}
+	return key
+}
+
+// BookRoom starts a booking from the availability check on a room page, with the booking token the check signed
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	q, _, ok := m.verifyQuote(w, r, r.URL.Query().Get("t"))
+	if !ok {
+		return
+	}
+
+	token, ok := m.holdRoom(w, r, q)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, "/make-reservation?t="+url.QueryEscape(token), http.StatusSeeOther)
+}
+
+// checkStayRules makes sure none of the stays breaks the stay rules of its room. When one does the guest is sent
+// back to the search page with the reason, and ok is false.
+func (m *Repository) checkStayRules(w http.ResponseWriter, r *http.Request, stays []models.RoomStay) bool {
+	for _, stay := range stays {
+		rules, err := m.db(r).GetStayRulesByDate(stay.StartDate, stay.EndDate)
+		if err != nil {
+			helpers.Log(r).Error("can't get stay rules", "error", err)
+			m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "can't get availability for rooms"))
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return false
+		}
+		if err := stayrules.Check(rules, stay.RoomID, stay.StartDate, stay.EndDate); err != nil {
+			m.App.Session.Put(r.Context(), "error", i18n.Message(r.Context(), err))
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context()) // good practice to do a login or logout
+
+	err := r.ParseForm() // this populates the r.Form and r.PostForm
+	if err != nil {
+		helpers.Log(r).Error("can't parse login form", "error", err)
+	}
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	form := newForm(r)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["email"] = email
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+	id, _, err := m.DB.Authenticate(email, password)
+	if err != nil {
+		metrics.LoginFailures.Inc()
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Invalid login credentials"))
+		stringMap := make(map[string]string)
+		stringMap["email"] = email
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "Logged in successfully!"))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// GuestLogin shows the form where guests ask for a link to log in to their account
+func (m *Repository) GuestLogin(w http.ResponseWriter, r *http.Request) {
+	if helpers.GuestID(r) > 0 {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	render.Template(w, r, "guest-login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostGuestLogin emails a login link to the guest with the email. The reply is the same whether or not the email
+// belongs to a guest, so the form can't be used to find out who stayed here.
+func (m *Repository) PostGuestLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	email := strings.TrimSpace(r.Form.Get("email"))
+
+	form := newForm(r)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["email"] = email
+		render.Template(w, r, "guest-login.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	guest, err := m.db(r).GetGuestByEmail(email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if err == nil {
+		key := guestlogin.NewKey()
+		expires := time.Now().Add(guestlogin.TTL)
+		err = m.db(r).InsertGuestLoginToken(guest.ID, guestlogin.HashKey(key), expires)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.App.MailChan <- guestlogin.Mail(guest, m.siteURL(r), m.property(r).Email, key, expires)
+		helpers.Log(r).Info("guest login link sent", "guest_id", guest.ID)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "If you have booked with this email, we've sent it a link to log in"))
+	http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+}
+
+// GuestLoginLink is where the login link in the email leads. Guests confirm with a button, so mail scanners
+// opening the link don't use it up.
+func (m *Repository) GuestLoginLink(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["key"] = r.URL.Query().Get("k")
+
+	render.Template(w, r, "guest-login-link.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+	})
+}
+
+// PostGuestLoginLink logs the guest of a login link in, using the link up
+func (m *Repository) PostGuestLoginLink(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	guestID, err := m.db(r).UseGuestLoginToken(guestlogin.HashKey(r.Form.Get("k")))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "This login link is not valid or has expired, please ask for a new one"))
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), guestlogin.SessionKey, guestID)
+	helpers.Log(r).Info("guest logged in", "guest_id", guestID)
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// GuestLogout logs the guest out of their account, leaving a staff login in the same session alone
+func (m *Repository) GuestLogout(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Remove(r.Context(), guestlogin.SessionKey)
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "You're logged out"))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// GuestAccount shows the logged in guest their upcoming and past stays and their contact details
+func (m *Repository) GuestAccount(w http.ResponseWriter, r *http.Request) {
+	guest, ok := m.loggedInGuest(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderGuestAccount(w, r, guest, forms.New(nil))
+}
+
+// PostGuestAccount saves the contact details of the logged in guest. The email can't be changed here, since it is
+// what tells guests apart and where login links go.
+func (m *Repository) PostGuestAccount(w http.ResponseWriter, r *http.Request) {
+	guest, ok := m.loggedInGuest(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	guest.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	guest.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	guest.Phone = strings.TrimSpace(r.Form.Get("phone"))
+	guest.MarketingConsent = r.Form.Get("marketing_consent") != ""
+
+	form := newForm(r)
+	form.Required("first_name", "last_name")
+	if !form.Valid() {
+		m.renderGuestAccount(w, r, guest, form)
+		return
+	}
+
+	err = m.db(r).UpdateGuest(guest)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", i18n.T(r.Context(), "Your details are saved"))
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// GuestReservationInvoice downloads the invoice of one of the logged in guest's reservations
+func (m *Repository) GuestReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	// other guests' reservations look the same as missing ones
+	if res.GuestID != helpers.GuestID(r) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	inv, err := m.invoiceFor(r, res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	writePDF(w, invoice.Number(inv.Number)+".pdf", inv.PDF)
+}
+
+// loggedInGuest returns the guest logged in to their account. A guest that no longer exists, say merged into
+// another by staff, is logged out and sent to log in again; ok is false when a response was written.
+func (m *Repository) loggedInGuest(w http.ResponseWriter, r *http.Request) (models.Guest, bool) {
+	guest, err := m.db(r).GetGuestByID(helpers.GuestID(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Remove(r.Context(), guestlogin.SessionKey)
+		m.App.Session.Put(r.Context(), "error", i18n.T(r.Context(), "Log in to see your bookings"))
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return guest, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return guest, false
+	}
+	return guest, true
+}
+
+// renderGuestAccount renders the account page of guest, with their stays split into upcoming, soonest first, and
+// past ones
+func (m *Repository) renderGuestAccount(w http.ResponseWriter, r *http.Request, guest models.Guest, form *forms.Form) {
+	stays, err := m.db(r).GetReservationsForGuest(guest.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var upcoming, past []models.Reservation
+	now := time.Now()
+	for _, s := range stays {
+		if s.EndDate.After(now) {
+			upcoming = append(upcoming, s)
+		} else {
+			past = append(past, s)
+		}
+	}
+	slices.Reverse(upcoming)
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["upcoming"] = upcoming
+	data["past"] = past
+
+	render.Template(w, r, "guest-account.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+// AdminAllReservations shows all reservations inu admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.db(r).AllReservations()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewReservations shows all new reservations in admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.db(r).AllNewReservations()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	stringMap["month"] = month
+	stringMap["year"] = year
+
+	// get reservation from the database
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	reservationPayments, err := m.db(r).GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["payments"] = reservationPayments
+
+	if res.GuestID > 0 {
+		guest, err := m.db(r).GetGuestByID(res.GuestID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		history, err := m.db(r).GetReservationsForGuest(res.GuestID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		var others []models.Reservation
+		for _, h := range history {
+			if h.ID != res.ID {
+				others = append(others, h)
+			}
+		}
+		data["guest"] = guest
+		data["other_stays"] = others
+	}
+
+	render.Template(w, r, "admin-reservation-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostShowReservation posts a reservation
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	err = m.db(r).UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	month := r.Form.Get("month")
+	year := r.Form.Get("year")
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminSendBalanceLink emails the guest a link to pay the balance of a reservation whose deposit is paid
+func (m *Repository) AdminSendBalanceLink(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+	back := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if res.PaymentStatus != models.PaymentDepositPaid || res.BalanceAmount == 0 {
+		m.App.Session.Put(r.Context(), "error", "Only reservations with the deposit paid have a balance to pay")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	checkoutURL, err := m.startCheckout(r, res, models.PaymentKindBalance, res.BalanceAmount, "/payments/done",
+		"/payments/done?status=cancelled")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:      res.Email,
+		From:    m.property(r).Email,
+		Subject: "Balance of your reservation",
+		Content: fmt.Sprintf(`
+		<strong>Balance of your reservation</strong><br>
+		Dear %s: <br>
+		The balance of %s for reservation %d can be paid <a href="%s">here</a>.
+`, res.FirstName, m.guestAmount(res, res.BalanceAmount), res.ID, checkoutURL),
+		Template: "basic.html",
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Balance payment link sent")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminCancelReservation shows staff the refund the guest gets before they cancel a reservation
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["refund"] = cancellation.Refund(res.CancellationPolicy, res.Paid(), res.StartDate, time.Now())
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = chi.URLParam(r, "src")
+	stringMap["cancellation_policy"] = cancellation.Describe(res.CancellationPolicy)
+
+	render.Template(w, r, "admin-reservation-cancel.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPostCancelReservation cancels a reservation, refunding what its cancellation policy gives
+func (m *Repository) AdminPostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+	back := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	refund, err := m.cancelReservation(r, res)
+	if errors.Is(err, repository.ErrAlreadyCancelled) {
+		m.App.Session.Put(r.Context(), "error", "The reservation is already cancelled")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation cancelled, %s refunded", render.Money(refund)))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminReservationInvoice downloads the invoice of a reservation, issuing it the first time
+func (m *Repository) AdminReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	inv, err := m.invoiceFor(r, res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	writePDF(w, invoice.Number(inv.Number)+".pdf", inv.PDF)
+}
+
+// AdminReservationConfirmation downloads the booking confirmation of a reservation
+func (m *Repository) AdminReservationConfirmation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	writePDF(w, fmt.Sprintf("confirmation-%d.pdf", res.ID), invoice.Confirmation(m.issuer(r), res))
+}
+
+// writePDF sends a PDF document as a download named name
+func writePDF(w http.ResponseWriter, name string, data []byte) {
+	writeDownload(w, name, "application/pdf", data)
+}
+
+// writeDownload sends data of contentType as a download named name
+func writeDownload(w http.ResponseWriter, name, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write(data)
+}
+
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+	err := m.db(r).UpdateProcessedForReservation(id, 1)
+	if err != nil {
+		helpers.Log(r).Error("can't mark reservation as processed", "reservation_id", id, "error", err)
+	}
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminDeleteReservation deletes a reservation. One not yet cancelled is cancelled first like staff cancelling it,
+// with the refund its cancellation policy gives, so the guest is refunded and told, and the waitlist is offered its
+// rooms.
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	flash := "Reservation deleted"
+	if !res.Cancelled() {
+		refund, err := m.cancelReservation(r, res)
+		if err != nil && !errors.Is(err, repository.ErrAlreadyCancelled) {
+			helpers.ServerError(w, r, err)
+			return
+		} else if err == nil {
+			flash = fmt.Sprintf("Reservation cancelled, %s refunded, and deleted", render.Money(refund))
+		}
+	}
+
+	err = m.db(r).DeleteReservation(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	m.App.Session.Put(r.Context(), "flash", flash)
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	// assume that there is no month/year specified
+	now := time.Now()
+
+	if r.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	nextMonth := next.Format("01")
+	nextMonthYear := next.Format("2006")
+
+	lastMonth := last.Format("01")
+	lastMonthYear := last.Format("2006")
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = nextMonth
+	stringMap["next_month_year"] = nextMonthYear
+	stringMap["last_month"] = lastMonth
+	stringMap["last_month_year"] = lastMonthYear
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+
+	// get the first and last days of the month
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	rooms, err := m.db(r).AllRooms()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data["rooms"] = rooms
+	for _, x := range rooms {
+		// create maps
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+		holdMap := make(map[string]int)
+
+		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-2")] = 0
+			blockMap[d.Format("2006-01-2")] = 0
+			holdMap[d.Format("2006-01-2")] = 0
+		}
+
+		// get all the restrictions for the current room
+		restrictions, err := m.db(r).GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		for _, y := range restrictions {
+			if y.ReservationID > 0 {
+				// it's a reservation
+				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
+					reservationMap[d.Format("2006-01-2")] = y.ReservationID
+				}
+			} else if y.RestrictionID == models.RestrictionHold {
+				// a guest is filling in the reservation form, the nights are taken until the hold expires
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					holdMap[d.Format("2006-01-2")] = y.ID
+				}
+			} else {
+				// it's a block
+				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
+			}
+		}
+		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("hold_map_%d", x.ID)] = holdMap
+
+		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("y"))
+	month, _ := strconv.Atoi(r.Form.Get("m"))
+
+	// process blocks
+	rooms, err := m.db(r).AllRooms()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	for _, x := range rooms {
+		// Get the block map from the session. Loop through entire map, if we have an entry in the map
+		// that does not exist in our posted data, and if the restriction id > 0, then it is a block we need to
+		// remove.
+		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
+		for name, value := range curMap {
+			// ok will be false if the value is not in the map
+			if val, ok := curMap[name]; ok {
+				// only pay attention to values > 0, and that are not in the form post
+				// the rest are just placeholders for days without blocks
+				if val > 0 {
+					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
+						// delete the restriction by id
+						err := m.db(r).DeleteBlockByID(value)
+						if err != nil {
+							helpers.Log(r).Error("can't delete block", "block_id", value, "error", err)
+						} else {
+							day, _ := time.Parse("2006-01-2", name)
+							m.roomFreed(r, x.ID, day, day.AddDate(0, 0, 1))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// now handle new blocks
+	for name := range r.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			exploded := strings.Split(name, "_")
+			roomID, _ := strconv.Atoi(exploded[2])
+			t, _ := time.Parse("2006-01-2", exploded[3])
+			// insert a new block
+			err := m.db(r).InsertBlockForRoom(roomID, t)
+			if err != nil {
+				helpers.Log(r).Error("can't insert block", "room_id", roomID, "date", t.Format("2006-01-02"), "error", err)
+			}
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// activeSession is a session shown on the admin active sessions page
+type activeSession struct {
+	ID      string
+	UserID  int
+	User    models.User
+	Expires time.Time
+	Current bool
+}
+
+// AdminSessions shows the active sessions of the staff working on a property the current user works on, the sessions
+// expiring first at the top
+func (m *Repository) AdminSessions(w http.ResponseWriter, r *http.Request) {
+	currentToken := m.App.Session.Token(r.Context())
+	colleagues := make(map[int]bool)
+
+	var sessions []activeSession
+	err := m.App.Session.Iterate(r.Context(), func(ctx context.Context) error {
+		userID := m.App.Session.GetInt(ctx, "user_id")
+		if userID == 0 {
+			return nil
+		}
+		shared, checked := colleagues[userID]
+		if !checked {
+			var err error
+			shared, err = m.worksWith(r, userID)
+			if err != nil {
+				return err
+			}
+			colleagues[userID] = shared
+		}
+		if !shared {
+			return nil
+		}
+
+		token := m.App.Session.Token(ctx)
+		sum := sha256.Sum256([]byte(token))
+		s := activeSession{
+			// never show the token itself, it would let anyone reading the page take over the session
+			ID:      hex.EncodeToString(sum[:6]),
+			UserID:  userID,
+			Expires: m.App.Session.Deadline(ctx),
+			Current: token == currentToken,
+		}
+		u, err := m.DB.GetUserByID(s.UserID)
+		if err == nil {
+			s.User = u
+		}
+		sessions = append(sessions, s)
+		return nil
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Expires.Before(sessions[j].Expires)
+	})
+
+	data := make(map[string]interface{})
+	data["sessions"] = sessions
+
+	render.Template(w, r, "admin-sessions.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRevokeSessions destroys every session of a user working on a property the current user works on, logging them
+// out everywhere
+func (m *Repository) AdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Form.Get("user_id"))
+	if err != nil || userID < 1 {
+		m.App.Session.Put(r.Context(), "error", "Invalid user")
+		http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+		return
+	}
+	shared, err := m.worksWith(r, userID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !shared {
+		// staff of other properties are not listed, so they are as unknown here as users who don't exist
+		m.App.Session.Put(r.Context(), "error", "Invalid user")
+		http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+		return
+	}
+
+	revoked := 0
+	err = m.App.Session.Iterate(r.Context(), func(ctx context.Context) error {
+		if m.App.Session.GetInt(ctx, "user_id") != userID {
+			return nil
+		}
+		revoked++
+		return m.App.Session.Destroy(ctx)
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	helpers.Log(r).Info("revoked sessions", "revoked_user_id", userID, "count", revoked)
+
+	if userID == m.App.Session.GetInt(r.Context(), "user_id") {
+		// the current session was revoked too, so the cookie must not be saved again
+		_ = m.App.Session.Destroy(r.Context())
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Revoked %d session(s)", revoked))
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// worksWith reports whether the staff member userID works on one of the properties the current user can switch to
+func (m *Repository) worksWith(r *http.Request, userID int) (bool, error) {
+	properties, err := m.DB.GetPropertiesForUser(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range properties {
+		for _, mine := range property.Choices(r.Context()) {
+			if p.ID == mine.ID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// AdminProperty puts the property staff work on in the request context, with the properties they can switch to: the
+// one they switched to last, or else the first they have access to. Staff without access to any property are logged
+// out. It must run after Auth.
+func (m *Repository) AdminProperty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		choices, err := m.DB.GetPropertiesForUser(m.App.Session.GetInt(r.Context(), "user_id"))
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if len(choices) == 0 {
+			_ = m.App.Session.RenewToken(r.Context())
+			m.App.Session.Remove(r.Context(), "user_id")
+			m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		current := choices[0]
+		id := m.App.Session.GetInt(r.Context(), "property_id")
+		for _, p := range choices {
+			if p.ID == id {
+				current = p
+			}
+		}
+
+		ctx := property.WithChoices(r.Context(), choices)
+		ctx = property.WithProperty(ctx, current, property.Prefix(r.Context()))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AdminSwitchProperty switches staff to another property they have access to
+func (m *Repository) AdminSwitchProperty(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("property_id"))
+	for _, p := range property.Choices(r.Context()) {
+		if p.ID == id {
+			m.App.Session.Put(r.Context(), "property_id", p.ID)
+			m.App.Session.Put(r.Context(), "flash", "Switched to "+p.Name)
+			http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "error", "Invalid property")
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// LoadProperties reads the properties into the app, for finding the property of each request
+func (m *Repository) LoadProperties() error {
+	properties, err := m.DB.AllProperties()
+	if err != nil {
+		return err
+	}
+	if len(properties) == 0 {
+		return errors.New("there are no properties")
+	}
+
+	m.App.Properties.Set(properties)
+	return nil
+}
+
+// maxRuleNights is the largest minimum or maximum stay a stay rule can set
+const maxRuleNights = 365
+
+type stayRuleRow struct {
+	Rule     models.StayRule
+	Arrivals string
+}
+
+type weekdayOption struct {
+	Value   int
+	Name    string
+	Checked bool
+}
+
+// AdminStayRules lists the stay rules of all rooms
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := m.db(r).AllStayRules()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var rows []stayRuleRow
+	for _, rule := range rules {
+		rows = append(rows, stayRuleRow{Rule: rule, Arrivals: stayrules.ArrivalDays(rule)})
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rows
+
+	render.Template(w, r, "admin-stay-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowStayRule shows the form to edit a stay rule, or to add one when the id is 0
+func (m *Repository) AdminShowStayRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var rule models.StayRule
+	if id > 0 {
+		rule, err = m.db(r).GetStayRuleByID(id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Can't find stay rule")
+			http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+			return
+		}
+	}
+
+	m.renderStayRuleForm(w, r, rule, forms.New(nil))
+}
+
+// AdminPostStayRule saves a stay rule, adding it when the id is 0
+func (m *Repository) AdminPostStayRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rule := models.StayRule{
+		ID:                id,
+		ClosedToArrival:   r.Form.Get("closed_to_arrival") != "",
+		ClosedToDeparture: r.Form.Get("closed_to_departure") != "",
+	}
+	rule.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	for _, v := range r.Form["arrival_weekdays"] {
+		d, err := strconv.Atoi(v)
+		if err == nil && d >= int(time.Sunday) && d <= int(time.Saturday) {
+			rule.ArrivalWeekdays |= 1 << d
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date", "min_nights", "max_nights")
+	if form.IntRange("min_nights", 0, maxRuleNights) {
+		rule.MinNights, _ = strconv.Atoi(form.Get("min_nights"))
+	}
+	if form.IntRange("max_nights", 0, maxRuleNights) {
+		rule.MaxNights, _ = strconv.Atoi(form.Get("max_nights"))
+	}
+	if rule.MaxNights > 0 && rule.MaxNights < rule.MinNights {
+		form.Errors.Add("max_nights", "Maximum nights can't be less than minimum nights")
+	}
+
+	rule.StartDate, err = time.Parse("2006-01-02", form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Please use yyyy-mm-dd")
+	}
+	rule.EndDate, err = time.Parse("2006-01-02", form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Please use yyyy-mm-dd")
+	} else if rule.EndDate.Before(rule.StartDate) {
+		form.Errors.Add("end_date", "The last day can't be before the first")
+	}
+
+	if !form.Valid() {
+		m.renderStayRuleForm(w, r, rule, form)
+		return
+	}
+
+	if id > 0 {
+		err = m.db(r).UpdateStayRule(rule)
+	} else {
+		_, err = m.db(r).InsertStayRule(rule)
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule saved")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// renderStayRuleForm renders the form of a stay rule with the rooms it can apply to
+func (m *Repository) renderStayRuleForm(w http.ResponseWriter, r *http.Request, rule models.StayRule, form *forms.Form) {
+	rooms, err := m.db(r).AllRooms()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var weekdays []weekdayOption
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays = append(weekdays, weekdayOption{
+			Value:   int(d),
+			Name:    d.String(),
+			Checked: rule.ArrivalWeekdays&(1<<d) != 0,
+		})
+	}
+
+	stringMap := make(map[string]string)
+	if !rule.StartDate.IsZero() {
+		stringMap["start_date"] = rule.StartDate.Format("2006-01-02")
+	}
+	if !rule.EndDate.IsZero() {
+		stringMap["end_date"] = rule.EndDate.Format("2006-01-02")
+	}
+
+	data := make(map[string]interface{})
+	data["rule"] = rule
+	data["rooms"] = rooms
+	data["weekdays"] = weekdays
+
+	render.Template(w, r, "admin-stay-rule.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminDeleteStayRule deletes a stay rule
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).DeleteStayRule(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule deleted")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// chargeRuleRow is a charge rule as listed on the admin charges page
+type chargeRuleRow struct {
+	Rule   models.ChargeRule
+	Amount string
+}
+
+// chargePerLabels describe what flat charges are charged per
+var chargePerLabels = map[string]string{
+	models.ChargePerStay:       "per stay",
+	models.ChargePerNight:      "per night",
+	models.ChargePerGuest:      "per guest",
+	models.ChargePerGuestNight: "per guest per night",
+}
+
+// describeChargeRule returns the amount of rule for people, e.g. "12.50%" or "1.50 per guest per night"
+func describeChargeRule(rule models.ChargeRule) string {
+	if rule.Calculation == models.ChargePercent {
+		return render.Money(rule.Amount) + "%"
+	}
+	return render.Money(rule.Amount) + " " + chargePerLabels[rule.Per]
+}
+
+// AdminCharges lists the tax and fee rules
+func (m *Repository) AdminCharges(w http.ResponseWriter, r *http.Request) {
+	rules, err := m.db(r).AllChargeRules()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var rows []chargeRuleRow
+	for _, rule := range rules {
+		rows = append(rows, chargeRuleRow{Rule: rule, Amount: describeChargeRule(rule)})
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rows
+
+	render.Template(w, r, "admin-charges.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowCharge shows the form to edit a tax or fee rule, or to add one when the id is 0
+func (m *Repository) AdminShowCharge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rule := models.ChargeRule{Kind: models.ChargeTax, Calculation: models.ChargeFlat, Per: models.ChargePerStay}
+	if id > 0 {
+		rule, err = m.db(r).GetChargeRuleByID(id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Can't find tax or fee")
+			http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+			return
+		}
+	}
+
+	m.renderChargeForm(w, r, rule, forms.New(nil))
+}
+
+// AdminPostCharge saves a tax or fee rule, adding it when the id is 0
+func (m *Repository) AdminPostCharge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rule := models.ChargeRule{
+		ID:          id,
+		Name:        strings.TrimSpace(r.Form.Get("name")),
+		Kind:        r.Form.Get("kind"),
+		Calculation: r.Form.Get("calculation"),
+		Per:         r.Form.Get("per"),
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "kind", "calculation", "amount")
+	if rule.Kind != models.ChargeTax && rule.Kind != models.ChargeFee {
+		form.Errors.Add("kind", "Please choose tax or fee")
+	}
+	switch rule.Calculation {
+	case models.ChargePercent:
+		// percentages are of the room price of the whole stay
+		rule.Per = models.ChargePerStay
+	case models.ChargeFlat:
+		if _, ok := chargePerLabels[rule.Per]; !ok {
+			form.Errors.Add("per", "Please choose what the amount is charged per")
+		}
+	default:
+		form.Errors.Add("calculation", "Please choose a percentage or a flat amount")
+	}
+	if form.Has("amount") {
+		rule.Amount, _ = form.Hundredths("amount")
+	}
+
+	rule.StartDate, rule.EndDate = adminDateRange(form, "start_date", "end_date")
+
+	if !form.Valid() {
+		m.renderChargeForm(w, r, rule, form)
+		return
+	}
+
+	if id > 0 {
+		err = m.db(r).UpdateChargeRule(rule)
+	} else {
+		_, err = m.db(r).InsertChargeRule(rule)
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Tax or fee saved")
+	http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+}
+
+// adminDateRange reads the optional first and last day of a range from the fields from and to of form
+func adminDateRange(form *forms.Form, from, to string) (time.Time, time.Time) {
+	var start, end time.Time
+	var err error
+	if form.Has(from) {
+		start, err = time.Parse("2006-01-02", form.Get(from))
+		if err != nil {
+			form.Errors.Add(from, "Please use yyyy-mm-dd")
+		}
+	}
+	if form.Has(to) {
+		end, err = time.Parse("2006-01-02", form.Get(to))
+		if err != nil {
+			form.Errors.Add(to, "Please use yyyy-mm-dd")
+		} else if end.Before(start) {
+			form.Errors.Add(to, "The last day can't be before the first")
+		}
+	}
+	return start, end
+}
+
+// renderChargeForm renders the form of a tax or fee rule
+func (m *Repository) renderChargeForm(w http.ResponseWriter, r *http.Request, rule models.ChargeRule, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["amount"] = render.Money(rule.Amount)
+	if form.Has("amount") {
+		stringMap["amount"] = form.Get("amount")
+	}
+	if !rule.StartDate.IsZero() {
+		stringMap["start_date"] = rule.StartDate.Format("2006-01-02")
+	}
+	if !rule.EndDate.IsZero() {
+		stringMap["end_date"] = rule.EndDate.Format("2006-01-02")
+	}
+
+	data := make(map[string]interface{})
+	data["rule"] = rule
+	data["per"] = []struct{ Value, Label string }{
+		{models.ChargePerStay, chargePerLabels[models.ChargePerStay]},
+		{models.ChargePerNight, chargePerLabels[models.ChargePerNight]},
+		{models.ChargePerGuest, chargePerLabels[models.ChargePerGuest]},
+		{models.ChargePerGuestNight, chargePerLabels[models.ChargePerGuestNight]},
+	}
+
+	render.Template(w, r, "admin-charge.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminDeleteCharge deletes a tax or fee rule. Reservations keep the charges they were made with.
+func (m *Repository) AdminDeleteCharge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).DeleteChargeRule(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Tax or fee deleted")
+	http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+}
+
+// maxPromoCodeUses is the highest usage limit a promo code can have
+const maxPromoCodeUses = 1000000
+
+// promoCodeRow is a promo code as listed on the admin promo codes page
+type promoCodeRow struct {
+	Code     models.PromoCode
+	Discount string
+	Rooms    string
+}
+
+type roomOption struct {
+	Room    models.Room
+	Checked bool
+}
+
+// describeDiscount returns the discount of p for people, e.g. "10.00%" or "20.00 off"
+func describeDiscount(p models.PromoCode) string {
+	if p.Calculation == models.DiscountPercent {
+		return render.Money(p.Amount) + "%"
+	}
+	return render.Money(p.Amount) + " off"
+}
+
+// AdminPromoCodes lists the promo codes
+func (m *Repository) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	codes, err := m.db(r).AllPromoCodes()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	rooms, err := m.db(r).AllRooms()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	roomNames := make(map[int]string)
+	for _, room := range rooms {
+		roomNames[room.ID] = room.RoomName
+	}
+
+	var rows []promoCodeRow
+	for _, p := range codes {
+		row := promoCodeRow{Code: p, Discount: describeDiscount(p), Rooms: "All rooms"}
+		if len(p.RoomIDs) > 0 {
+			var names []string
+			for _, id := range p.RoomIDs {
+				names = append(names, roomNames[id])
+			}
+			row.Rooms = strings.Join(names, ", ")
+		}
+		rows = append(rows, row)
+	}
+
+	data := make(map[string]interface{})
+	data["codes"] = rows
+
+	render.Template(w, r, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowPromoCode shows the form to edit a promo code, or to add one when the id is 0
+func (m *Repository) AdminShowPromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	code := models.PromoCode{Calculation: models.DiscountPercent}
+	if id > 0 {
+		code, err = m.db(r).GetPromoCodeByID(id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Can't find promo code")
+			http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+			return
+		}
+	}
+
+	m.renderPromoCodeForm(w, r, code, forms.New(nil))
+}
+
+// AdminPostPromoCode saves a promo code, adding it when the id is 0
+func (m *Repository) AdminPostPromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	code := models.PromoCode{
+		ID:          id,
+		Code:        promo.Normalize(r.Form.Get("code")),
+		Description: strings.TrimSpace(r.Form.Get("description")),
+		Calculation: r.Form.Get("calculation"),
+	}
+	for _, v := range r.Form["room_ids"] {
+		roomID, err := strconv.Atoi(v)
+		if err == nil {
+			code.RoomIDs = append(code.RoomIDs, roomID)
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "calculation", "amount", "max_uses")
+	if !promo.ValidCode(code.Code) {
+		form.Errors.Add("code", "Codes can only have letters and digits, up to 50")
+	} else {
+		existing, err := m.db(r).GetPromoCodeByCode(code.Code)
+		if err == nil && existing.ID != id {
+			form.Errors.Add("code", "This code is already in use")
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	if form.Has("amount") {
+		code.Amount, _ = form.Hundredths("amount")
+	}
+	switch code.Calculation {
+	case models.DiscountPercent:
+		if code.Amount > 10000 {
+			form.Errors.Add("amount", "A percentage can be at most 100")
+		}
+	case models.DiscountFixed:
+	default:
+		form.Errors.Add("calculation", "Please choose a percentage or a fixed discount")
+	}
+	if form.IntRange("max_uses", 0, maxPromoCodeUses) {
+		code.MaxUses, _ = strconv.Atoi(form.Get("max_uses"))
+	}
+
+	code.ValidFrom, code.ValidTo = adminDateRange(form, "valid_from", "valid_to")
+	code.StayFrom, code.StayTo = adminDateRange(form, "stay_from", "stay_to")
+
+	if !form.Valid() {
+		m.renderPromoCodeForm(w, r, code, form)
+		return
+	}
+
+	if id > 0 {
+		err = m.db(r).UpdatePromoCode(code)
+	} else {
+		_, err = m.db(r).InsertPromoCode(code)
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code saved")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// renderPromoCodeForm renders the form of a promo code with the rooms it can apply to
+func (m *Repository) renderPromoCodeForm(w http.ResponseWriter, r *http.Request, code models.PromoCode, form *forms.Form) {
+	rooms, err := m.db(r).AllRooms()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var roomOptions []roomOption
+	for _, room := range rooms {
+		roomOptions = append(roomOptions, roomOption{Room: room, Checked: slices.Contains(code.RoomIDs, room.ID)})
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["amount"] = render.Money(code.Amount)
+	if form.Has("amount") {
+		stringMap["amount"] = form.Get("amount")
+	}
+	for field, t := range map[string]time.Time{
+		"valid_from": code.ValidFrom,
+		"valid_to":   code.ValidTo,
+		"stay_from":  code.StayFrom,
+		"stay_to":    code.StayTo,
+	} {
+		if !t.IsZero() {
+			stringMap[field] = t.Format("2006-01-02")
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["code"] = code
+	data["rooms"] = roomOptions
+
+	render.Template(w, r, "admin-promo-code.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminDeletePromoCode deletes a promo code. Reservations keep the code they redeemed.
+func (m *Repository) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).DeletePromoCode(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code deleted")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminGuests lists the guests matching the search, or the latest guests
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	guests, err := m.db(r).SearchGuests(query)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	stringMap := make(map[string]string)
+	stringMap["q"] = query
+
+	data := make(map[string]interface{})
+	data["guests"] = guests
+
+	render.Template(w, r, "admin-guests.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminShowGuest shows the profile of a guest with their stays and possible duplicates
+func (m *Repository) AdminShowGuest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	guest, err := m.db(r).GetGuestByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Can't find guest")
+		http.Redirect(w, r, "/admin/guests", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
 	}
+
+	m.renderGuest(w, r, guest, forms.New(nil))
 }
 
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-	_ = m.DB.DeleteReservation(id)
+// AdminPostGuest saves the profile of a guest
+func (m *Repository) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	guest, err := m.db(r).GetGuestByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Can't find guest")
+		http.Redirect(w, r, "/admin/guests", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	guest.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	guest.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	guest.Email = strings.TrimSpace(r.Form.Get("email"))
+	guest.Phone = strings.TrimSpace(r.Form.Get("phone"))
+	guest.Notes = strings.TrimSpace(r.Form.Get("notes"))
+	guest.VIP = r.Form.Get("vip") != ""
+	guest.DoNotRent = r.Form.Get("do_not_rent") != ""
+	guest.MarketingConsent = r.Form.Get("marketing_consent") != ""
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+	if form.Valid() {
+		other, err := m.db(r).GetGuestByEmail(guest.Email)
+		if err == nil && other.ID != guest.ID {
+			form.Errors.Add("email", "Another guest has this email, merge them instead")
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, r, err)
+			return
+		}
 	}
-}
 
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	// assume that there is no month/year specified
-	now := time.Now()
+	if !form.Valid() {
+		m.renderGuest(w, r, guest, form)
+		return
+	}
 
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	err = m.db(r).UpdateGuest(guest)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Guest saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", id), http.StatusSeeOther)
+}
+
+// renderGuest renders the profile of guest with their stays and the guests that may be the same person
+func (m *Repository) renderGuest(w http.ResponseWriter, r *http.Request, guest models.Guest, form *forms.Form) {
+	stays, err := m.db(r).GetReservationsForGuest(guest.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	duplicates, err := m.db(r).FindDuplicateGuests(guest)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
 	}
 
 	data := make(map[string]interface{})
-	data["now"] = now
+	data["guest"] = guest
+	data["stays"] = stays
+	data["duplicates"] = duplicates
 
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
+	render.Template(w, r, "admin-guest.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
 
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
+// AdminMergeGuest merges another guest into the guest, moving their reservations over
+func (m *Repository) AdminMergeGuest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
+	back := fmt.Sprintf("/admin/guests/%d", id)
+	mergeID, err := strconv.Atoi(r.Form.Get("merge_id"))
+	if err != nil || mergeID == id {
+		m.App.Session.Put(r.Context(), "error", "Choose another guest to merge")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
 
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
+	err = m.db(r).MergeGuests(id, mergeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Can't find guest")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	// get the first and last days of the month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	helpers.Log(r).Info("guests merged", "guest_id", id, "merged_guest_id", mergeID)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Guest %d merged into this guest", mergeID))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
 
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastOfMonth.Day()
+// AdminPrivacy shows the forms to export or erase the personal data held about an email, with the privacy log
+func (m *Repository) AdminPrivacy(w http.ResponseWriter, r *http.Request) {
+	m.renderPrivacy(w, r, strings.TrimSpace(r.URL.Query().Get("email")), forms.New(nil))
+}
 
-	rooms, err := m.DB.AllRooms()
+// AdminPrivacyExport downloads everything held about an email as a ZIP archive of JSON and invoice PDFs
+func (m *Repository) AdminPrivacyExport(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+	email := strings.TrimSpace(r.Form.Get("email"))
 
-	data["rooms"] = rooms
-	for _, x := range rooms {
-		// create maps
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		m.renderPrivacy(w, r, email, form)
+		return
+	}
 
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
-		}
+	bundle, err := privacy.Export(m.db(r), email, time.Now())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data, err := bundle.Zip()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-		// get all the restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
+	// the export is only handed over once it is logged
+	err = m.db(r).InsertPrivacyLog(models.PrivacyLogEntry{
+		Action:  models.PrivacyExport,
+		Subject: privacy.HashEmail(email),
+		UserID:  m.App.Session.GetInt(r.Context(), "user_id"),
+		Records: bundle.Records(),
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	helpers.Log(r).Info("personal data exported", "records", bundle.Records())
 
-		for _, y := range restrictions {
-			if y.ReservationID > 0 {
-				// it's a reservation
-				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationID
-				}
-			} else {
-				// it's a block
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
-		}
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+	writeDownload(w, fmt.Sprintf("personal-data-%s.zip", time.Now().Format("2006-01-02")), "application/zip", data)
+}
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+// AdminPrivacyErase anonymises the reservations made with an email and deletes its guest profile and waitlist
+// entries. Staff type the email twice, since the erasure can't be undone.
+func (m *Repository) AdminPrivacyErase(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
 	}
+	email := strings.TrimSpace(r.Form.Get("email"))
 
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+	form := forms.New(r.PostForm)
+	form.Required("email", "confirm_email")
+	form.IsEmail("email")
+	if form.Valid() && !strings.EqualFold(email, strings.TrimSpace(r.Form.Get("confirm_email"))) {
+		form.Errors.Add("confirm_email", "Type the same email again to confirm")
+	}
+	if !form.Valid() {
+		m.renderPrivacy(w, r, email, form)
+		return
+	}
+
+	records, err := m.db(r).ErasePersonalData(email, models.PrivacyLogEntry{
+		Action:  models.PrivacyErasure,
+		Subject: privacy.HashEmail(email),
+		UserID:  m.App.Session.GetInt(r.Context(), "user_id"),
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	helpers.Log(r).Info("personal data erased", "records", records)
+
+	if records == 0 {
+		m.App.Session.Put(r.Context(), "warning", "Nothing is held about this email")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Personal data erased from %d record(s)", records))
+	}
+	http.Redirect(w, r, "/admin/privacy", http.StatusSeeOther)
+}
+
+// renderPrivacy renders the privacy page with email filled in the forms
+func (m *Repository) renderPrivacy(w http.ResponseWriter, r *http.Request, email string, form *forms.Form) {
+	entries, err := m.db(r).GetPrivacyLog()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["email"] = email
+
+	data := make(map[string]interface{})
+	data["log"] = entries
+
+	render.Template(w, r, "admin-privacy.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
-		IntMap:    intMap,
+		Form:      form,
 	})
 }
 
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+// LoadExchangeRates reads the exchange rates into the app, for showing prices in the guest's currency
+func (m *Repository) LoadExchangeRates() error {
+	stored, err := m.DB.AllExchangeRates()
+	if err != nil {
+		return err
+	}
+
+	rates := make(map[string]currency.Rate, len(stored))
+	for _, e := range stored {
+		rates[e.Currency] = currency.Rate(e.Rate)
+	}
+	m.App.Rates.Set(rates)
+	return nil
+}
+
+// AdminExchangeRates lists the exchange rates with a form to add or update one
+func (m *Repository) AdminExchangeRates(w http.ResponseWriter, r *http.Request) {
+	m.renderExchangeRates(w, r, forms.New(nil))
+}
+
+// AdminPostExchangeRate adds the rate of a currency, or updates it when the currency has one
+func (m *Repository) AdminPostExchangeRate(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+	form := forms.New(r.PostForm)
+	form.Required("currency", "rate")
+	code, ok := currency.Parse(r.Form.Get("currency"))
+	if form.Has("currency") {
+		if !ok {
+			form.Errors.Add("currency", "Unknown currency code")
+		} else if code == m.App.Rates.Base() {
+			form.Errors.Add("currency", "Prices are already in this currency")
+		}
+	}
+	rate, err := currency.ParseRate(r.Form.Get("rate"))
+	if form.Has("rate") && err != nil {
+		form.Errors.Add("rate", err.Error())
+	}
+	if !form.Valid() {
+		m.renderExchangeRates(w, r, form)
+		return
+	}
 
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+	err = m.DB.UpsertExchangeRates([]models.ExchangeRate{{Currency: code, Rate: int64(rate)}})
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+	m.reloadExchangeRates(r)
 
-	form := forms.New(r.PostForm)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("1 %s = %s %s saved", m.App.Rates.Base(), rate, code))
+	http.Redirect(w, r, "/admin/exchange-rates", http.StatusSeeOther)
+}
 
-	for _, x := range rooms {
-		// Get the block map from the session. Loop through entire map, if we have an entry in the map
-		// that does not exist in our posted data, and if the restriction id > 0, then it is a block we need to
-		// remove.
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						// delete the restriction by id
-						err := m.DB.DeleteBlockByID(value)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-			}
-		}
+// AdminDeleteExchangeRate deletes the rate of a currency, so prices are no longer shown in it. Reservations keep
+// the rate they were made with.
+func (m *Repository) AdminDeleteExchangeRate(w http.ResponseWriter, r *http.Request) {
+	code, ok := currency.Parse(chi.URLParam(r, "currency"))
+	if !ok {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
 	}
 
-	// now handle new blocks
-	for name := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			t, _ := time.Parse("2006-01-2", exploded[3])
-			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, t)
-			if err != nil {
-				log.Println(err)
-			}
-		}
+	err := m.DB.DeleteExchangeRate(code)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
 	}
+	m.reloadExchangeRates(r)
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "Exchange rate deleted")
+	http.Redirect(w, r, "/admin/exchange-rates", http.StatusSeeOther)
+}
+
+// reloadExchangeRates reads the exchange rates after staff changed them. The change is saved, so a failure only
+// delays it until the next refresh.
+func (m *Repository) reloadExchangeRates(r *http.Request) {
+	err := m.LoadExchangeRates()
+	if err != nil {
+		helpers.Log(r).Error("can't load exchange rates", "error", err)
+	}
+}
+
+// exchangeRateRow is an exchange rate as listed to staff, with what 100 units of the base currency are worth
+type exchangeRateRow struct {
+	Rate    models.ExchangeRate
+	Value   string
+	Example string
+}
+
+// renderExchangeRates renders the exchange rates page with form
+func (m *Repository) renderExchangeRates(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rates, err := m.DB.AllExchangeRates()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	base := m.App.Rates.Base()
+	var rows []exchangeRateRow
+	for _, e := range rates {
+		rate := currency.Rate(e.Rate)
+		rows = append(rows, exchangeRateRow{Rate: e, Value: rate.String(),
+			Example: currency.Display(10000, base, e.Currency, rate)})
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["base"] = base
+
+	data := make(map[string]interface{})
+	data["rates"] = rows
+
+	render.Template(w, r, "admin-exchange-rates.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"html"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -12,8 +13,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/flaviusp23/bookings/internal/currency"
 	"github.com/flaviusp23/bookings/internal/driver"
+	"github.com/flaviusp23/bookings/internal/health"
+	"github.com/flaviusp23/bookings/internal/i18n"
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/payments"
+	"github.com/flaviusp23/bookings/internal/property"
+	"github.com/flaviusp23/bookings/internal/quote"
+	"github.com/flaviusp23/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
 )
 
 var theTests = []struct {
@@ -28,6 +37,8 @@ var theTests = []struct {
 	{"ms", "/majors-suite", "GET", http.StatusOK},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"guest login", "/account/login", "GET", http.StatusOK},
+	{"guest login link", "/account/login/verify?k=abc", "GET", http.StatusOK},
 }
 
 // TestHandlers tests all routes that don't require extra tests (gets)
@@ -52,56 +63,56 @@ func TestHandlers(t *testing.T) {
 // data for the Reservation handler, /make-reservation route
 var reservationTests = []struct {
 	name               string
-	reservation        models.Reservation
+	quote              quote.Quote
+	token              string
 	expectedStatusCode int
 	expectedLocation   string
 	expectedHTML       string
 }{
 	{
-		name: "reservation-in-session",
-		reservation: models.Reservation{
-			RoomID: 1,
-			Room: models.Room{
-				ID:       1,
-				RoomName: "General's Quarters",
-			},
-		},
+		name:               "valid-token",
+		quote:              testQuote(1, "2040-01-01", "2040-01-02"),
 		expectedStatusCode: http.StatusOK,
-		expectedHTML:       `action="/make-reservation"`,
+		expectedHTML:       `name="booking_token"`,
 	},
 	{
-		name:               "reservation-not-in-session",
-		reservation:        models.Reservation{},
+		name:               "missing-token",
 		expectedStatusCode: http.StatusSeeOther,
-		expectedLocation:   "/",
-		expectedHTML:       "",
+		expectedLocation:   "/search-availability",
 	},
 	{
-		name: "non-existent-room",
-		reservation: models.Reservation{
-			RoomID: 10000,
-			Room: models.Room{
-				ID:       10000,
-				RoomName: "General's Quarters",
-			},
-		},
+		name:               "tampered-token",
+		token:              "not-a-token",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name:               "expired-token",
+		quote:              expiredQuote(testQuote(1, "2040-01-01", "2040-01-02")),
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name:               "non-existent-room",
+		quote:              testQuote(10000, "2040-01-01", "2040-01-02"),
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
-		expectedHTML:       "",
 	},
 }
 
 // TestReservation tests the reservation handler
 func TestReservation(t *testing.T) {
 	for _, e := range reservationTests {
-		req, _ := http.NewRequest("GET", "/make-reservation", nil)
+		token := e.token
+		if e.quote.RoomID > 0 {
+			token = app.Quotes.Sign(e.quote)
+		}
+
+		req, _ := http.NewRequest("GET", "/make-reservation?t="+url.QueryEscape(token), nil)
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 
 		rr := httptest.NewRecorder()
-		if e.reservation.RoomID > 0 {
-			session.Put(ctx, "reservation", e.reservation)
-		}
 
 		handler := http.HandlerFunc(Repo.Reservation)
 		handler.ServeHTTP(rr, req)
@@ -132,52 +143,110 @@ func Time(date string) time.Time {
 	return t
 }
 
+// testQuote returns a quote for roomID between the given dates
+func testQuote(roomID int, start, end string) quote.Quote {
+	return quote.New(models.Room{ID: roomID, Price: 10000}, Time(start), Time(end), time.Hour)
+}
+
+// expiredQuote returns q with an expiry in the past
+func expiredQuote(q quote.Quote) quote.Quote {
+	q.Expires = time.Now().Add(-time.Minute)
+	return q
+}
+
+// bookingRecorder is the test repository keeping the last booking made, which it returns as that reservation
+type bookingRecorder struct {
+	repository.DatabaseRepo
+	booked *models.Reservation
+}
+
+// ForProperty returns the recorder scoped to the property with id
+func (b bookingRecorder) ForProperty(id int) repository.DatabaseRepo {
+	return bookingRecorder{DatabaseRepo: b.DatabaseRepo.ForProperty(id), booked: b.booked}
+}
+
+// InsertBooking books res and keeps it
+func (b bookingRecorder) InsertBooking(res models.Reservation) (int, error) {
+	id, err := b.DatabaseRepo.InsertBooking(res)
+	if err == nil {
+		res.ID = id
+		*b.booked = res
+	}
+	return id, err
+}
+
+// GetReservationByID returns the booking kept for its id
+func (b bookingRecorder) GetReservationByID(id int) (models.Reservation, error) {
+	if b.booked.ID != 0 && b.booked.ID == id {
+		return *b.booked, nil
+	}
+	return b.DatabaseRepo.GetReservationByID(id)
+}
+
+// recordBookings keeps the bookings made until the test ends, returning where the last one is kept
+func recordBookings(t *testing.T) *models.Reservation {
+	booked := &models.Reservation{}
+	db := Repo.DB
+	Repo.DB = bookingRecorder{DatabaseRepo: db, booked: booked}
+	t.Cleanup(func() { Repo.DB = db })
+	return booked
+}
+
+// heldQuote returns q carrying the hold with id
+func heldQuote(q quote.Quote, id int) quote.Quote {
+	q.HoldID = id
+	return q
+}
+
 // postReservationTests is the test data for the PostReservation handler test
 var postReservationTests = []struct {
 	name                 string
-	reservation          models.Reservation
+	quote                quote.Quote
 	postedData           url.Values
 	expectedResponseCode int
 	expectedLocation     string
 	expectedHTML         string
 }{
 	{
-		name: "valid-data",
-		reservation: models.Reservation{
-			StartDate: Time("2050-01-01"),
-			EndDate:   Time("2050-01-02"),
-			RoomID:    1,
-			Room: models.Room{
-				ID:       1,
-				RoomName: "General's Quarters",
-			},
-		},
+		name:  "valid-data",
+		quote: testQuote(1, "2040-01-01", "2040-01-02"),
 		postedData: url.Values{
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
 		expectedLocation:     "/reservation-summary",
 	},
 	{
-		name:                 "missing-post-body",
-		reservation:          models.Reservation{},
-		postedData:           nil,
+		name:  "arrival closed since the search",
+		quote: testQuote(1, "2045-06-01", "2045-06-03"),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
+		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
-		expectedLocation:     "/",
+		expectedLocation:     "/search-availability",
 	},
 	{
-		name:        "invalid-session",
-		reservation: models.Reservation{},
+		name:  "taxes and fees fail",
+		quote: testQuote(1, "2047-01-01", "2047-01-03"),
 		postedData: url.Values{
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
@@ -185,91 +254,167 @@ var postReservationTests = []struct {
 	},
 	{
 		name:                 "missing-post-body",
-		reservation:          models.Reservation{},
 		postedData:           nil,
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
 		expectedLocation:     "/",
 	},
 	{
-		name: "invalid-room-id",
-		reservation: models.Reservation{
-			StartDate: Time("2050-01-01"),
-			EndDate:   Time("2050-01-02"),
-			RoomID:    9999, // Invalid room ID
-			Room: models.Room{
-				ID:       9999,
-				RoomName: "Unknown Room",
-			},
+		name: "missing-token",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/search-availability",
+	},
+	{
+		name:  "expired-token",
+		quote: expiredQuote(testQuote(1, "2040-01-01", "2040-01-02")),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/search-availability",
+	},
+	{
+		name:  "invalid-room-id",
+		quote: testQuote(9999, "2040-01-01", "2040-01-02"),
 		postedData: url.Values{
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
 		expectedLocation:     "/",
 	},
 	{
-		name: "invalid-data",
-		reservation: models.Reservation{
-			StartDate: Time("2050-01-01"),
-			EndDate:   Time("2050-01-02"),
-			RoomID:    1,
-			Room: models.Room{
-				ID:       1,
-				RoomName: "General's Quarters",
-			},
-		},
+		name:  "invalid-data",
+		quote: testQuote(1, "2040-01-01", "2040-01-02"),
 		postedData: url.Values{
 			"first_name": {"J"}, // Too short
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
 		expectedResponseCode: http.StatusOK,
 		expectedHTML:         `action="/make-reservation"`,
 		expectedLocation:     "",
 	},
 	{
-		name: "database-insert-fails-reservation",
-		reservation: models.Reservation{
-			StartDate: Time("2050-01-01"),
-			EndDate:   Time("2050-01-02"),
-			RoomID:    1000,
-			Room: models.Room{
-				ID:       1000,
-				RoomName: "King's Suite",
-			},
+		name:  "hold-converted",
+		quote: heldQuote(testQuote(1, "2050-01-01", "2050-01-02"), 1),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/reservation-summary",
+	},
+	{
+		name:  "hold-expired-room-still-free",
+		quote: heldQuote(testQuote(1, "2040-01-01", "2040-01-02"), 2),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/reservation-summary",
+	},
+	{
+		name:  "hold-expired-room-taken",
+		quote: heldQuote(testQuote(1, "2050-01-01", "2050-01-02"), 2),
 		postedData: url.Values{
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
-		expectedLocation:     "/",
+		expectedLocation:     "/search-availability",
+	},
+	{
+		name:  "too-many-guests",
+		quote: testQuote(1, "2040-01-01", "2040-01-02"),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "sleep at most 2 guests",
+		expectedLocation:     "",
+	},
+	{
+		name:  "missing-party-size",
+		quote: testQuote(1, "2040-01-01", "2040-01-02"),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/make-reservation"`,
+		expectedLocation:     "",
 	},
 	{
-		name: "database-insert-restrictions",
-		reservation: models.Reservation{
-			StartDate: Time("2050-01-01"),
-			EndDate:   Time("2050-01-02"),
-			RoomID:    1000,
-			Room: models.Room{
-				ID:       1000,
-				RoomName: "King's Suite",
-			},
+		name:  "room-no-longer-available",
+		quote: testQuote(1, "2050-01-01", "2050-01-02"),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/search-availability",
+	},
+	{
+		name:  "database-insert-fails-reservation",
+		quote: testQuote(1000, "2040-01-01", "2040-01-02"),
 		postedData: url.Values{
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
+			"adults":     {"2"},
+			"children":   {"0"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
@@ -282,6 +427,9 @@ func TestPostReservation(t *testing.T) {
 	for _, e := range postReservationTests {
 		var req *http.Request
 		if e.postedData != nil {
+			if e.quote.RoomID > 0 {
+				e.postedData.Set("booking_token", app.Quotes.Sign(e.quote))
+			}
 			req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(e.postedData.Encode()))
 		} else {
 			req, _ = http.NewRequest("POST", "/make-reservation", nil)
@@ -290,9 +438,6 @@ func TestPostReservation(t *testing.T) {
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 		rr := httptest.NewRecorder()
-		if e.reservation.RoomID > 0 {
-			session.Put(ctx, "reservation", e.reservation)
-		}
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 		handler := http.HandlerFunc(Repo.PostReservation)
@@ -305,9 +450,15 @@ func TestPostReservation(t *testing.T) {
 		if e.expectedLocation != "" {
 			// get the URL from test
 			actualLoc, _ := rr.Result().Location()
-			if actualLoc.String() != e.expectedLocation {
+			if actualLoc.Path != e.expectedLocation {
 				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
 			}
+			if actualLoc.Path == "/reservation-summary" {
+				// the summary is found by a link signed for the reservation
+				if id, err := app.Quotes.VerifyReservation(actualLoc.Query().Get("r")); err != nil || id != 1 {
+					t.Errorf("failed %s: expected a summary link for reservation 1, got %d %v", e.name, id, err)
+				}
+			}
 		}
 
 		if e.expectedHTML != "" {
@@ -321,6 +472,107 @@ func TestPostReservation(t *testing.T) {
 	}
 }
 
+// TestPostReservationMultipleRooms tests booking several rooms in one reservation
+func TestPostReservationMultipleRooms(t *testing.T) {
+	tests := []struct {
+		name             string
+		quotes           []quote.Quote
+		adults           string
+		expectedCode     int
+		expectedLocation string
+		expectedStays    int
+	}{
+		{
+			name:             "all-rooms-free",
+			quotes:           []quote.Quote{testQuote(1, "2040-01-01", "2040-01-03"), testQuote(2, "2040-01-01", "2040-01-03")},
+			adults:           "4",
+			expectedCode:     http.StatusSeeOther,
+			expectedLocation: "/reservation-summary",
+			expectedStays:    2,
+		},
+		{
+			name:             "same-room-twice",
+			quotes:           []quote.Quote{testQuote(1, "2040-01-01", "2040-01-03"), testQuote(1, "2040-01-01", "2040-01-03")},
+			adults:           "2",
+			expectedCode:     http.StatusSeeOther,
+			expectedLocation: "/reservation-summary",
+			expectedStays:    1,
+		},
+		{
+			name:             "same-room-for-two-stays",
+			quotes:           []quote.Quote{testQuote(1, "2040-01-01", "2040-01-03"), testQuote(1, "2040-01-05", "2040-01-07")},
+			adults:           "2",
+			expectedCode:     http.StatusSeeOther,
+			expectedLocation: "/reservation-summary",
+			expectedStays:    2,
+		},
+		{
+			name:             "same-room-for-overlapping-stays",
+			quotes:           []quote.Quote{testQuote(1, "2040-01-01", "2040-01-03"), testQuote(1, "2040-01-02", "2040-01-04")},
+			adults:           "2",
+			expectedCode:     http.StatusSeeOther,
+			expectedLocation: "/search-availability",
+		},
+		{
+			name:         "party-too-big-for-rooms",
+			quotes:       []quote.Quote{testQuote(1, "2040-01-01", "2040-01-03"), testQuote(2, "2040-01-01", "2040-01-03")},
+			adults:       "5",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:             "one-room-taken",
+			quotes:           []quote.Quote{testQuote(1, "2040-01-01", "2040-01-03"), testQuote(2, "2050-01-01", "2050-01-03")},
+			adults:           "2",
+			expectedCode:     http.StatusSeeOther,
+			expectedLocation: "/search-availability",
+		},
+	}
+
+	booked := recordBookings(t)
+	for _, e := range tests {
+		postedData := url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"adults":     {e.adults},
+			"children":   {"0"},
+		}
+		for _, q := range e.quotes {
+			postedData.Add("booking_token", app.Quotes.Sign(q))
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+			continue
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.Path != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedStays > 0 {
+			res := *booked
+			if len(res.Stays) != e.expectedStays {
+				t.Errorf("%s: expected %d room stays but got %d", e.name, e.expectedStays, len(res.Stays))
+			}
+			if res.Price != e.expectedStays*20000 {
+				t.Errorf("%s: expected a total of %d but got %d", e.name, e.expectedStays*20000, res.Price)
+			}
+		}
+	}
+}
+
 func TestNewRepo(t *testing.T) {
 	var db driver.DB
 	testRepo := NewRepo(&app, &db)
@@ -354,11 +606,72 @@ var testAvailabilityJSONData = []struct {
 		},
 		expectedOK: true,
 	},
+	{
+		name: "stay within the stay rules",
+		postedData: url.Values{
+			"start":   {"2045-03-06"},
+			"end":     {"2045-03-08"},
+			"room_id": {"1"},
+		},
+		expectedOK: true,
+	},
+	{
+		name: "stay shorter than the minimum",
+		postedData: url.Values{
+			"start":   {"2045-03-06"},
+			"end":     {"2045-03-07"},
+			"room_id": {"1"},
+		},
+		expectedOK:      false,
+		expectedMessage: "Stays arriving on Mon 06 Mar 2045 must be at least 2 nights",
+	},
+	{
+		name: "stay rules query fails",
+		postedData: url.Values{
+			"start":   {"2046-01-01"},
+			"end":     {"2046-01-03"},
+			"room_id": {"1"},
+		},
+		expectedOK:      false,
+		expectedMessage: "Error querying database",
+	},
+	{
+		name: "departure before arrival",
+		postedData: url.Values{
+			"start":   {"2040-01-05"},
+			"end":     {"2040-01-01"},
+			"room_id": {"1"},
+		},
+		expectedOK:      false,
+		expectedMessage: "End date must be at least one day after start date.",
+	},
+	{
+		name: "party too large for the room",
+		postedData: url.Values{
+			"start":    {"2040-01-01"},
+			"end":      {"2040-01-02"},
+			"room_id":  {"1"},
+			"adults":   {"2"},
+			"children": {"1"},
+		},
+		expectedOK:      false,
+		expectedMessage: "The rooms you chose sleep at most 2 guests",
+	},
+	{
+		name: "room query fails",
+		postedData: url.Values{
+			"start":   {"2040-01-01"},
+			"end":     {"2040-01-02"},
+			"room_id": {"44444"},
+		},
+		expectedOK:      false,
+		expectedMessage: "Error querying database",
+	},
 	{
 		name:            "empty post body",
 		postedData:      nil,
 		expectedOK:      false,
-		expectedMessage: "Internal Server Error",
+		expectedMessage: "Internal server error",
 	},
 	{
 		name: "database query fails",
@@ -420,6 +733,18 @@ func TestAvailabilityJSON(t *testing.T) {
 		if j.OK != e.expectedOK {
 			t.Errorf("%s: expected %v but got %v", e.name, e.expectedOK, j.OK)
 		}
+		if e.expectedMessage != "" && j.Message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, j.Message)
+		}
+		if j.OK {
+			// the booking token is what the room page books the room with
+			q, err := app.Quotes.Verify(j.Token)
+			if err != nil || q.RoomID != 1 || q.Nights() < 1 {
+				t.Errorf("%s: expected a booking token for the room, got %+v %v", e.name, q, err)
+			}
+		} else if j.Token != "" {
+			t.Errorf("%s: expected no booking token when the room isn't available", e.name)
+		}
 	}
 }
 
@@ -429,6 +754,7 @@ var testPostAvailabilityData = []struct {
 	postedData         url.Values
 	expectedStatusCode int
 	expectedLocation   string
+	expectedHTML       string
 }{
 	{
 		name: "rooms not available",
@@ -436,7 +762,8 @@ var testPostAvailabilityData = []struct {
 			"start": {"2050-01-01"},
 			"end":   {"2050-01-02"},
 		},
-		expectedStatusCode: http.StatusSeeOther,
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `action="/waitlist"`,
 	},
 	{
 		name: "rooms are available",
@@ -447,6 +774,49 @@ var testPostAvailabilityData = []struct {
 		},
 		expectedStatusCode: http.StatusOK,
 	},
+	{
+		name: "stay within the stay rules",
+		postedData: url.Values{
+			"start": {"2045-03-06"},
+			"end":   {"2045-03-08"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "arrival on a closed weekday",
+		postedData: url.Values{
+			"start": {"2045-03-05"},
+			"end":   {"2045-03-08"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "stay rules query fails",
+		postedData: url.Values{
+			"start": {"2046-01-01"},
+			"end":   {"2046-01-03"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "party fits",
+		postedData: url.Values{
+			"start":    {"2040-01-01"},
+			"end":      {"2040-01-02"},
+			"adults":   {"1"},
+			"children": {"1"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "invalid party size",
+		postedData: url.Values{
+			"start":  {"2040-01-01"},
+			"end":    {"2040-01-02"},
+			"adults": {"0"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
 	{
 		name:               "empty post body",
 		postedData:         url.Values{},
@@ -499,201 +869,2735 @@ func TestPostAvailability(t *testing.T) {
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
 		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
 	}
 }
 
-// reservationSummaryTests is the data to test ReservationSummary handler
-var reservationSummaryTests = []struct {
-	name               string
-	reservation        models.Reservation
-	url                string
-	expectedStatusCode int
-	expectedLocation   string
-}{
-	{
-		name: "res-in-session",
-		reservation: models.Reservation{
-			RoomID: 1,
-			Room: models.Room{
-				ID:       1,
-				RoomName: "General's Quarters",
-			},
-		},
-		url:                "/reservation-summary",
-		expectedStatusCode: http.StatusOK,
-		expectedLocation:   "",
-	},
-	{
-		name:               "res-not-in-session",
-		reservation:        models.Reservation{},
-		url:                "/reservation-summary",
-		expectedStatusCode: http.StatusSeeOther,
-		expectedLocation:   "/",
-	},
-}
-
-// TestReservationSummary tests the ReservationSummaryHandler
-func TestReservationSummary(t *testing.T) {
-	for _, e := range reservationSummaryTests {
-		req, _ := http.NewRequest("GET", e.url, nil)
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
+// TestPostAvailabilityWaitlist tests that fully booked dates offer the waitlist
+func TestPostAvailabilityWaitlist(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    string
+		end      string
+		waitlist bool
+	}{
+		{"fully booked", "2050-01-01", "2050-01-02", true},
+		{"free but breaking a stay rule", "2045-03-05", "2045-03-08", false},
+	}
 
+	for _, e := range tests {
+		postedData := url.Values{"start": {e.start}, "end": {e.end}}
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
-		if e.reservation.RoomID > 0 {
-			session.Put(ctx, "reservation", e.reservation)
-		}
-
-		handler := http.HandlerFunc(Repo.ReservationSummary)
 
+		handler := http.HandlerFunc(Repo.PostAvailability)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != e.expectedStatusCode {
-			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		if strings.Contains(rr.Body.String(), `action="/waitlist"`) != e.waitlist {
+			t.Errorf("%s: expected waitlist form %t", e.name, e.waitlist)
 		}
+	}
+}
 
-		if e.expectedLocation != "" {
-			actualLoc, _ := rr.Result().Location()
-			if actualLoc.String() != e.expectedLocation {
-				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
-			}
+// TestSearchOwnHold tests that guests find the room they hold themselves free when they search again
+func TestSearchOwnHold(t *testing.T) {
+	for _, key := range []string{"", "test-hold"} {
+		held := key != ""
+
+		postedData := url.Values{"start": {"2050-01-01"}, "end": {"2050-01-02"}}
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if held {
+			session.Put(ctx, "hold_key", key)
+		}
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+		if strings.Contains(rr.Body.String(), `action="/waitlist"`) == held {
+			t.Errorf("PostAvailability with hold %t: expected the waitlist form %t", held, !held)
+		}
+
+		postedData = url.Values{"start": {"2050-01-01"}, "end": {"2050-01-02"}, "room_id": {"1"}}
+		req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		ctx = getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if held {
+			session.Put(ctx, "hold_key", key)
+		}
+		rr = httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+		var j jsonResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &j)
+		if j.OK != held {
+			t.Errorf("AvailabilityJSON with hold %t: expected ok %t but got %t", held, held, j.OK)
 		}
 	}
 }
 
-// chooseRoomTests is the data for ChooseRoom handler tests, /choose-room/{id}
-var chooseRoomTests = []struct {
+// testJoinWaitlistData is data for the JoinWaitlist handler test
+var testJoinWaitlistData = []struct {
 	name               string
-	reservation        models.Reservation
-	url                string
+	postedData         url.Values
 	expectedStatusCode int
 	expectedLocation   string
+	expectedHTML       string
 }{
 	{
-		name: "reservation-in-session",
-		reservation: models.Reservation{
-			RoomID: 1,
-			Room: models.Room{
-				ID:       1,
-				RoomName: "General's Quarters",
-			},
-		},
-		url:                "/choose-room/1",
+		name: "valid",
+		postedData: url.Values{"start": {"2050-01-01"}, "end": {"2050-01-03"}, "adults": {"2"}, "room_id": {"1"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}},
 		expectedStatusCode: http.StatusSeeOther,
-		expectedLocation:   "/make-reservation",
+		expectedLocation:   "/search-availability",
 	},
 	{
-		name:               "reservation-not-in-session",
-		reservation:        models.Reservation{},
-		url:                "/choose-room/1",
+		name: "any room",
+		postedData: url.Values{"start": {"2050-01-01"}, "end": {"2050-01-03"}, "room_id": {"0"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}},
 		expectedStatusCode: http.StatusSeeOther,
-		expectedLocation:   "/",
+		expectedLocation:   "/search-availability",
 	},
 	{
-		name:               "malformed-url",
-		reservation:        models.Reservation{},
-		url:                "/choose-room/fish",
+		name: "invalid email",
+		postedData: url.Values{"start": {"2050-01-01"}, "end": {"2050-01-03"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `action="/waitlist"`,
+	},
+	{
+		name: "invalid dates",
+		postedData: url.Values{"start": {"2050-01-03"}, "end": {"2050-01-01"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name: "invalid party size",
+		postedData: url.Values{"start": {"2050-01-01"}, "end": {"2050-01-03"}, "adults": {"0"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name: "database fails",
+		postedData: url.Values{"start": {"2050-01-01"}, "end": {"2050-01-03"}, "room_id": {"1000"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}},
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
 	},
 }
 
-// TestChooseRoom tests the ChooseRoom handler
-func TestChooseRoom(t *testing.T) {
-	for _, e := range chooseRoomTests {
-		req, _ := http.NewRequest("GET", e.url, nil)
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
-		// set the RequestURI on the request so that we can grab the ID from the URL
-		req.RequestURI = e.url
-
+// TestJoinWaitlist tests the JoinWaitlist handler
+func TestJoinWaitlist(t *testing.T) {
+	for _, e := range testJoinWaitlistData {
+		req, _ := http.NewRequest("POST", "/waitlist", strings.NewReader(e.postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
-		if e.reservation.RoomID > 0 {
-			session.Put(ctx, "reservation", e.reservation)
+
+		handler := http.HandlerFunc(Repo.JoinWaitlist)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
 		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
 
-		handler := http.HandlerFunc(Repo.ChooseRoom)
+// TestClaimWaitlist tests the page claim links emailed to waiting guests open
+func TestClaimWaitlist(t *testing.T) {
+	// the test repository waits in January 2041 for the claim key "test-claim-key"
+	stay := testQuote(1, "2041-01-01", "2041-01-03")
+	tests := []struct {
+		name               string
+		key                string
+		quote              quote.Quote
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "test-claim-key", stay, http.StatusOK, ""},
+		{"unknown key", "other-key", stay, http.StatusSeeOther, "/search-availability"},
+		{"other dates", "test-claim-key", testQuote(1, "2041-01-01", "2041-01-04"), http.StatusSeeOther, "/search-availability"},
+		{"expired link", "test-claim-key", expiredQuote(stay), http.StatusSeeOther, "/search-availability"},
+	}
+
+	for _, e := range tests {
+		params := url.Values{"k": {e.key}, "t": {app.Quotes.Sign(e.quote)}}
+		req, _ := http.NewRequest("GET", "/waitlist/claim?"+params.Encode(), nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ClaimWaitlist)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
-			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if rr.Code == http.StatusOK {
+			html := rr.Body.String()
+			if !strings.Contains(html, `action="/waitlist/claim"`) || !strings.Contains(html, `value="test-claim-key"`) {
+				t.Errorf("%s: page has no form to claim the room", e.name)
+			}
+			if !strings.Contains(html, `formaction="/waitlist/decline"`) {
+				t.Errorf("%s: page has no button to decline the room", e.name)
+			}
+			if strings.Contains(html, `name="booking_token"`) {
+				t.Errorf("%s: opening the link went straight to the reservation form", e.name)
+			}
 		}
+	}
+}
+
+// TestPostClaimWaitlist tests claiming the room offered to a waiting guest
+func TestPostClaimWaitlist(t *testing.T) {
+	// the test repository waits in January 2041 for the claim key "test-claim-key"; "test-claimed-key" is claimed
+	// by someone else between showing the offer and claiming it
+	stay := testQuote(1, "2041-01-01", "2041-01-03")
+	tests := []struct {
+		name               string
+		key                string
+		quote              quote.Quote
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "test-claim-key", stay, http.StatusOK, ""},
+		{"unknown key", "other-key", stay, http.StatusSeeOther, "/search-availability"},
+		{"claimed in the meantime", "test-claimed-key", stay, http.StatusSeeOther, "/search-availability"},
+		{"other dates", "test-claim-key", testQuote(1, "2041-01-01", "2041-01-04"), http.StatusSeeOther, "/search-availability"},
+		{"expired link", "test-claim-key", expiredQuote(stay), http.StatusSeeOther, "/search-availability"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"k": {e.key}, "t": {app.Quotes.Sign(e.quote)}}
+		req, _ := http.NewRequest("POST", "/waitlist/claim", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostClaimWaitlist)
+		handler.ServeHTTP(rr, req)
 
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
 		if e.expectedLocation != "" {
 			actualLoc, _ := rr.Result().Location()
 			if actualLoc.String() != e.expectedLocation {
 				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
 			}
 		}
+		if rr.Code == http.StatusOK {
+			html := rr.Body.String()
+			if !strings.Contains(html, `value="John"`) || !strings.Contains(html, `value="john@smith.com"`) {
+				t.Errorf("%s: reservation form is not filled in with the waiting guest", e.name)
+			}
+			if !strings.Contains(html, `name="booking_token"`) {
+				t.Errorf("%s: reservation form has no booking token", e.name)
+			}
+		}
 	}
 }
 
-// bookRoomTests is the data for the BookRoom handler tests
-var bookRoomTests = []struct {
+// TestPostDeclineWaitlist tests declining the room offered to a waiting guest
+func TestPostDeclineWaitlist(t *testing.T) {
+	tests := []struct {
+		name             string
+		key              string
+		expectedLocation string
+	}{
+		{"valid", "test-claim-key", "/"},
+		{"unknown key", "other-key", "/search-availability"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"k": {e.key}}
+		req, _ := http.NewRequest("POST", "/waitlist/decline", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostDeclineWaitlist)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+// testPostFlexibleAvailabilityData is data for the PostFlexibleAvailability handler test
+var testPostFlexibleAvailabilityData = []struct {
 	name               string
-	url                string
+	postedData         url.Values
 	expectedStatusCode int
+	expectedLocation   string
 }{
 	{
-		name:               "database-works",
-		url:                "/book-room?s=2050-01-01&e=2050-01-02&id=1",
+		name:               "month",
+		postedData:         url.Values{"month": {"2040-03"}, "nights": {"3"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "date window",
+		postedData:         url.Values{"from": {"2040-03-01"}, "to": {"2040-03-10"}, "nights": {"3"}, "adults": {"2"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "no availability",
+		postedData:         url.Values{"month": {"2050-03"}, "nights": {"3"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name:               "window shorter than stay",
+		postedData:         url.Values{"from": {"2040-03-01"}, "to": {"2040-03-02"}, "nights": {"3"}},
 		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
 	},
 	{
-		name:               "database-fails",
-		url:                "/book-room?s=2040-01-01&e=2040-01-02&id=4",
+		name:               "window too wide",
+		postedData:         url.Values{"from": {"2040-01-01"}, "to": {"2040-06-01"}, "nights": {"3"}},
 		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
 	},
 	{
-		name:               "invalid room id",
-		url:                "/book-room?s=2040-01-01&e=2040-01-02&id=44444",
+		name:               "missing nights",
+		postedData:         url.Values{"month": {"2040-03"}},
 		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
 	},
 	{
-		name:               "invalid start-date",
-		url:                "/book-room?s=&e=2060-01-02&id=4",
+		name:               "invalid month",
+		postedData:         url.Values{"month": {"March"}, "nights": {"3"}},
 		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
 	},
 	{
-		name:               "invalid end-date",
-		url:                "/book-room?s=2060-01-01&e=&id=4",
+		name:               "database query fails",
+		postedData:         url.Values{"month": {"2060-01"}, "nights": {"3"}},
 		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
 	},
 }
 
-// TestBookRoom tests the BookRoom handler
-func TestBookRoom(t *testing.T) {
-	reservation := models.Reservation{
-		RoomID: 1,
-		Room: models.Room{
-			ID:       1,
-			RoomName: "General's Quarters",
-		},
+// TestPostFlexibleAvailability tests the PostFlexibleAvailability handler
+func TestPostFlexibleAvailability(t *testing.T) {
+	for _, e := range testPostFlexibleAvailabilityData {
+		req, _ := http.NewRequest("POST", "/search-availability-flexible", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostFlexibleAvailability)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// TestFlexibleAvailabilityJSON tests the flexible date search JSON handler
+func TestFlexibleAvailabilityJSON(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		expectedOK      bool
+		expectedOptions int
+	}{
+		{"march", "month=2040-03&nights=3", true, 29},
+		{"window", "from=2040-03-01&to=2040-03-05&nights=2", true, 3},
+		{"no availability", "month=2050-03&nights=3", true, 0},
+		{"too many guests", "month=2040-03&nights=3&adults=3", true, 0},
+		{"invalid", "month=2040-03", false, 0},
+		{"database error", "month=2060-01&nights=3", false, 0},
 	}
 
-	for _, e := range bookRoomTests {
-		req, _ := http.NewRequest("GET", e.url, nil)
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/search-availability-flexible-json?"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.FlexibleAvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		var j flexibleJSONResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Fatalf("%s: failed to parse json: %v", e.name, err)
+		}
+
+		if j.OK != e.expectedOK {
+			t.Errorf("%s: expected ok %t but got %t (%s)", e.name, e.expectedOK, j.OK, j.Message)
+		}
+		if len(j.Options) != e.expectedOptions {
+			t.Errorf("%s: expected %d options but got %d", e.name, e.expectedOptions, len(j.Options))
+		}
+		for _, o := range j.Options {
+			q, err := app.Quotes.Verify(o.Token)
+			if err != nil || q.RoomID != o.RoomID || q.StartDate.Format("2006-01-02") != o.StartDate {
+				t.Errorf("%s: option %+v does not carry a matching booking token", e.name, o)
+				break
+			}
+		}
+	}
+}
+
+// TestRoomAvailabilityCalendar tests the availability calendar of a room
+func TestRoomAvailabilityCalendar(t *testing.T) {
+	tests := []struct {
+		name         string
+		roomID       string
+		query        string
+		expectedOK   bool
+		expectedDays int
+	}{
+		{"one month", "1", "from=2040-02", true, 29},
+		{"three months", "1", "from=2040-01&to=2040-03", true, 91},
+		{"current month", "1", "", true, -1},
+		{"invalid month", "1", "from=February", false, 0},
+		{"backwards range", "1", "from=2040-03&to=2040-01", false, 0},
+		{"range too long", "1", "from=2040-01&to=2041-06", false, 0},
+		{"room not found", "2000", "from=2040-02", false, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/rooms/"+e.roomID+"/availability?"+e.query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.RoomAvailabilityCalendar)
+		handler.ServeHTTP(rr, req)
+
+		var j calendarJSONResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Fatalf("%s: failed to parse json: %v", e.name, err)
+		}
+
+		if j.OK != e.expectedOK {
+			t.Errorf("%s: expected ok %t but got %t (%s)", e.name, e.expectedOK, j.OK, j.Message)
+		}
+		if e.expectedDays >= 0 && len(j.Days) != e.expectedDays {
+			t.Errorf("%s: expected %d days but got %d", e.name, e.expectedDays, len(j.Days))
+		}
+	}
+
+	// the test repository blocks tonight and the night after tomorrow
+	req, _ := http.NewRequest("GET", "/rooms/1/availability", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.RoomAvailabilityCalendar).ServeHTTP(rr, req)
+
+	var j calendarJSONResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &j)
+	today := time.Now().Format("2006-01-02")
+	for _, d := range j.Days {
+		if d.Date == today && (d.Available || d.CheckIn) {
+			t.Errorf("expected today to be booked but got %+v", d)
+		}
+		if d.Date < today && d.Available {
+			t.Errorf("expected %s in the past to be unavailable", d.Date)
+		}
+	}
+}
+
+// TestRoomAvailabilityCalendarHold tests that held nights show as taken
+func TestRoomAvailabilityCalendarHold(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/rooms/1/availability?from=2040-02", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.RoomAvailabilityCalendar).ServeHTTP(rr, req)
+
+	var j calendarJSONResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Fatal("failed to parse json!")
+	}
+
+	// the test repository holds the nights of 10 and 11 February
+	for _, d := range j.Days {
+		held := d.Date == "2040-02-10" || d.Date == "2040-02-11"
+		if d.Available == held {
+			t.Errorf("expected %s to be available %t but got %+v", d.Date, !held, d)
+		}
+	}
+}
+
+// TestAdminReservationsCalendar tests that the calendar shows held nights, which can't be changed like blocks
+func TestAdminReservationsCalendar(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-calendar?y=2040&m=2", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminReservationsCalendar)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminReservationsCalendar returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if n := strings.Count(rr.Body.String(), "Held while a guest books"); n != 2 {
+		t.Errorf("AdminReservationsCalendar: expected 2 held nights but found %d", n)
+	}
+	blocks, _ := session.Get(ctx, "block_map_1").(map[string]int)
+	if blocks["2040-02-10"] != 0 || blocks["2040-02-11"] != 0 {
+		t.Error("AdminReservationsCalendar: a hold was taken for a block, saving the calendar would delete it")
+	}
+}
+
+// TestRoomAvailabilityCalendarStayRules tests that days closed by stay rules can't be picked for arrival or departure
+func TestRoomAvailabilityCalendarStayRules(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/rooms/1/availability?from=2045-06", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.RoomAvailabilityCalendar).ServeHTTP(rr, req)
+
+	var j calendarJSONResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Fatal("failed to parse json!")
+	}
+
+	// the test repository closes 1 June to arrivals, 10 June to departures and Sundays to arrivals
+	for _, d := range j.Days {
+		switch d.Date {
+		case "2045-06-01", "2045-06-04":
+			if d.CheckIn {
+				t.Errorf("expected no check in on %s", d.Date)
+			}
+		case "2045-06-02":
+			if !d.CheckIn || !d.CheckOut {
+				t.Errorf("expected check in and out on %s but got %+v", d.Date, d)
+			}
+		case "2045-06-10":
+			if d.CheckOut || !d.CheckIn {
+				t.Errorf("expected check in but no check out on %s but got %+v", d.Date, d)
+			}
+		}
+	}
+}
+
+// reservationSummaryTests is the data to test ReservationSummary handler
+var reservationSummaryTests = []struct {
+	name               string
+	reservationID      int
+	expires            time.Duration
+	token              string // used when there is no reservation
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name:               "valid-link",
+		reservationID:      2,
+		expires:            time.Hour,
+		expectedStatusCode: http.StatusOK,
+		expectedLocation:   "",
+	},
+	{
+		name:               "expired-link",
+		reservationID:      2,
+		expires:            -time.Minute,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name:               "no-link",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name:               "booking-token",
+		token:              "booking",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+}
+
+// TestReservationSummary tests the ReservationSummaryHandler
+func TestReservationSummary(t *testing.T) {
+	for _, e := range reservationSummaryTests {
+		token := e.token
+		if e.reservationID > 0 {
+			token = app.Quotes.SignReservation(e.reservationID, time.Now().Add(e.expires))
+		} else if token == "booking" {
+			// a booking token is signed with the same key, but doesn't carry a reservation
+			token = app.Quotes.Sign(testQuote(2, "2040-01-01", "2040-01-02"))
+		}
+
+		req, _ := http.NewRequest("GET", "/reservation-summary?r="+url.QueryEscape(token), nil)
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 
 		rr := httptest.NewRecorder()
-		session.Put(ctx, "reservation", reservation)
 
-		handler := http.HandlerFunc(Repo.BookRoom)
+		handler := http.HandlerFunc(Repo.ReservationSummary)
 
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
-			t.Errorf("%s failed: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
 		}
 	}
 }
 
+// chooseRoomTests is the data for ChooseRoom handler tests, /choose-room/{id}
+var chooseRoomTests = []struct {
+	name               string
+	quote              quote.Quote
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name:               "valid-token",
+		quote:              testQuote(1, "2040-01-01", "2040-01-02"),
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/make-reservation?t=",
+	},
+	{
+		name:               "missing-token",
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name:               "room-taken",
+		quote:              testQuote(1, "2050-01-01", "2050-01-02"),
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name:               "token-for-other-room",
+		quote:              testQuote(2, "2040-01-01", "2040-01-02"),
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name:               "malformed-url",
+		url:                "/choose-room/fish",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+}
+
+// TestChooseRoom tests the ChooseRoom handler
+func TestChooseRoom(t *testing.T) {
+	for _, e := range chooseRoomTests {
+		u := e.url
+		if e.quote.RoomID > 0 {
+			u += "?t=" + url.QueryEscape(app.Quotes.Sign(e.quote))
+		}
+
+		req, _ := http.NewRequest("GET", u, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if !strings.HasPrefix(actualLoc.String(), e.expectedLocation) {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// TestChooseRooms tests choosing several rooms from the search results
+func TestChooseRooms(t *testing.T) {
+	tests := []struct {
+		name             string
+		quotes           []quote.Quote
+		expectedLocation string
+		expectedTokens   int
+	}{
+		{
+			name:             "two-rooms",
+			quotes:           []quote.Quote{testQuote(1, "2040-01-01", "2040-01-02"), testQuote(2, "2040-01-01", "2040-01-02")},
+			expectedLocation: "/make-reservation",
+			expectedTokens:   2,
+		},
+		{
+			name:             "no-rooms",
+			expectedLocation: "/search-availability",
+		},
+		{
+			name:             "one-room-taken",
+			quotes:           []quote.Quote{testQuote(1, "2040-01-01", "2040-01-02"), testQuote(2, "2050-01-01", "2050-01-02")},
+			expectedLocation: "/search-availability",
+		},
+	}
+
+	for _, e := range tests {
+		params := url.Values{}
+		for _, q := range e.quotes {
+			params.Add("t", app.Quotes.Sign(q))
+		}
+
+		req, _ := http.NewRequest("GET", "/choose-rooms?"+params.Encode(), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ChooseRooms)
+		handler.ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if rr.Code != http.StatusSeeOther || actualLoc.Path != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %s but got %d %s", e.name, e.expectedLocation, rr.Code, actualLoc)
+			continue
+		}
+
+		tokens := actualLoc.Query()["t"]
+		if len(tokens) != e.expectedTokens {
+			t.Errorf("%s: expected %d booking tokens but got %d", e.name, e.expectedTokens, len(tokens))
+		}
+		for _, token := range tokens {
+			q, err := app.Quotes.Verify(token)
+			if err != nil || q.HoldID == 0 {
+				t.Errorf("%s: expected a valid token for a held room, got %+v %v", e.name, q, err)
+			}
+		}
+	}
+}
+
+// bookRoomTests is the data for the BookRoom handler tests
+var bookRoomTests = []struct {
+	name             string
+	quote            quote.Quote
+	token            string // used when there is no quote
+	expectedLocation string
+}{
+	{
+		name:             "database-works",
+		quote:            testQuote(1, "2040-01-01", "2040-01-02"),
+		expectedLocation: "/make-reservation?t=",
+	},
+	{
+		name:             "room-not-available",
+		quote:            testQuote(1, "2050-01-01", "2050-01-02"),
+		expectedLocation: "/search-availability",
+	},
+	{
+		name:             "database-fails",
+		quote:            testQuote(4, "2060-01-01", "2060-01-02"),
+		expectedLocation: "/",
+	},
+	{
+		name:             "invalid room id",
+		quote:            testQuote(44444, "2040-01-01", "2040-01-02"),
+		expectedLocation: "/",
+	},
+	{
+		name:             "expired token",
+		quote:            expiredQuote(testQuote(1, "2040-01-01", "2040-01-02")),
+		expectedLocation: "/search-availability",
+	},
+	{
+		name:             "departure before arrival",
+		quote:            testQuote(1, "2040-01-05", "2040-01-01"),
+		expectedLocation: "/search-availability",
+	},
+	{
+		name:             "no nights",
+		quote:            testQuote(1, "2040-01-05", "2040-01-05"),
+		expectedLocation: "/search-availability",
+	},
+	{
+		name:             "missing token",
+		expectedLocation: "/search-availability",
+	},
+	{
+		name:             "forged token",
+		token:            "bm90.aXQ",
+		expectedLocation: "/search-availability",
+	},
+}
+
+// TestBookRoom tests the BookRoom handler
+func TestBookRoom(t *testing.T) {
+	for _, e := range bookRoomTests {
+		token := e.token
+		if e.quote.RoomID > 0 {
+			token = app.Quotes.Sign(e.quote)
+		}
+		req, _ := http.NewRequest("GET", "/book-room?t="+url.QueryEscape(token), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.BookRoom)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s failed: returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if e.expectedLocation == "/" && actualLoc.String() != "/" {
+			t.Errorf("failed %s: expected location /, but got location %s", e.name, actualLoc.String())
+		}
+		if !strings.HasPrefix(actualLoc.String(), e.expectedLocation) {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+// TestHealthz tests the liveness handler
+func TestHealthz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Healthz)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("healthz returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+// TestReadyz tests the readiness handler, which fails without a database and while shutting down
+func TestReadyz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Readyz)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz returned wrong response code: got %d, wanted %d", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	var report health.Report
+	err := json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatal("failed to parse json!")
+	}
+	if report.Checks["database"].OK {
+		t.Error("database check passed without a database")
+	}
+	if !report.Checks["templates"].OK {
+		t.Error("templates check failed with a loaded template cache")
+	}
+
+	app.ShuttingDown.Store(true)
+	defer app.ShuttingDown.Store(false)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "shutting down") {
+		t.Error("readyz does not report shutting down")
+	}
+}
+
+// TestAdminSessions tests the active sessions page
+func TestAdminSessions(t *testing.T) {
+	// commit staff sessions to the store, so there is something to list: user 1 works on both properties and user 5
+	// on the first only
+	var tokens []string
+	for _, userID := range []int{1, 5} {
+		staff, _ := http.NewRequest("GET", "/", nil)
+		staffCtx := getCtx(staff)
+		session.Put(staffCtx, "user_id", userID)
+		token, _, err := session.Commit(staffCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	// the current user works on the second property only
+	lakeside, _ := app.Properties.Get(2)
+	req, _ := http.NewRequest("GET", "/admin/sessions", nil)
+	req = req.WithContext(property.WithChoices(getCtx(req), []models.Property{lakeside}))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminSessions)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminSessions returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `name="user_id" value="1"`) {
+		t.Error("session of staff sharing a property is not listed")
+	}
+	if strings.Contains(rr.Body.String(), `name="user_id" value="5"`) {
+		t.Error("session of staff of another property is listed")
+	}
+	for _, token := range tokens {
+		if strings.Contains(rr.Body.String(), token) {
+			t.Error("session token is shown on the page")
+		}
+	}
+}
+
+// TestAdminRevokeSessions tests revoking all sessions of a user
+func TestAdminRevokeSessions(t *testing.T) {
+	// user 5 works on the first property only
+	staff, _ := http.NewRequest("GET", "/", nil)
+	staffCtx := getCtx(staff)
+	session.Put(staffCtx, "user_id", 5)
+	token, _, err := session.Commit(staffCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fortSmythe, _ := app.Properties.Get(1)
+	lakeside, _ := app.Properties.Get(2)
+	tests := []struct {
+		name          string
+		choices       []models.Property
+		expectedError string
+		revoked       bool
+	}{
+		{"staff of another property", []models.Property{lakeside}, "Invalid user", false},
+		{"staff sharing a property", []models.Property{fortSmythe, lakeside}, "", true},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"user_id": {"5"}}
+		req, _ := http.NewRequest("POST", "/admin/sessions/revoke", strings.NewReader(postedData.Encode()))
+		ctx := property.WithChoices(getCtx(req), e.choices)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminRevokeSessions)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminRevokeSessions returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, msg)
+		}
+		_, found, _ := session.Store.Find(token)
+		if found == e.revoked {
+			t.Errorf("%s: expected the session revoked %t", e.name, e.revoked)
+		}
+	}
+}
+
+// TestAdminStayRules tests the stay rules list
+func TestAdminStayRules(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/stay-rules", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminStayRules)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminStayRules returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Monday, Tuesday, Wednesday, Thursday, Friday, Saturday") {
+		t.Error("arrival weekdays of a rule are not listed")
+	}
+}
+
+// TestAdminShowStayRule tests the stay rule form
+func TestAdminShowStayRule(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"new rule", "0", http.StatusOK, `action="/admin/stay-rules/0"`},
+		{"existing rule", "1", http.StatusOK, `value="2045-12-31"`},
+		{"missing rule", "99", http.StatusSeeOther, ""},
+		{"invalid id", "x", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/stay-rules/"+e.id, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminPostStayRule tests adding and editing stay rules
+func TestAdminPostStayRule(t *testing.T) {
+	valid := url.Values{
+		"room_id":          {"1"},
+		"start_date":       {"2045-07-01"},
+		"end_date":         {"2045-08-31"},
+		"min_nights":       {"3"},
+		"max_nights":       {"14"},
+		"arrival_weekdays": {"5", "6"},
+	}
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range valid {
+			v[k] = vs
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	tests := []struct {
+		name               string
+		id                 string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"new rule", "0", valid, http.StatusSeeOther, ""},
+		{"edit rule", "1", valid, http.StatusSeeOther, ""},
+		{"last day before first", "0", with("end_date", "2045-06-30"), http.StatusOK, "The last day can&#39;t be before the first"},
+		{"maximum below minimum", "0", with("max_nights", "2"), http.StatusOK, "Maximum nights can&#39;t be less than minimum nights"},
+		{"invalid nights", "0", with("min_nights", "lots"), http.StatusOK, "is-invalid"},
+		{"invalid date", "0", with("start_date", "July"), http.StatusOK, "Please use yyyy-mm-dd"},
+		{"database fails", "0", with("room_id", "1000"), http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/stay-rules/"+e.id, strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminDeleteStayRule tests deleting a stay rule
+func TestAdminDeleteStayRule(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/stay-rules/1/delete", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminDeleteStayRule)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminDeleteStayRule returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+// TestPostReservationCharges tests that the taxes and fees of the dates are added to the price of a booking
+func TestPostReservationCharges(t *testing.T) {
+	booked := recordBookings(t)
+
+	postedData := url.Values{
+		"first_name":    {"John"},
+		"last_name":     {"Smith"},
+		"email":         {"john@smith.com"},
+		"adults":        {"2"},
+		"children":      {"0"},
+		"booking_token": {app.Quotes.Sign(testQuote(1, "2041-06-10", "2041-06-12"))},
+	}
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	res := *booked
+	if len(res.Charges) != 2 {
+		t.Fatalf("expected a cleaning fee and a tourist tax but got %+v", res.Charges)
+	}
+	// a cleaning fee of 25.00 and a tourist tax of 1.50 for two guests over two nights
+	if res.Charges[0].Amount != 2500 || res.Charges[1].Amount != 600 {
+		t.Errorf("expected charges of 2500 and 600 but got %d and %d", res.Charges[0].Amount, res.Charges[1].Amount)
+	}
+	if res.Price != 23100 {
+		t.Errorf("expected a total of 23100 but got %d", res.Price)
+	}
+
+	// the summary lists the charges
+	req, _ = http.NewRequest("GET", rr.Header().Get("Location"), nil)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ReservationSummary)
+	handler.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "Tourist tax:") || !strings.Contains(rr.Body.String(), "231.00") {
+		t.Error("summary does not list the charges and their total")
+	}
+}
+
+// TestPostReservationPromoCode tests redeeming promo codes when booking
+func TestPostReservationPromoCode(t *testing.T) {
+	booked := recordBookings(t)
+
+	tests := []struct {
+		name             string
+		code             string
+		expectedCode     int
+		expectedLocation string
+		expectedHTML     string
+		expectedDiscount int
+	}{
+		{"percent off", "summer10", http.StatusSeeOther, "/reservation-summary", "", 1000},
+		{"unknown code", "WINTER", http.StatusOK, "", "This promo code isn&#39;t valid", 0},
+		{"expired code", "EXPIRED", http.StatusOK, "", "This promo code has expired", 0},
+		{"code for other rooms", "ROOM2", http.StatusOK, "", "This promo code doesn&#39;t apply to the rooms and dates you chose", 0},
+		{"last use taken meanwhile", "LASTONE", http.StatusOK, "", "This promo code has been used up", 0},
+		{"database fails", "FAIL", http.StatusSeeOther, "/", "", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"first_name":    {"John"},
+			"last_name":     {"Smith"},
+			"email":         {"john@smith.com"},
+			"adults":        {"2"},
+			"children":      {"0"},
+			"promo_code":    {e.code},
+			"booking_token": {app.Quotes.Sign(testQuote(1, "2040-01-01", "2040-01-02"))},
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.Path != e.expectedLocation {
+				t.Errorf("%s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if e.expectedDiscount > 0 {
+			res := *booked
+			if res.PromoCode != "SUMMER10" || res.Discount != e.expectedDiscount || res.Price != 10000-e.expectedDiscount {
+				t.Errorf("%s: expected a discount of %d but got %s, %d off, price %d", e.name, e.expectedDiscount,
+					res.PromoCode, res.Discount, res.Price)
+			}
+		}
+	}
+}
+
+// TestAdminPromoCodes tests the promo codes list
+func TestAdminPromoCodes(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/promo-codes", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminPromoCodes)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPromoCodes returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "3 of 100") {
+		t.Error("uses of a code are not listed")
+	}
+}
+
+// TestAdminShowPromoCode tests the promo code form
+func TestAdminShowPromoCode(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"new code", "0", http.StatusOK, `action="/admin/promo-codes/0"`},
+		{"existing code", "3", http.StatusOK, `value="2020-12-31"`},
+		{"missing code", "99", http.StatusSeeOther, ""},
+		{"invalid id", "x", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/promo-codes/"+e.id, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowPromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminPostPromoCode tests adding and editing promo codes
+func TestAdminPostPromoCode(t *testing.T) {
+	valid := url.Values{
+		"code":        {"spring25"},
+		"description": {"Spring campaign"},
+		"calculation": {"percent"},
+		"amount":      {"25"},
+		"valid_from":  {"2041-03-01"},
+		"valid_to":    {"2041-05-31"},
+		"stay_from":   {""},
+		"stay_to":     {""},
+		"max_uses":    {"100"},
+		"room_ids":    {"1", "2"},
+	}
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range valid {
+			v[k] = vs
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	tests := []struct {
+		name               string
+		id                 string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"new code", "0", valid, http.StatusSeeOther, ""},
+		{"edit code", "1", with("code", "SUMMER10"), http.StatusSeeOther, ""},
+		{"code taken", "0", with("code", "SUMMER10"), http.StatusOK, "This code is already in use"},
+		{"invalid code", "0", with("code", "10% OFF"), http.StatusOK, "Codes can only have letters and digits"},
+		{"percent above 100", "0", with("amount", "150"), http.StatusOK, "A percentage can be at most 100"},
+		{"invalid calculation", "0", with("calculation", "free"), http.StatusOK, "Please choose a percentage or a fixed discount"},
+		{"invalid uses", "0", with("max_uses", "-1"), http.StatusOK, "is-invalid"},
+		{"last day before first", "0", with("valid_to", "2041-02-28"), http.StatusOK, "The last day can&#39;t be before the first"},
+		{"checking code fails", "0", with("code", "FAIL"), http.StatusInternalServerError, ""},
+		{"database fails", "0", with("code", "DBFAIL"), http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/promo-codes/"+e.id, strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostPromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminDeletePromoCode tests deleting a promo code
+func TestAdminDeletePromoCode(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/promo-codes/1/delete", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminDeletePromoCode)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminDeletePromoCode returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+// TestAdminGuests tests the guest search
+func TestAdminGuests(t *testing.T) {
+	tests := []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"latest guests", "", http.StatusOK, "Do not rent"},
+		{"search", "smith", http.StatusOK, `value="smith"`},
+		{"database fails", "fail", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/guests?q="+e.query, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminGuests)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminShowGuest tests the guest profile
+func TestAdminShowGuest(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"guest with a duplicate", "1", http.StatusOK, `name="merge_id" value="2"`},
+		{"stays of the guest", "1", http.StatusOK, `href="/admin/reservations/all/5/show"`},
+		{"missing guest", "99", http.StatusSeeOther, ""},
+		{"invalid id", "x", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/guests/"+e.id, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowGuest)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminPostGuest tests editing a guest profile
+func TestAdminPostGuest(t *testing.T) {
+	valid := url.Values{
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"phone":      {"555-0100"},
+		"notes":      {"Prefers a quiet room"},
+		"vip":        {"1"},
+	}
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range valid {
+			v[k] = vs
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	tests := []struct {
+		name               string
+		id                 string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid profile", "1", valid, http.StatusSeeOther, ""},
+		{"email of another guest", "1", with("email", "js@example.com"), http.StatusOK, "Another guest has this email"},
+		{"missing name", "1", with("first_name", ""), http.StatusOK, "is-invalid"},
+		{"invalid email", "1", with("email", "john"), http.StatusOK, "Invalid email address"},
+		{"missing guest", "99", valid, http.StatusSeeOther, ""},
+		{"checking email fails", "1", with("email", "fail@here.com"), http.StatusInternalServerError, ""},
+		{"database fails", "1", with("first_name", "fail"), http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/guests/"+e.id, strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostGuest)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminMergeGuest tests merging a duplicate guest
+func TestAdminMergeGuest(t *testing.T) {
+	tests := []struct {
+		name               string
+		mergeID            string
+		expectedStatusCode int
+		expectedFlash      string
+		expectedError      string
+	}{
+		{"duplicate merged", "2", http.StatusSeeOther, "Guest 2 merged into this guest", ""},
+		{"same guest", "1", http.StatusSeeOther, "", "Choose another guest to merge"},
+		{"no guest chosen", "", http.StatusSeeOther, "", "Choose another guest to merge"},
+		{"missing guest", "99", http.StatusSeeOther, "", "Can't find guest"},
+		{"database fails", "1000", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"merge_id": {e.mergeID}}
+		req, _ := http.NewRequest("POST", "/admin/guests/1/merge", strings.NewReader(postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminMergeGuest)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+// TestAdminShowReservationGuest tests that a reservation shows the guest's profile and other stays
+func TestAdminShowReservationGuest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/2/show", nil)
+	req.RequestURI = "/admin/reservations/all/2/show"
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminShowReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminShowReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `href="/admin/guests/1"`) || !strings.Contains(body, "Prefers a quiet room") {
+		t.Error("AdminShowReservation: expected the guest's profile on the page")
+	}
+	if !strings.Contains(body, `href="/admin/reservations/all/5/show"`) {
+		t.Error("AdminShowReservation: expected the guest's other stay on the page")
+	}
+	if strings.Contains(body, `href="/admin/reservations/all/2/show"`) {
+		t.Error("AdminShowReservation: the reservation itself is listed as another stay")
+	}
+	if !strings.Contains(body, `action="/admin/reservations/all/2/delete`) || strings.Contains(body, "/admin/delete-reservation/") {
+		t.Error("AdminShowReservation: expected deleting the reservation to post")
+	}
+}
+
+// TestAdminPrivacy tests the privacy page
+func TestAdminPrivacy(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/privacy?email=john@smith.com", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminPrivacy)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPrivacy returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `value="john@smith.com"`) {
+		t.Error("AdminPrivacy: expected the email filled in")
+	}
+	if !strings.Contains(rr.Body.String(), "Admin User") {
+		t.Error("AdminPrivacy: expected the log with the staff member of each entry")
+	}
+}
+
+// TestAdminPrivacyExport tests exporting the personal data held about an email
+func TestAdminPrivacyExport(t *testing.T) {
+	tests := []struct {
+		name                string
+		email               string
+		expectedStatusCode  int
+		expectedContentType string
+	}{
+		{"guest", "john@smith.com", http.StatusOK, "application/zip"},
+		{"nothing held", "nobody@here.com", http.StatusOK, "application/zip"},
+		{"invalid email", "john", http.StatusOK, "text/html"},
+		{"database fails", "fail@here.com", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/admin/privacy/export", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPrivacyExport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedContentType != "" && !strings.HasPrefix(rr.Header().Get("Content-Type"), e.expectedContentType) {
+			t.Errorf("%s: expected %s, got %q", e.name, e.expectedContentType, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// TestAdminPrivacyErase tests erasing the personal data held about an email
+func TestAdminPrivacyErase(t *testing.T) {
+	tests := []struct {
+		name               string
+		email              string
+		confirm            string
+		expectedStatusCode int
+		expectedFlash      string
+		expectedWarning    string
+		expectedHTML       string
+	}{
+		{"guest", "john@smith.com", "John@Smith.com", http.StatusSeeOther, "Personal data erased from 4 record(s)", "", ""},
+		{"nothing held", "nobody@here.com", "nobody@here.com", http.StatusSeeOther, "", "Nothing is held about this email", ""},
+		{"not confirmed", "john@smith.com", "jane@smith.com", http.StatusOK, "", "", "Type the same email again to confirm"},
+		{"invalid email", "john", "john", http.StatusOK, "", "", "Invalid email address"},
+		{"database fails", "fail@here.com", "fail@here.com", http.StatusInternalServerError, "", "", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"email": {e.email}, "confirm_email": {e.confirm}}
+		req, _ := http.NewRequest("POST", "/admin/privacy/erase", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPrivacyErase)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+		if warning := session.GetString(ctx, "warning"); warning != e.expectedWarning {
+			t.Errorf("%s: expected warning %q, got %q", e.name, e.expectedWarning, warning)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminExchangeRates tests the exchange rates list
+func TestAdminExchangeRates(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/exchange-rates", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminExchangeRates)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminExchangeRates returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	for _, want := range []string{"0.2011", html.EscapeString("100.00 RON (≈ 20.11 EUR)"), "/admin/exchange-rates/USD/delete"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("AdminExchangeRates: expected to find %s but did not", want)
+		}
+	}
+}
+
+// TestAdminPostExchangeRate tests adding and updating exchange rates
+func TestAdminPostExchangeRate(t *testing.T) {
+	tests := []struct {
+		name               string
+		currency           string
+		rate               string
+		expectedStatusCode int
+		expectedFlash      string
+		expectedHTML       string
+	}{
+		{"valid", "eur", "0.2011", http.StatusSeeOther, "1 RON = 0.2011 EUR saved", ""},
+		{"missing rate", "EUR", "", http.StatusOK, "", "This field cannot be blank"},
+		{"unknown currency", "XYZ", "1", http.StatusOK, "", "Unknown currency code"},
+		{"base currency", "RON", "1", http.StatusOK, "", "Prices are already in this currency"},
+		{"invalid rate", "EUR", "-0.2", http.StatusOK, "", "a rate must be a positive number"},
+		{"database fails", "XTS", "1", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"currency": {e.currency}, "rate": {e.rate}}
+		req, _ := http.NewRequest("POST", "/admin/exchange-rates", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostExchangeRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+
+	// the saved rates are used right away
+	if _, ok := app.Rates.Get("USD"); !ok {
+		t.Error("expected the rates to be reloaded after saving one")
+	}
+}
+
+// TestAdminDeleteExchangeRate tests deleting an exchange rate
+func TestAdminDeleteExchangeRate(t *testing.T) {
+	tests := []struct {
+		name               string
+		currency           string
+		expectedStatusCode int
+	}{
+		{"valid", "usd", http.StatusSeeOther},
+		{"unknown currency", "XYZ", http.StatusNotFound},
+		{"database fails", "XTS", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/exchange-rates/"+e.currency+"/delete", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("currency", e.currency)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteExchangeRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+// TestAdminProperty tests picking the property staff work on
+func TestAdminProperty(t *testing.T) {
+	tests := []struct {
+		name               string
+		userID             int
+		propertyID         int
+		expectedStatusCode int
+		expectedProperty   int
+	}{
+		{"first property", 1, 0, http.StatusOK, 1},
+		{"switched property", 1, 2, http.StatusOK, 2},
+		{"property without access", 1, 5, http.StatusOK, 1},
+		{"no properties", 2, 0, http.StatusSeeOther, 0},
+		{"database fails", 3, 0, http.StatusInternalServerError, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+		if e.propertyID != 0 {
+			session.Put(ctx, "property_id", e.propertyID)
+		}
+		rr := httptest.NewRecorder()
+
+		gotProperty := 0
+		gotChoices := 0
+		handler := Repo.AdminProperty(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotProperty = property.FromContext(r.Context()).ID
+			gotChoices = len(property.Choices(r.Context()))
+		}))
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if gotProperty != e.expectedProperty {
+			t.Errorf("%s: expected property %d, got %d", e.name, e.expectedProperty, gotProperty)
+		}
+		if e.expectedProperty != 0 && gotChoices != 2 {
+			t.Errorf("%s: expected 2 properties to switch to, got %d", e.name, gotChoices)
+		}
+	}
+
+	// staff without any property are logged out
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 2)
+	rr := httptest.NewRecorder()
+
+	Repo.AdminProperty(http.NotFoundHandler()).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/user/login" {
+		t.Errorf("AdminProperty: expected a redirect to the login, got %q", loc)
+	}
+	if session.Exists(ctx, "user_id") {
+		t.Error("AdminProperty: expected staff without any property to be logged out")
+	}
+}
+
+// TestAdminSwitchProperty tests switching to another property
+func TestAdminSwitchProperty(t *testing.T) {
+	choices, _ := Repo.DB.GetPropertiesForUser(1)
+
+	tests := []struct {
+		name             string
+		propertyID       string
+		expectedFlash    string
+		expectedError    string
+		expectedProperty int
+	}{
+		{"valid", "2", "Switched to Lakeside Lodge", "", 2},
+		{"property without access", "5", "", "Invalid property", 0},
+		{"not a number", "lakeside", "", "Invalid property", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"property_id": {e.propertyID}}
+		req, _ := http.NewRequest("POST", "/admin/property", strings.NewReader(postedData.Encode()))
+		ctx := property.WithChoices(getCtx(req), choices)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminSwitchProperty)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, msg)
+		}
+		if id := session.GetInt(ctx, "property_id"); id != e.expectedProperty {
+			t.Errorf("%s: expected property %d in the session, got %d", e.name, e.expectedProperty, id)
+		}
+	}
+}
+
+// TestPropertyIsolation checks that the reservations of one property can't be seen from another
+func TestPropertyIsolation(t *testing.T) {
+	lakeside, _ := app.Properties.Get(2)
+
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/1/show", nil)
+	req.RequestURI = "/admin/reservations/all/1/show"
+	req = req.WithContext(property.WithProperty(getCtx(req), lakeside, ""))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminShowReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("AdminShowReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(rr.Body.String(), "John") {
+		t.Error("AdminShowReservation: the reservation of another property was shown")
+	}
+}
+
+// TestAdminCharges tests the taxes and fees list
+func TestAdminCharges(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/charges", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminCharges)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminCharges returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "1.50 per guest per night") {
+		t.Error("amount of a rule is not listed")
+	}
+}
+
+// TestAdminShowCharge tests the tax and fee form
+func TestAdminShowCharge(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"new rule", "0", http.StatusOK, `action="/admin/charges/0"`},
+		{"existing rule", "2", http.StatusOK, `value="1.50"`},
+		{"missing rule", "99", http.StatusSeeOther, ""},
+		{"invalid id", "x", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/charges/"+e.id, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowCharge)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminPostCharge tests adding and editing taxes and fees
+func TestAdminPostCharge(t *testing.T) {
+	valid := url.Values{
+		"name":        {"Tourist tax"},
+		"kind":        {"tax"},
+		"calculation": {"flat"},
+		"amount":      {"1.50"},
+		"per":         {"guest_night"},
+		"start_date":  {"2041-01-01"},
+		"end_date":    {""},
+	}
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range valid {
+			v[k] = vs
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	tests := []struct {
+		name               string
+		id                 string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"new rule", "0", valid, http.StatusSeeOther, ""},
+		{"edit rule", "2", valid, http.StatusSeeOther, ""},
+		{"percentage", "0", with("calculation", "percent"), http.StatusSeeOther, ""},
+		{"missing name", "0", with("name", ""), http.StatusOK, "This field cannot be blank"},
+		{"invalid amount", "0", with("amount", "1.505"), http.StatusOK, "This field must be an amount like 12.50"},
+		{"invalid per", "0", with("per", "room"), http.StatusOK, "Please choose what the amount is charged per"},
+		{"invalid kind", "0", with("kind", "discount"), http.StatusOK, "Please choose tax or fee"},
+		{"last day before first", "0", with("end_date", "2040-12-31"), http.StatusOK, "The last day can&#39;t be before the first"},
+		{"database fails", "0", with("name", "fail"), http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/charges/"+e.id, strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCharge)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminDeleteCharge tests deleting a tax or fee
+func TestAdminDeleteCharge(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/charges/1/delete", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminDeleteCharge)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminDeleteCharge returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+// TestPostReservationDeposit tests that booking sends the guest to pay the deposit, and paying it at the fake
+// provider's checkout brings them back to the summary
+func TestPostReservationDeposit(t *testing.T) {
+	app.DepositPercent = 30
+	defer func() { app.DepositPercent = 0 }()
+
+	postedData := url.Values{
+		"first_name":    {"John"},
+		"last_name":     {"Smith"},
+		"email":         {"john@smith.com"},
+		"adults":        {"2"},
+		"children":      {"0"},
+		"booking_token": {app.Quotes.Sign(testQuote(1, "2040-01-01", "2040-01-02"))},
+	}
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	loc := rr.Header().Get("Location")
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(loc, "/payments/fake/") {
+		t.Fatalf("PostReservation: expected a redirect to the checkout, got %d to %q", rr.Code, loc)
+	}
+	id := strings.TrimPrefix(loc, "/payments/fake/")
+
+	// the checkout page shows the deposit
+	req, _ = http.NewRequest("GET", loc, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.FakeCheckout)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("FakeCheckout returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "30.00") {
+		t.Error("FakeCheckout: expected the deposit of 30.00 on the page")
+	}
+
+	// paying sends the guest to the summary of their reservation on the site of the property, and the checkout can't
+	// be paid again
+	for _, expectedLocation := range []string{"http://localhost:8080/reservation-summary", "/"} {
+		form := url.Values{"outcome": {"pay"}}
+		req, _ = http.NewRequest("POST", loc, strings.NewReader(form.Encode()))
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+
+		handler = http.HandlerFunc(Repo.PostFakeCheckout)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("PostFakeCheckout returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		}
+		actual, query, _ := strings.Cut(rr.Header().Get("Location"), "?")
+		if actual != expectedLocation {
+			t.Errorf("PostFakeCheckout: expected location %s, but got location %s", expectedLocation, actual)
+		}
+		if query != "" {
+			params, _ := url.ParseQuery(query)
+			if id, err := app.Quotes.VerifyReservation(params.Get("r")); err != nil || id != 1 {
+				t.Errorf("PostFakeCheckout: expected the summary link of reservation 1, got %d %v", id, err)
+			}
+		}
+	}
+}
+
+// TestPostFakeCheckoutDeclined tests declining a payment at the fake provider's checkout
+func TestPostFakeCheckoutDeclined(t *testing.T) {
+	session, _ := app.Payments.CreateCheckout(payments.Checkout{
+		ReservationID: 1,
+		Amount:        3000,
+		SuccessURL:    "/reservation-summary",
+		CancelURL:     "/reservation-summary?payment=cancelled",
+	})
+
+	form := url.Values{"outcome": {"decline"}}
+	req, _ := http.NewRequest("POST", session.URL, strings.NewReader(form.Encode()))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", session.ID)
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostFakeCheckout)
+	handler.ServeHTTP(rr, req)
+
+	if actual := rr.Header().Get("Location"); actual != "/reservation-summary?payment=cancelled" {
+		t.Errorf("PostFakeCheckout: expected the cancel location, but got location %s", actual)
+	}
+}
+
+// TestPaymentWebhook tests the payment provider's webhook
+func TestPaymentWebhook(t *testing.T) {
+	secret := []byte("test webhook secret")
+	event := func(id, sessionID string, amount int) []byte {
+		out, _ := json.Marshal(payments.Event{ID: id, Type: payments.EventSucceeded, SessionID: sessionID, Amount: amount})
+		return out
+	}
+
+	tests := []struct {
+		name               string
+		payload            []byte
+		signature          func(payload []byte) string
+		expectedStatusCode int
+	}{
+		{
+			name:               "valid",
+			payload:            event("evt_1", "fake_cs_1", 3000),
+			signature:          func(p []byte) string { return payments.Sign(secret, p, time.Now()) },
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "duplicate",
+			payload:            event("evt_duplicate", "fake_cs_1", 3000),
+			signature:          func(p []byte) string { return payments.Sign(secret, p, time.Now()) },
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "wrong secret",
+			payload:            event("evt_1", "fake_cs_1", 3000),
+			signature:          func(p []byte) string { return payments.Sign([]byte("other"), p, time.Now()) },
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "old signature",
+			payload:            event("evt_1", "fake_cs_1", 3000),
+			signature:          func(p []byte) string { return payments.Sign(secret, p, time.Now().Add(-time.Hour)) },
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "no signature",
+			payload:            event("evt_1", "fake_cs_1", 3000),
+			signature:          func(p []byte) string { return "" },
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "wrong amount",
+			payload:            event("evt_1", "fake_cs_1", 100),
+			signature:          func(p []byte) string { return payments.Sign(secret, p, time.Now()) },
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "unknown payment",
+			payload:            event("evt_1", "fake_cs_unknown", 3000),
+			signature:          func(p []byte) string { return payments.Sign(secret, p, time.Now()) },
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "database fails",
+			payload:            event("evt_1", "fake_cs_fails", 3000),
+			signature:          func(p []byte) string { return payments.Sign(secret, p, time.Now()) },
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(string(e.payload)))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set(payments.SignatureHeader, e.signature(e.payload))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+// TestPaymentDone tests the page guests return to after paying a balance
+func TestPaymentDone(t *testing.T) {
+	tests := []struct {
+		url          string
+		expectedHTML string
+	}{
+		{"/payments/done", "Your payment was received"},
+		{"/payments/done?status=cancelled", "Your payment was not made"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PaymentDone)
+		handler.ServeHTTP(rr, req)
+
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.url, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminSendBalanceLink tests emailing a link to pay the balance
+func TestAdminSendBalanceLink(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		expectedFlash string
+	}{
+		{"deposit paid", "2", "Balance payment link sent"},
+		{"nothing paid", "1", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/balance-link", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminSendBalanceLink)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+// TestCancelReservation tests the page guests reach from the cancellation link in their email
+func TestCancelReservation(t *testing.T) {
+	tests := []struct {
+		name               string
+		key                string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid key", "test-cancel-key", http.StatusOK, "30.00"},
+		{"unknown key", "nope", http.StatusSeeOther, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/reservations/cancel?k="+e.key, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.CancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestPostCancelReservation tests guests cancelling their reservation
+func TestPostCancelReservation(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		expectedFlash string
+	}{
+		{"valid key", "test-cancel-key", "Your reservation is cancelled. 30.00 RON will be refunded."},
+		{"unknown key", "nope", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"k": {e.key}}
+		req, _ := http.NewRequest("POST", "/reservations/cancel", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+// TestAdminCancelReservation tests the refund shown to staff before cancelling
+func TestAdminCancelReservation(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/2/cancel", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("src", "all")
+	rctx.URLParams.Add("id", "2")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminCancelReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminCancelReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Refund if cancelled now:</strong> 30.00") {
+		t.Error("AdminCancelReservation: expected the refund of 30.00 on the page")
+	}
+}
+
+// TestAdminPostCancelReservation tests staff cancelling a reservation
+func TestAdminPostCancelReservation(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		expectedFlash string
+		expectedError string
+	}{
+		{"deposit refunded", "2", "Reservation cancelled, 30.00 refunded", ""},
+		{"nothing paid", "1", "Reservation cancelled, 0.00 refunded", ""},
+		{"already cancelled", "3", "", "The reservation is already cancelled"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/cancel", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+// TestAdminDeleteReservation tests staff deleting a reservation, which cancels it first
+func TestAdminDeleteReservation(t *testing.T) {
+	tests := []struct {
+		name             string
+		id               string
+		query            string
+		expectedFlash    string
+		expectedLocation string
+	}{
+		{"deposit refunded", "2", "", "Reservation cancelled, 30.00 refunded, and deleted", "/admin/reservations-all"},
+		{"already cancelled", "3", "", "Reservation deleted", "/admin/reservations-all"},
+		{"from the calendar", "1", "?y=2040&m=01", "Reservation cancelled, 0.00 refunded, and deleted", "/admin/reservations-calendar?y=2040&m=01"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/delete"+e.query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+// TestAdminReservationInvoice tests downloading invoices
+func TestAdminReservationInvoice(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedFilename   string
+	}{
+		{"already issued", "2", http.StatusOK, "INV-000001.pdf"},
+		{"issued now", "1", http.StatusOK, "INV-000002.pdf"},
+		{"database fails", "1000", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/"+e.id+"/invoice", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedFilename == "" {
+			continue
+		}
+		if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, e.expectedFilename) {
+			t.Errorf("%s: expected a download named %s, got %q", e.name, e.expectedFilename, cd)
+		}
+		if !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+			t.Errorf("%s: expected a PDF", e.name)
+		}
+	}
+}
+
+// TestAdminReservationConfirmation tests downloading booking confirmations
+func TestAdminReservationConfirmation(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/1/confirmation", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("src", "all")
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminReservationConfirmation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminReservationConfirmation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("AdminReservationConfirmation: expected application/pdf, got %s", ct)
+	}
+}
+
+// TestPostGuestLogin tests asking for a login link
+func TestPostGuestLogin(t *testing.T) {
+	tests := []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectedFlash      string
+	}{
+		{"known guest", "John@Smith.com", http.StatusSeeOther, "If you have booked with this email, we've sent it a link to log in"},
+		{"unknown email", "nobody@here.com", http.StatusSeeOther, "If you have booked with this email, we've sent it a link to log in"},
+		{"invalid email", "john", http.StatusOK, ""},
+		{"finding guest fails", "fail@here.com", http.StatusInternalServerError, ""},
+		{"storing link fails", "js@example.com", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/account/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+// TestPostGuestLoginLink tests logging in with the link from the email
+func TestPostGuestLoginLink(t *testing.T) {
+	tests := []struct {
+		name               string
+		key                string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedGuestID    int
+	}{
+		{"valid link", "test-login-key", http.StatusSeeOther, "/account", 1},
+		{"used or expired link", "other-key", http.StatusSeeOther, "/account/login", 0},
+		{"database fails", "fail-login-key", http.StatusInternalServerError, "", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"k": {e.key}}
+		req, _ := http.NewRequest("POST", "/account/login/verify", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestLoginLink)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q, got %q", e.name, e.expectedLocation, loc)
+		}
+		if id := session.GetInt(ctx, "guest_id"); id != e.expectedGuestID {
+			t.Errorf("%s: expected guest %d logged in, got %d", e.name, e.expectedGuestID, id)
+		}
+	}
+}
+
+// TestGuestLogout tests that logging a guest out leaves a staff login alone
+func TestGuestLogout(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/account/logout", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	session.Put(ctx, "guest_id", 1)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.GuestLogout)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("GuestLogout returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if session.Exists(ctx, "guest_id") {
+		t.Error("GuestLogout: the guest is still logged in")
+	}
+	if !session.Exists(ctx, "user_id") {
+		t.Error("GuestLogout: the staff login was lost")
+	}
+}
+
+// TestGuestAccount tests the account page of a logged in guest
+func TestGuestAccount(t *testing.T) {
+	tests := []struct {
+		name               string
+		guestID            int
+		expectedStatusCode int
+		expectedHTML       []string
+	}{
+		{"guest with stays", 1, http.StatusOK, []string{
+			`href="/account/reservations/2/invoice"`, "General&#39;s Quarters", `value="Smith"`}},
+		{"guest without stays", 3, http.StatusOK, []string{"No upcoming stays", "No past stays"}},
+		{"guest gone", 99, http.StatusSeeOther, nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/account", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "guest_id", e.guestID)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.GuestAccount)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		for _, html := range e.expectedHTML {
+			if !strings.Contains(rr.Body.String(), html) {
+				t.Errorf("%s: expected to find %s but did not", e.name, html)
+			}
+		}
+	}
+
+	// the past stay comes after the upcoming one
+	req, _ := http.NewRequest("GET", "/account", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_id", 1)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.GuestAccount).ServeHTTP(rr, req)
+	body := rr.Body.String()
+	if strings.Index(body, "Past stays") > strings.Index(body, "/account/reservations/5/invoice") {
+		t.Error("GuestAccount: expected the stay of 2020 under past stays")
+	}
+}
+
+// TestPostGuestAccount tests guests updating their contact details
+func TestPostGuestAccount(t *testing.T) {
+	tests := []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+	}{
+		{"valid details", url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "phone": {"555-0100"}}, http.StatusSeeOther},
+		{"missing name", url.Values{"first_name": {""}, "last_name": {"Smith"}}, http.StatusOK},
+		{"database fails", url.Values{"first_name": {"fail"}, "last_name": {"Smith"}}, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/account", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "guest_id", 1)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestAccount)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+// TestGuestReservationInvoice tests that guests can download the invoices of their own reservations only
+func TestGuestReservationInvoice(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"own reservation", "2", http.StatusOK},
+		{"reservation of someone else", "1", http.StatusNotFound},
+		{"invalid id", "x", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/account/reservations/"+e.id+"/invoice", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		session.Put(ctx, "guest_id", 1)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.GuestReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedStatusCode == http.StatusOK && !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+			t.Errorf("%s: expected a PDF", e.name)
+		}
+	}
+}
+
+// TestLocalizedMessages tests that guests get messages, form errors and pages in their locale
+func TestLocalizedMessages(t *testing.T) {
+	// flash message
+	postedData := url.Values{"email": {"john@smith.com"}}
+	req, _ := http.NewRequest("POST", "/account/login", strings.NewReader(postedData.Encode()))
+	ctx := i18n.WithLocale(getCtx(req), i18n.Romanian)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostGuestLogin).ServeHTTP(rr, req)
+
+	want := "Dacă ați rezervat cu acest email, v-am trimis pe el un link de autentificare"
+	if flash := session.GetString(ctx, "flash"); flash != want {
+		t.Errorf("expected flash %q, got %q", want, flash)
+	}
+
+	// form error and page
+	postedData = url.Values{"email": {"john"}}
+	req, _ = http.NewRequest("POST", "/account/login", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(i18n.WithLocale(getCtx(req), i18n.Romanian))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostGuestLogin).ServeHTTP(rr, req)
+
+	for _, want := range []string{`<html lang="ro">`, "Adresă de email invalidă", "Trimite-mi linkul de autentificare"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected the login page to contain %q", want)
+		}
+	}
+
+	// stay rule with a date
+	postedData = url.Values{"start": {"2045-03-06"}, "end": {"2045-03-07"}, "room_id": {"1"}}
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(i18n.WithLocale(getCtx(req), i18n.Romanian))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+	var j jsonResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatal("failed to parse json", err)
+	}
+	want = "Sejururile cu sosire lun. 06 mar. 2045 trebuie să fie de cel puțin 2 nopți"
+	if j.Message != want {
+		t.Errorf("expected message %q, got %q", want, j.Message)
+	}
+}
+
+// TestDisplayCurrency tests prices shown in the guest's currency, and the rate kept with the reservation
+func TestDisplayCurrency(t *testing.T) {
+	booked := recordBookings(t)
+
+	// flexible search
+	req, _ := http.NewRequest("GET", "/search-availability-flexible-json?from=2040-03-01&to=2040-03-05&nights=2", nil)
+	req = req.WithContext(currency.WithCurrency(getCtx(req), "EUR"))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.FlexibleAvailabilityJSON).ServeHTTP(rr, req)
+
+	var j flexibleJSONResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatal("failed to parse json", err)
+	}
+	if j.Currency != "RON" || j.DisplayCurrency != "EUR" || len(j.Options) == 0 {
+		t.Fatalf("expected options in RON and EUR, got %+v", j)
+	}
+	if o := j.Options[0]; o.DisplayPrice != currency.Convert(o.Price, "RON", "EUR", 201100) {
+		t.Errorf("expected the price converted to EUR, got %+v", o)
+	}
+
+	// booking keeps the rate
+	postedData := url.Values{
+		"first_name":    {"John"},
+		"last_name":     {"Smith"},
+		"email":         {"john@smith.com"},
+		"adults":        {"2"},
+		"children":      {"0"},
+		"booking_token": {app.Quotes.Sign(testQuote(1, "2040-01-01", "2040-01-02"))},
+	}
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := currency.WithCurrency(getCtx(req), "EUR")
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	res := *booked
+	if res.DisplayCurrency != "EUR" || res.DisplayRate != 201100 {
+		t.Fatalf("expected the reservation to keep the EUR rate, got %q at %d", res.DisplayCurrency, res.DisplayRate)
+	}
+
+	// the summary shows both currencies at the rate of the booking, whatever the guest has chosen since
+	req, _ = http.NewRequest("GET", rr.Header().Get("Location"), nil)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.ReservationSummary).ServeHTTP(rr, req)
+
+	want := currency.Display(res.Price, "RON", "EUR", 201100)
+	if !strings.Contains(rr.Body.String(), html.EscapeString(want)) {
+		t.Errorf("expected the summary to show the total as %q", want)
+	}
+
+	// a reservation in the base currency shows only the base amounts
+	booked.DisplayCurrency, booked.DisplayRate = "", 0
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.ReservationSummary).ServeHTTP(rr, req)
+
+	if strings.Contains(rr.Body.String(), html.EscapeString("≈")) {
+		t.Error("expected no converted amounts on the summary of a reservation made in RON")
+	}
+}
+
 // gets the context
 func getCtx(req *http.Request) context.Context {
 	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
 	if err != nil {
 		log.Println(err)
 	}
-	return ctx
+	p, _ := app.Properties.Default()
+	return property.WithProperty(ctx, p, "")
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,14 +18,31 @@ import (
 	"github.com/justinas/nosurf"
 
 	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/currency"
+	"github.com/flaviusp23/bookings/internal/helpers"
+	"github.com/flaviusp23/bookings/internal/i18n"
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/payments"
+	"github.com/flaviusp23/bookings/internal/property"
+	"github.com/flaviusp23/bookings/internal/quote"
 	"github.com/flaviusp23/bookings/internal/render"
 )
 
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":     render.HumanDate,
+	"formatDate":    render.FormatDate,
+	"iterate":       render.Iterate,
+	"add":           render.Add,
+	"nightsBetween": render.NightsBetween,
+	"money":         render.Money,
+	"price":         render.Price,
+	"priceIn":       render.PriceIn,
+	"t":             i18n.Default.T,
+	"url":           render.URL,
+}
 
 func TestMain(m *testing.M) {
 	gob.Register(models.Reservation{})
@@ -35,10 +53,7 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	app.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	// set up the session
 	session = scs.New()
@@ -48,6 +63,12 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = app.InProduction
 
 	app.Session = session
+	app.Quotes = quote.NewSigner([]byte("test booking key"))
+	app.Rates = currency.NewRates("RON")
+	app.Rates.Set(map[string]currency.Rate{"EUR": 201100})
+	// deposits are only taken by the tests that set a deposit percent
+	app.Payments = payments.NewFakeProvider("", []byte("test webhook secret"))
+	app.Properties = property.NewDirectory("http://localhost:8080")
 
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
@@ -65,8 +86,13 @@ func TestMain(m *testing.M) {
 
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
+	err = repo.LoadProperties()
+	if err != nil {
+		log.Fatal("cannot load properties")
+	}
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 	os.Exit(m.Run())
 }
 
@@ -84,6 +110,7 @@ func getRoutes() http.Handler {
 	mux.Use(middleware.Recoverer)
 	// mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(DefaultProperty)
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
@@ -100,6 +127,9 @@ func getRoutes() http.Handler {
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/account/login", Repo.GuestLogin)
+	mux.Get("/account/login/verify", Repo.GuestLoginLink)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -119,6 +149,14 @@ func NoSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
+// DefaultProperty puts the default property in the request context
+func DefaultProperty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := app.Properties.Default()
+		next.ServeHTTP(w, r.WithContext(property.WithProperty(r.Context(), p, "")))
+	})
+}
+
 // SessionLoad loads and saves session data for current request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
@@ -0,0 +1,36 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ok := Check{Name: "ok", Run: func(ctx context.Context) error { return nil }}
+	failing := Check{Name: "failing", Run: func(ctx context.Context) error { return errors.New("down") }}
+	slow := Check{Name: "slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	report := Run(context.Background(), time.Second, []Check{ok})
+	if report.Status != "ok" || !report.Checks["ok"].OK {
+		t.Errorf("expected passing report but got %+v", report)
+	}
+
+	report = Run(context.Background(), 10*time.Millisecond, []Check{ok, failing, slow})
+	if report.Status != "unavailable" {
+		t.Errorf("expected status unavailable but got %s", report.Status)
+	}
+	if report.Checks["failing"].Error != "down" {
+		t.Errorf("expected error of failing check but got %q", report.Checks["failing"].Error)
+	}
+	if report.Checks["slow"].OK {
+		t.Error("slow check passed even though it timed out")
+	}
+	if !report.Checks["ok"].OK {
+		t.Error("ok check failed")
+	}
+}
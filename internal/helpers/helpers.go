@@ -1,31 +1,62 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/guestlogin"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 var app *config.AppConfig
 
+type contextKey string
+
+const loggerKey contextKey = "logger"
+
 func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
+// WithLogger returns a copy of ctx that carries the request logger
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// Log returns the logger for the request, which includes the request id and the user id when
+// the request went through the request logger middleware, or the application logger otherwise
+func Log(r *http.Request) *slog.Logger {
+	if l, ok := r.Context().Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return app.Logger.With("request_id", middleware.GetReqID(r.Context()))
+}
+
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	Log(r).Info("Client error", "status", status)
 	http.Error(w, http.StatusText(status), status)
 }
 
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	Log(r).Error(err.Error(), "stack", string(debug.Stack()))
+
+	msg := http.StatusText(http.StatusInternalServerError)
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		msg = fmt.Sprintf("%s (request id %s)", msg, id)
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
 }
 
 func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// GuestID returns the id of the guest logged in to their account, 0 when there is none
+func GuestID(r *http.Request) int {
+	return app.Session.GetInt(r.Context(), guestlogin.SessionKey)
+}
@@ -0,0 +1,105 @@
+package i18n
+
+import (
+	"strings"
+	"time"
+)
+
+// dateFormat holds how a locale writes dates: Go layouts for long and short dates, and the names of months and
+// weekdays that time.Format only knows in English
+type dateFormat struct {
+	long        string
+	short       string
+	months      [12]string
+	shortMonths [12]string
+	days        [7]string
+	shortDays   [7]string
+}
+
+var dateFormats = map[Locale]dateFormat{
+	English: {
+		long:  "02 January, 2006",
+		short: "Mon 02 Jan 2006",
+	},
+	Romanian: {
+		long:  "02 January 2006",
+		short: "Mon 02 Jan 2006",
+		months: [12]string{"ianuarie", "februarie", "martie", "aprilie", "mai", "iunie", "iulie", "august",
+			"septembrie", "octombrie", "noiembrie", "decembrie"},
+		shortMonths: [12]string{"ian.", "feb.", "mar.", "apr.", "mai", "iun.", "iul.", "aug.", "sept.", "oct.",
+			"nov.", "dec."},
+		days:      [7]string{"duminică", "luni", "marți", "miercuri", "joi", "vineri", "sâmbătă"},
+		shortDays: [7]string{"dum.", "lun.", "mar.", "mie.", "joi", "vin.", "sâm."},
+	},
+}
+
+// Date writes t as a long date, e.g. 05 March, 2045 or 05 martie 2045
+func (l Locale) Date(t time.Time) string {
+	f := l.dateFormat()
+	return f.format(t, f.long)
+}
+
+// ShortDate writes t as a short date with the weekday, e.g. Sun 05 Mar 2045 or dum. 05 mar. 2045
+func (l Locale) ShortDate(t time.Time) string {
+	f := l.dateFormat()
+	return f.format(t, f.short)
+}
+
+// Weekday returns the name of d, e.g. Friday or vineri
+func (l Locale) Weekday(d time.Weekday) string {
+	if f := l.dateFormat(); f.days[d] != "" {
+		return f.days[d]
+	}
+	return d.String()
+}
+
+func (l Locale) dateFormat() dateFormat {
+	if f, ok := dateFormats[l]; ok {
+		return f
+	}
+	return dateFormats[Default]
+}
+
+// names are the layout elements for month and weekday names, longest first so January wins over Jan
+var names = []string{"January", "Monday", "Jan", "Mon"}
+
+// format writes t with layout, putting in the names of f where the layout has month or weekday names. Locales
+// without names keep the English ones of time.Format.
+func (f dateFormat) format(t time.Time, layout string) string {
+	if f.months[0] == "" {
+		return t.Format(layout)
+	}
+
+	var b strings.Builder
+	for layout != "" {
+		i, name := nextName(layout)
+		if i < 0 {
+			b.WriteString(t.Format(layout))
+			break
+		}
+		b.WriteString(t.Format(layout[:i]))
+		switch name {
+		case "January":
+			b.WriteString(f.months[t.Month()-1])
+		case "Jan":
+			b.WriteString(f.shortMonths[t.Month()-1])
+		case "Monday":
+			b.WriteString(f.days[t.Weekday()])
+		case "Mon":
+			b.WriteString(f.shortDays[t.Weekday()])
+		}
+		layout = layout[i+len(name):]
+	}
+	return b.String()
+}
+
+// nextName returns the index and element of the first month or weekday name in layout, or -1
+func nextName(layout string) (int, string) {
+	first, found := -1, ""
+	for _, name := range names {
+		if i := strings.Index(layout, name); i >= 0 && (first < 0 || i < first) {
+			first, found = i, name
+		}
+	}
+	return first, found
+}
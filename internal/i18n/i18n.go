@@ -0,0 +1,199 @@
+// Package i18n translates the public site. Messages are looked up by their English text in the catalog of a
+// locale, so English needs no catalog and a message missing from a catalog falls back to English.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Locale is a language the site is translated into, as its ISO 639-1 code
+type Locale string
+
+const (
+	English  Locale = "en"
+	Romanian Locale = "ro"
+)
+
+// Default is the locale of guests who don't ask for a supported one
+const Default = English
+
+// Param is the URL parameter that switches the locale, e.g. ?lang=ro, and CookieName the cookie that remembers it
+const (
+	Param      = "lang"
+	CookieName = "lang"
+)
+
+// supported lists the locales the site is translated into, the default first
+var supported = []Locale{English, Romanian}
+
+var matcher = language.NewMatcher([]language.Tag{language.English, language.Romanian})
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// catalogs maps a locale to its translations, keyed by the English message
+var catalogs = mustLoadCatalogs()
+
+func mustLoadCatalogs() map[Locale]map[string]string {
+	catalogs := map[Locale]map[string]string{}
+	for _, l := range supported {
+		data, err := localesFS.ReadFile(fmt.Sprintf("locales/%s.json", l))
+		// English is the language of the keys and needs no catalog
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: catalog %s: %v", l, err))
+		}
+		catalogs[l] = catalog
+	}
+	return catalogs
+}
+
+// Supported returns the locales the site is translated into, the default first
+func Supported() []Locale {
+	return append([]Locale(nil), supported...)
+}
+
+// Parse returns the supported locale of a language code like ro or ro-RO
+func Parse(s string) (Locale, bool) {
+	code, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "-")
+	for _, l := range supported {
+		if string(l) == code {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// Negotiate picks the supported locale that best matches an Accept-Language header, or the default
+func Negotiate(acceptLanguage string) Locale {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Default
+	}
+	_, i, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	return supported[i]
+}
+
+// Name returns the name of the language in the language itself, for the language switcher
+func (l Locale) Name() string {
+	switch l {
+	case Romanian:
+		return "Română"
+	default:
+		return "English"
+	}
+}
+
+// T translates key into l and formats it with args like fmt.Sprintf. Dates among args are written with
+// ShortDate, and weekdays, alone or in a list, with their names in l.
+func (l Locale) T(key string, args ...any) string {
+	msg := key
+	if s, ok := catalogs[l][key]; ok && s != "" {
+		msg = s
+	}
+	if len(args) == 0 {
+		return msg
+	}
+
+	localized := make([]any, len(args))
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case time.Time:
+			arg = l.ShortDate(a)
+		case time.Weekday:
+			arg = l.Weekday(a)
+		case []time.Weekday:
+			names := make([]string, len(a))
+			for j, d := range a {
+				names[j] = l.Weekday(d)
+			}
+			arg = strings.Join(names, ", ")
+		}
+		localized[i] = arg
+	}
+	return fmt.Sprintf(msg, localized...)
+}
+
+// Has reports whether the catalog of l has key. English has every key.
+func (l Locale) Has(key string) bool {
+	if l == English {
+		return true
+	}
+	_, ok := catalogs[l][key]
+	return ok
+}
+
+type contextKey struct{}
+
+// WithLocale returns a copy of ctx that carries l
+func WithLocale(ctx context.Context, l Locale) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the locale of ctx, or the default when it has none
+func FromContext(ctx context.Context) Locale {
+	if l, ok := ctx.Value(contextKey{}).(Locale); ok {
+		return l
+	}
+	return Default
+}
+
+// T translates key into the locale of ctx, see Locale.T
+func T(ctx context.Context, key string, args ...any) string {
+	return FromContext(ctx).T(key, args...)
+}
+
+// Translatable is an error that keeps the English message and arguments it was made from, so it can be
+// translated
+type Translatable interface {
+	error
+	Translation() (key string, args []any)
+}
+
+// Error is an error in words meant for the guest. Its Error method gives the message in English.
+type Error struct {
+	Key  string
+	Args []any
+}
+
+// Errorf returns an *Error of key formatted with args
+func Errorf(key string, args ...any) *Error {
+	return &Error{Key: key, Args: args}
+}
+
+func (e *Error) Error() string {
+	return Default.T(e.Key, e.Args...)
+}
+
+// Translation returns the key and arguments of e
+func (e *Error) Translation() (string, []any) {
+	return e.Key, e.Args
+}
+
+// Message returns err in the locale of ctx. Errors that are not Translatable are looked up by their text.
+func Message(ctx context.Context, err error) string {
+	var t Translatable
+	if errors.As(err, &t) {
+		key, args := t.Translation()
+		return T(ctx, key, args...)
+	}
+	return T(ctx, err.Error())
+}
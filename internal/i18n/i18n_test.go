@@ -0,0 +1,157 @@
+package i18n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want Locale
+		ok   bool
+	}{
+		{"en", English, true},
+		{"ro", Romanian, true},
+		{"RO-ro", Romanian, true},
+		{" ro ", Romanian, true},
+		{"de", "", false},
+		{"", "", false},
+	}
+
+	for _, e := range tests {
+		got, ok := Parse(e.in)
+		if got != e.want || ok != e.ok {
+			t.Errorf("Parse(%q) = %q, %t, want %q, %t", e.in, got, ok, e.want, e.ok)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	var tests = []struct {
+		header string
+		want   Locale
+	}{
+		{"", English},
+		{"ro-RO,ro;q=0.9,en-US;q=0.8,en;q=0.7", Romanian},
+		{"en-GB,en;q=0.9,ro;q=0.8", English},
+		{"de-DE,de;q=0.9,ro;q=0.5", Romanian},
+		{"de-DE,fr;q=0.9", English},
+		{"not a header;;", English},
+	}
+
+	for _, e := range tests {
+		if got := Negotiate(e.header); got != e.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", e.header, got, e.want)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	if got := English.T("Search Availability"); got != "Search Availability" {
+		t.Errorf("expected English to be the key, got %q", got)
+	}
+	if got := Romanian.T("Search Availability"); got != "Caută disponibilitate" {
+		t.Errorf("expected the Romanian translation, got %q", got)
+	}
+	if got := Romanian.T("A message nobody translated %d", 3); got != "A message nobody translated 3" {
+		t.Errorf("expected a missing translation to fall back to English, got %q", got)
+	}
+	if got := Romanian.T("This field must be between %d and %d", 1, 10); got != "Acest câmp trebuie să fie între 1 și 10" {
+		t.Errorf("expected the arguments to be formatted, got %q", got)
+	}
+	if got := Locale("de").T("Search Availability"); got != "Search Availability" {
+		t.Errorf("expected an unknown locale to use English, got %q", got)
+	}
+
+	arrival := time.Date(2045, time.March, 6, 0, 0, 0, 0, time.UTC)
+	days := []time.Weekday{time.Friday, time.Saturday}
+	if got := English.T("Arrivals from %s to %s are only possible on %s", arrival, arrival, days); got !=
+		"Arrivals from Mon 06 Mar 2045 to Mon 06 Mar 2045 are only possible on Friday, Saturday" {
+		t.Errorf("expected dates and weekdays in English, got %q", got)
+	}
+	if got := Romanian.T("Arrivals from %s to %s are only possible on %s", arrival, arrival, days); got !=
+		"Între lun. 06 mar. 2045 și lun. 06 mar. 2045 sosirile sunt posibile doar vineri, sâmbătă" {
+		t.Errorf("expected dates and weekdays in Romanian, got %q", got)
+	}
+}
+
+func TestDates(t *testing.T) {
+	d := time.Date(2045, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		locale Locale
+		long   string
+		short  string
+	}{
+		{English, "01 January, 2045", "Sun 01 Jan 2045"},
+		{Romanian, "01 ianuarie 2045", "dum. 01 ian. 2045"},
+		{Locale("de"), "01 January, 2045", "Sun 01 Jan 2045"},
+	}
+
+	for _, e := range tests {
+		if got := e.locale.Date(d); got != e.long {
+			t.Errorf("%s: Date = %q, want %q", e.locale, got, e.long)
+		}
+		if got := e.locale.ShortDate(d); got != e.short {
+			t.Errorf("%s: ShortDate = %q, want %q", e.locale, got, e.short)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != Default {
+		t.Errorf("expected the default locale without one in the context, got %q", got)
+	}
+
+	ctx := WithLocale(context.Background(), Romanian)
+	if got := FromContext(ctx); got != Romanian {
+		t.Errorf("expected ro, got %q", got)
+	}
+	if got := T(ctx, "Home"); got != "Acasă" {
+		t.Errorf("expected Acasă, got %q", got)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	ctx := WithLocale(context.Background(), Romanian)
+
+	err := Errorf("Flexible searches can cover at most %d days", 92)
+	if err.Error() != "Flexible searches can cover at most 92 days" {
+		t.Errorf("expected the error in English, got %q", err.Error())
+	}
+	if got := Message(ctx, fmt.Errorf("searching: %w", err)); got != "Căutările flexibile pot acoperi cel mult 92 zile" {
+		t.Errorf("expected a wrapped *Error to be translated, got %q", got)
+	}
+	if got := Message(ctx, errors.New("Invalid email address")); got != "Adresă de email invalidă" {
+		t.Errorf("expected a plain error to be looked up by its text, got %q", got)
+	}
+	if got := Message(ctx, errors.New("connection refused")); got != "connection refused" {
+		t.Errorf("expected an unknown error to be kept, got %q", got)
+	}
+}
+
+// TestCatalogs checks that every translation has the formatting verbs of its key, in the same order
+func TestCatalogs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[-+# 0]*[0-9]*[a-zA-Z%]`)
+
+	for _, l := range Supported() {
+		for key, msg := range catalogs[l] {
+			if msg == "" {
+				t.Errorf("%s: %q has an empty translation", l, key)
+			}
+			if want, got := verbs.FindAllString(key, -1), verbs.FindAllString(msg, -1); !slices.Equal(want, got) {
+				t.Errorf("%s: %q has verbs %v, want %v", l, msg, got, want)
+			}
+		}
+	}
+
+	if len(catalogs[Romanian]) == 0 {
+		t.Error("expected a Romanian catalog")
+	}
+}
@@ -0,0 +1,241 @@
+// Package invoice lays out the invoice and the booking confirmation of a reservation as PDF documents.
+package invoice
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/currency"
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/pdf"
+)
+
+// Issuer is the property issuing the documents
+type Issuer struct {
+	Name     string
+	Address  string
+	Email    string
+	Currency string // the base currency of the amounts, not shown when empty
+}
+
+// Line is a priced line of a document
+type Line struct {
+	Description string
+	Quantity    int
+	UnitPrice   int // in cents
+	Amount      int // in cents
+}
+
+// Number formats an invoice number for display, e.g. 42 as INV-000042
+func Number(n int) string {
+	return fmt.Sprintf("INV-%06d", n)
+}
+
+// Lines returns a line per room stay of res, priced per night, followed by its discount and a line per tax or fee
+func Lines(res models.Reservation) []Line {
+	var lines []Line
+	for _, stay := range res.Stays {
+		nights := int(stay.EndDate.Sub(stay.StartDate).Hours() / 24)
+		unit := 0
+		if nights > 0 {
+			unit = stay.Price / nights
+		}
+		lines = append(lines, Line{
+			Description: fmt.Sprintf("%s, %s to %s", stay.Room.RoomName, stay.StartDate.Format(dateLayout),
+				stay.EndDate.Format(dateLayout)),
+			Quantity:  nights,
+			UnitPrice: unit,
+			Amount:    stay.Price,
+		})
+	}
+	if res.Discount > 0 {
+		lines = append(lines, Line{
+			Description: "Promo code " + res.PromoCode,
+			Quantity:    1,
+			UnitPrice:   -res.Discount,
+			Amount:      -res.Discount,
+		})
+	}
+	for _, c := range res.Charges {
+		lines = append(lines, Line{
+			Description: c.Name,
+			Quantity:    c.Quantity,
+			UnitPrice:   c.Amount / c.Quantity,
+			Amount:      c.Amount,
+		})
+	}
+	return lines
+}
+
+// Invoice returns invoice number n for res, issued at issued
+func Invoice(issuer Issuer, n int, issued time.Time, res models.Reservation) []byte {
+	w := newWriter(issuer, "Invoice")
+	w.field("Invoice number", Number(n))
+	w.field("Date", issued.Format(dateLayout))
+	w.field("Reservation", fmt.Sprint(res.ID))
+	w.currency(issuer)
+	w.guest(res)
+	w.lines(Lines(res))
+	w.total("Total", money(res.Price))
+	if res.DepositAmount > 0 {
+		w.total("Deposit", money(res.DepositAmount))
+		w.total("Balance", money(res.BalanceAmount))
+	}
+	w.converted(issuer, res)
+	return w.doc.Bytes()
+}
+
+// Confirmation returns the booking confirmation of res
+func Confirmation(issuer Issuer, res models.Reservation) []byte {
+	w := newWriter(issuer, "Booking Confirmation")
+	w.field("Reservation", fmt.Sprint(res.ID))
+	w.field("Booked on", res.CreatedAt.Format(dateLayout))
+	w.field("Arrival", res.StartDate.Format(dateLayout))
+	w.field("Departure", res.EndDate.Format(dateLayout))
+	w.field("Guests", fmt.Sprintf("%d adult(s), %d child(ren)", res.Adults, res.Children))
+	w.currency(issuer)
+	w.guest(res)
+	w.lines(Lines(res))
+	w.total("Total", money(res.Price))
+	w.converted(issuer, res)
+	return w.doc.Bytes()
+}
+
+const dateLayout = "2006-01-02"
+
+// layout of the page, in points
+const (
+	left       = 50.0
+	right      = pdf.PageWidth - 50
+	top        = 60.0
+	bottom     = pdf.PageHeight - 60
+	lineHeight = 16.0
+	fontSize   = 10.0
+)
+
+// columns of the price lines, by their right edge
+const (
+	quantityRight = 360.0
+	unitRight     = 450.0
+)
+
+// writer lays out a document top to bottom, starting new pages as needed
+type writer struct {
+	doc   *pdf.Document
+	title string
+	y     float64
+}
+
+// newWriter starts a document with the issuer's details and title
+func newWriter(issuer Issuer, title string) *writer {
+	w := &writer{doc: pdf.New(), title: title}
+	w.page()
+
+	w.doc.Text(left, w.y, 16, true, issuer.Name)
+	w.y += lineHeight + 4
+	for _, s := range []string{issuer.Address, issuer.Email} {
+		if s != "" {
+			w.doc.Text(left, w.y, fontSize, false, s)
+			w.y += lineHeight
+		}
+	}
+	w.y += lineHeight
+	w.doc.Text(left, w.y, 14, true, title)
+	w.y += lineHeight * 1.5
+	return w
+}
+
+// page starts a new page
+func (w *writer) page() {
+	w.doc.AddPage()
+	w.y = top
+}
+
+// next moves down a line, on a new page when this one is full
+func (w *writer) next() {
+	w.y += lineHeight
+	if w.y > bottom {
+		w.page()
+		w.doc.Text(left, w.y, fontSize, true, w.title+" (continued)")
+		w.y += lineHeight * 1.5
+	}
+}
+
+// field writes a labelled value
+func (w *writer) field(label, value string) {
+	w.doc.Text(left, w.y, fontSize, true, label+":")
+	w.doc.Text(left+110, w.y, fontSize, false, value)
+	w.next()
+}
+
+// guest writes who the document is for
+func (w *writer) guest(res models.Reservation) {
+	w.next()
+	w.doc.Text(left, w.y, fontSize, true, "Guest")
+	w.next()
+	for _, s := range []string{res.FirstName + " " + res.LastName, res.Email, res.Phone} {
+		if s != "" && s != " " {
+			w.doc.Text(left, w.y, fontSize, false, s)
+			w.next()
+		}
+	}
+}
+
+// lines writes the price lines as a table
+func (w *writer) lines(lines []Line) {
+	w.next()
+	w.doc.Text(left, w.y, fontSize, true, "Description")
+	w.doc.TextRight(quantityRight, w.y, fontSize, true, "Qty")
+	w.doc.TextRight(unitRight, w.y, fontSize, true, "Price")
+	w.doc.TextRight(right, w.y, fontSize, true, "Amount")
+	w.y += 4
+	w.doc.Line(left, w.y, right, w.y)
+	w.next()
+
+	for _, l := range lines {
+		w.doc.Text(left, w.y, fontSize, false, l.Description)
+		w.doc.TextRight(quantityRight, w.y, fontSize, false, fmt.Sprint(l.Quantity))
+		w.doc.TextRight(unitRight, w.y, fontSize, false, money(l.UnitPrice))
+		w.doc.TextRight(right, w.y, fontSize, false, money(l.Amount))
+		w.next()
+	}
+
+	w.doc.Line(left, w.y-lineHeight+4, right, w.y-lineHeight+4)
+}
+
+// total writes a labelled amount under the amount column
+func (w *writer) total(label, amount string) {
+	w.doc.TextRight(unitRight, w.y, fontSize, true, label)
+	w.doc.TextRight(right, w.y, fontSize, true, amount)
+	w.next()
+}
+
+// currency writes the currency of the amounts
+func (w *writer) currency(issuer Issuer) {
+	if issuer.Currency != "" {
+		w.field("Currency", issuer.Currency)
+	}
+}
+
+// converted writes the total in the currency the guest booked in, at the rate they were shown, when it isn't the
+// currency of the amounts
+func (w *writer) converted(issuer Issuer, res models.Reservation) {
+	if issuer.Currency == "" || res.DisplayCurrency == "" || res.DisplayCurrency == issuer.Currency || res.DisplayRate <= 0 {
+		return
+	}
+	rate := currency.Rate(res.DisplayRate)
+	w.total("Total in "+res.DisplayCurrency,
+		currency.Format(currency.Convert(res.Price, issuer.Currency, res.DisplayCurrency, rate), res.DisplayCurrency))
+	w.next()
+	w.doc.Text(left, w.y, fontSize, false, fmt.Sprintf("Amounts in %s are for information, at 1 %s = %s %s. %s is charged.",
+		res.DisplayCurrency, issuer.Currency, rate, res.DisplayCurrency, issuer.Currency))
+	w.next()
+}
+
+// money formats an amount in cents, e.g. 12550 as 125.50
+func money(cents int) string {
+	if cents < 0 {
+		return "-" + money(-cents)
+	}
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,120 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func testReservation(stays int) models.Reservation {
+	res := models.Reservation{
+		ID:            7,
+		FirstName:     "John",
+		LastName:      "Smith",
+		Email:         "john@smith.com",
+		StartDate:     date("2040-01-01"),
+		EndDate:       date("2040-01-03"),
+		Adults:        2,
+		DepositAmount: 6000,
+	}
+	for i := 0; i < stays; i++ {
+		res.Stays = append(res.Stays, models.RoomStay{
+			RoomID:    i + 1,
+			Room:      models.Room{RoomName: fmt.Sprintf("Room %d", i+1)},
+			StartDate: date("2040-01-01"),
+			EndDate:   date("2040-01-03"),
+			Price:     20000,
+		})
+		res.Price += 20000
+	}
+	res.BalanceAmount = res.Price - res.DepositAmount
+	return res
+}
+
+func TestNumber(t *testing.T) {
+	if got := Number(42); got != "INV-000042" {
+		t.Errorf("Number(42) = %s, want INV-000042", got)
+	}
+}
+
+func TestLines(t *testing.T) {
+	lines := Lines(testReservation(2))
+	want := Line{Description: "Room 1, 2040-01-01 to 2040-01-03", Quantity: 2, UnitPrice: 10000, Amount: 20000}
+	if len(lines) != 2 || lines[0] != want {
+		t.Errorf("Lines = %+v, want two lines starting with %+v", lines, want)
+	}
+
+	res := testReservation(1)
+	res.Charges = []models.Charge{{Name: "Tourist tax", Quantity: 4, Amount: 600}}
+	lines = Lines(res)
+	want = Line{Description: "Tourist tax", Quantity: 4, UnitPrice: 150, Amount: 600}
+	if len(lines) != 2 || lines[1] != want {
+		t.Errorf("Lines = %+v, want the stay followed by %+v", lines, want)
+	}
+
+	res.PromoCode, res.Discount = "SUMMER10", 2000
+	lines = Lines(res)
+	want = Line{Description: "Promo code SUMMER10", Quantity: 1, UnitPrice: -2000, Amount: -2000}
+	if len(lines) != 3 || lines[1] != want {
+		t.Errorf("Lines = %+v, want the stay followed by %+v", lines, want)
+	}
+	if got := money(-2050); got != "-20.50" {
+		t.Errorf("money(-2050) = %s, want -20.50", got)
+	}
+}
+
+func TestInvoice(t *testing.T) {
+	issuer := Issuer{Name: "Fort Smythe Bed and Breakfast", Email: "me@here.com"}
+	out := Invoice(issuer, 42, date("2039-12-01"), testReservation(1))
+
+	for _, s := range []string{"(INV-000042)", "(Fort Smythe Bed and Breakfast)", "(John Smith)", "(200.00)", "(140.00)", "/Count 1"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("expected the invoice to contain %s", s)
+		}
+	}
+
+	// a long reservation runs over several pages
+	out = Invoice(issuer, 43, date("2039-12-01"), testReservation(60))
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("expected the invoice of 60 rooms to take two pages")
+	}
+}
+
+func TestConfirmation(t *testing.T) {
+	out := Confirmation(Issuer{Name: "Fort Smythe Bed and Breakfast"}, testReservation(1))
+	for _, s := range []string{"(Booking Confirmation)", "(2040-01-03)", "(2 adult\\(s\\), 0 child\\(ren\\))"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("expected the confirmation to contain %s", s)
+		}
+	}
+}
+
+func TestConvertedTotal(t *testing.T) {
+	issuer := Issuer{Name: "Fort Smythe Bed and Breakfast", Currency: "RON"}
+	res := testReservation(1)
+	res.DisplayCurrency, res.DisplayRate = "EUR", 201100
+
+	for name, out := range map[string][]byte{
+		"invoice":      Invoice(issuer, 42, date("2039-12-01"), res),
+		"confirmation": Confirmation(issuer, res),
+	} {
+		for _, s := range []string{"(RON)", "(200.00)", "(Total in EUR)", "(40.22 EUR)", "1 RON = 0.2011 EUR"} {
+			if !bytes.Contains(out, []byte(s)) {
+				t.Errorf("expected the %s to contain %s", name, s)
+			}
+		}
+	}
+
+	res.DisplayCurrency = "RON"
+	if out := Invoice(issuer, 42, date("2039-12-01"), res); bytes.Contains(out, []byte("Total in")) {
+		t.Error("expected no converted total when the guest booked in the base currency")
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var handlerTests = []struct {
+	name               string
+	token              string
+	authorization      string
+	expectedStatusCode int
+}{
+	{"no-token", "", "", http.StatusOK},
+	{"valid-token", "secret", "Bearer secret", http.StatusOK},
+	{"missing-token", "secret", "", http.StatusUnauthorized},
+	{"wrong-token", "secret", "Bearer nope", http.StatusUnauthorized},
+}
+
+func TestHandler(t *testing.T) {
+	BookingsCreated.Inc()
+
+	for _, e := range handlerTests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+
+		Handler(e.token).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "bookings_reservations_created_total 1") {
+			t.Errorf("%s: expected bookings_reservations_created_total in the output", e.name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/flaviusp23/bookings/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20250102000000_second.up.sql":   {Data: []byte("create table b (id int);")},
+		"20250102000000_second.down.sql": {Data: []byte("drop table b;")},
+		"20250101000000_first.up.sql":    {Data: []byte("create table a (id int);")},
+	}
+
+	ms, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ms) != 2 {
+		t.Fatalf("expected 2 migrations but got %d", len(ms))
+	}
+	if ms[0].Name != "first" || ms[1].Name != "second" {
+		t.Errorf("migrations are not sorted by version: got %s, %s", ms[0].Name, ms[1].Name)
+	}
+	if ms[1].Down != "drop table b;" {
+		t.Errorf("down sql not loaded, got %q", ms[1].Down)
+	}
+}
+
+var loadErrorTests = []struct {
+	name string
+	fsys fstest.MapFS
+}{
+	{"bad-suffix", fstest.MapFS{"20250101000000_first.sql": {}}},
+	{"no-name", fstest.MapFS{"20250101000000.up.sql": {}}},
+	{"bad-version", fstest.MapFS{"abc_first.up.sql": {}}},
+	{"missing-up", fstest.MapFS{"20250101000000_first.down.sql": {Data: []byte("x")}}},
+	{"duplicate-version", fstest.MapFS{
+		"20250101000000_first.up.sql":  {Data: []byte("x")},
+		"20250101000000_second.up.sql": {Data: []byte("x")},
+	}},
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, e := range loadErrorTests {
+		_, err := Load(e.fsys)
+		if err == nil {
+			t.Errorf("%s: expected an error but did not get one", e.name)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	ms, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for _, m := range ms {
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
@@ -16,14 +17,71 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
-type Room struct {
+// Property is a guesthouse the site serves. Rooms, reservations, pricing and guests belong to one property, and
+// staff work on the properties they were given access to.
+type Property struct {
 	ID        int
-	RoomName  string
+	Slug      string // the path prefix of its public site, e.g. fort-smythe for /fort-smythe/about
+	Name      string
+	Host      string // the host name of its public site, empty when it is only reached by its path prefix
+	Address   string // one line per line of the address
+	Phone     string
+	Email     string // the address guests write to and emails are sent from
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// AddressLines returns the lines of the address of p
+func (p Property) AddressLines() []string {
+	if p.Address == "" {
+		return nil
+	}
+	return strings.Split(p.Address, "\n")
+}
+
+// Guest is a person who booked, told apart by email. Reservations keep the details given when booking.
+type Guest struct {
+	ID               int
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	Notes            string // for staff only
+	VIP              bool
+	DoNotRent        bool
+	MarketingConsent bool
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+
+	// set by guest searches
+	Reservations int
+	LastStay     time.Time
+}
+
+// Tags returns the labels staff put on g
+func (g Guest) Tags() []string {
+	var tags []string
+	if g.VIP {
+		tags = append(tags, "VIP")
+	}
+	if g.DoNotRent {
+		tags = append(tags, "Do not rent")
+	}
+	return tags
+}
+
+// Room is the room model
+type Room struct {
+	ID           int
+	RoomName     string
+	Price        int // per night, in cents
+	MaxOccupancy int
+	// CancellationPolicy is one of the policies of the cancellation package
+	CancellationPolicy string
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
 // Restriction is the restriction model
 type Restriction struct {
 	ID              int
@@ -32,9 +90,17 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
+// Restriction ids, as seeded in the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionHold        = 3
+)
+
 // Reservation is the reservation model
 type Reservation struct {
 	ID        int
+	GuestID   int // 0 when the reservation has no guest profile
 	FirstName string
 	LastName  string
 	Email     string
@@ -46,6 +112,222 @@ type Reservation struct {
 	UpdatedAt time.Time
 	Room      Room
 	Processed int
+	Price     int // for the whole stay with discounts, taxes and fees, in cents
+	Adults    int
+	Children  int
+	Stays     []RoomStay
+	Charges   []Charge // the taxes and fees included in Price
+
+	PromoCodeID int    // the promo code redeemed, 0 when there is none
+	PromoCode   string // the promo code as the guest entered it, normalized
+	Discount    int    // taken off the room price by the promo code, in cents
+
+	// MarketingConsent is whether the guest agreed to marketing when booking
+	MarketingConsent bool
+
+	PaymentStatus string
+	DepositAmount int // taken when booking, in cents
+	BalanceAmount int // still to pay after the deposit, in cents
+
+	// CancellationPolicy is the strictest policy of the rooms when the reservation was made
+	CancellationPolicy string
+	CancelKeyHash      string    // hash of the key in the guest's cancellation link
+	CancelledAt        time.Time // zero while the reservation stands
+	RefundAmount       int       // refunded on cancellation, in cents
+
+	// DisplayCurrency is the currency the guest was shown prices in when booking, empty for the base currency, and
+	// DisplayRate its exchange rate then, in millionths
+	DisplayCurrency string
+	DisplayRate     int64
+}
+
+// Guests returns the party size of the reservation
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+// Paid returns how much of the price the guest has paid, in cents
+func (r Reservation) Paid() int {
+	switch r.PaymentStatus {
+	case PaymentPaid:
+		return r.Price
+	case PaymentDepositPaid:
+		return r.DepositAmount
+	}
+	return 0
+}
+
+// Cancelled reports whether the reservation was cancelled
+func (r Reservation) Cancelled() bool {
+	return !r.CancelledAt.IsZero()
+}
+
+// RoomStay is one of the rooms booked by a reservation
+type RoomStay struct {
+	ID            int
+	ReservationID int
+	RoomID        int
+	Room          Room
+	StartDate     time.Time
+	EndDate       time.Time
+	Price         int // in cents
+	HoldID        int // the hold to turn into the room restriction, 0 when there is none
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// StayOption is a room that is free for a stay starting on StartDate
+type StayOption struct {
+	Room      Room
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Reservation payment statuses
+const (
+	PaymentUnpaid      = "unpaid"
+	PaymentDepositPaid = "deposit_paid"
+	PaymentPaid        = "paid"
+)
+
+// Payment kinds and statuses
+const (
+	PaymentKindDeposit = "deposit"
+	PaymentKindBalance = "balance"
+
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+)
+
+// Payment is a checkout started with the payment provider for a reservation
+type Payment struct {
+	ID            int
+	ReservationID int
+	Provider      string
+	ProviderRef   string // the provider's checkout session id
+	Kind          string
+	Amount        int // in cents
+	Status        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// WaitlistEntry is a guest waiting for a room to free up for their dates. RoomID is 0 when any room will do.
+type WaitlistEntry struct {
+	ID            int
+	RoomID        int
+	Room          Room
+	StartDate     time.Time
+	EndDate       time.Time
+	Adults        int
+	Children      int
+	FirstName     string
+	LastName      string
+	Email         string
+	ClaimKeyHash  string
+	OfferedRoomID int
+	NotifiedAt    time.Time
+	ClaimedAt     time.Time
+	DeclinedAt    time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// StayRule is a revenue management rule for a room over a range of dates, both inclusive. Limits of 0 mean
+// no limit.
+type StayRule struct {
+	ID                int
+	RoomID            int
+	Room              Room
+	StartDate         time.Time
+	EndDate           time.Time
+	MinNights         int
+	MaxNights         int
+	ClosedToArrival   bool
+	ClosedToDeparture bool
+	ArrivalWeekdays   int // bit n is set when arrivals are allowed on time.Weekday(n); 0 allows every day
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// Charge rule kinds, calculations and what flat amounts are charged per
+const (
+	ChargeTax = "tax"
+	ChargeFee = "fee"
+
+	ChargePercent = "percent"
+	ChargeFlat    = "flat"
+
+	ChargePerStay       = "stay"
+	ChargePerNight      = "night"
+	ChargePerGuest      = "guest"
+	ChargePerGuestNight = "guest_night"
+)
+
+// ChargeRule is a tax or fee added to bookings. Percentages are of the room price, in hundredths of a percent; flat
+// amounts are in cents, per room stay, room night, guest or guest night. The rule applies to nights from StartDate
+// to EndDate, both inclusive, and a zero date leaves that end open.
+type ChargeRule struct {
+	ID          int
+	Name        string
+	Kind        string
+	Calculation string
+	Amount      int
+	Per         string
+	StartDate   time.Time
+	EndDate     time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Charge is a tax or fee charged on a reservation
+type Charge struct {
+	ID            int
+	ReservationID int
+	ChargeRuleID  int
+	Name          string
+	Kind          string
+	Quantity      int
+	Amount        int // in cents
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Promo code discounts
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// PromoCode is a campaign code giving a discount on the room price. Percentages are in hundredths of a percent and
+// fixed discounts in cents, taken once per reservation. Codes can be redeemed from ValidFrom to ValidTo and give the
+// discount on nights from StayFrom to StayTo, all inclusive; zero dates leave that end open. MaxUses of 0 means no
+// limit, and no RoomIDs means every room.
+type PromoCode struct {
+	ID          int
+	Code        string
+	Description string
+	Calculation string
+	Amount      int
+	ValidFrom   time.Time
+	ValidTo     time.Time
+	StayFrom    time.Time
+	StayTo      time.Time
+	MaxUses     int
+	Uses        int
+	RoomIDs     []int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ExchangeRate is how much of a currency one unit of the base currency buys, in millionths, for showing prices in
+// that currency
+type ExchangeRate struct {
+	Currency  string
+	Rate      int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 // RoomRestriction is the room restriction model
@@ -64,9 +346,46 @@ type RoomRestriction struct {
 }
 
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Template    string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Invoice is an invoice issued for a reservation. Its PDF is kept as issued.
+type Invoice struct {
+	ID            int
+	Number        int
+	ReservationID int
+	Total         int // in cents
+	PDF           []byte
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// the actions in the privacy log
+const (
+	PrivacyExport    = "export"
+	PrivacyErasure   = "erasure"
+	PrivacyRetention = "retention"
+)
+
+// PrivacyLogEntry records an export or erasure of personal data
+type PrivacyLogEntry struct {
+	ID        int
+	Action    string
+	Subject   string // the hash of the email for exports and erasures, the cutoff date for retention
+	UserID    int    // the staff member, 0 for the retention policy
+	User      User
+	Records   int // the reservations, guest profiles and waitlist entries exported or anonymised
+	CreatedAt time.Time
 }
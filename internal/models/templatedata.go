@@ -14,4 +14,9 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	Locale          string
+	Currency        string     // the currency the guest has prices shown in
+	Currencies      []string   // the currencies prices can be shown in, the base first
+	Property        Property   // the property the page is about
+	Properties      []Property // the properties staff can switch to, only in the admin area
 }
@@ -0,0 +1,107 @@
+package payments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeProvider is a payment provider that takes no money. Its checkout page is served by the application itself at
+// /payments/fake/{id}, where the guest chooses whether the payment goes through, so the whole flow works offline.
+type FakeProvider struct {
+	baseURL  string
+	secret   []byte
+	mu       sync.Mutex
+	sessions map[string]Checkout
+}
+
+// NewFakeProvider returns a fake provider whose checkout pages are under baseURL and whose webhooks are signed with
+// secret
+func NewFakeProvider(baseURL string, secret []byte) *FakeProvider {
+	return &FakeProvider{
+		baseURL:  baseURL,
+		secret:   secret,
+		sessions: make(map[string]Checkout),
+	}
+}
+
+// Name identifies the provider
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// CreateCheckout starts a fake payment
+func (p *FakeProvider) CreateCheckout(c Checkout) (Session, error) {
+	id := "fake_cs_" + randomID()
+
+	p.mu.Lock()
+	p.sessions[id] = c
+	p.mu.Unlock()
+
+	return Session{ID: id, URL: p.baseURL + "/payments/fake/" + id}, nil
+}
+
+// Checkout returns the checkout of session id
+func (p *FakeProvider) Checkout(id string) (Checkout, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.sessions[id]
+	return c, ok
+}
+
+// Complete ends the checkout session id, paid or not, and returns the signed webhook a real provider would send
+func (p *FakeProvider) Complete(id string, paid bool) ([]byte, http.Header, error) {
+	c, ok := p.Checkout(id)
+	if !ok {
+		return nil, nil, ErrUnknownSession
+	}
+
+	p.mu.Lock()
+	delete(p.sessions, id)
+	p.mu.Unlock()
+
+	e := Event{ID: "fake_evt_" + randomID(), Type: EventFailed, SessionID: id, Amount: c.Amount}
+	if paid {
+		e.Type = EventSucceeded
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := http.Header{}
+	header.Set(SignatureHeader, Sign(p.secret, payload, time.Now()))
+	return payload, header, nil
+}
+
+// ParseWebhook checks the signature of a webhook and returns its event
+func (p *FakeProvider) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	var e Event
+
+	err := Verify(p.secret, payload, header.Get(SignatureHeader), time.Now())
+	if err != nil {
+		return e, err
+	}
+	err = json.Unmarshal(payload, &e)
+	return e, err
+}
+
+// Refund pretends to return amount of a payment to the guest
+func (p *FakeProvider) Refund(sessionID string, amount int) error {
+	if !strings.HasPrefix(sessionID, "fake_cs_") {
+		return ErrUnknownSession
+	}
+	return nil
+}
+
+// randomID returns a random identifier for sessions and events
+func randomID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
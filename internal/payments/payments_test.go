@@ -0,0 +1,92 @@
+package payments
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeposit(t *testing.T) {
+	tests := []struct {
+		price, percent, deposit int
+	}{
+		{10000, 30, 3000},
+		{10001, 30, 3000},
+		{10005, 30, 3002},
+		{10000, 100, 10000},
+		{10000, 0, 0},
+	}
+	for _, e := range tests {
+		if got := Deposit(e.price, e.percent); got != e.deposit {
+			t.Errorf("Deposit(%d, %d) = %d, want %d", e.price, e.percent, got, e.deposit)
+		}
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	secret := []byte("secret")
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+	header := Sign(secret, payload, now)
+
+	if err := Verify(secret, payload, header, now); err != nil {
+		t.Errorf("expected a valid signature but got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		payload []byte
+		header  string
+		now     time.Time
+	}{
+		{"other secret", []byte("other"), payload, header, now},
+		{"tampered payload", secret, []byte(`{"id":"evt_2"}`), header, now},
+		{"replayed later", secret, payload, header, now.Add(SignatureTolerance + time.Minute)},
+		{"new timestamp", secret, payload, strings.Replace(header, "t=", "t=1", 1), now},
+		{"missing signature", secret, payload, "", now},
+	}
+	for _, e := range tests {
+		if err := Verify(e.secret, e.payload, e.header, e.now); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s: expected ErrSignature but got %v", e.name, err)
+		}
+	}
+}
+
+func TestFakeProvider(t *testing.T) {
+	p := NewFakeProvider("http://localhost:8080", []byte("secret"))
+
+	s, err := p.CreateCheckout(Checkout{ReservationID: 1, Amount: 3000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.URL, "http://localhost:8080/payments/fake/") {
+		t.Errorf("unexpected checkout URL %s", s.URL)
+	}
+	if _, ok := p.Checkout(s.ID); !ok {
+		t.Fatal("checkout session not found")
+	}
+
+	payload, header, err := p.Complete(s.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := p.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventSucceeded || e.SessionID != s.ID || e.Amount != 3000 || e.ID == "" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// a session can only be completed once
+	if _, _, err = p.Complete(s.ID, true); !errors.Is(err, ErrUnknownSession) {
+		t.Errorf("expected ErrUnknownSession but got %v", err)
+	}
+
+	header.Set(SignatureHeader, "t=1,v1=00")
+	if _, err = p.ParseWebhook(payload, header); !errors.Is(err, ErrSignature) {
+		t.Errorf("expected ErrSignature but got %v", err)
+	}
+}
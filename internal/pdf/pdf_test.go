@@ -0,0 +1,66 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	d := New()
+	d.Text(50, 50, 12, true, "Invoice (copy)")
+	d.AddPage()
+	d.TextRight(545, 50, 10, false, "125.50")
+	out := d.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("expected a PDF header and trailer")
+	}
+	if !bytes.Contains(out, []byte(`(Invoice \(copy\)) Tj`)) {
+		t.Error("expected the text to be escaped")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("expected two pages")
+	}
+
+	// every xref entry points at its object
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(out)
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatal("startxref does not point at the xref table")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("expected 8 objects, got %d", len(entries))
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Errorf("xref entry %d does not point at its object", i+1)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{`a\b`, `a\\b`},
+		{"Café", `Caf\351`},
+		{"10 €", `10 \200`},
+		{"日本", "??"},
+	}
+	for _, e := range tests {
+		if got := escape(e.in); got != e.out {
+			t.Errorf("escape(%q) = %q, want %q", e.in, got, e.out)
+		}
+	}
+}
+
+func TestWidth(t *testing.T) {
+	if got := Width("10.00", 10); got != 25.02 {
+		t.Errorf("Width(10.00) = %v, want 25.02", got)
+	}
+}
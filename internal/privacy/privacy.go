@@ -0,0 +1,264 @@
+// Package privacy exports the personal data held about an email address, for guests exercising their right of
+// access, and hashes the addresses recorded in the privacy log.
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/invoice"
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/repository"
+)
+
+const dateLayout = "2006-01-02"
+
+// HashEmail returns the hash the privacy log keeps of an email in its place, the same whatever the case of email
+func HashEmail(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Bundle is everything held about an email address
+type Bundle struct {
+	Email        string          `json:"email"`
+	ExportedAt   time.Time       `json:"exported_at"`
+	Guest        *guestData      `json:"guest"`
+	Reservations []reservation   `json:"reservations"`
+	Waitlist     []waitlistEntry `json:"waitlist"`
+
+	// invoices are the PDFs of the reservations' invoices by file name
+	invoices map[string][]byte
+}
+
+type guestData struct {
+	FirstName        string    `json:"first_name"`
+	LastName         string    `json:"last_name"`
+	Email            string    `json:"email"`
+	Phone            string    `json:"phone"`
+	Notes            string    `json:"staff_notes"`
+	VIP              bool      `json:"vip"`
+	DoNotRent        bool      `json:"do_not_rent"`
+	MarketingConsent bool      `json:"marketing_consent"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type reservation struct {
+	ID                 int        `json:"id"`
+	FirstName          string     `json:"first_name"`
+	LastName           string     `json:"last_name"`
+	Email              string     `json:"email"`
+	Phone              string     `json:"phone"`
+	StartDate          string     `json:"start_date"`
+	EndDate            string     `json:"end_date"`
+	Adults             int        `json:"adults"`
+	Children           int        `json:"children"`
+	Rooms              []roomStay `json:"rooms"`
+	PromoCode          string     `json:"promo_code,omitempty"`
+	Discount           int        `json:"discount"`
+	Charges            []charge   `json:"charges"`
+	Price              int        `json:"price"`
+	PaymentStatus      string     `json:"payment_status"`
+	Payments           []payment  `json:"payments"`
+	CancellationPolicy string     `json:"cancellation_policy"`
+	CancelledAt        *time.Time `json:"cancelled_at"`
+	RefundAmount       int        `json:"refund_amount"`
+	Invoice            string     `json:"invoice,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+type roomStay struct {
+	Room      string `json:"room"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Price     int    `json:"price"`
+}
+
+type charge struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+type payment struct {
+	Kind      string    `json:"kind"`
+	Amount    int       `json:"amount"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type waitlistEntry struct {
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Adults    int       `json:"adults"`
+	Children  int       `json:"children"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export gathers the guest profile, reservations with their payments and invoices, and waitlist entries held about
+// email. Amounts are in cents.
+func Export(db repository.DatabaseRepo, email string, now time.Time) (Bundle, error) {
+	b := Bundle{
+		Email:        email,
+		ExportedAt:   now,
+		Reservations: []reservation{},
+		Waitlist:     []waitlistEntry{},
+		invoices:     make(map[string][]byte),
+	}
+
+	g, err := db.GetGuestByEmail(email)
+	if err == nil {
+		b.Guest = &guestData{
+			FirstName:        g.FirstName,
+			LastName:         g.LastName,
+			Email:            g.Email,
+			Phone:            g.Phone,
+			Notes:            g.Notes,
+			VIP:              g.VIP,
+			DoNotRent:        g.DoNotRent,
+			MarketingConsent: g.MarketingConsent,
+			CreatedAt:        g.CreatedAt,
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return b, err
+	}
+
+	history, err := db.GetReservationsByEmail(email)
+	if err != nil {
+		return b, err
+	}
+	for _, h := range history {
+		res, err := db.GetReservationByID(h.ID)
+		if err != nil {
+			return b, err
+		}
+		payments, err := db.GetPaymentsForReservation(res.ID)
+		if err != nil {
+			return b, err
+		}
+		r := newReservation(res, payments)
+
+		inv, err := db.GetInvoiceForReservation(res.ID)
+		if err == nil {
+			r.Invoice = "invoices/" + invoice.Number(inv.Number) + ".pdf"
+			b.invoices[r.Invoice] = inv.PDF
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return b, err
+		}
+		b.Reservations = append(b.Reservations, r)
+	}
+
+	entries, err := db.GetWaitlistEntriesByEmail(email)
+	if err != nil {
+		return b, err
+	}
+	for _, e := range entries {
+		b.Waitlist = append(b.Waitlist, waitlistEntry{
+			FirstName: e.FirstName,
+			LastName:  e.LastName,
+			Email:     e.Email,
+			StartDate: e.StartDate.Format(dateLayout),
+			EndDate:   e.EndDate.Format(dateLayout),
+			Adults:    e.Adults,
+			Children:  e.Children,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	return b, nil
+}
+
+// newReservation returns the exported form of res with its payments
+func newReservation(res models.Reservation, payments []models.Payment) reservation {
+	r := reservation{
+		ID:                 res.ID,
+		FirstName:          res.FirstName,
+		LastName:           res.LastName,
+		Email:              res.Email,
+		Phone:              res.Phone,
+		StartDate:          res.StartDate.Format(dateLayout),
+		EndDate:            res.EndDate.Format(dateLayout),
+		Adults:             res.Adults,
+		Children:           res.Children,
+		Rooms:              []roomStay{},
+		PromoCode:          res.PromoCode,
+		Discount:           res.Discount,
+		Charges:            []charge{},
+		Price:              res.Price,
+		PaymentStatus:      res.PaymentStatus,
+		Payments:           []payment{},
+		CancellationPolicy: res.CancellationPolicy,
+		RefundAmount:       res.RefundAmount,
+		CreatedAt:          res.CreatedAt,
+	}
+	if res.Cancelled() {
+		r.CancelledAt = &res.CancelledAt
+	}
+	for _, s := range res.Stays {
+		r.Rooms = append(r.Rooms, roomStay{
+			Room:      s.Room.RoomName,
+			StartDate: s.StartDate.Format(dateLayout),
+			EndDate:   s.EndDate.Format(dateLayout),
+			Price:     s.Price,
+		})
+	}
+	for _, c := range res.Charges {
+		r.Charges = append(r.Charges, charge{Name: c.Name, Amount: c.Amount})
+	}
+	for _, p := range payments {
+		r.Payments = append(r.Payments, payment{Kind: p.Kind, Amount: p.Amount, Status: p.Status, CreatedAt: p.CreatedAt})
+	}
+	return r
+}
+
+// Records returns the number of guest profiles, reservations and waitlist entries in b
+func (b Bundle) Records() int {
+	n := len(b.Reservations) + len(b.Waitlist)
+	if b.Guest != nil {
+		n++
+	}
+	return n
+}
+
+// Zip returns b as a ZIP archive holding personal-data.json and the PDFs of the invoices
+func (b Bundle) Zip() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{"personal-data.json": data}
+	for name, pdf := range b.invoices {
+		files[name] = pdf
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		f, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(files[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
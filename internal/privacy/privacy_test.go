@@ -0,0 +1,106 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/repository/dbrepo"
+)
+
+func TestHashEmail(t *testing.T) {
+	if HashEmail("John@Smith.com ") != HashEmail("john@smith.com") {
+		t.Error("expected the hash to ignore case and spaces")
+	}
+	if HashEmail("john@smith.com") == HashEmail("jane@smith.com") {
+		t.Error("expected different emails to hash differently")
+	}
+}
+
+func TestExport(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{}).ForProperty(1)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// the test repository holds a guest profile, two reservations and a waitlist entry for john@smith.com, and an
+	// invoice for reservation 2
+	b, err := Export(db, "john@smith.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Guest == nil || b.Guest.Notes != "Prefers a quiet room" {
+		t.Errorf("expected the guest profile with the staff notes, got %+v", b.Guest)
+	}
+	if len(b.Reservations) != 2 || len(b.Waitlist) != 1 || b.Records() != 4 {
+		t.Errorf("expected 2 reservations and 1 waitlist entry, 4 records, got %d, %d and %d",
+			len(b.Reservations), len(b.Waitlist), b.Records())
+	}
+	if b.Reservations[0].Invoice != "invoices/INV-000001.pdf" || len(b.Reservations[0].Payments) != 1 {
+		t.Errorf("expected reservation 2 with its invoice and payment, got %+v", b.Reservations[0])
+	}
+
+	data, err := b.Zip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	if len(files) != 2 || files["invoices/INV-000001.pdf"] == nil {
+		t.Errorf("expected the JSON and an invoice in the archive, got %d files", len(files))
+	}
+	var decoded map[string]interface{}
+	err = json.Unmarshal(files["personal-data.json"], &decoded)
+	if err != nil {
+		t.Fatalf("personal-data.json is not valid JSON: %v", err)
+	}
+	if decoded["email"] != "john@smith.com" {
+		t.Errorf("expected the email in personal-data.json, got %v", decoded["email"])
+	}
+}
+
+func TestExportNothingHeld(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{}).ForProperty(1)
+
+	b, err := Export(db, "nobody@here.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Records() != 0 || b.Guest != nil {
+		t.Errorf("expected nothing held, got %d records", b.Records())
+	}
+
+	_, err = Export(db, "fail@here.com", time.Now())
+	if err == nil {
+		t.Error("expected an error when the database fails")
+	}
+}
+
+func TestExportMergedGuest(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{}).ForProperty(1)
+
+	// johnny@smith.com booked a duplicate of John Smith, merged into him since
+	b, err := Export(db, "johnny@smith.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Guest == nil || b.Guest.Email != "john@smith.com" {
+		t.Errorf("expected the profile of the guest the duplicate was merged into, got %+v", b.Guest)
+	}
+	if len(b.Reservations) != 2 {
+		t.Errorf("expected the 2 reservations of the guest, got %d", len(b.Reservations))
+	}
+}
@@ -0,0 +1,114 @@
+// Package promo checks campaign codes against a booking and works out the discount they give.
+package promo
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+// Reasons a code can't be used, in words meant for the guest
+var (
+	ErrNotStarted    = errors.New("This promo code isn't valid yet")
+	ErrExpired       = errors.New("This promo code has expired")
+	ErrUsedUp        = errors.New("This promo code has been used up")
+	ErrNotApplicable = errors.New("This promo code doesn't apply to the rooms and dates you chose")
+)
+
+// Normalize returns code the way codes are stored, trimmed and in upper case
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ValidCode reports whether a normalized code can be used as a promo code: 1 to 50 letters and digits
+func ValidCode(code string) bool {
+	if code == "" || len(code) > 50 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Discounts returns the discount p gives on each of stays when redeemed on day now, in cents, or the reason it
+// can't be redeemed. A fixed discount is taken from the stays in order until it is used up.
+func Discounts(p models.PromoCode, stays []models.RoomStay, now time.Time) ([]int, error) {
+	today := day(now)
+	if !p.ValidFrom.IsZero() && today.Before(day(p.ValidFrom)) {
+		return nil, ErrNotStarted
+	}
+	if !p.ValidTo.IsZero() && today.After(day(p.ValidTo)) {
+		return nil, ErrExpired
+	}
+	if p.MaxUses > 0 && p.Uses >= p.MaxUses {
+		return nil, ErrUsedUp
+	}
+
+	discounts := make([]int, len(stays))
+	remaining, total := p.Amount, 0
+	for i, stay := range stays {
+		if !appliesTo(p, stay.RoomID) {
+			continue
+		}
+		base := 0
+		if nights := nights(stay.StartDate, stay.EndDate); nights > 0 {
+			base = stay.Price * coveredNights(p, stay.StartDate, stay.EndDate) / nights
+		}
+
+		switch p.Calculation {
+		case models.DiscountPercent:
+			discounts[i] = (base*p.Amount + 5000) / 10000
+		case models.DiscountFixed:
+			discounts[i] = min(base, remaining)
+			remaining -= discounts[i]
+		}
+		total += discounts[i]
+	}
+
+	if total == 0 {
+		return nil, ErrNotApplicable
+	}
+	return discounts, nil
+}
+
+// appliesTo reports whether p gives a discount on roomID
+func appliesTo(p models.PromoCode, roomID int) bool {
+	if len(p.RoomIDs) == 0 {
+		return true
+	}
+	for _, id := range p.RoomIDs {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// coveredNights counts the nights from start to end within the stay dates of p
+func coveredNights(p models.PromoCode, start, end time.Time) int {
+	n := 0
+	for night := start; night.Before(end); night = night.AddDate(0, 0, 1) {
+		if !p.StayFrom.IsZero() && night.Before(p.StayFrom) {
+			continue
+		}
+		if !p.StayTo.IsZero() && night.After(p.StayTo) {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func nights(start, end time.Time) int {
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// day returns the date of t at midnight UTC
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
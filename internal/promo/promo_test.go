@@ -0,0 +1,66 @@
+package promo
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("  summer10 "); got != "SUMMER10" {
+		t.Errorf("Normalize = %q, want SUMMER10", got)
+	}
+}
+
+func TestValidCode(t *testing.T) {
+	for code, want := range map[string]bool{"SUMMER10": true, "": false, "SUMMER-10": false, "summer": false} {
+		if got := ValidCode(code); got != want {
+			t.Errorf("ValidCode(%q) = %t, want %t", code, got, want)
+		}
+	}
+}
+
+func TestDiscounts(t *testing.T) {
+	stays := []models.RoomStay{
+		{RoomID: 1, StartDate: date("2050-06-01"), EndDate: date("2050-06-03"), Price: 20000},
+		{RoomID: 2, StartDate: date("2050-06-03"), EndDate: date("2050-06-05"), Price: 30000},
+	}
+	now := date("2050-05-01")
+
+	var tests = []struct {
+		name string
+		code models.PromoCode
+		want []int
+		err  error
+	}{
+		{"percent", models.PromoCode{Calculation: models.DiscountPercent, Amount: 1000}, []int{2000, 3000}, nil},
+		{"percent for some rooms", models.PromoCode{Calculation: models.DiscountPercent, Amount: 1000, RoomIDs: []int{2}}, []int{0, 3000}, nil},
+		{"percent for some nights", models.PromoCode{Calculation: models.DiscountPercent, Amount: 1000, StayTo: date("2050-06-03")}, []int{2000, 1500}, nil},
+		{"fixed", models.PromoCode{Calculation: models.DiscountFixed, Amount: 25000}, []int{20000, 5000}, nil},
+		{"fixed above the price", models.PromoCode{Calculation: models.DiscountFixed, Amount: 90000}, []int{20000, 30000}, nil},
+		{"not started", models.PromoCode{Calculation: models.DiscountFixed, Amount: 1000, ValidFrom: date("2050-05-02")}, nil, ErrNotStarted},
+		{"last day", models.PromoCode{Calculation: models.DiscountFixed, Amount: 1000, ValidTo: date("2050-05-01")}, []int{1000, 0}, nil},
+		{"expired", models.PromoCode{Calculation: models.DiscountFixed, Amount: 1000, ValidTo: date("2050-04-30")}, nil, ErrExpired},
+		{"used up", models.PromoCode{Calculation: models.DiscountFixed, Amount: 1000, MaxUses: 5, Uses: 5}, nil, ErrUsedUp},
+		{"other rooms", models.PromoCode{Calculation: models.DiscountFixed, Amount: 1000, RoomIDs: []int{3}}, nil, ErrNotApplicable},
+		{"other dates", models.PromoCode{Calculation: models.DiscountFixed, Amount: 1000, StayFrom: date("2050-07-01")}, nil, ErrNotApplicable},
+	}
+
+	for _, e := range tests {
+		got, err := Discounts(e.code, stays, now)
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.err, err)
+		}
+		if !slices.Equal(got, e.want) {
+			t.Errorf("%s: expected discounts %v but got %v", e.name, e.want, got)
+		}
+	}
+}
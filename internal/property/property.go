@@ -0,0 +1,177 @@
+// Package property finds the property, of the guesthouses the site serves, a request is for. A property is reached
+// by its own host name, e.g. lakeside.example.com, or by its path prefix on any host, e.g. /lakeside/about.
+// Requests matching neither are for the default property, the first one, so a site serving one property works as
+// it always did.
+package property
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+// slugPattern is what a slug looks like: lowercase letters and digits, with single hyphens between them
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// reserved are the first path segments of the pages of the site, which a property with the same slug would hide
+var reserved = []string{"about", "account", "admin", "book-room", "choose-room", "choose-rooms", "contact",
+	"generals-quarters", "healthz", "majors-suite", "make-reservation", "metrics", "payments", "readyz",
+	"reservation-summary", "reservations", "rooms", "search-availability", "search-availability-flexible",
+	"search-availability-flexible-json", "search-availability-json", "static", "user", "waitlist"}
+
+// maxSlugLength is the longest slug, the size of the column
+const maxSlugLength = 50
+
+// ValidSlug reports whether slug can be the path prefix of a property
+func ValidSlug(slug string) bool {
+	return len(slug) <= maxSlugLength && slugPattern.MatchString(slug) && !slices.Contains(reserved, slug)
+}
+
+// NormalizeHost returns a host name as it is compared, in lower case and without a port
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Directory holds the properties the site serves. It is safe for concurrent use.
+type Directory struct {
+	baseURL    *url.URL
+	mu         sync.RWMutex
+	properties []models.Property
+}
+
+// NewDirectory returns a directory of the properties served at baseURL, which has none until they are Set
+func NewDirectory(baseURL string) *Directory {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		u = &url.URL{}
+	}
+	return &Directory{baseURL: u}
+}
+
+// Set replaces the properties
+func (d *Directory) Set(properties []models.Property) {
+	sorted := slices.Clone(properties)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.properties = sorted
+}
+
+// All returns the properties, the default first
+func (d *Directory) All() []models.Property {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.properties)
+}
+
+// Get returns the property with id
+func (d *Directory) Get(id int) (models.Property, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, p := range d.properties {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return models.Property{}, false
+}
+
+// Default returns the property requests for no other property are for
+func (d *Directory) Default() (models.Property, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.properties) == 0 {
+		return models.Property{}, false
+	}
+	return d.properties[0], true
+}
+
+// Resolve returns the property of a request for path on host, with the path prefix the request reached it by, empty
+// when it was reached by its host name or is the default property. A host name wins over a path prefix.
+func (d *Directory) Resolve(host, path string) (models.Property, string, bool) {
+	host = NormalizeHost(host)
+	slug, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, p := range d.properties {
+		if p.Host != "" && NormalizeHost(p.Host) == host {
+			return p, "", true
+		}
+	}
+	for _, p := range d.properties {
+		if p.Slug == slug {
+			return p, "/" + slug, true
+		}
+	}
+	if len(d.properties) == 0 {
+		return models.Property{}, "", false
+	}
+	return d.properties[0], "", true
+}
+
+// URL returns the address of the public site of p, for links in emails: its host name with the scheme of the base
+// URL, or else the base URL, followed by the path prefix of p unless it is the default property
+func (d *Directory) URL(p models.Property) string {
+	if p.Host != "" {
+		return (&url.URL{Scheme: d.baseURL.Scheme, Host: p.Host}).String()
+	}
+
+	base := strings.TrimSuffix(d.baseURL.String(), "/")
+	if def, ok := d.Default(); ok && def.ID == p.ID {
+		return base
+	}
+	return base + "/" + p.Slug
+}
+
+type contextKey struct{}
+
+// site is what a request context carries about its property
+type site struct {
+	property models.Property
+	prefix   string
+	choices  []models.Property
+}
+
+// WithProperty returns a copy of ctx that carries p and the path prefix the request reached it by
+func WithProperty(ctx context.Context, p models.Property, prefix string) context.Context {
+	s, _ := ctx.Value(contextKey{}).(site)
+	s.property, s.prefix = p, prefix
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// WithChoices returns a copy of ctx that carries the properties staff can switch to
+func WithChoices(ctx context.Context, choices []models.Property) context.Context {
+	s, _ := ctx.Value(contextKey{}).(site)
+	s.choices = choices
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the property of ctx, which has an ID of 0 when ctx has none
+func FromContext(ctx context.Context) models.Property {
+	s, _ := ctx.Value(contextKey{}).(site)
+	return s.property
+}
+
+// Prefix returns the path prefix the property of ctx was reached by, empty when there is none
+func Prefix(ctx context.Context) string {
+	s, _ := ctx.Value(contextKey{}).(site)
+	return s.prefix
+}
+
+// Choices returns the properties staff can switch to
+func Choices(ctx context.Context) []models.Property {
+	s, _ := ctx.Value(contextKey{}).(site)
+	return s.choices
+}
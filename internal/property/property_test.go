@@ -0,0 +1,112 @@
+package property
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+var (
+	fortSmythe = models.Property{ID: 1, Slug: "fort-smythe", Name: "Fort Smythe"}
+	lakeside   = models.Property{ID: 2, Slug: "lakeside", Name: "Lakeside", Host: "Lakeside.example.com"}
+)
+
+func TestValidSlug(t *testing.T) {
+	for slug, want := range map[string]bool{
+		"lakeside":    true,
+		"fort-smythe": true,
+		"house2":      true,
+		"":            false,
+		"Lakeside":    false,
+		"-lakeside":   false,
+		"lake--side":  false,
+		"lake side":   false,
+		"admin":       false,
+		"static":      false,
+	} {
+		if got := ValidSlug(slug); got != want {
+			t.Errorf("ValidSlug(%q) = %t, want %t", slug, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	d := NewDirectory("https://example.com")
+	if _, _, ok := d.Resolve("example.com", "/"); ok {
+		t.Error("expected no property before they are set")
+	}
+	d.Set([]models.Property{lakeside, fortSmythe})
+
+	var tests = []struct {
+		host   string
+		path   string
+		want   int
+		prefix string
+	}{
+		{"example.com", "/", 1, ""},
+		{"example.com", "/about", 1, ""},
+		{"example.com", "/lakeside", 2, "/lakeside"},
+		{"example.com", "/lakeside/search-availability", 2, "/lakeside"},
+		{"example.com", "/lakesides/about", 1, ""},
+		{"example.com", "/fort-smythe/about", 1, "/fort-smythe"},
+		{"lakeside.example.com:8080", "/about", 2, ""},
+		{"LAKESIDE.example.com.", "/", 2, ""},
+		{"lakeside.example.com", "/fort-smythe/about", 2, ""},
+	}
+
+	for _, e := range tests {
+		p, prefix, ok := d.Resolve(e.host, e.path)
+		if !ok || p.ID != e.want || prefix != e.prefix {
+			t.Errorf("Resolve(%q, %q) = %d, %q, %t, want %d, %q", e.host, e.path, p.ID, prefix, ok, e.want, e.prefix)
+		}
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	d := NewDirectory("https://example.com/")
+	d.Set([]models.Property{lakeside, fortSmythe, {ID: 3, Slug: "old-mill"}})
+
+	if p, ok := d.Default(); !ok || p.ID != 1 {
+		t.Errorf("expected the first property to be the default, got %d", p.ID)
+	}
+	if p, ok := d.Get(2); !ok || p.Name != "Lakeside" {
+		t.Errorf("expected Lakeside, got %+v", p)
+	}
+	if _, ok := d.Get(4); ok {
+		t.Error("expected no property 4")
+	}
+	if got := d.All(); len(got) != 3 || got[0].ID != 1 {
+		t.Errorf("expected the properties by id, got %v", got)
+	}
+
+	for id, want := range map[int]string{
+		1: "https://example.com",
+		2: "https://Lakeside.example.com",
+		3: "https://example.com/old-mill",
+	} {
+		p, _ := d.Get(id)
+		if got := d.URL(p); got != want {
+			t.Errorf("URL of property %d = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if FromContext(ctx).ID != 0 || Prefix(ctx) != "" || Choices(ctx) != nil {
+		t.Error("expected no property without one in the context")
+	}
+
+	ctx = WithChoices(ctx, []models.Property{fortSmythe, lakeside})
+	ctx = WithProperty(ctx, lakeside, "/lakeside")
+	if got := FromContext(ctx); got.ID != 2 {
+		t.Errorf("expected Lakeside, got %+v", got)
+	}
+	if got := Prefix(ctx); got != "/lakeside" {
+		t.Errorf("expected the prefix, got %q", got)
+	}
+	if got := Choices(ctx); len(got) != 2 {
+		t.Errorf("expected the choices to be kept, got %v", got)
+	}
+}
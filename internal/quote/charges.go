@@ -0,0 +1,106 @@
+package quote
+
+import (
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+// Charges prices the tax and fee rules for a reservation of stays by a party of guests. Rules that charge
+// nothing for the reservation are left out.
+func Charges(rules []models.ChargeRule, stays []models.RoomStay, guests int) []models.Charge {
+	if len(stays) == 0 {
+		return nil
+	}
+
+	arrival, departure := stays[0].StartDate, stays[0].EndDate
+	for _, stay := range stays[1:] {
+		if stay.StartDate.Before(arrival) {
+			arrival = stay.StartDate
+		}
+		if stay.EndDate.After(departure) {
+			departure = stay.EndDate
+		}
+	}
+
+	var charges []models.Charge
+	for _, rule := range rules {
+		quantity, amount := 0, 0
+
+		switch {
+		case rule.Calculation == models.ChargePercent:
+			base := 0
+			for _, stay := range stays {
+				if nights := nights(stay.StartDate, stay.EndDate); nights > 0 {
+					base += stay.Price * coveredNights(rule, stay.StartDate, stay.EndDate) / nights
+				}
+			}
+			quantity = 1
+			amount = (base*rule.Amount + 5000) / 10000
+		case rule.Per == models.ChargePerStay:
+			for _, stay := range stays {
+				if covers(rule, stay.StartDate) {
+					quantity++
+				}
+			}
+		case rule.Per == models.ChargePerNight:
+			for _, stay := range stays {
+				quantity += coveredNights(rule, stay.StartDate, stay.EndDate)
+			}
+		case rule.Per == models.ChargePerGuest:
+			if covers(rule, arrival) {
+				quantity = guests
+			}
+		case rule.Per == models.ChargePerGuestNight:
+			quantity = guests * coveredNights(rule, arrival, departure)
+		}
+		if rule.Calculation == models.ChargeFlat {
+			amount = quantity * rule.Amount
+		}
+
+		if quantity == 0 || amount == 0 {
+			continue
+		}
+		charges = append(charges, models.Charge{
+			ChargeRuleID: rule.ID,
+			Name:         rule.Name,
+			Kind:         rule.Kind,
+			Quantity:     quantity,
+			Amount:       amount,
+		})
+	}
+
+	return charges
+}
+
+// Total returns the sum of charges, in cents
+func Total(charges []models.Charge) int {
+	total := 0
+	for _, c := range charges {
+		total += c.Amount
+	}
+	return total
+}
+
+// covers reports whether rule applies to the night starting on night
+func covers(rule models.ChargeRule, night time.Time) bool {
+	if !rule.StartDate.IsZero() && night.Before(rule.StartDate) {
+		return false
+	}
+	return rule.EndDate.IsZero() || !night.After(rule.EndDate)
+}
+
+// coveredNights counts the nights from start to end that rule applies to
+func coveredNights(rule models.ChargeRule, start, end time.Time) int {
+	n := 0
+	for night := start; night.Before(end); night = night.AddDate(0, 0, 1) {
+		if covers(rule, night) {
+			n++
+		}
+	}
+	return n
+}
+
+func nights(start, end time.Time) int {
+	return int(end.Sub(start).Hours() / 24)
+}
@@ -0,0 +1,54 @@
+package quote
+
+import (
+	"testing"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+func TestCharges(t *testing.T) {
+	stays := []models.RoomStay{
+		{RoomID: 1, StartDate: date("2050-06-01"), EndDate: date("2050-06-03"), Price: 20000},
+		{RoomID: 2, StartDate: date("2050-06-03"), EndDate: date("2050-06-04"), Price: 15000},
+	}
+
+	var tests = []struct {
+		name     string
+		rule     models.ChargeRule
+		quantity int
+		amount   int
+	}{
+		{"percent", models.ChargeRule{Calculation: models.ChargePercent, Amount: 1250}, 1, 4375},
+		{"percent for part of the stay", models.ChargeRule{Calculation: models.ChargePercent, Amount: 1000, StartDate: date("2050-06-02")}, 1, 2500},
+		{"per stay", models.ChargeRule{Calculation: models.ChargeFlat, Per: models.ChargePerStay, Amount: 2500}, 2, 5000},
+		{"per stay arriving in range", models.ChargeRule{Calculation: models.ChargeFlat, Per: models.ChargePerStay, Amount: 2500, EndDate: date("2050-06-02")}, 1, 2500},
+		{"per night", models.ChargeRule{Calculation: models.ChargeFlat, Per: models.ChargePerNight, Amount: 500}, 3, 1500},
+		{"per guest", models.ChargeRule{Calculation: models.ChargeFlat, Per: models.ChargePerGuest, Amount: 100}, 2, 200},
+		{"per guest after arrival", models.ChargeRule{Calculation: models.ChargeFlat, Per: models.ChargePerGuest, Amount: 100, StartDate: date("2050-06-02")}, 0, 0},
+		{"per guest night", models.ChargeRule{Calculation: models.ChargeFlat, Per: models.ChargePerGuestNight, Amount: 150, EndDate: date("2050-06-02")}, 4, 600},
+		{"out of range", models.ChargeRule{Calculation: models.ChargeFlat, Per: models.ChargePerNight, Amount: 500, StartDate: date("2050-07-01")}, 0, 0},
+	}
+
+	for _, e := range tests {
+		charges := Charges([]models.ChargeRule{e.rule}, stays, 2)
+		if e.quantity == 0 {
+			if len(charges) != 0 {
+				t.Errorf("%s: expected no charge but got %v", e.name, charges)
+			}
+			continue
+		}
+		if len(charges) != 1 {
+			t.Errorf("%s: expected one charge but got %d", e.name, len(charges))
+			continue
+		}
+		if charges[0].Quantity != e.quantity || charges[0].Amount != e.amount {
+			t.Errorf("%s: expected %d for %d but got %d for %d", e.name, e.amount, e.quantity, charges[0].Amount, charges[0].Quantity)
+		}
+	}
+}
+
+func TestTotal(t *testing.T) {
+	if got := Total([]models.Charge{{Amount: 2500}, {Amount: 600}}); got != 3100 {
+		t.Errorf("expected 3100 but got %d", got)
+	}
+}
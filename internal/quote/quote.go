@@ -0,0 +1,178 @@
+// Package quote prices a room for a stay and signs the result, so the booking flow can carry the
+// guest's selection, and then the reservation made from it, in the URL instead of the session.
+package quote
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+const dateLayout = "2006-01-02"
+
+var (
+	// ErrInvalid is returned for tokens that are malformed or were not signed by us
+	ErrInvalid = errors.New("invalid booking token")
+	// ErrExpired is returned for tokens past their expiry
+	ErrExpired = errors.New("booking token has expired")
+)
+
+// Quote is a priced room selection for a stay
+type Quote struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+	Price     int
+	Expires   time.Time
+	// HoldID is the room restriction holding the room for the guest, 0 when there is none
+	HoldID int
+}
+
+// New prices room for the stay from start to end. The quote is valid for ttl.
+func New(room models.Room, start, end time.Time, ttl time.Duration) Quote {
+	q := Quote{
+		RoomID:    room.ID,
+		StartDate: start,
+		EndDate:   end,
+		Expires:   time.Now().Add(ttl),
+	}
+	q.Price = q.Nights() * room.Price
+	return q
+}
+
+// Nights returns the number of nights of the stay
+func (q Quote) Nights() int {
+	return int(q.EndDate.Sub(q.StartDate).Hours() / 24)
+}
+
+// Signer signs and verifies quotes with an HMAC key
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer using key
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns a url safe token carrying q
+func (s *Signer) Sign(q Quote) string {
+	payload := strings.Join([]string{
+		strconv.Itoa(q.RoomID),
+		q.StartDate.Format(dateLayout),
+		q.EndDate.Format(dateLayout),
+		strconv.Itoa(q.Price),
+		strconv.FormatInt(q.Expires.Unix(), 10),
+		strconv.Itoa(q.HoldID),
+	}, "|")
+
+	return s.sign(payload)
+}
+
+// Verify checks the signature and expiry of token and returns the quote it carries
+func (s *Signer) Verify(token string) (Quote, error) {
+	var q Quote
+
+	parts, err := s.parts(token)
+	if err != nil {
+		return q, err
+	}
+	if len(parts) != 6 {
+		return q, ErrInvalid
+	}
+
+	q.RoomID, err = strconv.Atoi(parts[0])
+	if err == nil {
+		q.StartDate, err = time.Parse(dateLayout, parts[1])
+	}
+	if err == nil {
+		q.EndDate, err = time.Parse(dateLayout, parts[2])
+	}
+	if err == nil {
+		q.Price, err = strconv.Atoi(parts[3])
+	}
+	var expires int64
+	if err == nil {
+		expires, err = strconv.ParseInt(parts[4], 10, 64)
+	}
+	if err == nil {
+		q.HoldID, err = strconv.Atoi(parts[5])
+	}
+	if err != nil {
+		return Quote{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	q.Expires = time.Unix(expires, 0)
+
+	if time.Now().After(q.Expires) {
+		return q, ErrExpired
+	}
+
+	return q, nil
+}
+
+// SignReservation returns a url safe token for the reservation with id, which lets the guest who booked it see it
+// until expires
+func (s *Signer) SignReservation(id int, expires time.Time) string {
+	return s.sign(strings.Join([]string{"reservation", strconv.Itoa(id), strconv.FormatInt(expires.Unix(), 10)}, "|"))
+}
+
+// VerifyReservation checks the signature and expiry of a token of SignReservation and returns the reservation id
+// it carries
+func (s *Signer) VerifyReservation(token string) (int, error) {
+	parts, err := s.parts(token)
+	if err != nil {
+		return 0, err
+	}
+	if len(parts) != 3 || parts[0] != "reservation" {
+		return 0, ErrInvalid
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if time.Now().After(time.Unix(expires, 0)) {
+		return id, ErrExpired
+	}
+
+	return id, nil
+}
+
+// sign returns a url safe token carrying payload
+func (s *Signer) sign(payload string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + s.mac(payload)
+}
+
+// parts checks the signature of token and returns the fields of its payload
+func (s *Signer) parts(token string) ([]string, error) {
+	encoded, sig, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalid
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	payload := string(b)
+	if !hmac.Equal([]byte(sig), []byte(s.mac(payload))) {
+		return nil, ErrInvalid
+	}
+	return strings.Split(payload, "|"), nil
+}
+
+func (s *Signer) mac(payload string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,106 @@
+package quote
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestNew(t *testing.T) {
+	q := New(models.Room{ID: 1, Price: 10000}, date("2050-01-01"), date("2050-01-04"), time.Hour)
+
+	if q.Nights() != 3 {
+		t.Errorf("expected 3 nights but got %d", q.Nights())
+	}
+	if q.Price != 30000 {
+		t.Errorf("expected price 30000 but got %d", q.Price)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	q := New(models.Room{ID: 2, Price: 5000}, date("2050-01-01"), date("2050-01-02"), time.Hour)
+	q.HoldID = 7
+
+	token := s.Sign(q)
+	got, err := s.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RoomID != 2 || !got.StartDate.Equal(q.StartDate) || !got.EndDate.Equal(q.EndDate) || got.Price != 5000 || got.HoldID != 7 {
+		t.Errorf("verified quote does not match the signed one: got %+v", got)
+	}
+
+	// another key must not verify the token
+	_, err = NewSigner([]byte("other")).Verify(token)
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for a foreign key but got %v", err)
+	}
+
+	// changing the payload, e.g. the dates, must break the signature
+	payload, sig, _ := strings.Cut(token, ".")
+	tampered := s.Sign(New(models.Room{ID: 2, Price: 5000}, date("2050-01-01"), date("2050-01-09"), time.Hour))
+	tamperedPayload, _, _ := strings.Cut(tampered, ".")
+	if tamperedPayload == payload {
+		t.Fatal("payloads should differ")
+	}
+	_, err = s.Verify(tamperedPayload + "." + sig)
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for a tampered token but got %v", err)
+	}
+
+	for _, bad := range []string{"", "nodot", "!!!.sig"} {
+		_, err = s.Verify(bad)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for %q but got %v", bad, err)
+		}
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	q := New(models.Room{ID: 1}, date("2050-01-01"), date("2050-01-02"), -time.Minute)
+
+	_, err := s.Verify(s.Sign(q))
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired but got %v", err)
+	}
+}
+
+func TestSignAndVerifyReservation(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+
+	id, err := s.VerifyReservation(s.SignReservation(42, time.Now().Add(time.Hour)))
+	if err != nil || id != 42 {
+		t.Errorf("expected reservation 42 but got %d, %v", id, err)
+	}
+
+	_, err = s.VerifyReservation(s.SignReservation(42, time.Now().Add(-time.Minute)))
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired but got %v", err)
+	}
+
+	// a booking token is not a reservation token, nor the other way round
+	q := New(models.Room{ID: 42, Price: 5000}, date("2050-01-01"), date("2050-01-02"), time.Hour)
+	_, err = s.VerifyReservation(s.Sign(q))
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for a booking token but got %v", err)
+	}
+	_, err = s.Verify(s.SignReservation(42, time.Now().Add(time.Hour)))
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for a reservation token but got %v", err)
+	}
+
+	_, err = NewSigner([]byte("other")).VerifyReservation(s.SignReservation(42, time.Now().Add(time.Hour)))
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for a foreign key but got %v", err)
+	}
+}
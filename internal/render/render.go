@@ -10,7 +10,10 @@ import (
 	"time"
 
 	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/currency"
+	"github.com/flaviusp23/bookings/internal/i18n"
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/property"
 	"github.com/justinas/nosurf"
 )
 
@@ -20,11 +23,51 @@ var functions = template.FuncMap{
 	"iterate":       Iterate,
 	"add":           Add,
 	"nightsBetween": NightsBetween,
+	"money":         Money,
+	"price":         Price,
+	"priceIn":       PriceIn,
+	"t":             i18n.Default.T,
+	"url":           URL,
+}
+
+// localeFunctions are the functions that depend on the locale of the request. They replace the English ones of
+// functions when a template is rendered.
+func localeFunctions(l i18n.Locale) template.FuncMap {
+	return template.FuncMap{
+		"humanDate": l.Date,
+		"t":         l.T,
+	}
+}
+
+// currencyFunctions are the functions that depend on the display currency of the request. They replace the ones of
+// functions, which only give the base currency, when a template is rendered.
+func currencyFunctions(code string) template.FuncMap {
+	return template.FuncMap{
+		"price": func(amount int) string {
+			rate, _ := app.Rates.Get(code)
+			return currency.Display(amount, app.Rates.Base(), code, rate)
+		},
+	}
+}
+
+// propertyFunctions are the functions that depend on the path prefix the property of the request was reached by.
+// They replace the ones of functions, which give paths without a prefix, when a template is rendered.
+func propertyFunctions(prefix string) template.FuncMap {
+	return template.FuncMap{
+		"url": func(path string) string {
+			return prefix + path
+		},
+	}
 }
 
 var app *config.AppConfig
 var pathToTemplates = "./templates"
 
+// URL returns the address of path on the public site of the property
+func URL(path string) string {
+	return path
+}
+
 func Add(a, b int) int {
 	return a + b
 }
@@ -44,9 +87,27 @@ func NightsBetween(startDate, endDate time.Time) int {
 	return int(endDate.Sub(startDate).Hours() / 24) // Convert hours to days
 }
 
-// HumanDate returns time in YYYY-MM-DD format
+// Money formats an amount in cents, e.g. 12550 as 125.50
+func Money(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// Price formats an amount in the base currency, e.g. 12550 as 125.50 RON. Templates get it with the amount in the
+// guest's currency as well, e.g. 125.50 RON (≈ 25.24 EUR).
+func Price(amount int) string {
+	return currency.Format(amount, app.Rates.Base())
+}
+
+// PriceIn formats an amount in the base currency and in code at rate, for the amounts of a reservation at the rate
+// it was made with
+func PriceIn(amount int, code string, rate int64) string {
+	return currency.Display(amount, app.Rates.Base(), code, currency.Rate(rate))
+}
+
+// HumanDate returns time as a long date in English, e.g. 05 March, 2045. Templates get it in the locale of the
+// request.
 func HumanDate(t time.Time) string {
-	return t.Format("02 January, 2006")
+	return i18n.Default.Date(t)
 }
 
 func FormatDate(t time.Time, f string) string {
@@ -64,9 +125,17 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.CSRFToken = nosurf.Token(r)
+	td.Locale = string(i18n.FromContext(r.Context()))
+	td.Currency = currency.FromContext(r.Context())
+	if td.Currency == "" {
+		td.Currency = app.Rates.Base()
+	}
+	td.Currencies = app.Rates.Codes()
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	td.Property = property.FromContext(r.Context())
+	td.Properties = property.Choices(r.Context())
 
 	return td
 }
@@ -87,15 +156,25 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 		return errors.New("can't get template from cache")
 	}
 
+	// cached templates are never executed themselves, only their clones, so every request can have the functions
+	// of its own locale, currency and property
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	t.Funcs(localeFunctions(i18n.FromContext(r.Context())))
+	t.Funcs(currencyFunctions(currency.FromContext(r.Context())))
+	t.Funcs(propertyFunctions(property.Prefix(r.Context())))
+
 	buf := new(bytes.Buffer)
 
 	td = AddDefaultData(td, r)
 
 	_ = t.Execute(buf, td)
 
-	_, err := buf.WriteTo(w)
+	_, err = buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("error writing template to browser", err)
+		app.Logger.Error("error writing template to browser", "template", tmpl, "error", err)
 		return err
 	}
 	return nil
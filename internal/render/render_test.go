@@ -2,9 +2,17 @@ package render
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/flaviusp23/bookings/internal/i18n"
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/property"
 )
 
 func TestAddDefaultData(t *testing.T) {
@@ -77,3 +85,132 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRenderTemplateLocale(t *testing.T) {
+	pathToTemplates = "./../../templates"
+	tc, err := CreateTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TemplateCache = tc
+	app.UseCache = true
+
+	r, err := getSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []struct {
+		locale i18n.Locale
+		want   []string
+	}{
+		{i18n.English, []string{`<html lang="en">`, "Check Availability", "Choose your dates"}},
+		{i18n.Romanian, []string{`<html lang="ro">`, "Verifică disponibilitatea", "Alegeți datele"}},
+	} {
+		rr := httptest.NewRecorder()
+		err = Template(rr, r.WithContext(i18n.WithLocale(r.Context(), e.locale)), "generals.page.tmpl",
+			&models.TemplateData{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range e.want {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("%s: expected the page to contain %q", e.locale, want)
+			}
+		}
+	}
+
+	// the cached template is only cloned, so it can be rendered again in another locale
+	rr := httptest.NewRecorder()
+	_ = Template(rr, r, "generals.page.tmpl", &models.TemplateData{})
+	if !strings.Contains(rr.Body.String(), "Check Availability") {
+		t.Error("expected the page in English after rendering it in Romanian")
+	}
+}
+
+// TestTemplateTranslations checks that every message of the public templates is in the Romanian catalog
+func TestTemplateTranslations(t *testing.T) {
+	pages, err := filepath.Glob("./../../templates/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := regexp.MustCompile(`\{\{t "((?:[^"\\]|\\.)*)"`)
+	for _, page := range pages {
+		if strings.HasPrefix(filepath.Base(page), "admin") {
+			continue
+		}
+		data, err := os.ReadFile(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range keys.FindAllStringSubmatch(string(data), -1) {
+			key := strings.ReplaceAll(m[1], `\"`, `"`)
+			if !i18n.Romanian.Has(key) {
+				t.Errorf("%s: %q is not translated to Romanian", filepath.Base(page), key)
+			}
+		}
+	}
+}
+
+func TestRenderTemplateProperty(t *testing.T) {
+	pathToTemplates = "./../../templates"
+	tc, err := CreateTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TemplateCache = tc
+	app.UseCache = true
+
+	r, err := getSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lakeside := models.Property{ID: 2, Slug: "lakeside", Name: "Lakeside Lodge", Email: "lakeside@here.com"}
+	for _, e := range []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{"host name", "", []string{`href="/contact"`, "<title>Lakeside Lodge</title>", "lakeside@here.com"}},
+		{"path prefix", "/lakeside", []string{`href="/lakeside/contact"`, `action="/lakeside/search-availability-flexible"`}},
+	} {
+		rr := httptest.NewRecorder()
+		ctx := property.WithProperty(r.Context(), lakeside, e.prefix)
+		err = Template(rr, r.WithContext(ctx), "search-availability.page.tmpl", &models.TemplateData{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range e.want {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("%s: expected the page to contain %q", e.name, want)
+			}
+		}
+	}
+}
+
+func TestHumanDate(t *testing.T) {
+	d := time.Date(2045, time.March, 5, 0, 0, 0, 0, time.UTC)
+	if got := HumanDate(d); got != "05 March, 2045" {
+		t.Errorf("HumanDate = %q, want 05 March, 2045", got)
+	}
+}
+
+func TestPrice(t *testing.T) {
+	if got := Price(12550); got != "125.50 RON" {
+		t.Errorf("Price = %q, want 125.50 RON", got)
+	}
+	if got := PriceIn(12550, "EUR", 201100); got != "125.50 RON (≈ 25.24 EUR)" {
+		t.Errorf("PriceIn = %q, want the amount in RON and EUR", got)
+	}
+
+	price := currencyFunctions("EUR")["price"].(func(int) string)
+	if got := price(12550); got != "125.50 RON (≈ 25.24 EUR)" {
+		t.Errorf("price in EUR = %q, want the amount in RON and EUR", got)
+	}
+	price = currencyFunctions("GBP")["price"].(func(int) string)
+	if got := price(12550); got != "125.50 RON" {
+		t.Errorf("price in a currency without a rate = %q, want 125.50 RON", got)
+	}
+}
@@ -2,7 +2,7 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
@@ -10,6 +10,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/currency"
 	"github.com/flaviusp23/bookings/internal/models"
 )
 
@@ -23,10 +24,7 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	testApp.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	testApp.Logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	// set up the session
 	session = scs.New()
@@ -36,6 +34,8 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = false
 
 	testApp.Session = session
+	testApp.Rates = currency.NewRates("RON")
+	testApp.Rates.Set(map[string]currency.Rate{"EUR": 201100})
 
 	app = &testApp
 
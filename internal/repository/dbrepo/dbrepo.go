@@ -9,13 +9,15 @@ import (
 
 // here we can add many more DB connects like MySQL, MariaDB etc
 type postgresDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB
+	App        *config.AppConfig
+	DB         *sql.DB
+	PropertyID int // the property the queries are scoped to, 0 for none
 }
 
 type testDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB
+	App        *config.AppConfig
+	DB         *sql.DB
+	PropertyID int
 }
 
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
@@ -30,3 +32,13 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// ForProperty returns a copy of the repository scoped to the property with id
+func (m *postgresDBRepo) ForProperty(id int) repository.DatabaseRepo {
+	return &postgresDBRepo{App: m.App, DB: m.DB, PropertyID: id}
+}
+
+// ForProperty returns a copy of the repository scoped to the property with id
+func (m *testDBRepo) ForProperty(id int) repository.DatabaseRepo {
+	return &testDBRepo{App: m.App, DB: m.DB, PropertyID: id}
+}
@@ -2,11 +2,14 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,8 +22,11 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	defer cancel()
 	var newID int
 
-	stmt := `insert into reservations(first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-			 values($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	// the room must be one of the property's, or nothing is inserted and the scan finds no rows
+	stmt := `insert into reservations(first_name, last_name, email, phone, start_date, end_date, room_id, price, adults, children,
+			 created_at, updated_at, property_id)
+			 select $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
+			 where exists (select 1 from rooms where id = $7 and property_id = $13) returning id`
 
 	err := m.DB.QueryRowContext(ctx, stmt,
 		res.FirstName,
@@ -30,8 +36,12 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Price,
+		res.Adults,
+		res.Children,
 		time.Now(),
-		time.Now()).Scan(&newID)
+		time.Now(),
+		m.PropertyID).Scan(&newID)
 	if err != nil {
 		return 0, err
 	}
@@ -43,58 +53,251 @@ func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) error
 	defer cancel()
 
 	stmt := `insert into room_restrictions(start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
-			 values($1, $2, $3, $4, $5, $6, $7)`
+			 select $1, $2, $3, $4, $5, $6, $7 where exists (select 1 from rooms where id = $3 and property_id = $8)`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	result, err := m.DB.ExecContext(ctx, stmt,
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
 		res.ReservationID,
 		time.Now(),
 		time.Now(),
-		res.RestrictionID)
+		res.RestrictionID,
+		m.PropertyID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+// InsertBooking inserts a reservation with all its room stays and their room restrictions in one transaction,
+// turning the holds of the stays into the restrictions. It returns repository.ErrRoomUnavailable, and books
+// nothing, when any of the rooms has been taken, and repository.ErrInvalidStay when a stay isn't at least a night.
+func (m *postgresDBRepo) InsertBooking(res models.Reservation) (int, error) {
+	if !res.EndDate.After(res.StartDate) {
+		return 0, repository.ErrInvalidStay
+	}
+	for _, stay := range res.Stays {
+		if !stay.EndDate.After(stay.StartDate) {
+			return 0, repository.ErrInvalidStay
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var numRows int
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the rooms in id order, the same locks HoldRoom takes, so concurrent bookings can't deadlock
+	var roomIDs []int
+	for _, stay := range res.Stays {
+		roomIDs = append(roomIDs, stay.RoomID)
+	}
+	sort.Ints(roomIDs)
+	for _, id := range roomIDs {
+		_, err = tx.ExecContext(ctx, `select pg_advisory_xact_lock($1)`, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, stay := range res.Stays {
+		var ours bool
+		query := `select exists (select 1 from rooms where id = $1 and property_id = $2)`
+		err = tx.QueryRowContext(ctx, query, stay.RoomID, m.PropertyID).Scan(&ours)
+		if err != nil {
+			return 0, err
+		}
+		if !ours {
+			return 0, sql.ErrNoRows
+		}
+
+		var numRows int
+		query = `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date
+				and (expires_at is null or expires_at > now()) and id <> $4`
+		err = tx.QueryRowContext(ctx, query, stay.RoomID, stay.StartDate, stay.EndDate, stay.HoldID).Scan(&numRows)
+		if err != nil {
+			return 0, err
+		}
+		if numRows > 0 {
+			return 0, repository.ErrRoomUnavailable
+		}
+	}
+
+	if res.PromoCodeID != 0 {
+		// the row lock taken by the update makes concurrent bookings with the code wait for each other, so a code
+		// is never used more often than its limit
+		stmt := `update promo_codes set uses = uses + 1, updated_at = $1 where id = $2 and property_id = $3
+				and (max_uses = 0 or uses < max_uses)`
+		result, err := tx.ExecContext(ctx, stmt, time.Now(), res.PromoCodeID, m.PropertyID)
+		if err != nil {
+			return 0, err
+		}
+		counted, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if counted == 0 {
+			return 0, repository.ErrPromoCodeUsedUp
+		}
+	}
+
+	// the guest is recognised by email at the property, also by the emails of the duplicates merged into them, and
+	// takes the latest details; consent given once stays until staff change it
+	var guestID int
+	err = tx.QueryRowContext(ctx, `select guest_id from guest_emails where property_id = $1 and lower(email) = lower($2)`,
+		m.PropertyID, res.Email).Scan(&guestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if err == nil {
+		stmt := `update guests set first_name = $1, last_name = $2, phone = case when $3 = '' then phone else $3 end,
+				marketing_consent = marketing_consent or $4, updated_at = $5
+				where id = $6`
+		_, err = tx.ExecContext(ctx, stmt, res.FirstName, res.LastName, res.Phone, res.MarketingConsent, time.Now(),
+			guestID)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	query := `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date;`
+	stmt := `insert into guests (first_name, last_name, email, phone, marketing_consent, created_at, updated_at, property_id)
+			values ($1, $2, $3, $4, $5, $6, $7, $8)
+			on conflict (property_id, lower(email)) do update set first_name = excluded.first_name, last_name = excluded.last_name,
+			phone = case when excluded.phone = '' then guests.phone else excluded.phone end,
+			marketing_consent = guests.marketing_consent or excluded.marketing_consent, updated_at = excluded.updated_at
+			returning id`
+	if guestID == 0 {
+		err = tx.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.MarketingConsent,
+			time.Now(), time.Now(), m.PropertyID).Scan(&guestID)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
-	err := row.Scan(&numRows)
+	var newID int
+	stmt = `insert into reservations(first_name, last_name, email, phone, start_date, end_date, room_id, price, adults, children,
+			 payment_status, deposit_amount, balance_amount, cancellation_policy, cancel_key_hash, guest_id,
+			 display_currency, display_rate, created_at, updated_at, property_id)
+			 values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21) returning id`
+	err = tx.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate,
+		res.RoomID, res.Price, res.Adults, res.Children, res.PaymentStatus, res.DepositAmount, res.BalanceAmount,
+		res.CancellationPolicy, sql.NullString{String: res.CancelKeyHash, Valid: res.CancelKeyHash != ""},
+		guestID, res.DisplayCurrency, res.DisplayRate, time.Now(), time.Now(), m.PropertyID).Scan(&newID)
 	if err != nil {
-		return false, err
+		return 0, err
+	}
+
+	for _, stay := range res.Stays {
+		stmt = `insert into room_stays (reservation_id, room_id, start_date, end_date, price, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7)`
+		_, err = tx.ExecContext(ctx, stmt, newID, stay.RoomID, stay.StartDate, stay.EndDate, stay.Price, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+
+		stmt = `update room_restrictions set restriction_id = $1, reservation_id = $2, hold_key = null, expires_at = null,
+				updated_at = $3 where id = $4 and restriction_id = $5`
+		result, err := tx.ExecContext(ctx, stmt, models.RestrictionReservation, newID, time.Now(), stay.HoldID, models.RestrictionHold)
+		if err != nil {
+			return 0, err
+		}
+		converted, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if converted > 0 {
+			continue
+		}
+
+		// the hold was released in the meantime
+		stmt = `insert into room_restrictions(start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+				values($1, $2, $3, $4, $5, $6, $7)`
+		_, err = tx.ExecContext(ctx, stmt, stay.StartDate, stay.EndDate, stay.RoomID, newID, time.Now(), time.Now(), models.RestrictionReservation)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if res.PromoCodeID != 0 {
+		stmt = `insert into promo_redemptions (promo_code_id, reservation_id, code, discount, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6)`
+		_, err = tx.ExecContext(ctx, stmt, res.PromoCodeID, newID, res.PromoCode, res.Discount, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
 	}
-	if numRows == 0 {
-		return true, nil
+
+	for _, c := range res.Charges {
+		stmt = `insert into reservation_charges (reservation_id, charge_rule_id, name, kind, quantity, amount, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8)`
+		_, err = tx.ExecContext(ctx, stmt, newID, sql.NullInt64{Int64: int64(c.ChargeRuleID), Valid: c.ChargeRuleID != 0},
+			c.Name, c.Kind, c.Quantity, c.Amount, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return newID, tx.Commit()
+}
+
+// SearchAvailabilityByDatesByRoomID reports whether the room is free from start to end. The holds of holdKey don't
+// take it, as they are the guest's own.
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int, holdKey string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var available bool
+
+	// a room of another property is never available
+	query := `select exists (select 1 from rooms where id = $1 and property_id = $4) and not exists (
+			select 1 from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date
+			and (expires_at is null or expires_at > now()) and (hold_key is null or hold_key <> $5))`
+
+	row := m.DB.QueryRowContext(ctx, query, roomID, start, end, m.PropertyID, holdKey)
+	err := row.Scan(&available)
+	if err != nil {
+		return false, err
 	}
-	return false, nil
+	return available, nil
 }
 
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// SearchAvailabilityForAllRooms returns the rooms free from start to end that sleep at least guests people. The holds
+// of holdKey don't take a room, as they are the guest's own.
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, guests int, holdKey string) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var rooms []models.Room
 
-	query := `select r.id, r.room_name from rooms r where r.id not in (select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date);`
+	query := `select r.id, r.room_name, r.price, r.max_occupancy from rooms r where r.max_occupancy >= $3 and r.property_id = $4
+			and r.id not in (select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date
+			and (rr.expires_at is null or rr.expires_at > now()) and (rr.hold_key is null or rr.hold_key <> $5));`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, guests, m.PropertyID, holdKey)
 	if err != nil {
 		return rooms, err
 	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var room models.Room
 		err := rows.Scan(
 			&room.ID,
-			&room.RoomName)
+			&room.RoomName,
+			&room.Price,
+			&room.MaxOccupancy)
 		if err != nil {
 			return rooms, err
 		}
@@ -106,17 +309,73 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	return rooms, nil
 }
 
+// SearchFlexibleAvailability returns every room and arrival date for a stay of nights nights between from and
+// to (the last departure date) in rooms that sleep at least guests people, ordered by arrival date. The holds of
+// holdKey don't take a room, as they are the guest's own.
+func (m *postgresDBRepo) SearchFlexibleAvailability(from, to time.Time, nights, guests int, holdKey string) ([]models.StayOption, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var options []models.StayOption
+
+	query := `
+		select r.id, r.room_name, r.price, r.max_occupancy, d::date
+		from rooms r
+		cross join generate_series($1::date, $2::date - $3::integer, interval '1 day') d
+		where r.max_occupancy >= $4 and r.property_id = $5
+		and not exists (
+			select 1 from room_restrictions rr
+			where rr.room_id = r.id and d::date < rr.end_date and d::date + $3::integer > rr.start_date
+			and (rr.expires_at is null or rr.expires_at > now()) and (rr.hold_key is null or rr.hold_key <> $6)
+		)
+		order by d, r.id
+`
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to, nights, guests, m.PropertyID, holdKey)
+	if err != nil {
+		return options, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.StayOption
+		err := rows.Scan(
+			&o.Room.ID,
+			&o.Room.RoomName,
+			&o.Room.Price,
+			&o.Room.MaxOccupancy,
+			&o.StartDate,
+		)
+		if err != nil {
+			return options, err
+		}
+		o.EndDate = o.StartDate.AddDate(0, 0, nights)
+		options = append(options, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
+
 func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	var room models.Room
 
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
+	query := `select id, room_name, price, max_occupancy, cancellation_policy, created_at, updated_at from rooms
+			where id = $1 and property_id = $2`
+
+	row := m.DB.QueryRowContext(ctx, query, id, m.PropertyID)
 
-	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Price,
+		&room.MaxOccupancy,
+		&room.CancellationPolicy,
 		&room.CreatedAt,
 		&room.UpdatedAt)
 	if err != nil {
@@ -175,6 +434,71 @@ func (m *postgresDBRepo) UpdateUser(u models.User) error {
 	return nil
 }
 
+// InsertUser inserts a user, whose password must already be hashed
+func (m *postgresDBRepo) InsertUser(u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var newID int
+
+	stmt := `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Password,
+		u.AccessLevel,
+		time.Now(),
+		time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// GetUserByEmail returns a user by email
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+			from users where email = $1`
+	row := m.DB.QueryRowContext(ctx, query, email)
+
+	var user models.User
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// UpdateUserPassword sets a new password hash for a user
+func (m *postgresDBRepo) UpdateUserPassword(id int, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update users set password = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -204,14 +528,15 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-		rm.id, rm.room_name
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.adults, r.children, r.payment_status,
+		coalesce(r.cancelled_at, '0001-01-01'), rm.id, rm.room_name
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
+		where r.property_id = $1
 		order by r.start_date asc
 `
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, m.PropertyID)
 	if err != nil {
 		return reservations, err
 	}
@@ -231,6 +556,10 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Processed,
+			&i.Adults,
+			&i.Children,
+			&i.PaymentStatus,
+			&i.CancelledAt,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -256,15 +585,15 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, 
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.adults, r.children, r.payment_status,
 		rm.id, rm.room_name
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
-		where processed = 0
+		where processed = 0 and r.cancelled_at is null and r.property_id = $1
 		order by r.start_date asc
 `
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, m.PropertyID)
 	if err != nil {
 		return reservations, err
 	}
@@ -283,6 +612,9 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 			&i.RoomID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Adults,
+			&i.Children,
+			&i.PaymentStatus,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -309,13 +641,18 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, 
-		rm.id, rm.room_name
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.price, r.adults, r.children,
+		r.payment_status, r.deposit_amount, r.balance_amount, r.cancellation_policy,
+		coalesce(r.cancelled_at, '0001-01-01'), r.refund_amount, rm.id, rm.room_name,
+		coalesce(pr.promo_code_id, 0), coalesce(pr.code, ''), coalesce(pr.discount, 0), coalesce(r.guest_id, 0),
+		r.display_currency, r.display_rate
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
-		where r.id = $1
+		left join promo_redemptions pr on (pr.reservation_id = r.id)
+		where r.id = $1 and r.property_id = $2
 `
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.DB.QueryRowContext(ctx, query, id, m.PropertyID)
+
 	err := row.Scan(
 		&res.ID,
 		&res.FirstName,
@@ -328,15 +665,98 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.CreatedAt,
 		&res.UpdatedAt,
 		&res.Processed,
+		&res.Price,
+		&res.Adults,
+		&res.Children,
+		&res.PaymentStatus,
+		&res.DepositAmount,
+		&res.BalanceAmount,
+		&res.CancellationPolicy,
+		&res.CancelledAt,
+		&res.RefundAmount,
 		&res.Room.ID,
 		&res.Room.RoomName,
+		&res.PromoCodeID,
+		&res.PromoCode,
+		&res.Discount,
+		&res.GuestID,
+		&res.DisplayCurrency,
+		&res.DisplayRate,
 	)
 
 	if err != nil {
 		return res, err
 	}
 
-	return res, nil
+	query = `
+		select s.id, s.reservation_id, s.room_id, s.start_date, s.end_date, s.price, s.created_at, s.updated_at, rm.room_name
+		from room_stays s
+		left join rooms rm on (s.room_id = rm.id)
+		where s.reservation_id = $1
+		order by s.start_date, s.room_id
+`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.RoomStay
+		err := rows.Scan(
+			&s.ID,
+			&s.ReservationID,
+			&s.RoomID,
+			&s.StartDate,
+			&s.EndDate,
+			&s.Price,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&s.Room.RoomName,
+		)
+		if err != nil {
+			return res, err
+		}
+		s.Room.ID = s.RoomID
+		res.Stays = append(res.Stays, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return res, err
+	}
+
+	query = `
+		select id, reservation_id, coalesce(charge_rule_id, 0), name, kind, quantity, amount, created_at, updated_at
+		from reservation_charges
+		where reservation_id = $1
+		order by id
+`
+	chargeRows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return res, err
+	}
+	defer chargeRows.Close()
+
+	for chargeRows.Next() {
+		var c models.Charge
+		err := chargeRows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.ChargeRuleID,
+			&c.Name,
+			&c.Kind,
+			&c.Quantity,
+			&c.Amount,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return res, err
+		}
+		res.Charges = append(res.Charges, c)
+	}
+
+	return res, chargeRows.Err()
 }
 
 func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
@@ -345,7 +765,7 @@ func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 
 	query := `
 		update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5
-		where id = $6
+		where id = $6 and property_id = $7
 `
 
 	_, err := m.DB.ExecContext(ctx, query,
@@ -355,6 +775,7 @@ func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 		u.Phone,
 		time.Now(),
 		u.ID,
+		m.PropertyID,
 	)
 
 	if err != nil {
@@ -368,9 +789,9 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "delete from reservations where id = $1"
+	query := "delete from reservations where id = $1 and property_id = $2"
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err := m.DB.ExecContext(ctx, query, id, m.PropertyID)
 	if err != nil {
 		return err
 	}
@@ -382,9 +803,9 @@ func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "update reservations set processed = $1 where id = $2"
+	query := "update reservations set processed = $1 where id = $2 and property_id = $3"
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	_, err := m.DB.ExecContext(ctx, query, processed, id, m.PropertyID)
 	if err != nil {
 		return err
 	}
@@ -398,9 +819,10 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 
 	var rooms []models.Room
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+	query := `select id, room_name, price, max_occupancy, cancellation_policy, created_at, updated_at from rooms
+			where property_id = $1 order by room_name`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, m.PropertyID)
 	if err != nil {
 		return rooms, err
 	}
@@ -411,6 +833,9 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 		err := rows.Scan(
 			&rm.ID,
 			&rm.RoomName,
+			&rm.Price,
+			&rm.MaxOccupancy,
+			&rm.CancellationPolicy,
 			&rm.CreatedAt,
 			&rm.UpdatedAt,
 		)
@@ -427,7 +852,7 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	return rooms, nil
 }
 
-// GetRestrictionsForRoomByDate returns restrictions for a room by date range
+// GetRestrictionsForRoomByDate returns restrictions for a room by date range, with the holds that haven't expired
 func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -437,10 +862,11 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 	query := `
 		select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
 		from room_restrictions where $1 < end_date and $2 >= start_date
-		and room_id = $3
+		and room_id = $3 and (expires_at is null or expires_at > now())
+		and room_id in (select id from rooms where property_id = $4)
 `
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID, m.PropertyID)
 	if err != nil {
 		return nil, err
 	}
@@ -475,13 +901,21 @@ func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 	defer cancel()
 
 	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
-			created_at, updated_at) values ($1, $2, $3, $4, $5, $6)`
+			created_at, updated_at) select $1, $2, $3, $4, $5, $6
+			where exists (select 1 from rooms where id = $3 and property_id = $7)`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	result, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now(),
+		m.PropertyID)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
@@ -490,12 +924,1809 @@ func (m *postgresDBRepo) DeleteBlockByID(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from room_restrictions where id = $1`
+	query := `delete from room_restrictions where id = $1 and room_id in (select id from rooms where property_id = $2)`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err := m.DB.ExecContext(ctx, query, id, m.PropertyID)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	return nil
 }
+
+// HoldRoom holds a room for the stay until expires, on behalf of the guest identified by key. An existing hold of the
+// same guest for the same stay is extended instead. It returns the id of the hold, and false when the room is taken.
+func (m *postgresDBRepo) HoldRoom(key string, roomID int, start, end, expires time.Time) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var ours bool
+	err = tx.QueryRowContext(ctx, `select exists (select 1 from rooms where id = $1 and property_id = $2)`,
+		roomID, m.PropertyID).Scan(&ours)
+	if err != nil {
+		return 0, false, err
+	}
+	if !ours {
+		return 0, false, sql.ErrNoRows
+	}
+
+	// serialise holds per room so two guests can't both see the room as free
+	_, err = tx.ExecContext(ctx, `select pg_advisory_xact_lock($1)`, roomID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var holdID int
+	query := `update room_restrictions set expires_at = $1, updated_at = $2
+			where hold_key = $3 and room_id = $4 and start_date = $5 and end_date = $6 and expires_at > now()
+			returning id`
+	err = tx.QueryRowContext(ctx, query, expires, time.Now(), key, roomID, start, end).Scan(&holdID)
+	if err == nil {
+		return holdID, true, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	var numRows int
+	query = `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date
+			and (expires_at is null or expires_at > now()) and hold_key is distinct from $4`
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, key).Scan(&numRows)
+	if err != nil {
+		return 0, false, err
+	}
+	if numRows > 0 {
+		return 0, false, nil
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, hold_key, expires_at,
+			created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err = tx.QueryRowContext(ctx, stmt, start, end, roomID, models.RestrictionHold, key, expires, time.Now(), time.Now()).Scan(&holdID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return holdID, true, tx.Commit()
+}
+
+// DeleteExpiredHolds releases the holds past their expiry and returns how many were released
+func (m *postgresDBRepo) DeleteExpiredHolds() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from room_restrictions where restriction_id = $1 and expires_at <= now()`
+	result, err := m.DB.ExecContext(ctx, query, models.RestrictionHold)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetStayRulesByDate returns the stay rules of all rooms whose dates overlap start to end, both inclusive
+func (m *postgresDBRepo) GetStayRulesByDate(start, end time.Time) ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival,
+			closed_to_departure, arrival_weekdays, created_at, updated_at
+		from stay_rules
+		where start_date <= $2 and end_date >= $1 and room_id in (select id from rooms where property_id = $3)
+		order by room_id, start_date`
+
+	return m.queryStayRules(ctx, query, start, end, m.PropertyID)
+}
+
+// AllStayRules returns every stay rule with its room, by room and start date
+func (m *postgresDBRepo) AllStayRules() ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select s.id, s.room_id, s.start_date, s.end_date, s.min_nights, s.max_nights, s.closed_to_arrival,
+			s.closed_to_departure, s.arrival_weekdays, s.created_at, s.updated_at, r.room_name
+		from stay_rules s
+		join rooms r on (s.room_id = r.id)
+		where r.property_id = $1
+		order by r.room_name, s.start_date`
+
+	var rules []models.StayRule
+
+	rows, err := m.DB.QueryContext(ctx, query, m.PropertyID)
+
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.StayRule
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.StartDate,
+			&s.EndDate,
+			&s.MinNights,
+			&s.MaxNights,
+			&s.ClosedToArrival,
+			&s.ClosedToDeparture,
+			&s.ArrivalWeekdays,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&s.Room.RoomName,
+		)
+		if err != nil {
+			return rules, err
+		}
+		s.Room.ID = s.RoomID
+		rules = append(rules, s)
+	}
+
+	return rules, rows.Err()
+}
+
+// queryStayRules runs a query selecting the columns of stay_rules
+func (m *postgresDBRepo) queryStayRules(ctx context.Context, query string, args ...interface{}) ([]models.StayRule, error) {
+	var rules []models.StayRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.StayRule
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.StartDate,
+			&s.EndDate,
+			&s.MinNights,
+			&s.MaxNights,
+			&s.ClosedToArrival,
+			&s.ClosedToDeparture,
+			&s.ArrivalWeekdays,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, s)
+	}
+
+	return rules, rows.Err()
+}
+
+// InsertStayRule inserts a stay rule into the database
+func (m *postgresDBRepo) InsertStayRule(s models.StayRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `insert into stay_rules (room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival,
+			closed_to_departure, arrival_weekdays, created_at, updated_at)
+			select $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+			where exists (select 1 from rooms where id = $1 and property_id = $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		s.RoomID,
+		s.StartDate,
+		s.EndDate,
+		s.MinNights,
+		s.MaxNights,
+		s.ClosedToArrival,
+		s.ClosedToDeparture,
+		s.ArrivalWeekdays,
+		time.Now(),
+		time.Now(),
+		m.PropertyID,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateStayRule updates a stay rule in the database
+func (m *postgresDBRepo) UpdateStayRule(s models.StayRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update stay_rules set room_id = $1, start_date = $2, end_date = $3, min_nights = $4, max_nights = $5,
+			closed_to_arrival = $6, closed_to_departure = $7, arrival_weekdays = $8, updated_at = $9
+			where id = $10 and room_id in (select id from rooms where property_id = $11)
+			and $1 in (select id from rooms where property_id = $11)`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		s.RoomID,
+		s.StartDate,
+		s.EndDate,
+		s.MinNights,
+		s.MaxNights,
+		s.ClosedToArrival,
+		s.ClosedToDeparture,
+		s.ArrivalWeekdays,
+		time.Now(),
+		s.ID,
+		m.PropertyID,
+	)
+	return err
+}
+
+// DeleteStayRule deletes a stay rule
+func (m *postgresDBRepo) DeleteStayRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_rules where id = $1
+			and room_id in (select id from rooms where property_id = $2)`, id, m.PropertyID)
+	return err
+}
+
+// GetStayRuleByID returns a stay rule by id
+func (m *postgresDBRepo) GetStayRuleByID(id int) (models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival,
+			closed_to_departure, arrival_weekdays, created_at, updated_at
+		from stay_rules
+		where id = $1 and room_id in (select id from rooms where property_id = $2)`
+
+	rules, err := m.queryStayRules(ctx, query, id, m.PropertyID)
+	if err != nil {
+		return models.StayRule{}, err
+	}
+	if len(rules) == 0 {
+		return models.StayRule{}, sql.ErrNoRows
+	}
+	return rules[0], nil
+}
+
+// chargeRuleColumns are the columns of charge_rules read by queryChargeRules; open dates read as the zero time
+const chargeRuleColumns = `id, name, kind, calculation, amount, per, coalesce(start_date, '0001-01-01'),
+			coalesce(end_date, '0001-01-01'), created_at, updated_at`
+
+// GetChargeRulesByDate returns the charge rules that apply to any night from start to end, end excluded
+func (m *postgresDBRepo) GetChargeRulesByDate(start, end time.Time) ([]models.ChargeRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select ` + chargeRuleColumns + `
+		from charge_rules
+		where (start_date is null or start_date < $2) and (end_date is null or end_date >= $1) and property_id = $3
+		order by kind desc, id`
+
+	return m.queryChargeRules(ctx, query, start, end, m.PropertyID)
+}
+
+// AllChargeRules returns every charge rule, taxes first
+func (m *postgresDBRepo) AllChargeRules() ([]models.ChargeRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select ` + chargeRuleColumns + `
+		from charge_rules
+		where property_id = $1
+		order by kind desc, name, start_date nulls first`
+
+	return m.queryChargeRules(ctx, query, m.PropertyID)
+}
+
+// GetChargeRuleByID returns a charge rule by id
+func (m *postgresDBRepo) GetChargeRuleByID(id int) (models.ChargeRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select ` + chargeRuleColumns + `
+		from charge_rules
+		where id = $1 and property_id = $2`
+
+	rules, err := m.queryChargeRules(ctx, query, id, m.PropertyID)
+	if err != nil {
+		return models.ChargeRule{}, err
+	}
+	if len(rules) == 0 {
+		return models.ChargeRule{}, sql.ErrNoRows
+	}
+	return rules[0], nil
+}
+
+// queryChargeRules runs a query selecting chargeRuleColumns
+func (m *postgresDBRepo) queryChargeRules(ctx context.Context, query string, args ...interface{}) ([]models.ChargeRule, error) {
+	var rules []models.ChargeRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ChargeRule
+		err := rows.Scan(
+			&c.ID,
+			&c.Name,
+			&c.Kind,
+			&c.Calculation,
+			&c.Amount,
+			&c.Per,
+			&c.StartDate,
+			&c.EndDate,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return rules, err
+		}
+		if c.StartDate.Year() == 1 {
+			c.StartDate = time.Time{}
+		}
+		if c.EndDate.Year() == 1 {
+			c.EndDate = time.Time{}
+		}
+		rules = append(rules, c)
+	}
+
+	return rules, rows.Err()
+}
+
+// InsertChargeRule inserts a charge rule into the database
+func (m *postgresDBRepo) InsertChargeRule(c models.ChargeRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `insert into charge_rules (name, kind, calculation, amount, per, start_date, end_date, created_at, updated_at,
+			property_id) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		c.Name,
+		c.Kind,
+		c.Calculation,
+		c.Amount,
+		c.Per,
+		nullDate(c.StartDate),
+		nullDate(c.EndDate),
+		time.Now(),
+		time.Now(),
+		m.PropertyID,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateChargeRule updates a charge rule in the database. Reservations keep the charges they were made with.
+func (m *postgresDBRepo) UpdateChargeRule(c models.ChargeRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update charge_rules set name = $1, kind = $2, calculation = $3, amount = $4, per = $5, start_date = $6,
+			end_date = $7, updated_at = $8
+			where id = $9 and property_id = $10`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		c.Name,
+		c.Kind,
+		c.Calculation,
+		c.Amount,
+		c.Per,
+		nullDate(c.StartDate),
+		nullDate(c.EndDate),
+		time.Now(),
+		c.ID,
+		m.PropertyID,
+	)
+	return err
+}
+
+// DeleteChargeRule deletes a charge rule
+func (m *postgresDBRepo) DeleteChargeRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from charge_rules where id = $1 and property_id = $2`, id, m.PropertyID)
+	return err
+}
+
+// nullDate stores the zero time as null
+func nullDate(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// InsertWaitlistEntry puts a guest on the waitlist
+func (m *postgresDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var roomID sql.NullInt64
+	if e.RoomID > 0 {
+		roomID = sql.NullInt64{Int64: int64(e.RoomID), Valid: true}
+	}
+
+	var newID int
+
+	// a room, when there is one, must be one of the property's
+	query := `insert into waitlist_entries (room_id, start_date, end_date, adults, children, first_name, last_name,
+			email, created_at, updated_at, property_id)
+			select $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+			where $1::integer is null or exists (select 1 from rooms where id = $1 and property_id = $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		roomID,
+		e.StartDate,
+		e.EndDate,
+		e.Adults,
+		e.Children,
+		e.FirstName,
+		e.LastName,
+		e.Email,
+		time.Now(),
+		time.Now(),
+		m.PropertyID,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetWaitlistEntriesForRoomByDate returns the waitlist entries neither claimed nor declined, in the order the guests
+// joined, that want roomID or any room for a stay overlapping start to end and not yet begun
+func (m *postgresDBRepo) GetWaitlistEntriesForRoomByDate(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select ` + waitlistColumns + `
+		from waitlist_entries
+		where (room_id is null or room_id = $1) and start_date < $3 and end_date > $2
+			and start_date >= current_date and claimed_at is null and declined_at is null and property_id = $4
+		order by created_at, id`
+
+	return m.queryWaitlistEntries(ctx, query, roomID, start, end, m.PropertyID)
+}
+
+// GetWaitlistEntryByClaimKey returns the waitlist entry, neither claimed nor declined, whose claim link carries the
+// key hashing to hash
+func (m *postgresDBRepo) GetWaitlistEntryByClaimKey(hash string) (models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + waitlistColumns + ` from waitlist_entries where claim_key_hash = $1 and claim_key_hash <> ''
+			and claimed_at is null and declined_at is null and property_id = $2`
+
+	entries, err := m.queryWaitlistEntries(ctx, query, hash, m.PropertyID)
+	if err != nil {
+		return models.WaitlistEntry{}, err
+	}
+	if len(entries) == 0 {
+		return models.WaitlistEntry{}, sql.ErrNoRows
+	}
+	return entries[0], nil
+}
+
+// UpdateWaitlistNotified records that a waitlist entry was sent a claim link offering roomID
+func (m *postgresDBRepo) UpdateWaitlistNotified(id, roomID int, claimKeyHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update waitlist_entries set claim_key_hash = $1, offered_room_id = $2, notified_at = $3, updated_at = $3
+			where id = $4 and property_id = $5`
+
+	_, err := m.DB.ExecContext(ctx, query, claimKeyHash, roomID, time.Now(), id, m.PropertyID)
+	return err
+}
+
+// ClaimWaitlistEntry takes the waitlist entry, neither claimed nor declined, whose claim link carries the key hashing
+// to claimKeyHash off the waitlist and returns it. Claiming and checking the entry is still open are one statement,
+// so a link used twice at once is claimed once; the other use gets sql.ErrNoRows.
+func (m *postgresDBRepo) ClaimWaitlistEntry(claimKeyHash string) (models.WaitlistEntry, error) {
+	return m.closeWaitlistEntry("claimed_at", claimKeyHash)
+}
+
+// DeclineWaitlistEntry takes the waitlist entry, neither claimed nor declined, whose claim link carries the key
+// hashing to claimKeyHash off the waitlist without booking, and returns it; sql.ErrNoRows when there is none
+func (m *postgresDBRepo) DeclineWaitlistEntry(claimKeyHash string) (models.WaitlistEntry, error) {
+	return m.closeWaitlistEntry("declined_at", claimKeyHash)
+}
+
+// closeWaitlistEntry sets column, claimed_at or declined_at, of the open waitlist entry whose claim link carries the
+// key hashing to claimKeyHash, and returns the entry
+func (m *postgresDBRepo) closeWaitlistEntry(column, claimKeyHash string) (models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update waitlist_entries set ` + column + ` = $1, updated_at = $1
+			where claim_key_hash = $2 and claim_key_hash <> '' and claimed_at is null and declined_at is null
+			and property_id = $3
+			returning ` + waitlistColumns
+
+	entries, err := m.queryWaitlistEntries(ctx, query, time.Now(), claimKeyHash, m.PropertyID)
+	if err != nil {
+		return models.WaitlistEntry{}, err
+	}
+	if len(entries) == 0 {
+		return models.WaitlistEntry{}, sql.ErrNoRows
+	}
+	return entries[0], nil
+}
+
+// ExpireWaitlistOffers takes the waiting guests who were sent a claim link before notifiedBefore and neither used nor
+// declined it off the waitlist, as if they had declined, and returns their entries
+func (m *postgresDBRepo) ExpireWaitlistOffers(notifiedBefore time.Time) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update waitlist_entries set declined_at = $1, updated_at = $1
+			where claim_key_hash <> '' and notified_at < $2 and claimed_at is null and declined_at is null
+			and property_id = $3
+			returning ` + waitlistColumns
+
+	return m.queryWaitlistEntries(ctx, query, time.Now(), notifiedBefore, m.PropertyID)
+}
+
+// GetWaitlistEntriesByEmail returns the waitlist entries made with an email, whatever its case, claimed ones included
+func (m *postgresDBRepo) GetWaitlistEntriesByEmail(email string) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + waitlistColumns + ` from waitlist_entries where lower(email) = lower($1) and property_id = $2
+			order by created_at`
+
+	return m.queryWaitlistEntries(ctx, query, email, m.PropertyID)
+
+}
+
+// waitlistColumns are the columns read by queryWaitlistEntries
+const waitlistColumns = `id, coalesce(room_id, 0), start_date, end_date, adults, children, first_name, last_name,
+	email, claim_key_hash, coalesce(offered_room_id, 0), coalesce(notified_at, '0001-01-01'),
+	coalesce(claimed_at, '0001-01-01'), coalesce(declined_at, '0001-01-01'), created_at, updated_at`
+
+// queryWaitlistEntries runs a query selecting waitlistColumns
+func (m *postgresDBRepo) queryWaitlistEntries(ctx context.Context, query string, args ...interface{}) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.WaitlistEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.RoomID,
+			&e.StartDate,
+			&e.EndDate,
+			&e.Adults,
+			&e.Children,
+			&e.FirstName,
+			&e.LastName,
+			&e.Email,
+			&e.ClaimKeyHash,
+			&e.OfferedRoomID,
+			&e.NotifiedAt,
+			&e.ClaimedAt,
+			&e.DeclinedAt,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// InsertPayment records a checkout started with the payment provider
+func (m *postgresDBRepo) InsertPayment(p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `insert into payments (reservation_id, provider, provider_ref, kind, amount, status, created_at, updated_at)
+			select $1, $2, $3, $4, $5, $6, $7, $8
+			where exists (select 1 from reservations where id = $1 and property_id = $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		p.ReservationID,
+		p.Provider,
+		p.ProviderRef,
+		p.Kind,
+		p.Amount,
+		p.Status,
+		time.Now(),
+		time.Now(),
+		m.PropertyID,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetPaymentByProviderRef returns the payment of a provider's checkout session
+func (m *postgresDBRepo) GetPaymentByProviderRef(provider, ref string) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + paymentColumns + ` from payments where provider = $1 and provider_ref = $2
+			and reservation_id in (select id from reservations where property_id = $3)`
+
+	payments, err := m.queryPayments(ctx, query, provider, ref, m.PropertyID)
+	if err != nil {
+		return models.Payment{}, err
+	}
+	if len(payments) == 0 {
+		return models.Payment{}, sql.ErrNoRows
+	}
+	return payments[0], nil
+}
+
+// GetPaymentsForReservation returns the payments of a reservation, oldest first
+func (m *postgresDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + paymentColumns + ` from payments where reservation_id = $1
+			and reservation_id in (select id from reservations where property_id = $2) order by created_at, id`
+
+	return m.queryPayments(ctx, query, reservationID, m.PropertyID)
+}
+
+// GetPropertyIDForPayment returns the property of the reservation a provider's checkout session is for. Webhooks
+// reach the site without a property, so it is found before scoping the repository to it.
+func (m *postgresDBRepo) GetPropertyIDForPayment(provider, ref string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var propertyID int
+	query := `select r.property_id from payments p join reservations r on (p.reservation_id = r.id)
+			where p.provider = $1 and p.provider_ref = $2`
+	err := m.DB.QueryRowContext(ctx, query, provider, ref).Scan(&propertyID)
+
+	return propertyID, err
+}
+
+// ApplyPaymentEvent records the outcome of a pending payment from a provider's webhook event, and moves its
+// reservation to reservationStatus unless that is empty. Each event is applied at most once, and a payment that is
+// no longer pending is left alone; in both cases ApplyPaymentEvent returns false.
+func (m *postgresDBRepo) ApplyPaymentEvent(provider, eventID string, paymentID int, status, reservationStatus string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `insert into payment_events (provider, event_id, created_at) values ($1, $2, $3)
+			on conflict do nothing`, provider, eventID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		// seen before, keep the event recorded as it was
+		return false, err
+	}
+
+	var reservationID int
+	err = tx.QueryRowContext(ctx, `update payments set status = $1, updated_at = $2 where id = $3 and status = $4
+			and reservation_id in (select id from reservations where property_id = $5)
+			returning reservation_id`, status, time.Now(), paymentID, models.PaymentPending, m.PropertyID).Scan(&reservationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, tx.Commit()
+	} else if err != nil {
+		return false, err
+	}
+
+	if reservationStatus != "" {
+		_, err = tx.ExecContext(ctx, `update reservations set payment_status = $1, updated_at = $2 where id = $3`,
+			reservationStatus, time.Now(), reservationID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// paymentColumns are the columns read by queryPayments
+const paymentColumns = `id, reservation_id, provider, provider_ref, kind, amount, status, created_at, updated_at`
+
+// queryPayments runs a query selecting paymentColumns
+func (m *postgresDBRepo) queryPayments(ctx context.Context, query string, args ...interface{}) ([]models.Payment, error) {
+	var payments []models.Payment
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Provider,
+			&p.ProviderRef,
+			&p.Kind,
+			&p.Amount,
+			&p.Status,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	return payments, rows.Err()
+}
+
+// UpdateRoomCancellationPolicy sets the cancellation policy of a room, which applies to reservations made from now on
+func (m *postgresDBRepo) UpdateRoomCancellationPolicy(roomID int, policy string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update rooms set cancellation_policy = $1, updated_at = $2
+			where id = $3 and property_id = $4`, policy, time.Now(), roomID, m.PropertyID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetReservationByCancelKey returns the standing reservation whose cancellation link has the key with hash
+func (m *postgresDBRepo) GetReservationByCancelKey(hash string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, `select id from reservations where cancel_key_hash = $1 and cancelled_at is null
+			and property_id = $2`, hash, m.PropertyID).Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return m.GetReservationByID(id)
+}
+
+// CancelReservation marks a reservation cancelled with the refund it was given and frees its rooms. The reservation
+// is kept, so its payments and refund stay on record.
+func (m *postgresDBRepo) CancelReservation(id, refund int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update reservations set cancelled_at = $1, refund_amount = $2, cancel_key_hash = null, updated_at = $1
+			where id = $3 and cancelled_at is null and property_id = $4`
+	result, err := tx.ExecContext(ctx, stmt, time.Now(), refund, id, m.PropertyID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrAlreadyCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetInvoiceForReservation returns the invoice of a reservation, sql.ErrNoRows when none was issued
+func (m *postgresDBRepo) GetInvoiceForReservation(reservationID int) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv models.Invoice
+	query := `select id, number, reservation_id, total, pdf, created_at, updated_at from invoices
+			where reservation_id = $1 and property_id = $2`
+	err := m.DB.QueryRowContext(ctx, query, reservationID, m.PropertyID).Scan(
+		&inv.ID,
+		&inv.Number,
+		&inv.ReservationID,
+		&inv.Total,
+		&inv.PDF,
+		&inv.CreatedAt,
+		&inv.UpdatedAt,
+	)
+
+	return inv, err
+}
+
+// IssueInvoice issues the next invoice number of the property for a reservation and stores the PDF render makes for
+// it. The number is taken in the same transaction as the invoice is stored, so a failed invoice leaves no gap and
+// numbers are never reused.
+func (m *postgresDBRepo) IssueInvoice(reservationID, total int, render func(number int) []byte) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	inv := models.Invoice{ReservationID: reservationID, Total: total, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	var ours bool
+	err = tx.QueryRowContext(ctx, `select exists (select 1 from reservations where id = $1 and property_id = $2)`,
+		reservationID, m.PropertyID).Scan(&ours)
+	if err != nil {
+		return inv, err
+	}
+	if !ours {
+		return inv, sql.ErrNoRows
+	}
+
+	err = tx.QueryRowContext(ctx, `update properties set last_invoice_number = last_invoice_number + 1 where id = $1
+			returning last_invoice_number`, m.PropertyID).Scan(&inv.Number)
+	if err != nil {
+		return inv, err
+	}
+	inv.PDF = render(inv.Number)
+
+	stmt := `insert into invoices (number, reservation_id, total, pdf, created_at, updated_at, property_id)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	err = tx.QueryRowContext(ctx, stmt, inv.Number, inv.ReservationID, inv.Total, inv.PDF, inv.CreatedAt,
+		inv.UpdatedAt, m.PropertyID).Scan(&inv.ID)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// promoCodeColumns are the columns of promo_codes read by queryPromoCodes; open dates read as the zero time
+const promoCodeColumns = `id, code, description, calculation, amount, coalesce(valid_from, '0001-01-01'),
+			coalesce(valid_to, '0001-01-01'), coalesce(stay_from, '0001-01-01'), coalesce(stay_to, '0001-01-01'),
+			max_uses, uses, created_at, updated_at`
+
+// GetPromoCodeByCode returns the promo code with code, which must be normalized
+func (m *postgresDBRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + promoCodeColumns + ` from promo_codes where code = $1 and property_id = $2`
+
+	codes, err := m.queryPromoCodes(ctx, query, code, m.PropertyID)
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+	if len(codes) == 0 {
+		return models.PromoCode{}, sql.ErrNoRows
+	}
+	return codes[0], nil
+}
+
+// GetPromoCodeByID returns a promo code by id
+func (m *postgresDBRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + promoCodeColumns + ` from promo_codes where id = $1 and property_id = $2`
+
+	codes, err := m.queryPromoCodes(ctx, query, id, m.PropertyID)
+
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+	if len(codes) == 0 {
+		return models.PromoCode{}, sql.ErrNoRows
+	}
+	return codes[0], nil
+}
+
+// AllPromoCodes returns every promo code, by code
+func (m *postgresDBRepo) AllPromoCodes() ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + promoCodeColumns + ` from promo_codes where property_id = $1 order by code`
+
+	return m.queryPromoCodes(ctx, query, m.PropertyID)
+}
+
+// queryPromoCodes runs a query selecting promoCodeColumns and reads the rooms of each code
+func (m *postgresDBRepo) queryPromoCodes(ctx context.Context, query string, args ...interface{}) ([]models.PromoCode, error) {
+	var codes []models.PromoCode
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.PromoCode
+		err := rows.Scan(
+			&p.ID,
+			&p.Code,
+			&p.Description,
+			&p.Calculation,
+			&p.Amount,
+			&p.ValidFrom,
+			&p.ValidTo,
+			&p.StayFrom,
+			&p.StayTo,
+			&p.MaxUses,
+			&p.Uses,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return codes, err
+		}
+		for _, t := range []*time.Time{&p.ValidFrom, &p.ValidTo, &p.StayFrom, &p.StayTo} {
+			if t.Year() == 1 {
+				*t = time.Time{}
+			}
+		}
+		codes = append(codes, p)
+	}
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	for i := range codes {
+		roomRows, err := m.DB.QueryContext(ctx,
+			`select room_id from promo_code_rooms where promo_code_id = $1 order by room_id`, codes[i].ID)
+		if err != nil {
+			return codes, err
+		}
+		for roomRows.Next() {
+			var id int
+			if err := roomRows.Scan(&id); err != nil {
+				roomRows.Close()
+				return codes, err
+			}
+			codes[i].RoomIDs = append(codes[i].RoomIDs, id)
+		}
+		err = roomRows.Err()
+		roomRows.Close()
+		if err != nil {
+			return codes, err
+		}
+	}
+
+	return codes, nil
+}
+
+// InsertPromoCode inserts a promo code and its rooms into the database
+func (m *postgresDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	query := `insert into promo_codes (code, description, calculation, amount, valid_from, valid_to, stay_from, stay_to,
+			max_uses, created_at, updated_at, property_id)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+
+	err = tx.QueryRowContext(ctx, query,
+		p.Code,
+		p.Description,
+		p.Calculation,
+		p.Amount,
+		nullDate(p.ValidFrom),
+		nullDate(p.ValidTo),
+		nullDate(p.StayFrom),
+		nullDate(p.StayTo),
+		p.MaxUses,
+		time.Now(),
+		time.Now(),
+		m.PropertyID,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertPromoCodeRooms(ctx, tx, m.PropertyID, newID, p.RoomIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// UpdatePromoCode updates a promo code and replaces its rooms. The uses counted so far are kept.
+func (m *postgresDBRepo) UpdatePromoCode(p models.PromoCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update promo_codes set code = $1, description = $2, calculation = $3, amount = $4, valid_from = $5,
+			valid_to = $6, stay_from = $7, stay_to = $8, max_uses = $9, updated_at = $10
+			where id = $11 and property_id = $12`
+
+	result, err := tx.ExecContext(ctx, query,
+		p.Code,
+		p.Description,
+		p.Calculation,
+		p.Amount,
+		nullDate(p.ValidFrom),
+		nullDate(p.ValidTo),
+		nullDate(p.StayFrom),
+		nullDate(p.StayTo),
+		p.MaxUses,
+		time.Now(),
+		p.ID,
+		m.PropertyID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from promo_code_rooms where promo_code_id = $1`, p.ID)
+	if err != nil {
+		return err
+	}
+	err = insertPromoCodeRooms(ctx, tx, m.PropertyID, p.ID, p.RoomIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertPromoCodeRooms limits the promo code with id to roomIDs, leaving out the rooms of other properties
+func insertPromoCodeRooms(ctx context.Context, tx *sql.Tx, propertyID, id int, roomIDs []int) error {
+	for _, roomID := range roomIDs {
+		_, err := tx.ExecContext(ctx, `insert into promo_code_rooms (promo_code_id, room_id)
+				select $1, id from rooms where id = $2 and property_id = $3`, id, roomID, propertyID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePromoCode deletes a promo code. Reservations keep the code they redeemed.
+func (m *postgresDBRepo) DeletePromoCode(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from promo_codes where id = $1 and property_id = $2`, id, m.PropertyID)
+	return err
+}
+
+// guestQuery selects guests with the number of reservations they didn't cancel and their latest arrival, to be
+// completed with a where clause scoping g.property_id and "group by g.id"
+const guestQuery = `
+		select g.id, g.first_name, g.last_name, g.email, g.phone, g.notes, g.vip, g.do_not_rent, g.marketing_consent,
+			g.created_at, g.updated_at, count(r.id), coalesce(max(r.start_date), '0001-01-01')
+		from guests g
+		left join reservations r on (r.guest_id = g.id and r.cancelled_at is null)`
+
+// GetGuestByID returns a guest by id
+func (m *postgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	guests, err := m.queryGuests(ctx, guestQuery+` where g.id = $1 and g.property_id = $2 group by g.id`, id,
+		m.PropertyID)
+	if err != nil {
+		return models.Guest{}, err
+	}
+	if len(guests) == 0 {
+		return models.Guest{}, sql.ErrNoRows
+	}
+	return guests[0], nil
+}
+
+// GetGuestByEmail returns the guest with email, whatever its case, or the guest a duplicate with email was merged into
+func (m *postgresDBRepo) GetGuestByEmail(email string) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	guests, err := m.queryGuests(ctx, guestQuery+` where (lower(g.email) = lower($1)
+		or g.id in (select guest_id from guest_emails where property_id = $2 and lower(email) = lower($1)))
+		and g.property_id = $2 group by g.id`, email, m.PropertyID)
+	if err != nil {
+		return models.Guest{}, err
+	}
+	if len(guests) == 0 {
+		return models.Guest{}, sql.ErrNoRows
+	}
+	return guests[0], nil
+}
+
+// SearchGuests returns the guests whose name, email or phone contains query, by name. An empty query returns the
+// most recent guests.
+func (m *postgresDBRepo) SearchGuests(query string) ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if query == "" {
+		return m.queryGuests(ctx, guestQuery+` where g.property_id = $1 group by g.id
+		order by g.created_at desc limit 100`, m.PropertyID)
+	}
+
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	q := guestQuery + `
+		where (g.first_name || ' ' || g.last_name ilike $1 or g.email ilike $1 or g.phone ilike $1)
+			and g.property_id = $2
+		group by g.id
+		order by g.last_name, g.first_name
+		limit 100`
+
+	return m.queryGuests(ctx, q, pattern, m.PropertyID)
+}
+
+// FindDuplicateGuests returns the other guests with the name or the phone of g
+func (m *postgresDBRepo) FindDuplicateGuests(g models.Guest) ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	q := guestQuery + `
+		where g.id <> $1 and ((lower(g.first_name) = lower($2) and lower(g.last_name) = lower($3))
+			or ($4 <> '' and g.phone = $4)) and g.property_id = $5
+		group by g.id
+		order by g.last_name, g.first_name`
+
+	return m.queryGuests(ctx, q, g.ID, g.FirstName, g.LastName, g.Phone, m.PropertyID)
+}
+
+// queryGuests runs a query built on guestQuery
+func (m *postgresDBRepo) queryGuests(ctx context.Context, query string, args ...interface{}) ([]models.Guest, error) {
+	var guests []models.Guest
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return guests, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Guest
+		err := rows.Scan(
+			&g.ID,
+			&g.FirstName,
+			&g.LastName,
+			&g.Email,
+			&g.Phone,
+			&g.Notes,
+			&g.VIP,
+			&g.DoNotRent,
+			&g.MarketingConsent,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+			&g.Reservations,
+			&g.LastStay,
+		)
+		if err != nil {
+			return guests, err
+		}
+		if g.LastStay.Year() == 1 {
+			g.LastStay = time.Time{}
+		}
+		guests = append(guests, g)
+	}
+
+	return guests, rows.Err()
+}
+
+// GetReservationsForGuest returns the reservations of a guest, cancelled ones included, latest arrival first
+func (m *postgresDBRepo) GetReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryReservationHistory(ctx, `r.guest_id = $1`, guestID)
+}
+
+// GetReservationsByEmail returns the reservations made with an email, whatever its case, and those of the guest found
+// by it, which include the reservations of the duplicates merged into the guest, latest arrival first
+func (m *postgresDBRepo) GetReservationsByEmail(email string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryReservationHistory(ctx, `(lower(r.email) = lower($1) or r.guest_id in (`+guestIDsByEmail("$1", "$2")+`))`,
+		email)
+}
+
+// guestIDsByEmail is a subquery of the ids of the guests of the property in the parameter propertyID found by the
+// email in the parameter email, also by the emails of the duplicates merged into them
+func guestIDsByEmail(email, propertyID string) string {
+	return `select id from guests where property_id = ` + propertyID + ` and lower(email) = lower(` + email + `)
+		union select guest_id from guest_emails where property_id = ` + propertyID + ` and lower(email) = lower(` + email + `)`
+}
+
+// queryReservationHistory returns the reservations of the property matching the where condition on $1, with their
+// room, cancelled ones included, latest arrival first
+func (m *postgresDBRepo) queryReservationHistory(ctx context.Context, where string, arg interface{}) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, coalesce(r.guest_id, 0), r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.created_at, r.updated_at, r.processed, r.price, r.adults, r.children,
+			coalesce(r.cancelled_at, '0001-01-01'), r.display_currency, r.display_rate, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.property_id = $2 and ` + where + `
+		order by r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, arg, m.PropertyID)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.GuestID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.Price,
+			&i.Adults,
+			&i.Children,
+			&i.CancelledAt,
+			&i.DisplayCurrency,
+			&i.DisplayRate,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		if i.CancelledAt.Year() == 1 {
+			i.CancelledAt = time.Time{}
+		}
+		reservations = append(reservations, i)
+	}
+
+	return reservations, rows.Err()
+}
+
+// UpdateGuest updates the profile of a guest. Their reservations keep the details given when booking.
+func (m *postgresDBRepo) UpdateGuest(g models.Guest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update guests set first_name = $1, last_name = $2, email = $3, phone = $4, notes = $5, vip = $6,
+			do_not_rent = $7, marketing_consent = $8, updated_at = $9
+			where id = $10 and property_id = $11`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		g.FirstName,
+		g.LastName,
+		g.Email,
+		g.Phone,
+		g.Notes,
+		g.VIP,
+		g.DoNotRent,
+		g.MarketingConsent,
+		time.Now(),
+		g.ID,
+		m.PropertyID,
+	)
+	return err
+}
+
+// MergeGuests moves the reservations of the guest mergeID to the guest keepID and deletes mergeID. The kept guest
+// takes the notes and tags of the other, and keeps its own details and marketing consent. The emails of mergeID stay
+// with keepID, so later bookings made with them don't bring the duplicate back.
+func (m *postgresDBRepo) MergeGuests(keepID, mergeID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update guests k set notes = trim(both e'\n' from k.notes || e'\n' || d.notes), vip = k.vip or d.vip,
+			do_not_rent = k.do_not_rent or d.do_not_rent,
+			phone = case when k.phone = '' then d.phone else k.phone end, updated_at = $3
+			from guests d
+			where k.id = $1 and d.id = $2 and k.id <> d.id and k.property_id = $4 and d.property_id = $4`
+	result, err := tx.ExecContext(ctx, stmt, keepID, mergeID, time.Now(), m.PropertyID)
+	if err != nil {
+		return err
+	}
+	merged, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if merged == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set guest_id = $1, updated_at = $2 where guest_id = $3`,
+		keepID, time.Now(), mergeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update guest_emails set guest_id = $1, updated_at = $2 where guest_id = $3`,
+		keepID, time.Now(), mergeID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into guest_emails (guest_id, property_id, email, created_at, updated_at)
+			select $1, property_id, email, $2, $2 from guests where id = $3
+			on conflict (property_id, lower(email)) do nothing`
+	_, err = tx.ExecContext(ctx, stmt, keepID, time.Now(), mergeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from guests where id = $1`, mergeID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertGuestLoginToken stores the hash of a login link key for a guest, usable until expires
+func (m *postgresDBRepo) InsertGuestLoginToken(guestID int, hash string, expires time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `insert into guest_login_tokens (guest_id, token_hash, expires_at, created_at, updated_at)
+			select $1, $2, $3, $4, $5 where exists (select 1 from guests where id = $1 and property_id = $6)`
+
+	result, err := m.DB.ExecContext(ctx, query, guestID, hash, expires, time.Now(), time.Now(), m.PropertyID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UseGuestLoginToken marks the login token with hash used and returns its guest. The token must be unused and
+// unexpired, otherwise sql.ErrNoRows is returned; marking it in the same statement means a link logs in only once.
+func (m *postgresDBRepo) UseGuestLoginToken(hash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update guest_login_tokens set used_at = $1, updated_at = $1
+			where token_hash = $2 and used_at is null and expires_at > $1
+			and guest_id in (select id from guests where property_id = $3)
+			returning guest_id`
+
+	var guestID int
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), hash, m.PropertyID).Scan(&guestID)
+	return guestID, err
+}
+
+// anonymisedName is the first name left on reservations whose guest's personal fields were removed
+const anonymisedName = "Anonymised"
+
+// ErasePersonalData anonymises the reservations made with an email and those of the guest found by it, including the
+// ones merged from duplicates, and deletes the guest profile and the waitlist entries, recording entry in the privacy
+// log with the number of records. Reservations keep their dates, rooms, prices and payments for accounting, and
+// invoices are kept as issued.
+func (m *postgresDBRepo) ErasePersonalData(email string, entry models.PrivacyLogEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	records := 0
+
+	result, err := tx.ExecContext(ctx, `
+		update reservations set first_name = $1, last_name = '', email = '', phone = '', guest_id = null,
+			cancel_key_hash = null, anonymised_at = $2, updated_at = $2
+		where (lower(email) = lower($3) or guest_id in (`+guestIDsByEmail("$3", "$4")+`)) and property_id = $4`,
+		anonymisedName, now, email, m.PropertyID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	records += int(n)
+
+	for _, query := range []string{
+		`delete from guests where id in (` + guestIDsByEmail("$1", "$2") + `) and property_id = $2`,
+		`delete from waitlist_entries where lower(email) = lower($1) and property_id = $2`,
+	} {
+		result, err = tx.ExecContext(ctx, query, email, m.PropertyID)
+		if err != nil {
+			return 0, err
+		}
+		n, _ = result.RowsAffected()
+		records += int(n)
+	}
+
+	entry.Records = records
+	err = insertPrivacyLog(ctx, tx, m.PropertyID, entry)
+	if err != nil {
+		return 0, err
+	}
+
+	return records, tx.Commit()
+}
+
+// AnonymiseReservationsBefore anonymises the reservations that ended before cutoff, deletes the guest profiles left
+// without reservations and the waitlist entries for dates before cutoff, recording entry in the privacy log with the
+// number of records
+func (m *postgresDBRepo) AnonymiseReservationsBefore(cutoff time.Time, entry models.PrivacyLogEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	records := 0
+
+	result, err := tx.ExecContext(ctx, `
+		update reservations set first_name = $1, last_name = '', email = '', phone = '', guest_id = null,
+			cancel_key_hash = null, anonymised_at = $2, updated_at = $2
+		where end_date < $3 and anonymised_at is null and property_id = $4`, anonymisedName, now, cutoff, m.PropertyID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	records += int(n)
+
+	// guests are only created with a reservation, so a guest without any had them all anonymised
+	result, err = tx.ExecContext(ctx, `delete from guests g where g.property_id = $1
+			and not exists (select 1 from reservations r where r.guest_id = g.id)`, m.PropertyID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ = result.RowsAffected()
+	records += int(n)
+
+	result, err = tx.ExecContext(ctx, `delete from waitlist_entries where end_date < $1 and property_id = $2`, cutoff,
+		m.PropertyID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ = result.RowsAffected()
+	records += int(n)
+
+	if records == 0 {
+		return 0, nil
+	}
+	entry.Records = records
+	err = insertPrivacyLog(ctx, tx, m.PropertyID, entry)
+	if err != nil {
+		return 0, err
+	}
+
+	return records, tx.Commit()
+}
+
+// InsertPrivacyLog records an export of personal data in the privacy log
+func (m *postgresDBRepo) InsertPrivacyLog(entry models.PrivacyLogEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, privacyLogInsert, privacyLogArgs(m.PropertyID, entry)...)
+	return err
+}
+
+// insertPrivacyLog records entry in the privacy log of a property in the transaction of the erasure it logs
+func insertPrivacyLog(ctx context.Context, tx *sql.Tx, propertyID int, entry models.PrivacyLogEntry) error {
+	_, err := tx.ExecContext(ctx, privacyLogInsert, privacyLogArgs(propertyID, entry)...)
+	return err
+}
+
+const privacyLogInsert = `insert into privacy_log (action, subject, user_id, records, created_at, property_id)
+		values ($1, $2, $3, $4, $5, $6)`
+
+// privacyLogArgs are the arguments of privacyLogInsert for entry; entries of the retention policy have no user
+func privacyLogArgs(propertyID int, entry models.PrivacyLogEntry) []interface{} {
+	var userID sql.NullInt64
+	if entry.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(entry.UserID), Valid: true}
+	}
+	return []interface{}{entry.Action, entry.Subject, userID, entry.Records, time.Now(), propertyID}
+}
+
+// GetPrivacyLog returns the latest entries of the privacy log with the staff member of each, newest first
+func (m *postgresDBRepo) GetPrivacyLog() ([]models.PrivacyLogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.PrivacyLogEntry
+
+	query := `
+		select l.id, l.action, l.subject, coalesce(l.user_id, 0), coalesce(u.first_name, ''),
+			coalesce(u.last_name, ''), l.records, l.created_at
+		from privacy_log l
+		left join users u on (u.id = l.user_id)
+		where l.property_id = $1
+		order by l.created_at desc
+		limit 200`
+
+	rows, err := m.DB.QueryContext(ctx, query, m.PropertyID)
+
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.PrivacyLogEntry
+		err := rows.Scan(&e.ID, &e.Action, &e.Subject, &e.UserID, &e.User.FirstName, &e.User.LastName, &e.Records,
+			&e.CreatedAt)
+		if err != nil {
+			return entries, err
+		}
+		e.User.ID = e.UserID
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// AllExchangeRates returns the exchange rates, by currency
+func (m *postgresDBRepo) AllExchangeRates() ([]models.ExchangeRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rates []models.ExchangeRate
+
+	rows, err := m.DB.QueryContext(ctx,
+		`select currency, rate, created_at, updated_at from exchange_rates order by currency`)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.ExchangeRate
+		err := rows.Scan(&e.Currency, &e.Rate, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, e)
+	}
+
+	return rates, rows.Err()
+}
+
+// UpsertExchangeRates adds the rates of new currencies and updates the others, in one transaction so an import
+// applies completely or not at all
+func (m *postgresDBRepo) UpsertExchangeRates(rates []models.ExchangeRate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into exchange_rates (currency, rate, created_at, updated_at) values ($1, $2, $3, $4)
+			on conflict (currency) do update set rate = excluded.rate, updated_at = excluded.updated_at`
+	for _, e := range rates {
+		_, err = tx.ExecContext(ctx, stmt, e.Currency, e.Rate, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExchangeRate deletes the rate of a currency. Reservations keep the rate they were made with.
+func (m *postgresDBRepo) DeleteExchangeRate(currency string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from exchange_rates where currency = $1`, currency)
+	return err
+}
+
+// propertyColumns are the columns of properties read by queryProperties
+const propertyColumns = `p.id, p.slug, p.name, p.host, p.address, p.phone, p.email, p.created_at, p.updated_at`
+
+// AllProperties returns every property, by id
+func (m *postgresDBRepo) AllProperties() ([]models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryProperties(ctx, `select `+propertyColumns+` from properties p order by p.id`)
+}
+
+// GetPropertiesForUser returns the properties a staff member was given access to, by id
+func (m *postgresDBRepo) GetPropertiesForUser(userID int) ([]models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties p
+			join property_users pu on (pu.property_id = p.id)
+			where pu.user_id = $1
+			order by p.id`
+
+	return m.queryProperties(ctx, query, userID)
+}
+
+// queryProperties runs a query selecting propertyColumns
+func (m *postgresDBRepo) queryProperties(ctx context.Context, query string, args ...interface{}) ([]models.Property, error) {
+	var properties []models.Property
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return properties, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Property
+		err := rows.Scan(
+			&p.ID,
+			&p.Slug,
+			&p.Name,
+			&p.Host,
+			&p.Address,
+			&p.Phone,
+			&p.Email,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return properties, err
+		}
+		properties = append(properties, p)
+	}
+
+	return properties, rows.Err()
+}
+
+// InsertProperty inserts a property, whose invoices are numbered from 1
+func (m *postgresDBRepo) InsertProperty(p models.Property) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into properties (slug, name, host, address, phone, email, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.Slug,
+		p.Name,
+		p.Host,
+		p.Address,
+		p.Phone,
+		p.Email,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateProperty updates the details of a property
+func (m *postgresDBRepo) UpdateProperty(p models.Property) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update properties set slug = $1, name = $2, host = $3, address = $4, phone = $5, email = $6, updated_at = $7
+			where id = $8`
+
+	result, err := m.DB.ExecContext(ctx, stmt, p.Slug, p.Name, p.Host, p.Address, p.Phone, p.Email, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GrantPropertyAccess lets a staff member work on a property; granting it again changes nothing
+func (m *postgresDBRepo) GrantPropertyAccess(propertyID, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into property_users (property_id, user_id, created_at) values ($1, $2, $3) on conflict do nothing`
+
+	_, err := m.DB.ExecContext(ctx, stmt, propertyID, userID, time.Now())
+	return err
+}
+
+// RevokePropertyAccess takes a property away from a staff member
+func (m *postgresDBRepo) RevokePropertyAccess(propertyID, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from property_users where property_id = $1 and user_id = $2`,
+		propertyID, userID)
+	return err
+}
@@ -0,0 +1,164 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/driver"
+	"github.com/flaviusp23/bookings/internal/migrate"
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/repository"
+	"github.com/flaviusp23/bookings/migrations"
+)
+
+// testPostgresRepo returns a repository for property 1 of the migrated database in BOOKINGS_TEST_DSN, skipping the
+// test when it isn't set
+func testPostgresRepo(t *testing.T) repository.DatabaseRepo {
+	dsn := os.Getenv("BOOKINGS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BOOKINGS_TEST_DSN is not set")
+	}
+
+	db, err := driver.NewDatabase(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewPostgresRepo(db, &config.AppConfig{}).ForProperty(1)
+}
+
+// testBooking books room 1 without stays for the email, so the tests don't need free dates
+func testBooking(t *testing.T, repo repository.DatabaseRepo, firstName, email string) int {
+	id, err := repo.InsertBooking(models.Reservation{
+		FirstName: firstName,
+		LastName:  "Merged",
+		Email:     email,
+		StartDate: time.Date(2090, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2090, 1, 2, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Adults:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestMergeGuestsBookAgain(t *testing.T) {
+	repo := testPostgresRepo(t)
+
+	suffix := time.Now().UnixNano()
+	keepEmail := fmt.Sprintf("keep-%d@example.com", suffix)
+	mergeEmail := fmt.Sprintf("merge-%d@example.com", suffix)
+
+	testBooking(t, repo, "Keep", keepEmail)
+	testBooking(t, repo, "Merge", mergeEmail)
+
+	keep, err := repo.GetGuestByEmail(keepEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge, err := repo.GetGuestByEmail(mergeEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.MergeGuests(keep.ID, merge.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	resID := testBooking(t, repo, "Again", mergeEmail)
+
+	found, err := repo.GetGuestByEmail(mergeEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != keep.ID {
+		t.Errorf("booking again with the merged email went to guest %d, expected the kept guest %d", found.ID, keep.ID)
+	}
+
+	res, err := repo.GetReservationByID(resID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GuestID != keep.ID {
+		t.Errorf("reservation %d belongs to guest %d, expected the kept guest %d", resID, res.GuestID, keep.ID)
+	}
+
+	guests, err := repo.SearchGuests(fmt.Sprintf("%d@example.com", suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(guests) != 1 {
+		t.Errorf("expected 1 guest after booking again but got %d", len(guests))
+	}
+}
+
+func TestErasePersonalDataMergedGuest(t *testing.T) {
+	repo := testPostgresRepo(t)
+
+	suffix := time.Now().UnixNano()
+	keepEmail := fmt.Sprintf("keep-%d@example.com", suffix)
+	mergeEmail := fmt.Sprintf("merge-%d@example.com", suffix)
+
+	keepResID := testBooking(t, repo, "Keep", keepEmail)
+	mergeResID := testBooking(t, repo, "Merge", mergeEmail)
+
+	keep, err := repo.GetGuestByEmail(keepEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge, err := repo.GetGuestByEmail(mergeEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MergeGuests(keep.ID, merge.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := repo.GetReservationsByEmail(keepEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected the 2 reservations of the merged guest for export but got %d", len(history))
+	}
+
+	// the 2 reservations and the guest are counted
+	records, err := repo.ErasePersonalData(keepEmail, models.PrivacyLogEntry{Action: "erase", Subject: keepEmail})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != 3 {
+		t.Errorf("expected 3 records erased but got %d", records)
+	}
+
+	for _, id := range []int{keepResID, mergeResID} {
+		res, err := repo.GetReservationByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Email != "" || res.GuestID != 0 {
+			t.Errorf("expected reservation %d anonymised, got email %q and guest %d", id, res.Email, res.GuestID)
+		}
+	}
+
+	_, err = repo.GetGuestByEmail(mergeEmail)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the merged email to find no guest after erasure, got %v", err)
+	}
+}
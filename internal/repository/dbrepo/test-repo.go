@@ -1,11 +1,16 @@
 package dbrepo
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/repository"
 )
 
 func (m *testDBRepo) AllUsers() bool {
@@ -21,6 +26,26 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	return 1, nil
 }
 
+// InsertBooking inserts a reservation with its room stays; room 1000 fails, and stays starting after 2049-12-31
+// are taken unless they hold the room with hold 1; stays of no nights are refused
+func (m *testDBRepo) InsertBooking(res models.Reservation) (int, error) {
+	for _, stay := range res.Stays {
+		if !stay.EndDate.After(stay.StartDate) {
+			return 0, repository.ErrInvalidStay
+		}
+		if stay.RoomID == 1000 {
+			return 0, errors.New("some error")
+		}
+		if stay.StartDate.After(time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)) && stay.HoldID != 1 {
+			return 0, repository.ErrRoomUnavailable
+		}
+	}
+	if res.PromoCodeID == testPromoCodeTaken {
+		return 0, repository.ErrPromoCodeUsedUp
+	}
+	return 1, nil
+}
+
 // InsertRoomRestriction inserts a room restriction into the database
 func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	if r.RoomID == 1000 {
@@ -29,8 +54,11 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// testHoldKey is the hold key of the guest holding room 1 for the stays after 2049-12-31, which only they find free
+const testHoldKey = "test-hold"
+
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int, holdKey string) (bool, error) {
 	// set up a test time
 	layout := "2006-01-02"
 	str := "2049-12-31"
@@ -51,7 +79,7 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roo
 
 	// if the start date is after 2049-12-31, then return false,
 	// indicating no availability;
-	if start.After(t) {
+	if start.After(t) && holdKey != testHoldKey {
 		return false, nil
 	}
 
@@ -60,7 +88,7 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roo
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, guests int, holdKey string) ([]models.Room, error) {
 	var rooms []models.Room
 
 	// if the start date is after 2049-12-31, then return empty slice,
@@ -81,20 +109,43 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 		return rooms, errors.New("some error")
 	}
 
-	if start.After(t) {
+	if start.After(t) && holdKey != testHoldKey {
 		return rooms, nil
 	}
 
 	// otherwise, put an entry into the slice, indicating that some room is
 	// available for search dates
 	room := models.Room{
-		ID: 1,
+		ID:           1,
+		MaxOccupancy: 2,
+	}
+	if guests > room.MaxOccupancy {
+		return rooms, nil
 	}
 	rooms = append(rooms, room)
 
 	return rooms, nil
 }
 
+// SearchFlexibleAvailability returns room 1 for every arrival date in the window; like the other searches,
+// windows starting after 2049-12-31 have no availability but for testHoldKey and a start of 2060-01-01 fails
+func (m *testDBRepo) SearchFlexibleAvailability(from, to time.Time, nights, guests int, holdKey string) ([]models.StayOption, error) {
+	var options []models.StayOption
+
+	if from.Equal(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return options, errors.New("some error")
+	}
+	if (from.After(time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)) && holdKey != testHoldKey) || guests > 2 {
+		return options, nil
+	}
+
+	room := models.Room{ID: 1, RoomName: "General's Quarters", Price: 10000, MaxOccupancy: 2}
+	for d := from; !d.AddDate(0, 0, nights).After(to); d = d.AddDate(0, 0, 1) {
+		options = append(options, models.StayOption{Room: room, StartDate: d, EndDate: d.AddDate(0, 0, nights)})
+	}
+	return options, nil
+}
+
 // GetRoomByID gets a room by id
 func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
@@ -102,6 +153,7 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 		return room, errors.New("some error")
 	}
 	room.ID = id
+	room.MaxOccupancy = 2
 	return room, nil
 }
 
@@ -115,6 +167,24 @@ func (m *testDBRepo) UpdateUser(u models.User) error {
 	return nil
 }
 
+func (m *testDBRepo) InsertUser(u models.User) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	var u models.User
+	if email != "me@here.ca" {
+		return u, errors.New("some error")
+	}
+	u.ID = 1
+	u.Email = email
+	return u, nil
+}
+
+func (m *testDBRepo) UpdateUserPassword(id int, hashedPassword string) error {
+	return nil
+}
+
 func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
 	if email == "me@here.ca" {
 		return 1, "", nil
@@ -134,8 +204,26 @@ func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
+// GetReservationByID returns a reservation with only its id set; reservation 2, a month away under the moderate policy, has its
+// deposit of 3000 cents paid and a balance of 7000 cents. The reservations belong to property 1, so other properties
+// find none.
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	var res models.Reservation
+	if m.PropertyID != 1 {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+	res := models.Reservation{ID: id}
+
+	if id == 2 {
+		res.GuestID = 1
+		res.Email = "john@smith.com"
+		res.Price = 10000
+		res.PaymentStatus = models.PaymentDepositPaid
+		res.DepositAmount = 3000
+		res.BalanceAmount = 7000
+		res.CancellationPolicy = "moderate"
+		res.StartDate = time.Now().AddDate(0, 1, 0)
+		res.EndDate = res.StartDate.AddDate(0, 0, 1)
+	}
 
 	return res, nil
 }
@@ -179,6 +267,15 @@ func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Ti
 		ReservationID: 1,
 		RestrictionID: 1,
 	})
+
+	// add a hold of the nights of 10 and 11 February 2040
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:            3,
+		StartDate:     time.Date(2040, 2, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2040, 2, 12, 0, 0, 0, 0, time.UTC),
+		RoomID:        1,
+		RestrictionID: models.RestrictionHold,
+	})
 	return restrictions, nil
 }
 
@@ -191,3 +288,692 @@ func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 func (m *testDBRepo) DeleteBlockByID(id int) error {
 	return nil
 }
+
+// HoldRoom holds a room for a guest; like the availability search, stays starting after 2049-12-31 are taken
+// and a start of 2060-01-01 fails
+func (m *testDBRepo) HoldRoom(key string, roomID int, start, end, expires time.Time) (int, bool, error) {
+	if start.Equal(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, false, errors.New("some error")
+	}
+	if start.After(time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		return 0, false, nil
+	}
+	return 1, true, nil
+}
+
+// DeleteExpiredHolds releases expired holds
+func (m *testDBRepo) DeleteExpiredHolds() (int64, error) {
+	return 0, nil
+}
+
+// testStayRules are the stay rules of the test repository: in 2045 room 1 takes stays of 2 to 7 nights arriving
+// Monday to Saturday, with no arrivals on 1 June and no departures on 10 June
+var testStayRules = []models.StayRule{
+	{
+		ID:              1,
+		RoomID:          1,
+		StartDate:       time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:         time.Date(2045, 12, 31, 0, 0, 0, 0, time.UTC),
+		MinNights:       2,
+		MaxNights:       7,
+		ArrivalWeekdays: 0b1111110,
+	},
+	{
+		ID:              2,
+		RoomID:          1,
+		StartDate:       time.Date(2045, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:         time.Date(2045, 6, 1, 0, 0, 0, 0, time.UTC),
+		ClosedToArrival: true,
+	},
+	{
+		ID:                3,
+		RoomID:            1,
+		StartDate:         time.Date(2045, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:           time.Date(2045, 6, 10, 0, 0, 0, 0, time.UTC),
+		ClosedToDeparture: true,
+	},
+}
+
+// GetStayRulesByDate returns the test stay rules overlapping start to end; a start of 2046-01-01 fails
+func (m *testDBRepo) GetStayRulesByDate(start, end time.Time) ([]models.StayRule, error) {
+	if start.Equal(time.Date(2046, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return nil, errors.New("some error")
+	}
+	var rules []models.StayRule
+	for _, s := range testStayRules {
+		if !s.StartDate.After(end) && !s.EndDate.Before(start) {
+			rules = append(rules, s)
+		}
+	}
+	return rules, nil
+}
+
+// AllStayRules returns the test stay rules
+func (m *testDBRepo) AllStayRules() ([]models.StayRule, error) {
+	return testStayRules, nil
+}
+
+// GetStayRuleByID returns a test stay rule; other ids fail
+func (m *testDBRepo) GetStayRuleByID(id int) (models.StayRule, error) {
+	for _, s := range testStayRules {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return models.StayRule{}, errors.New("some error")
+}
+
+// InsertStayRule inserts a stay rule; room 1000 fails
+func (m *testDBRepo) InsertStayRule(s models.StayRule) (int, error) {
+	if s.RoomID == 1000 {
+		return 0, errors.New("some error")
+	}
+	return 4, nil
+}
+
+// UpdateStayRule updates a stay rule; room 1000 fails
+func (m *testDBRepo) UpdateStayRule(s models.StayRule) error {
+	if s.RoomID == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteStayRule deletes a stay rule
+func (m *testDBRepo) DeleteStayRule(id int) error {
+	return nil
+}
+
+// testChargeRules are the charge rules of the test repository: in June 2041 a cleaning fee of 25.00 per stay and
+// a tourist tax of 1.50 per guest night
+var testChargeRules = []models.ChargeRule{
+	{
+		ID:          1,
+		Name:        "Cleaning fee",
+		Kind:        models.ChargeFee,
+		Calculation: models.ChargeFlat,
+		Amount:      2500,
+		Per:         models.ChargePerStay,
+		StartDate:   time.Date(2041, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:     time.Date(2041, 6, 30, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		ID:          2,
+		Name:        "Tourist tax",
+		Kind:        models.ChargeTax,
+		Calculation: models.ChargeFlat,
+		Amount:      150,
+		Per:         models.ChargePerGuestNight,
+		StartDate:   time.Date(2041, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:     time.Date(2041, 6, 30, 0, 0, 0, 0, time.UTC),
+	},
+}
+
+// GetChargeRulesByDate returns the test charge rules for nights from start to end; a start of 2047-01-01 fails
+func (m *testDBRepo) GetChargeRulesByDate(start, end time.Time) ([]models.ChargeRule, error) {
+	if start.Equal(time.Date(2047, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return nil, errors.New("some error")
+	}
+	var rules []models.ChargeRule
+	for _, c := range testChargeRules {
+		if c.StartDate.Before(end) && !c.EndDate.Before(start) {
+			rules = append(rules, c)
+		}
+	}
+	return rules, nil
+}
+
+// AllChargeRules returns the test charge rules
+func (m *testDBRepo) AllChargeRules() ([]models.ChargeRule, error) {
+	return testChargeRules, nil
+}
+
+// GetChargeRuleByID returns a test charge rule; other ids fail
+func (m *testDBRepo) GetChargeRuleByID(id int) (models.ChargeRule, error) {
+	for _, c := range testChargeRules {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return models.ChargeRule{}, errors.New("some error")
+}
+
+// InsertChargeRule inserts a charge rule; a rule named "fail" fails
+func (m *testDBRepo) InsertChargeRule(c models.ChargeRule) (int, error) {
+	if c.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// UpdateChargeRule updates a charge rule; a rule named "fail" fails
+func (m *testDBRepo) UpdateChargeRule(c models.ChargeRule) error {
+	if c.Name == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteChargeRule deletes a charge rule
+func (m *testDBRepo) DeleteChargeRule(id int) error {
+	return nil
+}
+
+// testPromoCodeTaken is the test promo code whose last use goes to another booking while the guest books
+const testPromoCodeTaken = 4
+
+// testPromoCodes are the promo codes of the test repository: SUMMER10 takes 10% off any room, ROOM2 takes 20.00 off
+// room 2, EXPIRED ended in 2020 and LASTONE has one use left that another booking takes first
+var testPromoCodes = []models.PromoCode{
+	{ID: 1, Code: "SUMMER10", Description: "Summer campaign", Calculation: models.DiscountPercent, Amount: 1000},
+	{ID: 2, Code: "ROOM2", Calculation: models.DiscountFixed, Amount: 2000, RoomIDs: []int{2}, MaxUses: 100, Uses: 3},
+	{ID: 3, Code: "EXPIRED", Calculation: models.DiscountPercent, Amount: 1000, ValidTo: time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)},
+	{ID: testPromoCodeTaken, Code: "LASTONE", Calculation: models.DiscountFixed, Amount: 1000, MaxUses: 10, Uses: 9},
+}
+
+// GetPromoCodeByCode returns a test promo code; FAIL fails and other codes don't exist
+func (m *testDBRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	if code == "FAIL" {
+		return models.PromoCode{}, errors.New("some error")
+	}
+	for _, p := range testPromoCodes {
+		if p.Code == code {
+			return p, nil
+		}
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+// GetPromoCodeByID returns a test promo code; other ids fail
+func (m *testDBRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	for _, p := range testPromoCodes {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return models.PromoCode{}, errors.New("some error")
+}
+
+// AllPromoCodes returns the test promo codes
+func (m *testDBRepo) AllPromoCodes() ([]models.PromoCode, error) {
+	return testPromoCodes, nil
+}
+
+// InsertPromoCode inserts a promo code; code DBFAIL fails
+func (m *testDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	if p.Code == "DBFAIL" {
+		return 0, errors.New("some error")
+	}
+	return 5, nil
+}
+
+// UpdatePromoCode updates a promo code; code DBFAIL fails
+func (m *testDBRepo) UpdatePromoCode(p models.PromoCode) error {
+	if p.Code == "DBFAIL" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeletePromoCode deletes a promo code
+func (m *testDBRepo) DeletePromoCode(id int) error {
+	return nil
+}
+
+// testGuests are the guests of the test repository: John Smith, a VIP who stayed twice, a duplicate of him booked
+// with another email, and a guest staff don't rent to
+var testGuests = []models.Guest{
+	{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-555-5555", VIP: true,
+		Notes: "Prefers a quiet room", Reservations: 2},
+	{ID: 2, FirstName: "John", LastName: "Smith", Email: "js@example.com", Reservations: 1},
+	{ID: 3, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", DoNotRent: true},
+}
+
+// GetGuestByID returns a test guest; other ids don't exist
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	for _, g := range testGuests {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return models.Guest{}, sql.ErrNoRows
+}
+
+// testGuestEmails are the emails of the duplicates merged into the test guests: one of John Smith booked with
+// johnny@smith.com
+var testGuestEmails = map[string]int{"johnny@smith.com": 1}
+
+// GetGuestByEmail returns a test guest, also by the email of a duplicate merged into them; fail@here.com fails and
+// other emails don't exist
+func (m *testDBRepo) GetGuestByEmail(email string) (models.Guest, error) {
+	if email == "fail@here.com" {
+		return models.Guest{}, errors.New("some error")
+	}
+	if id, ok := testGuestEmails[strings.ToLower(email)]; ok {
+		return m.GetGuestByID(id)
+	}
+	for _, g := range testGuests {
+		if strings.EqualFold(g.Email, email) {
+			return g, nil
+		}
+	}
+	return models.Guest{}, sql.ErrNoRows
+}
+
+// SearchGuests returns the test guests whose name or email contains query; "fail" fails
+func (m *testDBRepo) SearchGuests(query string) ([]models.Guest, error) {
+	if query == "fail" {
+		return nil, errors.New("some error")
+	}
+	var guests []models.Guest
+	for _, g := range testGuests {
+		name := strings.ToLower(g.FirstName + " " + g.LastName + " " + g.Email)
+		if strings.Contains(name, strings.ToLower(query)) {
+			guests = append(guests, g)
+		}
+	}
+	return guests, nil
+}
+
+// FindDuplicateGuests returns the other test guests with the name of g
+func (m *testDBRepo) FindDuplicateGuests(g models.Guest) ([]models.Guest, error) {
+	var guests []models.Guest
+	for _, d := range testGuests {
+		if d.ID != g.ID && d.FirstName == g.FirstName && d.LastName == g.LastName {
+			guests = append(guests, d)
+		}
+	}
+	return guests, nil
+}
+
+// GetReservationsForGuest returns the reservations of a test guest; guest 1 has an upcoming and a past stay
+func (m *testDBRepo) GetReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	if guestID != 1 {
+		return nil, nil
+	}
+	return []models.Reservation{
+		{ID: 2, GuestID: 1, StartDate: time.Now().AddDate(0, 1, 0), EndDate: time.Now().AddDate(0, 1, 1)},
+		{ID: 5, GuestID: 1, StartDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC), Room: models.Room{RoomName: "General's Quarters"}},
+	}, nil
+}
+
+// UpdateGuest updates a guest; a first name of "fail" fails
+func (m *testDBRepo) UpdateGuest(g models.Guest) error {
+	if g.FirstName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// MergeGuests merges two test guests; guest 1000 fails
+func (m *testDBRepo) MergeGuests(keepID, mergeID int) error {
+	if mergeID == 1000 {
+		return errors.New("some error")
+	}
+	if _, err := m.GetGuestByID(mergeID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertGuestLoginToken stores a test login token; guest 2 fails
+func (m *testDBRepo) InsertGuestLoginToken(guestID int, hash string, expires time.Time) error {
+	if guestID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testLoginKey is the key of the unused test login link of guest 1
+const testLoginKey = "test-login-key"
+
+// UseGuestLoginToken returns guest 1 for the hash of testLoginKey, fails for the hash of "fail-login-key" and finds
+// no other token
+func (m *testDBRepo) UseGuestLoginToken(hash string) (int, error) {
+	valid := sha256.Sum256([]byte(testLoginKey))
+	failing := sha256.Sum256([]byte("fail-login-key"))
+	switch hash {
+	case hex.EncodeToString(valid[:]):
+		return 1, nil
+	case hex.EncodeToString(failing[:]):
+		return 0, errors.New("some error")
+	}
+	return 0, sql.ErrNoRows
+}
+
+// testClaimKey is the claim key of the notified test waitlist entry; testClaimedKey finds the entry but is claimed
+// by someone else first
+const (
+	testClaimKey   = "test-claim-key"
+	testClaimedKey = "test-claimed-key"
+)
+
+// testWaitlist is the waitlist of the test repository: in January 2041 a party of two wants any room and a party of
+// three wants room 1, which sleeps two
+var testWaitlist = []models.WaitlistEntry{
+	{
+		ID:        1,
+		StartDate: time.Date(2041, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2041, 1, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+	},
+	{
+		ID:        2,
+		RoomID:    1,
+		StartDate: time.Date(2041, 1, 2, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2041, 1, 4, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+		Children:  1,
+		FirstName: "Jane",
+		LastName:  "Smith",
+		Email:     "jane@smith.com",
+	},
+}
+
+// InsertWaitlistEntry puts a guest on the waitlist; room 1000 fails
+func (m *testDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	if e.RoomID == 1000 {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// GetWaitlistEntriesForRoomByDate returns the test waitlist entries overlapping start to end
+func (m *testDBRepo) GetWaitlistEntriesForRoomByDate(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	for _, e := range testWaitlist {
+		if (e.RoomID == 0 || e.RoomID == roomID) && e.StartDate.Before(end) && e.EndDate.After(start) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// GetWaitlistEntryByClaimKey returns the first test waitlist entry for the hash of testClaimKey or testClaimedKey
+func (m *testDBRepo) GetWaitlistEntryByClaimKey(hash string) (models.WaitlistEntry, error) {
+	if hash != testHashKey(testClaimKey) && hash != testHashKey(testClaimedKey) {
+		return models.WaitlistEntry{}, errors.New("some error")
+	}
+	return testWaitlist[0], nil
+}
+
+// testHashKey hashes a claim key like the waitlist package does
+func testHashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// UpdateWaitlistNotified records that a waitlist entry was sent a claim link
+func (m *testDBRepo) UpdateWaitlistNotified(id, roomID int, claimKeyHash string) error {
+	return nil
+}
+
+// ClaimWaitlistEntry takes the first test waitlist entry off the waitlist for the hash of testClaimKey
+func (m *testDBRepo) ClaimWaitlistEntry(claimKeyHash string) (models.WaitlistEntry, error) {
+	if claimKeyHash != testHashKey(testClaimKey) {
+		return models.WaitlistEntry{}, sql.ErrNoRows
+	}
+	return testWaitlist[0], nil
+}
+
+// DeclineWaitlistEntry takes the first test waitlist entry, offered room 1, off the waitlist for the hash of
+// testClaimKey
+func (m *testDBRepo) DeclineWaitlistEntry(claimKeyHash string) (models.WaitlistEntry, error) {
+	if claimKeyHash != testHashKey(testClaimKey) {
+		return models.WaitlistEntry{}, sql.ErrNoRows
+	}
+	e := testWaitlist[0]
+	e.OfferedRoomID = 1
+	return e, nil
+}
+
+// ExpireWaitlistOffers finds no expired offers
+func (m *testDBRepo) ExpireWaitlistOffers(notifiedBefore time.Time) ([]models.WaitlistEntry, error) {
+	return nil, nil
+}
+
+// InsertPayment records a checkout
+func (m *testDBRepo) InsertPayment(p models.Payment) (int, error) {
+	return 1, nil
+}
+
+// GetPaymentByProviderRef returns a pending deposit of 3000 cents for reservation 1; ref "fake_cs_unknown" isn't
+// found and "fake_cs_fails" fails
+func (m *testDBRepo) GetPaymentByProviderRef(provider, ref string) (models.Payment, error) {
+	switch ref {
+	case "fake_cs_unknown":
+		return models.Payment{}, sql.ErrNoRows
+	case "fake_cs_fails":
+		return models.Payment{}, errors.New("some error")
+	}
+	return models.Payment{
+		ID:            1,
+		ReservationID: 1,
+		Provider:      provider,
+		ProviderRef:   ref,
+		Kind:          models.PaymentKindDeposit,
+		Amount:        3000,
+		Status:        models.PaymentPending,
+	}, nil
+}
+
+// GetPaymentsForReservation returns the payments of a reservation; reservation 2 paid a deposit of 3000 cents
+func (m *testDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	if reservationID != 2 {
+		return nil, nil
+	}
+	return []models.Payment{{
+		ID:            1,
+		ReservationID: 2,
+		Provider:      "fake",
+		ProviderRef:   "fake_cs_1",
+		Kind:          models.PaymentKindDeposit,
+		Amount:        3000,
+		Status:        models.PaymentSucceeded,
+	}}, nil
+}
+
+// ApplyPaymentEvent applies a payment event; event "evt_duplicate" was seen before
+func (m *testDBRepo) ApplyPaymentEvent(provider, eventID string, paymentID int, status, reservationStatus string) (bool, error) {
+	return eventID != "evt_duplicate", nil
+}
+
+// UpdateRoomCancellationPolicy sets the cancellation policy of a room; room 1000 fails
+func (m *testDBRepo) UpdateRoomCancellationPolicy(roomID int, policy string) error {
+	if roomID == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GetReservationByCancelKey returns reservation 2 for the hash of "test-cancel-key"
+func (m *testDBRepo) GetReservationByCancelKey(hash string) (models.Reservation, error) {
+	// sha256 of "test-cancel-key"
+	if hash != "8f691ead93447fcde84b0332b15168527eee75077f2beb80f8eb131351b7973c" {
+		return models.Reservation{}, errors.New("no rows")
+	}
+	return m.GetReservationByID(2)
+}
+
+// CancelReservation cancels a reservation; reservation 3 was cancelled before
+func (m *testDBRepo) CancelReservation(id, refund int) error {
+	if id == 3 {
+		return repository.ErrAlreadyCancelled
+	}
+	return nil
+}
+
+// GetInvoiceForReservation returns the invoice of reservation 2; others have none
+func (m *testDBRepo) GetInvoiceForReservation(reservationID int) (models.Invoice, error) {
+	if reservationID != 2 {
+		return models.Invoice{}, sql.ErrNoRows
+	}
+	return models.Invoice{ID: 1, Number: 1, ReservationID: 2, Total: 10000, PDF: []byte("%PDF-1.4\n")}, nil
+}
+
+// IssueInvoice issues invoice number 2; reservation 1000 fails
+func (m *testDBRepo) IssueInvoice(reservationID, total int, render func(number int) []byte) (models.Invoice, error) {
+	if reservationID == 1000 {
+		return models.Invoice{}, errors.New("some error")
+	}
+	return models.Invoice{ID: 2, Number: 2, ReservationID: reservationID, Total: total, PDF: render(2)}, nil
+}
+
+// GetReservationsByEmail returns the reservations of the test guest found by email, so those of guest 1 for
+// john@smith.com and johnny@smith.com; fail@here.com fails
+func (m *testDBRepo) GetReservationsByEmail(email string) ([]models.Reservation, error) {
+	if email == "fail@here.com" {
+		return nil, errors.New("some error")
+	}
+	g, err := m.GetGuestByEmail(email)
+	if err != nil {
+		return nil, nil
+	}
+	return m.GetReservationsForGuest(g.ID)
+}
+
+// GetWaitlistEntriesByEmail returns the test waitlist entries with the email; fail@here.com fails
+func (m *testDBRepo) GetWaitlistEntriesByEmail(email string) ([]models.WaitlistEntry, error) {
+	if email == "fail@here.com" {
+		return nil, errors.New("some error")
+	}
+	var entries []models.WaitlistEntry
+	for _, e := range testWaitlist {
+		if strings.EqualFold(e.Email, email) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// ErasePersonalData counts the test records held for the email; fail@here.com fails
+func (m *testDBRepo) ErasePersonalData(email string, entry models.PrivacyLogEntry) (int, error) {
+	if email == "fail@here.com" {
+		return 0, errors.New("some error")
+	}
+	reservations, _ := m.GetReservationsByEmail(email)
+	entries, _ := m.GetWaitlistEntriesByEmail(email)
+	records := len(reservations) + len(entries)
+	if _, err := m.GetGuestByEmail(email); err == nil {
+		records++
+	}
+	return records, nil
+}
+
+// AnonymiseReservationsBefore finds nothing to anonymise
+func (m *testDBRepo) AnonymiseReservationsBefore(cutoff time.Time, entry models.PrivacyLogEntry) (int, error) {
+	return 0, nil
+}
+
+// InsertPrivacyLog records a privacy log entry
+func (m *testDBRepo) InsertPrivacyLog(entry models.PrivacyLogEntry) error {
+	return nil
+}
+
+// GetPrivacyLog returns an export by user 1
+func (m *testDBRepo) GetPrivacyLog() ([]models.PrivacyLogEntry, error) {
+	return []models.PrivacyLogEntry{{
+		ID:        1,
+		Action:    models.PrivacyExport,
+		Subject:   "7b5f2ad9a09e2d8a3c1c57f8b4ad0ba5f8a1d0d4c0e8b0c0f5e2c2ab6e8b3b1d",
+		UserID:    1,
+		User:      models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+		Records:   4,
+		CreatedAt: time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC),
+	}}, nil
+}
+
+// AllExchangeRates returns the test rates of EUR and USD
+func (m *testDBRepo) AllExchangeRates() ([]models.ExchangeRate, error) {
+	return []models.ExchangeRate{
+		{Currency: "EUR", Rate: 201100, UpdatedAt: time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)},
+		{Currency: "USD", Rate: 220000, UpdatedAt: time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)},
+	}, nil
+}
+
+// UpsertExchangeRates stores exchange rates; the XTS test currency fails
+func (m *testDBRepo) UpsertExchangeRates(rates []models.ExchangeRate) error {
+	for _, e := range rates {
+		if e.Currency == "XTS" {
+			return errors.New("some error")
+		}
+	}
+	return nil
+}
+
+// DeleteExchangeRate deletes an exchange rate; the XTS test currency fails
+func (m *testDBRepo) DeleteExchangeRate(currency string) error {
+	if currency == "XTS" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testProperties are the properties of the test repository: the default Fort Smythe and Lakeside, reached by its path
+// prefix
+var testProperties = []models.Property{
+	{ID: 1, Slug: "fort-smythe", Name: "Fort Smythe Bed and Breakfast", Address: "Iuliu Maniu 105\nBucuresti",
+		Phone: "(+40)787774397", Email: "fort@here.com"},
+	{ID: 2, Slug: "lakeside", Name: "Lakeside Lodge", Email: "lakeside@here.com"},
+}
+
+// AllProperties returns the test properties
+func (m *testDBRepo) AllProperties() ([]models.Property, error) {
+	return testProperties, nil
+}
+
+// GetPropertiesForUser returns the properties of a staff member; user 1 works on both, user 2 on none, user 3 fails,
+// user 4 works on the second and user 5 on the first
+func (m *testDBRepo) GetPropertiesForUser(userID int) ([]models.Property, error) {
+	switch userID {
+	case 1:
+		return testProperties, nil
+	case 3:
+		return nil, errors.New("some error")
+	case 4:
+		return testProperties[1:], nil
+	case 5:
+		return testProperties[:1], nil
+	}
+	return nil, nil
+}
+
+// InsertProperty inserts a property; the slug "fails" fails
+func (m *testDBRepo) InsertProperty(p models.Property) (int, error) {
+	if p.Slug == "fails" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// UpdateProperty updates a property; property 1000 does not exist
+func (m *testDBRepo) UpdateProperty(p models.Property) error {
+	if p.ID == 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (m *testDBRepo) GrantPropertyAccess(propertyID, userID int) error {
+	return nil
+}
+
+func (m *testDBRepo) RevokePropertyAccess(propertyID, userID int) error {
+	return nil
+}
+
+// GetPropertyIDForPayment returns property 1 for every checkout session; ref "fake_cs_unknown" isn't found and
+// "fake_cs_fails" fails
+func (m *testDBRepo) GetPropertyIDForPayment(provider, ref string) (int, error) {
+	switch ref {
+	case "fake_cs_unknown":
+		return 0, sql.ErrNoRows
+	case "fake_cs_fails":
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
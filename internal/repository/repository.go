@@ -1,20 +1,44 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/flaviusp23/bookings/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a room of a booking has been taken
+var ErrRoomUnavailable = errors.New("room is not available")
+
+// ErrInvalidStay is returned when a stay of a booking doesn't end after it starts
+var ErrInvalidStay = errors.New("stay must be at least one night")
+
+// ErrPromoCodeUsedUp is returned when the promo code of a booking reached its usage limit
+var ErrPromoCodeUsedUp = errors.New("promo code is used up")
+
+// ErrAlreadyCancelled is returned when cancelling a reservation that was cancelled before
+var ErrAlreadyCancelled = errors.New("reservation is already cancelled")
+
+// DatabaseRepo is the storage of the site. Rooms, reservations, pricing, guests, waitlists, invoices and the privacy
+// log belong to a property, and their methods only see the property the repository is scoped to with ForProperty;
+// asking for a record of another property finds nothing, as if it did not exist. Users, sessions, properties and
+// exchange rates are shared by all properties. The availability searches take the hold key of the guest searching, so
+// the rooms they hold themselves still show as free to them.
 type DatabaseRepo interface {
+	ForProperty(propertyID int) DatabaseRepo
 	AllUsers() bool
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(res models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	InsertBooking(res models.Reservation) (int, error)
+	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int, holdKey string) (bool, error)
+	SearchAvailabilityForAllRooms(start, end time.Time, guests int, holdKey string) ([]models.Room, error)
+	SearchFlexibleAvailability(from, to time.Time, nights, guests int, holdKey string) ([]models.StayOption, error)
 	GetRoomByID(id int) (models.Room, error)
 	GetUserByID(id int) (models.User, error)
 	UpdateUser(u models.User) error
+	InsertUser(u models.User) (int, error)
+	GetUserByEmail(email string) (models.User, error)
+	UpdateUserPassword(id int, hashedPassword string) error
 	Authenticate(email, testPassword string) (int, string, error)
 	AllReservations() ([]models.Reservation, error)
 	AllNewReservations() ([]models.Reservation, error)
@@ -26,4 +50,65 @@ type DatabaseRepo interface {
 	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
 	InsertBlockForRoom(id int, startDate time.Time) error
 	DeleteBlockByID(id int) error
+	HoldRoom(key string, roomID int, start, end, expires time.Time) (int, bool, error)
+	DeleteExpiredHolds() (int64, error)
+	GetStayRulesByDate(start, end time.Time) ([]models.StayRule, error)
+	AllStayRules() ([]models.StayRule, error)
+	GetStayRuleByID(id int) (models.StayRule, error)
+	InsertStayRule(s models.StayRule) (int, error)
+	UpdateStayRule(s models.StayRule) error
+	DeleteStayRule(id int) error
+	GetChargeRulesByDate(start, end time.Time) ([]models.ChargeRule, error)
+	AllChargeRules() ([]models.ChargeRule, error)
+	GetChargeRuleByID(id int) (models.ChargeRule, error)
+	InsertChargeRule(c models.ChargeRule) (int, error)
+	UpdateChargeRule(c models.ChargeRule) error
+	DeleteChargeRule(id int) error
+	GetPromoCodeByCode(code string) (models.PromoCode, error)
+	GetPromoCodeByID(id int) (models.PromoCode, error)
+	AllPromoCodes() ([]models.PromoCode, error)
+	InsertPromoCode(p models.PromoCode) (int, error)
+	UpdatePromoCode(p models.PromoCode) error
+	DeletePromoCode(id int) error
+	GetGuestByID(id int) (models.Guest, error)
+	GetGuestByEmail(email string) (models.Guest, error)
+	SearchGuests(query string) ([]models.Guest, error)
+	FindDuplicateGuests(g models.Guest) ([]models.Guest, error)
+	GetReservationsForGuest(guestID int) ([]models.Reservation, error)
+	GetReservationsByEmail(email string) ([]models.Reservation, error)
+	UpdateGuest(g models.Guest) error
+	MergeGuests(keepID, mergeID int) error
+	InsertGuestLoginToken(guestID int, hash string, expires time.Time) error
+	UseGuestLoginToken(hash string) (int, error)
+	InsertWaitlistEntry(e models.WaitlistEntry) (int, error)
+	GetWaitlistEntriesForRoomByDate(roomID int, start, end time.Time) ([]models.WaitlistEntry, error)
+	GetWaitlistEntryByClaimKey(hash string) (models.WaitlistEntry, error)
+	UpdateWaitlistNotified(id, roomID int, claimKeyHash string) error
+	ClaimWaitlistEntry(claimKeyHash string) (models.WaitlistEntry, error)
+	DeclineWaitlistEntry(claimKeyHash string) (models.WaitlistEntry, error)
+	ExpireWaitlistOffers(notifiedBefore time.Time) ([]models.WaitlistEntry, error)
+	GetWaitlistEntriesByEmail(email string) ([]models.WaitlistEntry, error)
+	InsertPayment(p models.Payment) (int, error)
+	GetPaymentByProviderRef(provider, ref string) (models.Payment, error)
+	GetPaymentsForReservation(reservationID int) ([]models.Payment, error)
+	ApplyPaymentEvent(provider, eventID string, paymentID int, status, reservationStatus string) (bool, error)
+	UpdateRoomCancellationPolicy(roomID int, policy string) error
+	GetReservationByCancelKey(hash string) (models.Reservation, error)
+	CancelReservation(id, refund int) error
+	GetInvoiceForReservation(reservationID int) (models.Invoice, error)
+	IssueInvoice(reservationID, total int, render func(number int) []byte) (models.Invoice, error)
+	ErasePersonalData(email string, entry models.PrivacyLogEntry) (int, error)
+	AnonymiseReservationsBefore(cutoff time.Time, entry models.PrivacyLogEntry) (int, error)
+	InsertPrivacyLog(entry models.PrivacyLogEntry) error
+	GetPrivacyLog() ([]models.PrivacyLogEntry, error)
+	AllExchangeRates() ([]models.ExchangeRate, error)
+	UpsertExchangeRates(rates []models.ExchangeRate) error
+	DeleteExchangeRate(currency string) error
+	AllProperties() ([]models.Property, error)
+	GetPropertiesForUser(userID int) ([]models.Property, error)
+	InsertProperty(p models.Property) (int, error)
+	UpdateProperty(p models.Property) error
+	GrantPropertyAccess(propertyID, userID int) error
+	RevokePropertyAccess(propertyID, userID int) error
+	GetPropertyIDForPayment(provider, ref string) (int, error)
 }
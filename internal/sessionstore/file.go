@@ -0,0 +1,177 @@
+package sessionstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore keeps every session in its own file in a directory
+type FileStore struct {
+	dir         string
+	mu          sync.RWMutex
+	stopCleanup chan bool
+}
+
+// fileSession is what is written to a session file
+type fileSession struct {
+	Token  string
+	Data   []byte
+	Expiry time.Time
+}
+
+// NewFileStore returns a store writing to dir, which is created if needed. Expired sessions are
+// deleted every cleanupInterval; an interval of 0 disables the cleanup.
+func NewFileStore(dir string, cleanupInterval time.Duration) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{dir: dir}
+	if cleanupInterval > 0 {
+		f.stopCleanup = make(chan bool)
+		go runCleanup(cleanupInterval, f.stopCleanup, f.deleteExpired)
+	}
+	return f, nil
+}
+
+// Find returns the data for an unexpired session token
+func (f *FileStore) Find(token string) ([]byte, bool, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	s, err := f.read(f.path(token))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if s.Token != token || time.Now().After(s.Expiry) {
+		return nil, false, nil
+	}
+	return s.Data, true, nil
+}
+
+// Commit adds or replaces a session
+func (f *FileStore) Commit(token string, b []byte, expiry time.Time) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(fileSession{Token: token, Data: b, Expiry: expiry})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// write to a temporary file first so a crash never leaves a half written session
+	tmp, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(token))
+}
+
+// Delete removes a session
+func (f *FileStore) Delete(token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(f.path(token))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// All returns the data of every unexpired session by token
+func (f *FileStore) All() (map[string][]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	sessions := make(map[string][]byte)
+	err := f.each(func(path string, s fileSession) error {
+		if time.Now().Before(s.Expiry) {
+			sessions[s.Token] = s.Data
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+// StopCleanup stops the background cleanup of expired sessions
+func (f *FileStore) StopCleanup() {
+	if f.stopCleanup != nil {
+		f.stopCleanup <- true
+	}
+}
+
+func (f *FileStore) deleteExpired() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.each(func(path string, s fileSession) error {
+		if time.Now().After(s.Expiry) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// each calls fn for every session file in the directory
+func (f *FileStore) each(fn func(path string, s fileSession) error) error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+		s, err := f.read(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = fn(path, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStore) read(path string) (fileSession, error) {
+	var s fileSession
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&s)
+	return s, err
+}
+
+// path hashes the token so it can never escape the directory
+func (f *FileStore) path(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:]))
+}
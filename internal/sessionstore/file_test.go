@@ -0,0 +1,64 @@
+package sessionstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Commit("token", []byte("data"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := store.Find("token")
+	if err != nil || !found || !bytes.Equal(b, []byte("data")) {
+		t.Errorf("expected to find committed session but got %q, %v, %v", b, found, err)
+	}
+
+	_, found, _ = store.Find("missing")
+	if found {
+		t.Error("found a session that was never committed")
+	}
+
+	err = store.Commit("expired", []byte("old"), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, _ = store.Find("expired")
+	if found {
+		t.Error("found an expired session")
+	}
+
+	all, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || !bytes.Equal(all["token"], []byte("data")) {
+		t.Errorf("expected only the active session from All but got %v", all)
+	}
+
+	err = store.deleteExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Delete("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Delete("token")
+	if err != nil {
+		t.Error("deleting a missing session should not fail")
+	}
+
+	all, _ = store.All()
+	if len(all) != 0 {
+		t.Errorf("expected no sessions after delete but got %d", len(all))
+	}
+}
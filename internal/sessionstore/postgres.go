@@ -0,0 +1,106 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore keeps sessions in the sessions table, so they survive restarts and deploys
+type PostgresStore struct {
+	db          *sql.DB
+	stopCleanup chan bool
+}
+
+// NewPostgresStore returns a store using db. Expired sessions are deleted every cleanupInterval;
+// an interval of 0 disables the cleanup.
+func NewPostgresStore(db *sql.DB, cleanupInterval time.Duration) *PostgresStore {
+	p := &PostgresStore{db: db}
+	if cleanupInterval > 0 {
+		p.stopCleanup = make(chan bool)
+		go runCleanup(cleanupInterval, p.stopCleanup, p.deleteExpired)
+	}
+	return p
+}
+
+// Find returns the data for an unexpired session token
+func (p *PostgresStore) Find(token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b []byte
+	row := p.db.QueryRowContext(ctx, `select data from sessions where token = $1 and current_timestamp < expiry`, token)
+	err := row.Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit adds or replaces a session
+func (p *PostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+			 on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+
+	_, err := p.db.ExecContext(ctx, stmt, token, b, expiry)
+	return err
+}
+
+// Delete removes a session
+func (p *PostgresStore) Delete(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `delete from sessions where token = $1`, token)
+	return err
+}
+
+// All returns the data of every unexpired session by token
+func (p *PostgresStore) All() (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.db.QueryContext(ctx, `select token, data from sessions where current_timestamp < expiry`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make(map[string][]byte)
+	for rows.Next() {
+		var token string
+		var data []byte
+		err := rows.Scan(&token, &data)
+		if err != nil {
+			return nil, err
+		}
+		sessions[token] = data
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// StopCleanup stops the background cleanup of expired sessions
+func (p *PostgresStore) StopCleanup() {
+	if p.stopCleanup != nil {
+		p.stopCleanup <- true
+	}
+}
+
+func (p *PostgresStore) deleteExpired() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `delete from sessions where expiry < current_timestamp`)
+	return err
+}
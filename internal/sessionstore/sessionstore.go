@@ -0,0 +1,25 @@
+// Package sessionstore has the scs session stores that persist sessions across restarts.
+package sessionstore
+
+import (
+	"log/slog"
+	"time"
+)
+
+// runCleanup calls deleteExpired every interval until stop receives a value
+func runCleanup(interval time.Duration, stop chan bool, deleteExpired func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := deleteExpired()
+			if err != nil {
+				slog.Error("can't delete expired sessions", "error", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
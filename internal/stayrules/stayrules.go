@@ -0,0 +1,127 @@
+// Package stayrules enforces the revenue management rules of rooms: minimum and maximum nights, closed to arrival,
+// closed to departure and allowed arrival weekdays.
+package stayrules
+
+import (
+	"strings"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/i18n"
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+// Violation explains which rule a stay breaks, in words meant for the guest. Reason is in English; format and
+// args are kept so it can be translated.
+type Violation struct {
+	Reason string
+	format string
+	args   []any
+}
+
+// violation returns a *Violation of format and args, which may hold dates and weekdays for i18n to write
+func violation(format string, args ...any) *Violation {
+	return &Violation{Reason: i18n.Default.T(format, args...), format: format, args: args}
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// Translation returns the English format and arguments of the reason, see i18n.Translatable
+func (v *Violation) Translation() (string, []any) {
+	if v.format == "" {
+		return v.Reason, nil
+	}
+	return v.format, v.args
+}
+
+// Check returns a *Violation when a stay in roomID from arrival to departure breaks one of rules, or nil.
+// Rules of other rooms are ignored.
+func Check(rules []models.StayRule, roomID int, arrival, departure time.Time) error {
+	nights := int(departure.Sub(arrival).Hours() / 24)
+
+	for _, r := range rules {
+		if r.RoomID != roomID {
+			continue
+		}
+
+		if covers(r, arrival) {
+			if r.ClosedToArrival {
+				return violation("Arrivals are not possible on %s", arrival)
+			}
+			if !WeekdayAllowed(r, arrival.Weekday()) {
+				return violation("Arrivals from %s to %s are only possible on %s", r.StartDate, r.EndDate,
+					arrivalWeekdays(r))
+			}
+			if r.MinNights > 0 && nights < r.MinNights {
+				return violation("Stays arriving on %s must be at least %d nights", arrival, r.MinNights)
+			}
+			if r.MaxNights > 0 && nights > r.MaxNights {
+				return violation("Stays arriving on %s can be at most %d nights", arrival, r.MaxNights)
+			}
+		}
+
+		if covers(r, departure) && r.ClosedToDeparture {
+			return violation("Departures are not possible on %s", departure)
+		}
+	}
+
+	return nil
+}
+
+// ArrivalAllowed reports whether guests may arrive in roomID on day
+func ArrivalAllowed(rules []models.StayRule, roomID int, day time.Time) bool {
+	for _, r := range rules {
+		if r.RoomID == roomID && covers(r, day) && (r.ClosedToArrival || !WeekdayAllowed(r, day.Weekday())) {
+			return false
+		}
+	}
+	return true
+}
+
+// DepartureAllowed reports whether guests may leave roomID on day
+func DepartureAllowed(rules []models.StayRule, roomID int, day time.Time) bool {
+	for _, r := range rules {
+		if r.RoomID == roomID && covers(r, day) && r.ClosedToDeparture {
+			return false
+		}
+	}
+	return true
+}
+
+// WeekdayAllowed reports whether r allows arrivals on day
+func WeekdayAllowed(r models.StayRule, day time.Weekday) bool {
+	return r.ArrivalWeekdays == 0 || r.ArrivalWeekdays&(1<<day) != 0
+}
+
+// covers reports whether day falls within the dates of r, both inclusive
+func covers(r models.StayRule, day time.Time) bool {
+	d := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	start := time.Date(r.StartDate.Year(), r.StartDate.Month(), r.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(r.EndDate.Year(), r.EndDate.Month(), r.EndDate.Day(), 0, 0, 0, 0, time.UTC)
+	return !d.Before(start) && !d.After(end)
+}
+
+// ArrivalDays lists the weekdays r allows arrivals on, e.g. "Friday, Saturday"
+func ArrivalDays(r models.StayRule) string {
+	if r.ArrivalWeekdays == 0 {
+		return "Any day"
+	}
+
+	var names []string
+	for _, d := range arrivalWeekdays(r) {
+		names = append(names, d.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// arrivalWeekdays returns the weekdays r allows arrivals on
+func arrivalWeekdays(r models.StayRule) []time.Weekday {
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if WeekdayAllowed(r, d) {
+			days = append(days, d)
+		}
+	}
+	return days
+}
@@ -0,0 +1,85 @@
+package stayrules
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var rules = []models.StayRule{
+	// March 2045: 2 to 7 nights, arriving Friday or Saturday
+	{RoomID: 1, StartDate: date("2045-03-01"), EndDate: date("2045-03-31"), MinNights: 2, MaxNights: 7,
+		ArrivalWeekdays: 1<<time.Friday | 1<<time.Saturday},
+	{RoomID: 1, StartDate: date("2045-03-17"), EndDate: date("2045-03-17"), ClosedToArrival: true},
+	{RoomID: 1, StartDate: date("2045-03-20"), EndDate: date("2045-03-20"), ClosedToDeparture: true},
+	{RoomID: 2, StartDate: date("2045-03-01"), EndDate: date("2045-03-31"), MinNights: 5},
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		roomID    int
+		arrival   string
+		departure string
+		reason    string
+	}{
+		{"allowed", 1, "2045-03-03", "2045-03-05", ""},
+		{"too short", 1, "2045-03-03", "2045-03-04", "Stays arriving on Fri 03 Mar 2045 must be at least 2 nights"},
+		{"too long", 1, "2045-03-03", "2045-03-11", "Stays arriving on Fri 03 Mar 2045 can be at most 7 nights"},
+		{"wrong weekday", 1, "2045-03-06", "2045-03-08",
+			"Arrivals from Wed 01 Mar 2045 to Fri 31 Mar 2045 are only possible on Friday, Saturday"},
+		{"closed to arrival", 1, "2045-03-17", "2045-03-19", "Arrivals are not possible on Fri 17 Mar 2045"},
+		{"closed to departure", 1, "2045-03-18", "2045-03-20", "Departures are not possible on Mon 20 Mar 2045"},
+		{"arrival before the rules", 1, "2045-02-27", "2045-03-02", ""},
+		{"other room", 2, "2045-03-06", "2045-03-11", ""},
+		{"other room too short", 2, "2045-03-06", "2045-03-08", "Stays arriving on Mon 06 Mar 2045 must be at least 5 nights"},
+		{"room without rules", 3, "2045-03-17", "2045-03-18", ""},
+	}
+
+	for _, e := range tests {
+		err := Check(rules, e.roomID, date(e.arrival), date(e.departure))
+		if e.reason == "" {
+			if err != nil {
+				t.Errorf("%s: expected no violation but got %q", e.name, err)
+			}
+			continue
+		}
+
+		var v *Violation
+		if !errors.As(err, &v) {
+			t.Errorf("%s: expected a violation but got %v", e.name, err)
+			continue
+		}
+		if v.Reason != e.reason {
+			t.Errorf("%s: expected reason %q but got %q", e.name, e.reason, v.Reason)
+		}
+	}
+}
+
+func TestArrivalAndDepartureAllowed(t *testing.T) {
+	if !ArrivalAllowed(rules, 1, date("2045-03-03")) {
+		t.Error("arrival on a Friday should be allowed")
+	}
+	if ArrivalAllowed(rules, 1, date("2045-03-06")) {
+		t.Error("arrival on a Monday should not be allowed")
+	}
+	if ArrivalAllowed(rules, 1, date("2045-03-17")) {
+		t.Error("arrival on a day closed to arrival should not be allowed")
+	}
+	if !DepartureAllowed(rules, 1, date("2045-03-19")) {
+		t.Error("departure on 19 March should be allowed")
+	}
+	if DepartureAllowed(rules, 1, date("2045-03-20")) {
+		t.Error("departure on a day closed to departure should not be allowed")
+	}
+	if !ArrivalAllowed(rules, 2, date("2045-03-17")) || !DepartureAllowed(rules, 2, date("2045-03-20")) {
+		t.Error("rules of room 1 should not apply to room 2")
+	}
+}
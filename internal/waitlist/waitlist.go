@@ -0,0 +1,153 @@
+// Package waitlist offers rooms that free up to the guests waiting for them.
+package waitlist
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/quote"
+	"github.com/flaviusp23/bookings/internal/repository"
+	"github.com/flaviusp23/bookings/internal/stayrules"
+)
+
+// ClaimTTL is how long the claim link sent to a waiting guest can be used
+const ClaimTTL = 24 * time.Hour
+
+// Notifier emails claim links to the guests waiting at a property
+type Notifier struct {
+	DB      repository.DatabaseRepo // scoped to the property
+	Quotes  *quote.Signer
+	BaseURL string // of the property's public site
+	From    string // the property's email
+	Send    func(models.MailData)
+}
+
+// RoomFreed is called when the nights from start to end in roomID become free. They are offered to one guest at a
+// time: the first, in the order they joined, waiting for roomID or any room for a stay overlapping them who hasn't
+// been offered a room yet, provided the room is now free for their whole stay, sleeps their party and their stay
+// keeps to the stay rules. That guest is emailed a claim link; when they decline it or it expires, the room is
+// offered again to the next guest. Nothing is offered while a claim link for roomID and an overlapping stay is still
+// open. RoomFreed returns the number of guests notified, 0 or 1.
+func (n *Notifier) RoomFreed(roomID int, start, end time.Time) (int, error) {
+	entries, err := n.DB.GetWaitlistEntriesForRoomByDate(roomID, start, end)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		if e.OfferedRoomID == roomID && time.Since(e.NotifiedAt) < ClaimTTL {
+			// the room is already offered to this guest
+			return 0, nil
+		}
+	}
+
+	room, err := n.DB.GetRoomByID(roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		if !e.NotifiedAt.IsZero() {
+			// each guest is offered one room; an open offer is theirs to answer, and one they let expire is declined
+			continue
+		}
+		if e.Adults+e.Children > room.MaxOccupancy {
+			continue
+		}
+		available, err := n.DB.SearchAvailabilityByDatesByRoomID(e.StartDate, e.EndDate, roomID, "")
+		if err != nil {
+			return 0, err
+		}
+		if !available {
+			continue
+		}
+		rules, err := n.DB.GetStayRulesByDate(e.StartDate, e.EndDate)
+		if err != nil {
+			return 0, err
+		}
+		if stayrules.Check(rules, roomID, e.StartDate, e.EndDate) != nil {
+			continue
+		}
+
+		key := newClaimKey()
+		err = n.DB.UpdateWaitlistNotified(e.ID, roomID, HashKey(key))
+		if err != nil {
+			return 0, err
+		}
+		n.Send(n.claimMail(e, room, key))
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// Declined offers the room of a claim link the guest of e declined to the next guest waiting for it. It returns the
+// number of guests notified, 0 or 1.
+func (n *Notifier) Declined(e models.WaitlistEntry) (int, error) {
+	if e.OfferedRoomID == 0 {
+		return 0, nil
+	}
+	return n.RoomFreed(e.OfferedRoomID, e.StartDate, e.EndDate)
+}
+
+// ExpireOffers takes the guests whose claim links expired unused off the waitlist, and offers their rooms to the next
+// guests waiting for them. It returns the number of guests notified.
+func (n *Notifier) ExpireOffers() (int, error) {
+	expired, err := n.DB.ExpireWaitlistOffers(time.Now().Add(-ClaimTTL))
+	if err != nil {
+		return 0, err
+	}
+
+	notified := 0
+	for _, e := range expired {
+		count, err := n.Declined(e)
+		if err != nil {
+			return notified, err
+		}
+		notified += count
+	}
+
+	return notified, nil
+}
+
+// claimMail is the email offering room to the guest of e
+func (n *Notifier) claimMail(e models.WaitlistEntry, room models.Room, key string) models.MailData {
+	q := quote.New(room, e.StartDate, e.EndDate, ClaimTTL)
+	params := url.Values{"k": {key}, "t": {n.Quotes.Sign(q)}}
+	link := fmt.Sprintf("%s/waitlist/claim?%s", n.BaseURL, params.Encode())
+
+	content := fmt.Sprintf(`
+		<strong>A room is free for your dates</strong><br>
+		Dear %s: <br>
+		%s is now available from %s to %s, and we're offering it to you first. <a href="%s">Book it now</a>;
+		the link is valid until %s. If you no longer need it, please decline it from the same link so we can offer
+		it to the next guest waiting.
+`, e.FirstName, room.RoomName, e.StartDate.Format("2006-01-02"), e.EndDate.Format("2006-01-02"), link,
+		q.Expires.Format("2006-01-02 15:04"))
+
+	return models.MailData{
+		To:       e.Email,
+		From:     n.From,
+		Subject:  "A room is free for your dates",
+		Content:  content,
+		Template: "basic.html",
+	}
+}
+
+// HashKey returns the hash of a claim key, which is all the database keeps of it
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newClaimKey returns a random claim key
+func newClaimKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,180 @@
+package waitlist
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flaviusp23/bookings/internal/config"
+	"github.com/flaviusp23/bookings/internal/models"
+	"github.com/flaviusp23/bookings/internal/quote"
+	"github.com/flaviusp23/bookings/internal/repository"
+	"github.com/flaviusp23/bookings/internal/repository/dbrepo"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestRoomFreed(t *testing.T) {
+	var sent []models.MailData
+	n := &Notifier{
+		DB:      dbrepo.NewTestingRepo(&config.AppConfig{}).ForProperty(1),
+		Quotes:  quote.NewSigner([]byte("secret")),
+		BaseURL: "https://example.com",
+		From:    "fort@here.com",
+		Send:    func(msg models.MailData) { sent = append(sent, msg) },
+	}
+
+	// the test repository has two guests waiting in January 2041, the second one too many for room 1
+	notified, err := n.RoomFreed(1, date("2041-01-01"), date("2041-01-05"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 1 || len(sent) != 1 {
+		t.Fatalf("expected 1 guest notified but got %d, with %d emails", notified, len(sent))
+	}
+	if sent[0].To != "john@smith.com" {
+		t.Errorf("expected the email to go to john@smith.com but it went to %s", sent[0].To)
+	}
+	if sent[0].From != "fort@here.com" {
+		t.Errorf("expected the email to come from the property but it came from %s", sent[0].From)
+	}
+
+	start := strings.Index(sent[0].Content, "https://example.com/waitlist/claim?")
+	if start < 0 {
+		t.Fatalf("claim link not found in %q", sent[0].Content)
+	}
+	link, err := url.Parse(strings.SplitN(sent[0].Content[start:], `"`, 2)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Query().Get("k") == "" {
+		t.Error("claim link has no claim key")
+	}
+	q, err := n.Quotes.Verify(link.Query().Get("t"))
+	if err != nil {
+		t.Fatalf("claim link does not carry a valid booking token: %v", err)
+	}
+	if q.RoomID != 1 || !q.StartDate.Equal(date("2041-01-01")) || !q.EndDate.Equal(date("2041-01-03")) {
+		t.Errorf("booking token does not match the waiting guest's stay: %+v", q)
+	}
+	if q.Expires.Before(time.Now().Add(ClaimTTL - time.Minute)) {
+		t.Errorf("claim link expires too early: %s", q.Expires)
+	}
+
+	sent = nil
+	notified, err = n.RoomFreed(1, date("2041-02-01"), date("2041-02-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 0 || len(sent) != 0 {
+		t.Errorf("expected nobody notified for dates nobody waits for but got %d", notified)
+	}
+}
+
+// waitingRepo is the test repository with entries waiting and expired offers, recording the entries notified
+type waitingRepo struct {
+	repository.DatabaseRepo
+	entries  []models.WaitlistEntry
+	expired  []models.WaitlistEntry
+	notified []int
+}
+
+func (m *waitingRepo) GetWaitlistEntriesForRoomByDate(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	return m.entries, nil
+}
+
+func (m *waitingRepo) UpdateWaitlistNotified(id, roomID int, claimKeyHash string) error {
+	m.notified = append(m.notified, id)
+	return nil
+}
+
+func (m *waitingRepo) ExpireWaitlistOffers(notifiedBefore time.Time) ([]models.WaitlistEntry, error) {
+	return m.expired, nil
+}
+
+func TestRoomFreedOneGuestAtATime(t *testing.T) {
+	waiting := func(id int) models.WaitlistEntry {
+		return models.WaitlistEntry{ID: id, StartDate: date("2041-01-01"), EndDate: date("2041-01-03"), Adults: 1}
+	}
+	offered := func(id, roomID int, ago time.Duration) models.WaitlistEntry {
+		e := waiting(id)
+		e.OfferedRoomID = roomID
+		e.ClaimKeyHash = "hash"
+		e.NotifiedAt = time.Now().Add(-ago)
+		return e
+	}
+
+	tests := []struct {
+		name     string
+		entries  []models.WaitlistEntry
+		expected []int
+	}{
+		{"first guest only", []models.WaitlistEntry{waiting(1), waiting(2)}, []int{1}},
+		{"room offered already", []models.WaitlistEntry{offered(1, 1, time.Hour), waiting(2)}, nil},
+		{"first guest offered another room", []models.WaitlistEntry{offered(1, 2, time.Hour), waiting(2)}, []int{2}},
+		{"offer expired", []models.WaitlistEntry{offered(1, 1, ClaimTTL+time.Hour), waiting(2)}, []int{2}},
+	}
+
+	for _, e := range tests {
+		var sent []models.MailData
+		repo := &waitingRepo{DatabaseRepo: dbrepo.NewTestingRepo(&config.AppConfig{}).ForProperty(1), entries: e.entries}
+		n := &Notifier{
+			DB:     repo,
+			Quotes: quote.NewSigner([]byte("secret")),
+			Send:   func(msg models.MailData) { sent = append(sent, msg) },
+		}
+
+		notified, err := n.RoomFreed(1, date("2041-01-01"), date("2041-01-03"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notified != len(e.expected) || len(sent) != len(e.expected) {
+			t.Errorf("%s: expected %d guest(s) notified but got %d, with %d emails", e.name, len(e.expected), notified, len(sent))
+		}
+		if fmt.Sprint(repo.notified) != fmt.Sprint(e.expected) {
+			t.Errorf("%s: expected entries %v notified but got %v", e.name, e.expected, repo.notified)
+		}
+	}
+}
+
+func TestExpireOffers(t *testing.T) {
+	lapsed := models.WaitlistEntry{ID: 1, OfferedRoomID: 1, StartDate: date("2041-01-01"), EndDate: date("2041-01-03")}
+	next := models.WaitlistEntry{ID: 2, StartDate: date("2041-01-01"), EndDate: date("2041-01-03"), Adults: 1}
+
+	var sent []models.MailData
+	repo := &waitingRepo{
+		DatabaseRepo: dbrepo.NewTestingRepo(&config.AppConfig{}).ForProperty(1),
+		entries:      []models.WaitlistEntry{next},
+		expired:      []models.WaitlistEntry{lapsed},
+	}
+	n := &Notifier{
+		DB:     repo,
+		Quotes: quote.NewSigner([]byte("secret")),
+		Send:   func(msg models.MailData) { sent = append(sent, msg) },
+	}
+
+	notified, err := n.ExpireOffers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 1 || len(sent) != 1 || len(repo.notified) != 1 || repo.notified[0] != 2 {
+		t.Errorf("expected the room of the expired offer offered to entry 2 but got %v notified", repo.notified)
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	if HashKey("a") == HashKey("b") {
+		t.Error("different keys hash the same")
+	}
+	if HashKey("a") != HashKey("a") {
+		t.Error("the same key hashes differently")
+	}
+	if newClaimKey() == newClaimKey() {
+		t.Error("claim keys are not random")
+	}
+}
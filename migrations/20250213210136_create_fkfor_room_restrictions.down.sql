@@ -0,0 +1,2 @@
+alter table room_restrictions drop constraint room_restrictions_restrictions_id_fk;
+alter table room_restrictions drop constraint room_restrictions_rooms_id_fk;
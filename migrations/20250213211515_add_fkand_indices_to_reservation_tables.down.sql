@@ -0,0 +1,3 @@
+drop index reservations_last_name_idx;
+drop index reservations_email_idx;
+alter table room_restrictions drop constraint room_restrictions_reservations_id_fk;
@@ -0,0 +1,7 @@
+create table sessions (
+    token text primary key,
+    data bytea not null,
+    expiry timestamptz not null
+);
+
+create index sessions_expiry_idx on sessions (expiry);
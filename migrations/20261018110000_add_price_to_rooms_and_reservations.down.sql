@@ -0,0 +1,2 @@
+alter table reservations drop column price;
+alter table rooms drop column price;
@@ -0,0 +1,5 @@
+alter table rooms add column price integer not null default 0;
+alter table reservations add column price integer not null default 0;
+
+update rooms set price = 10000 where room_name = 'General''s Quarters';
+update rooms set price = 15000 where room_name = 'Major''s Suite';
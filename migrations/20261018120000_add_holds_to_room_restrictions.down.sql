@@ -0,0 +1,4 @@
+delete from room_restrictions where restriction_id = 3;
+alter table room_restrictions drop column expires_at;
+alter table room_restrictions drop column hold_key;
+delete from restrictions where id = 3;
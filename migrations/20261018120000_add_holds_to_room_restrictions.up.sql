@@ -0,0 +1,6 @@
+insert into restrictions (id, restriction_name, created_at, updated_at) values (3, 'Hold', now(), now());
+select setval(pg_get_serial_sequence('restrictions', 'id'), (select max(id) from restrictions));
+
+alter table room_restrictions add column hold_key text;
+alter table room_restrictions add column expires_at timestamptz;
+create index room_restrictions_expires_at_idx on room_restrictions (expires_at) where expires_at is not null;
@@ -0,0 +1,24 @@
+create table room_stays (
+    id serial primary key,
+    reservation_id integer not null,
+    room_id integer not null,
+    start_date date not null,
+    end_date date not null,
+    price integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+alter table room_stays
+    add constraint room_stays_reservations_id_fk foreign key (reservation_id)
+    references reservations (id) on update cascade on delete cascade;
+
+alter table room_stays
+    add constraint room_stays_rooms_id_fk foreign key (room_id)
+    references rooms (id) on update cascade on delete cascade;
+
+create index room_stays_reservation_id_idx on room_stays (reservation_id);
+
+-- every existing reservation booked exactly one room
+insert into room_stays (reservation_id, room_id, start_date, end_date, price, created_at, updated_at)
+select id, room_id, start_date, end_date, price, created_at, updated_at from reservations;
@@ -0,0 +1,19 @@
+create table stay_rules (
+    id serial primary key,
+    room_id integer not null,
+    start_date date not null,
+    end_date date not null,
+    min_nights integer not null default 0,
+    max_nights integer not null default 0,
+    closed_to_arrival boolean not null default false,
+    closed_to_departure boolean not null default false,
+    arrival_weekdays integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+alter table stay_rules
+    add constraint stay_rules_rooms_id_fk foreign key (room_id)
+    references rooms (id) on update cascade on delete cascade;
+
+create index stay_rules_room_id_dates_idx on stay_rules (room_id, start_date, end_date);
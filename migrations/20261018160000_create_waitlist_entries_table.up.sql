@@ -0,0 +1,23 @@
+create table waitlist_entries (
+    id serial primary key,
+    room_id integer,
+    start_date date not null,
+    end_date date not null,
+    adults integer not null default 1,
+    children integer not null default 0,
+    first_name varchar(255) not null,
+    last_name varchar(255) not null,
+    email varchar(255) not null,
+    claim_key_hash text not null default '',
+    notified_at timestamp,
+    claimed_at timestamp,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+alter table waitlist_entries
+    add constraint waitlist_entries_rooms_id_fk foreign key (room_id)
+    references rooms (id) on update cascade on delete cascade;
+
+create index waitlist_entries_dates_idx on waitlist_entries (start_date, end_date);
+create index waitlist_entries_claim_key_hash_idx on waitlist_entries (claim_key_hash);
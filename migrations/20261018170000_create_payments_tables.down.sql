@@ -0,0 +1,5 @@
+drop table payment_events;
+drop table payments;
+alter table reservations drop column balance_amount;
+alter table reservations drop column deposit_amount;
+alter table reservations drop column payment_status;
@@ -0,0 +1,31 @@
+alter table reservations add column payment_status varchar(20) not null default 'unpaid';
+alter table reservations add column deposit_amount integer not null default 0;
+alter table reservations add column balance_amount integer not null default 0;
+
+update reservations set balance_amount = price;
+
+create table payments (
+    id serial primary key,
+    reservation_id integer not null,
+    provider varchar(50) not null,
+    provider_ref varchar(255) not null,
+    kind varchar(20) not null,
+    amount integer not null,
+    status varchar(20) not null default 'pending',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+alter table payments
+    add constraint payments_reservations_id_fk foreign key (reservation_id)
+    references reservations (id) on update cascade on delete cascade;
+
+create unique index payments_provider_ref_idx on payments (provider, provider_ref);
+create index payments_reservation_id_idx on payments (reservation_id);
+
+create table payment_events (
+    provider varchar(50) not null,
+    event_id varchar(255) not null,
+    created_at timestamp not null,
+    primary key (provider, event_id)
+);
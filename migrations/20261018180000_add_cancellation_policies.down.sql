@@ -0,0 +1,8 @@
+drop index reservations_cancel_key_hash_idx;
+
+alter table reservations drop column refund_amount;
+alter table reservations drop column cancelled_at;
+alter table reservations drop column cancel_key_hash;
+alter table reservations drop column cancellation_policy;
+
+alter table rooms drop column cancellation_policy;
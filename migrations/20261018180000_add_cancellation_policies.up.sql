@@ -0,0 +1,8 @@
+alter table rooms add column cancellation_policy varchar(20) not null default 'moderate';
+
+alter table reservations add column cancellation_policy varchar(20) not null default 'moderate';
+alter table reservations add column cancel_key_hash varchar(64);
+alter table reservations add column cancelled_at timestamp;
+alter table reservations add column refund_amount integer not null default 0;
+
+create unique index reservations_cancel_key_hash_idx on reservations (cancel_key_hash);
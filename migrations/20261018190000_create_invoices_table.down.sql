@@ -0,0 +1,2 @@
+drop table invoices;
+drop table invoice_counter;
@@ -0,0 +1,25 @@
+-- a single row holding the last invoice number issued, taken under a row lock so numbers are gapless and never reused
+create table invoice_counter (
+    id boolean primary key default true check (id),
+    last_number integer not null
+);
+
+insert into invoice_counter (id, last_number) values (true, 0);
+
+create table invoices (
+    id serial primary key,
+    number integer not null,
+    reservation_id integer,
+    total integer not null,
+    pdf bytea not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+-- invoices outlive their reservation
+alter table invoices
+    add constraint invoices_reservations_id_fk foreign key (reservation_id)
+    references reservations (id) on update cascade on delete set null;
+
+create unique index invoices_number_idx on invoices (number);
+create unique index invoices_reservation_id_idx on invoices (reservation_id);
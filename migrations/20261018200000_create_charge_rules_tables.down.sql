@@ -0,0 +1,2 @@
+drop table reservation_charges;
+drop table charge_rules;
@@ -0,0 +1,35 @@
+create table charge_rules (
+    id serial primary key,
+    name varchar(255) not null,
+    kind varchar(20) not null,
+    calculation varchar(20) not null,
+    amount integer not null,
+    per varchar(20) not null,
+    start_date date,
+    end_date date,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create table reservation_charges (
+    id serial primary key,
+    reservation_id integer not null,
+    charge_rule_id integer,
+    name varchar(255) not null,
+    kind varchar(20) not null,
+    quantity integer not null,
+    amount integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+alter table reservation_charges
+    add constraint reservation_charges_reservations_id_fk foreign key (reservation_id)
+    references reservations (id) on update cascade on delete cascade;
+
+-- the breakdown of a reservation stays as it was charged when its rule changes or goes
+alter table reservation_charges
+    add constraint reservation_charges_charge_rules_id_fk foreign key (charge_rule_id)
+    references charge_rules (id) on update cascade on delete set null;
+
+create index reservation_charges_reservation_id_idx on reservation_charges (reservation_id);
@@ -0,0 +1,3 @@
+drop table promo_redemptions;
+drop table promo_code_rooms;
+drop table promo_codes;
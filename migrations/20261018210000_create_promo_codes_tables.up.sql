@@ -0,0 +1,53 @@
+create table promo_codes (
+    id serial primary key,
+    code varchar(50) not null,
+    description varchar(255) not null default '',
+    calculation varchar(20) not null,
+    amount integer not null,
+    valid_from date,
+    valid_to date,
+    stay_from date,
+    stay_to date,
+    max_uses integer not null default 0,
+    uses integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index promo_codes_code_idx on promo_codes (code);
+
+-- a code without rooms applies to every room
+create table promo_code_rooms (
+    promo_code_id integer not null,
+    room_id integer not null,
+    primary key (promo_code_id, room_id)
+);
+
+alter table promo_code_rooms
+    add constraint promo_code_rooms_promo_codes_id_fk foreign key (promo_code_id)
+    references promo_codes (id) on update cascade on delete cascade;
+
+alter table promo_code_rooms
+    add constraint promo_code_rooms_rooms_id_fk foreign key (room_id)
+    references rooms (id) on update cascade on delete cascade;
+
+create table promo_redemptions (
+    id serial primary key,
+    promo_code_id integer,
+    reservation_id integer not null,
+    code varchar(50) not null,
+    discount integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index promo_redemptions_reservation_id_idx on promo_redemptions (reservation_id);
+
+alter table promo_redemptions
+    add constraint promo_redemptions_reservations_id_fk foreign key (reservation_id)
+    references reservations (id) on update cascade on delete cascade;
+
+-- the redemption keeps the code as it was used when the code is deleted
+alter table promo_redemptions
+    add constraint promo_redemptions_promo_codes_id_fk foreign key (promo_code_id)
+    references promo_codes (id) on update cascade on delete set null;
@@ -0,0 +1,2 @@
+alter table reservations drop column guest_id;
+drop table guests;
@@ -0,0 +1,32 @@
+create table guests (
+    id serial primary key,
+    first_name varchar(255) not null,
+    last_name varchar(255) not null,
+    email varchar(255) not null,
+    phone varchar(255) not null default '',
+    notes text not null default '',
+    vip boolean not null default false,
+    do_not_rent boolean not null default false,
+    marketing_consent boolean not null default false,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+-- guests are told apart by email, whatever its case
+create unique index guests_email_idx on guests (lower(email));
+
+alter table reservations add column guest_id integer;
+
+alter table reservations
+    add constraint reservations_guests_id_fk foreign key (guest_id)
+    references guests (id) on update cascade on delete set null;
+
+create index reservations_guest_id_idx on reservations (guest_id);
+
+-- existing reservations become the stay history of a guest per email, with the details of their latest reservation
+insert into guests (first_name, last_name, email, phone, created_at, updated_at)
+select distinct on (lower(email)) first_name, last_name, email, phone, now(), now()
+from reservations
+order by lower(email), created_at desc;
+
+update reservations r set guest_id = g.id from guests g where lower(r.email) = lower(g.email);
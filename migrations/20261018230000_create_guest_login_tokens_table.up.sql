@@ -0,0 +1,12 @@
+-- one-time links guests use to log in to their account, kept as hashes
+create table guest_login_tokens (
+    id serial primary key,
+    guest_id integer not null references guests (id) on update cascade on delete cascade,
+    token_hash varchar(64) not null,
+    expires_at timestamp not null,
+    used_at timestamp,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index guest_login_tokens_token_hash_idx on guest_login_tokens (token_hash);
@@ -0,0 +1,2 @@
+alter table reservations drop column anonymised_at;
+drop table privacy_log;
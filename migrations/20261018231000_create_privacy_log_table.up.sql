@@ -0,0 +1,14 @@
+-- every export and erasure of personal data. The email is kept as a hash, so the log doesn't undo an erasure.
+create table privacy_log (
+    id serial primary key,
+    action varchar(20) not null,
+    subject varchar(255) not null,
+    user_id integer references users (id) on update cascade on delete set null,
+    records integer not null,
+    created_at timestamp not null
+);
+
+create index privacy_log_created_at_idx on privacy_log (created_at);
+
+-- reservations whose guest's personal fields were removed, kept for accounting
+alter table reservations add column anonymised_at timestamp;
@@ -0,0 +1,3 @@
+alter table reservations drop column display_rate;
+alter table reservations drop column display_currency;
+drop table exchange_rates;
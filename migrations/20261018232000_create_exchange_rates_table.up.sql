@@ -0,0 +1,11 @@
+-- how much of a currency one unit of the base currency buys, in millionths, for showing prices to guests
+create table exchange_rates (
+    currency char(3) primary key,
+    rate bigint not null check (rate > 0),
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+-- the currency the guest was shown and its rate when booking, so confirmations keep the amounts they saw
+alter table reservations add column display_currency varchar(3) not null default '';
+alter table reservations add column display_rate bigint not null default 0;
@@ -0,0 +1,54 @@
+drop index privacy_log_property_id_created_at_idx;
+alter table privacy_log drop column property_id;
+
+-- invoices share one numbering again: the first property keeps its numbers, and the invoices of the others are
+-- numbered on after the highest number issued, in the order they were issued. Their stored PDFs keep the number
+-- they were issued with.
+drop index invoices_property_id_number_idx;
+update invoices i set number = n.number
+from (
+    select id, (select max(number) from invoices) + row_number() over (order by created_at, id) as number
+    from invoices
+    where property_id <> (select min(id) from properties)
+) n
+where i.id = n.id;
+create unique index invoices_number_idx on invoices (number);
+alter table invoices drop column property_id;
+
+alter table waitlist_entries drop column property_id;
+
+-- guests are one per email again: the reservations of a guest of another property with the email of an earlier guest
+-- move to the earliest one, and the later guests, with their notes, are deleted
+drop index guests_property_id_email_idx;
+update reservations r set guest_id = k.keep_id
+from (select id, min(id) over (partition by lower(email)) as keep_id from guests) k
+where r.guest_id = k.id and k.id <> k.keep_id;
+delete from guests g
+using (select id, min(id) over (partition by lower(email)) as keep_id from guests) k
+where g.id = k.id and k.id <> k.keep_id;
+create unique index guests_email_idx on guests (lower(email));
+alter table guests drop column property_id;
+
+-- promo codes are unique again: a code another property created earlier gets the id of the later one appended
+drop index promo_codes_property_id_code_idx;
+update promo_codes p set code = left(p.code, 39) || '-' || p.id
+where exists (select 1 from promo_codes o where o.code = p.code and o.id < p.id);
+create unique index promo_codes_code_idx on promo_codes (code);
+alter table promo_codes drop column property_id;
+
+alter table charge_rules drop column property_id;
+alter table reservations drop column property_id;
+alter table rooms drop column property_id;
+
+drop table property_users;
+
+create table invoice_counter (
+    id boolean primary key default true check (id),
+    last_number integer not null
+);
+
+insert into invoice_counter (id, last_number)
+select true, greatest((select coalesce(max(number), 0) from invoices), coalesce(max(last_invoice_number), 0))
+from properties;
+
+drop table properties;
@@ -0,0 +1,85 @@
+-- the guesthouses the site serves, reached by their host name or by their slug as a path prefix
+create table properties (
+    id serial primary key,
+    slug varchar(50) not null,
+    name varchar(255) not null,
+    host varchar(255) not null default '',
+    address text not null default '',
+    phone varchar(255) not null default '',
+    email varchar(255) not null,
+    last_invoice_number integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index properties_slug_idx on properties (slug);
+create unique index properties_host_idx on properties (lower(host)) where host <> '';
+
+-- the property served so far, which carries on its invoice numbers
+insert into properties (slug, name, address, phone, email, last_invoice_number, created_at, updated_at)
+select 'fort-smythe', 'Fort Smythe Bed and Breakfast', e'Iuliu Maniu 105\nSector 6, Bucuresti\nRomania',
+    '(+40)787774397', 'flavius.paltin@yahoo.com', last_number, now(), now()
+from invoice_counter;
+
+-- invoices are numbered per property
+drop table invoice_counter;
+
+-- the properties each staff member works on
+create table property_users (
+    property_id integer not null references properties (id) on update cascade on delete cascade,
+    user_id integer not null references users (id) on update cascade on delete cascade,
+    created_at timestamp not null,
+    primary key (property_id, user_id)
+);
+
+create index property_users_user_id_idx on property_users (user_id);
+
+insert into property_users (property_id, user_id, created_at)
+select p.id, u.id, now() from properties p cross join users u;
+
+-- everything the site kept so far belongs to the existing property; the tables not listed belong to a property
+-- through their room, reservation, promo code or guest
+alter table rooms add column property_id integer references properties (id) on update cascade;
+update rooms set property_id = (select min(id) from properties);
+alter table rooms alter column property_id set not null;
+create index rooms_property_id_idx on rooms (property_id);
+
+alter table reservations add column property_id integer references properties (id) on update cascade;
+update reservations set property_id = (select min(id) from properties);
+alter table reservations alter column property_id set not null;
+create index reservations_property_id_idx on reservations (property_id);
+
+alter table charge_rules add column property_id integer references properties (id) on update cascade;
+update charge_rules set property_id = (select min(id) from properties);
+alter table charge_rules alter column property_id set not null;
+create index charge_rules_property_id_idx on charge_rules (property_id);
+
+alter table promo_codes add column property_id integer references properties (id) on update cascade;
+update promo_codes set property_id = (select min(id) from properties);
+alter table promo_codes alter column property_id set not null;
+drop index promo_codes_code_idx;
+create unique index promo_codes_property_id_code_idx on promo_codes (property_id, code);
+
+-- a guest of two properties has a profile at each
+alter table guests add column property_id integer references properties (id) on update cascade;
+update guests set property_id = (select min(id) from properties);
+alter table guests alter column property_id set not null;
+drop index guests_email_idx;
+create unique index guests_property_id_email_idx on guests (property_id, lower(email));
+
+alter table waitlist_entries add column property_id integer references properties (id) on update cascade;
+update waitlist_entries set property_id = (select min(id) from properties);
+alter table waitlist_entries alter column property_id set not null;
+create index waitlist_entries_property_id_idx on waitlist_entries (property_id);
+
+-- invoices outlive their reservation, so they keep their property themselves
+alter table invoices add column property_id integer references properties (id) on update cascade;
+update invoices set property_id = (select min(id) from properties);
+alter table invoices alter column property_id set not null;
+drop index invoices_number_idx;
+create unique index invoices_property_id_number_idx on invoices (property_id, number);
+
+alter table privacy_log add column property_id integer references properties (id) on update cascade;
+update privacy_log set property_id = (select min(id) from properties);
+alter table privacy_log alter column property_id set not null;
+create index privacy_log_property_id_created_at_idx on privacy_log (property_id, created_at);
@@ -0,0 +1,22 @@
+-- the other emails of a guest, taken over from the duplicates merged into them, so bookings made with one of them
+-- keep going to the guest
+create table guest_emails (
+    id serial primary key,
+    guest_id integer not null references guests (id) on update cascade on delete cascade,
+    property_id integer not null references properties (id) on update cascade,
+    email varchar(255) not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index guest_emails_property_id_email_idx on guest_emails (property_id, lower(email));
+create index guest_emails_guest_id_idx on guest_emails (guest_id);
+
+-- guests merged so far still have the reservations made with the emails of their duplicates
+insert into guest_emails (guest_id, property_id, email, created_at, updated_at)
+select distinct on (g.property_id, lower(r.email)) g.id, g.property_id, r.email, now(), now()
+from reservations r
+join guests g on (g.id = r.guest_id)
+where r.email <> '' and lower(r.email) <> lower(g.email)
+and not exists (select 1 from guests o where o.property_id = g.property_id and lower(o.email) = lower(r.email))
+order by g.property_id, lower(r.email), r.created_at desc;
@@ -0,0 +1,2 @@
+alter table waitlist_entries drop column declined_at;
+alter table waitlist_entries drop column offered_room_id;
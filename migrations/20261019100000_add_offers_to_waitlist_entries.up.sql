@@ -0,0 +1,3 @@
+-- the room last offered to a waiting guest, and when they declined it or let the offer expire
+alter table waitlist_entries add column offered_room_id integer;
+alter table waitlist_entries add column declined_at timestamp;
@@ -0,0 +1,12 @@
+// Package migrations holds the SQL schema migrations that are embedded in the bookings binary.
+//
+// Every migration is a pair of files named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// where version is a timestamp in the form YYYYMMDDHHMMSS.
+package migrations
+
+import "embed"
+
+// FS contains all the migration files
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,68 @@
+// roomCalendar shows a month calendar of the availability of a room in elem, with booked nights greyed out
+function roomCalendar(elem, roomID) {
+  const weekdays = ["Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"];
+  let month = new Date();
+  month.setDate(1);
+
+  function monthParam(d) {
+    return d.getFullYear() + "-" + String(d.getMonth() + 1).padStart(2, "0");
+  }
+
+  function render(data) {
+    const title = month.toLocaleString("default", { month: "long", year: "numeric" });
+    let html = '<div class="d-flex justify-content-between align-items-center mb-2">'
+      + '<button type="button" class="btn btn-sm btn-outline-secondary" data-step="-1">&laquo;</button>'
+      + '<strong>' + title + '</strong>'
+      + '<button type="button" class="btn btn-sm btn-outline-secondary" data-step="1">&raquo;</button>'
+      + '</div>'
+      + '<table class="table table-bordered availability-calendar"><thead><tr>';
+    weekdays.forEach(w => html += '<th>' + w + '</th>');
+    html += '</tr></thead><tbody><tr>';
+
+    // weeks start on Monday
+    const offset = (month.getDay() + 6) % 7;
+    for (let i = 0; i < offset; i++) {
+      html += '<td></td>';
+    }
+    data.days.forEach((day, i) => {
+      if ((i + offset) % 7 === 0 && i > 0) {
+        html += '</tr><tr>';
+      }
+      let cls = day.available ? "free" : "booked";
+      let title = day.available ? "Available" : "Not available";
+      if (!day.check_in) {
+        title += ", no arrivals";
+      }
+      if (!day.check_out) {
+        title += ", no departures";
+      }
+      html += '<td class="' + cls + '" title="' + title + '">' + Number(day.date.slice(8)) + '</td>';
+    });
+    html += '</tr></tbody></table>'
+      + '<p class="small">Check-in from ' + data.check_in_time + ', check-out by ' + data.check_out_time
+      + '. Greyed out nights are not available.</p>';
+
+    elem.innerHTML = html;
+    elem.querySelectorAll("button[data-step]").forEach(b => {
+      b.addEventListener("click", () => {
+        month.setMonth(month.getMonth() + Number(b.dataset.step));
+        load();
+      });
+    });
+  }
+
+  function load() {
+    // the page carries the path prefix of its property
+    fetch(document.body.dataset.prefix + '/rooms/' + roomID + '/availability?from=' + monthParam(month))
+      .then(response => response.json())
+      .then(data => {
+        if (data.ok) {
+          render(data);
+        } else {
+          elem.innerHTML = '<p class="text-danger">' + data.message + '</p>';
+        }
+      });
+  }
+
+  load();
+}